@@ -32,6 +32,8 @@ const (
 	DirectiveQueryTimeout = "QUERY_TIMEOUT_MS"
 	// DirectiveScatterErrorsAsWarnings enables partial success scatter select queries
 	DirectiveScatterErrorsAsWarnings = "SCATTER_ERRORS_AS_WARNINGS"
+	// DirectiveNoBuffer disables the buffering of the query during a failover.
+	DirectiveNoBuffer = "NO_BUFFER"
 )
 
 func isNonSpace(r rune) bool {
@@ -19,10 +19,12 @@ package vtctl
 import (
 	"flag"
 	"fmt"
+	"strings"
 
 	"golang.org/x/net/context"
 
 	"vitess.io/vitess/go/vt/workflow"
+	"vitess.io/vitess/go/vt/workflow/reshardingworkflowgen"
 	"vitess.io/vitess/go/vt/wrangler"
 )
 
@@ -64,6 +66,11 @@ func init() {
 		commandWorkflowWait,
 		"<uuid>",
 		"Waits for the workflow to finish."})
+	addCommand(workflowsGroupName, command{
+		"WorkflowUpdateVtworkers",
+		commandWorkflowUpdateVtworkers,
+		"<uuid> <vtworkers>",
+		"Replaces the vtworkers of the tasks of the keyspace resharding workflow which did not create their child workflow yet. <vtworkers> is a comma-separated list with one address per destination shard of these tasks. The workflow must not be running. The new vtworkers are used when it is loaded the next time."})
 
 	addCommand(workflowsGroupName, command{
 		"WorkflowTree",
@@ -163,6 +170,18 @@ func commandWorkflowWait(ctx context.Context, wr *wrangler.Wrangler, subFlags *f
 	return WorkflowManager.Wait(ctx, uuid)
 }
 
+func commandWorkflowUpdateVtworkers(ctx context.Context, wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
+	if err := subFlags.Parse(args); err != nil {
+		return err
+	}
+	if subFlags.NArg() != 2 {
+		return fmt.Errorf("the <uuid> and <vtworkers> arguments are required for the WorkflowUpdateVtworkers command")
+	}
+	uuid := subFlags.Arg(0)
+	vtworkers := strings.Split(subFlags.Arg(1), ",")
+	return reshardingworkflowgen.UpdateVtworkers(ctx, wr.TopoServer(), uuid, vtworkers)
+}
+
 func commandWorkflowTree(ctx context.Context, wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) error {
 	if WorkflowManager == nil {
 		return fmt.Errorf("no workflow.Manager registered")
@@ -58,7 +58,7 @@ func initWorkflowManager(ts *topo.Server) {
 		// Register the Schema Swap workflow.
 		schemaswap.RegisterWorkflowFactory()
 
-		// Register the Horizontal Resharding workflow.
+		// Register the Horizontal Resharding and the Vertical Split workflows.
 		resharding.Register()
 
 		// Register workflow that generates Horizontal Resharding workflows.
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo/topoproto"
+)
+
+// DrainInProgress returns true if the buffer of keyspace/shard is currently
+// draining i.e. the failover is over and the buffered requests are retried.
+// Dry-run bufferings are not reported because they do not hold back requests.
+func (b *Buffer) DrainInProgress(keyspace, shard string) bool {
+	b.mu.RLock()
+	sb, ok := b.buffers[topoproto.KeyspaceShardString(keyspace, shard)]
+	b.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	return sb.drainInProgress()
+}
+
+// signalDrainBackpressure is called for each new request which is not
+// buffered during the drain. During the drain, the buffered requests are
+// retried against the newly promoted master and the new request is delayed by
+// up to -buffer_drain_backpressure_delay to reduce the load.
+// The delay is advisory: It ends early if "ctx" is done and the request is
+// sent to the master afterwards in any case.
+func (sb *shardBuffer) signalDrainBackpressure(ctx context.Context) {
+	sb.vars.drainBackpressureEvents.Add(sb.statsKey, 1)
+	if sb.cfg.DrainBackpressureDelay <= 0 {
+		return
+	}
+
+	timer := sb.clock.NewTimer(sb.cfg.DrainBackpressureDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+}
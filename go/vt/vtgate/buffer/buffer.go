@@ -28,13 +28,13 @@ package buffer
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/net/context"
 
-	"vitess.io/vitess/go/sync2"
 	"vitess.io/vitess/go/vt/discovery"
 	"vitess.io/vitess/go/vt/log"
 	"vitess.io/vitess/go/vt/topo/topoproto"
@@ -44,12 +44,33 @@ import (
 	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
 )
 
+// ErrBufferFull is returned to the caller if a request should have been
+// buffered, but the buffer has no capacity left and no older request of the
+// same shard could be evicted. Callers should back off instead of retrying
+// immediately. Use IsErrBufferFull() to detect it because vtgate wraps the
+// error and the buffer skips requests for other capacity limits as well.
+var ErrBufferFull = vterrors.New(vtrpcpb.Code_UNAVAILABLE, "master buffer is full")
+
 var (
-	bufferFullError      = vterrors.New(vtrpcpb.Code_UNAVAILABLE, "master buffer is full")
+	softLimitError       = vterrors.New(vtrpcpb.Code_UNAVAILABLE, "master buffer is above the soft limit and only accepts high priority requests")
+	perShardLimitError   = vterrors.New(vtrpcpb.Code_UNAVAILABLE, "master buffer of this shard is full (see -buffer_max_per_shard)")
 	entryEvictedError    = vterrors.New(vtrpcpb.Code_UNAVAILABLE, "buffer full: request evicted for newer request")
 	contextCanceledError = vterrors.New(vtrpcpb.Code_UNAVAILABLE, "context was canceled before failover finished")
 )
 
+// IsErrBufferFull returns true if the root cause of "err" is ErrBufferFull or
+// one of the other errors for requests which were not buffered due to
+// missing capacity (the soft limit and the per-shard limit).
+// It follows the chain of vterrors.Wrap(), which is used by vtgate to add
+// context to the error.
+func IsErrBufferFull(err error) bool {
+	switch vterrors.RootCause(err) {
+	case ErrBufferFull, softLimitError, perShardLimitError:
+		return true
+	}
+	return false
+}
+
 // bufferMode specifies how the buffer is configured for a given shard.
 type bufferMode int
 
@@ -71,18 +92,40 @@ const (
 // instance of "ShardBuffer" will be created.
 type Buffer struct {
 	// Immutable configuration fields.
-	// Except for "now", they are parsed from command line flags.
+	// Except for "clock", they are derived from "cfg".
+	// cfg is the configuration of the buffer. Usually, it's read from the
+	// command line flags. It's shared by all shardBuffer instances.
+	cfg *BufferConfig
+	// vars has the stats variables which are updated by the buffer. Except for
+	// Simulate(), they are the exported stats of the process.
+	vars *variables
 	// keyspaces has the same purpose as "shards" but applies to a whole keyspace.
 	keyspaces map[string]bool
 	// shards is a set of keyspace/shard entries to which buffering is limited.
-	// If empty (and *enabled==true), buffering is enabled for all shards.
+	// If empty (and cfg.Enabled==true), buffering is enabled for all shards.
 	shards map[string]bool
-	// now returns the current time. Overriden in tests.
-	now func() time.Time
-
-	// bufferSizeSema limits how many requests can be buffered
-	// ("-buffer_size") and is shared by all shardBuffer instances.
-	bufferSizeSema *sync2.Semaphore
+	// clock returns the current time and creates the timers. Overriden in
+	// tests.
+	clock Clock
+	// events delivers the lifecycle events to the subscribers. It is shared
+	// by all shardBuffer instances.
+	events *eventPublisher
+	// persister writes the last failover stats to the topology. It is shared
+	// by all shardBuffer instances. See SetTopoServer().
+	persister *statsPersister
+	// watcher detects deleted keyspaces and shards. It is shared by all
+	// shardBuffer instances. See SetTopoServer().
+	watcher *topologyWatcher
+
+	// defaultPool limits how many requests can be buffered ("-buffer_size").
+	// It is shared by all shardBuffer instances of keyspaces which are not
+	// assigned to one of the "pools".
+	defaultPool *bufferPool
+	// pools has the pools defined in -buffer_pools by their name.
+	pools map[string]*bufferPool
+	// keyspacePools maps a keyspace to the name of its pool
+	// (-buffer_keyspace_pools).
+	keyspacePools map[string]string
 
 	// mu guards all fields in this group.
 	// In particular, it is used to serialize the following Go routines:
@@ -95,62 +138,103 @@ type Buffer struct {
 	// progress.
 	// Key Format: "<keyspace>/<shard>"
 	buffers map[string]*shardBuffer
+	// masters has the "<keyspace>/<shard>" key of each known master by its
+	// tablet alias. It's used to detect a master which moved to another
+	// keyspace. See recordMasterKeyspace().
+	masters map[string]string
 	// stopped is true after Shutdown() was run.
 	stopped bool
 }
 
 // New creates a new Buffer object.
 func New() *Buffer {
-	return newWithNow(time.Now)
+	return NewWithClock(realClock{})
 }
 
-func newWithNow(now func() time.Time) *Buffer {
-	if err := verifyFlags(); err != nil {
+// NewWithClock creates a new Buffer object which uses "clock" instead of the
+// system time. It's meant for tests which control the time.
+func NewWithClock(clock Clock) *Buffer {
+	cfg, err := configFromFlags()
+	if err != nil {
 		log.Fatalf("Invalid buffer configuration: %v", err)
 	}
-	bufferSize.Set(int64(*size))
-	keyspaces, shards := keyspaceShardsToSets(*shards)
 
-	if *enabledDryRun {
+	if cfg.DryRun {
 		log.Infof("vtgate buffer in dry-run mode enabled for all requests. Dry-run bufferings will log failovers but not buffer requests.")
 	}
 
-	if *enabled {
+	if cfg.Enabled {
 		log.Infof("vtgate buffer enabled. MASTER requests will be buffered during detected failovers.")
 
 		// Log a second line if it's only enabled for some keyspaces or shards.
 		header := "Buffering limited to configured "
 		limited := ""
-		if len(keyspaces) > 0 {
-			limited += "keyspaces: " + setToString(keyspaces)
+		if len(cfg.Keyspaces) > 0 {
+			limited += "keyspaces: " + strings.Join(cfg.Keyspaces, ", ")
 		}
-		if len(shards) > 0 {
+		if len(cfg.Shards) > 0 {
 			if limited == "" {
 				limited += " and "
 			}
-			limited += "shards: " + setToString(shards)
+			limited += "shards: " + strings.Join(cfg.Shards, ", ")
 		}
 		if limited != "" {
 			limited = header + limited
 			dryRunOverride := ""
-			if *enabledDryRun {
+			if cfg.DryRun {
 				dryRunOverride = " Dry-run mode is overriden for these entries and actual buffering will take place."
 			}
 			log.Infof("%v.%v", limited, dryRunOverride)
 		}
 	}
 
-	if !*enabledDryRun && !*enabled {
+	if !cfg.DryRun && !cfg.Enabled {
 		log.Infof("vtgate buffer not enabled.")
 	}
 
-	return &Buffer{
-		keyspaces:      keyspaces,
-		shards:         shards,
-		now:            now,
-		bufferSizeSema: sync2.NewSemaphore(*size, 0),
-		buffers:        make(map[string]*shardBuffer),
+	return newBuffer(clock, &cfg, publishedVariables)
+}
+
+// newBuffer creates a new Buffer object with the configuration "cfg" which
+// must be valid. The buffer updates the stats variables "vars".
+func newBuffer(clock Clock, cfg *BufferConfig, vars *variables) *Buffer {
+	vars.bufferSize.Set(int64(cfg.Size))
+	keyspaces := make(map[string]bool)
+	for _, keyspace := range cfg.Keyspaces {
+		keyspaces[keyspace] = true
+	}
+	shards := make(map[string]bool)
+	for _, shard := range cfg.Shards {
+		shards[shard] = true
+	}
+	bufferPools := make(map[string]*bufferPool)
+	totalSize := cfg.Size
+	for name, size := range cfg.PoolSizes {
+		bufferPools[name] = newBufferPool(name, size, vars)
+		totalSize += size
+	}
+	vars.slotsTotal.Set(int64(totalSize))
+	keyspacePools := make(map[string]string)
+	for keyspace, pool := range cfg.KeyspacePools {
+		keyspacePools[keyspace] = pool
 	}
+
+	b := &Buffer{
+		cfg:           cfg,
+		vars:          vars,
+		keyspaces:     keyspaces,
+		shards:        shards,
+		clock:         clock,
+		events:        newEventPublisher(vars.subscriberEventsDropped),
+		persister:     newStatsPersister(),
+		defaultPool:   newBufferPool(defaultPoolName, cfg.Size, vars),
+		pools:         bufferPools,
+		keyspacePools: keyspacePools,
+		buffers:       make(map[string]*shardBuffer),
+		masters:       make(map[string]string),
+	}
+	b.watcher = newTopologyWatcher(b.recordSrvKeyspace)
+	return b
 }
 
 // mode determines for the given keyspace and shard if buffering, dry-run
@@ -158,7 +242,7 @@ func newWithNow(now func() time.Time) *Buffer {
 func (b *Buffer) mode(keyspace, shard string) bufferMode {
 	// Actual buffering is enabled if
 	// a) no keyspaces and shards were listed in particular,
-	if *enabled && len(b.keyspaces) == 0 && len(b.shards) == 0 {
+	if b.cfg.Enabled && len(b.keyspaces) == 0 && len(b.shards) == 0 {
 		// No explicit whitelist given i.e. all shards should be buffered.
 		return bufferEnabled
 	}
@@ -172,7 +256,7 @@ func (b *Buffer) mode(keyspace, shard string) bufferMode {
 		return bufferEnabled
 	}
 
-	if *enabledDryRun {
+	if b.cfg.DryRun {
 		return bufferDryRun
 	}
 
@@ -202,11 +286,19 @@ func (b *Buffer) WaitForFailoverEnd(ctx context.Context, keyspace, shard string,
 	sb := b.getOrCreateBuffer(keyspace, shard)
 	if sb == nil {
 		// Buffer is shut down. Ignore all calls.
-		requestsSkipped.Add([]string{keyspace, shard, skippedShutdown}, 1)
+		b.vars.requestsSkipped.Add([]string{keyspace, shard, skippedShutdown}, 1)
 		return nil, nil
 	}
 	if sb.disabled() {
-		requestsSkipped.Add([]string{keyspace, shard, skippedDisabled}, 1)
+		if inTransactionFromContext(ctx) {
+			// Requests within a transaction are never buffered. They are
+			// only accounted for as "InTransaction" (see waitForFailoverEnd()).
+			return nil, nil
+		}
+		// This is the path of every MASTER request if buffering is disabled.
+		// Therefore, the skip is only counted. Unlike recordSkipped(), it does
+		// not lock the recent skip history.
+		sb.vars.requestsSkipped.Add(append(sb.statsKey, string(skippedDisabled)), 1)
 		return nil, nil
 	}
 
@@ -221,6 +313,8 @@ func (b *Buffer) StatsUpdate(ts *discovery.TabletStats) {
 		panic(fmt.Sprintf("BUG: non MASTER TabletStats object must not be forwarded: %#v", ts))
 	}
 
+	b.recordMasterKeyspace(ts.Target.Keyspace, ts.Target.Shard, ts.Tablet.Alias)
+
 	timestamp := ts.TabletExternallyReparentedTimestamp
 	if timestamp == 0 {
 		// Masters where TabletExternallyReparented was never called will return 0.
@@ -236,6 +330,134 @@ func (b *Buffer) StatsUpdate(ts *discovery.TabletStats) {
 	sb.recordExternallyReparentedTimestamp(timestamp, ts.Tablet.Alias)
 }
 
+// recordMasterKeyspace remembers that "alias" is the master of
+// keyspace/shard. If it was the master of a shard in another keyspace before,
+// the topology was reconfigured and the shard moved to "keyspace". The
+// requests which are buffered under the old keyspace are evicted then because
+// no new master would end their failover.
+func (b *Buffer) recordMasterKeyspace(keyspace, shard string, alias *topodatapb.TabletAlias) {
+	aliasStr := topoproto.TabletAliasString(alias)
+	key := topoproto.KeyspaceShardString(keyspace, shard)
+	// Fast path (read lock): The master did not move.
+	b.mu.RLock()
+	oldKey, ok := b.masters[aliasStr]
+	b.mu.RUnlock()
+	if ok && oldKey == key {
+		return
+	}
+
+	b.mu.Lock()
+	oldKey, ok = b.masters[aliasStr]
+	b.masters[aliasStr] = key
+	oldBuffer := b.buffers[oldKey]
+	b.mu.Unlock()
+	if !ok || oldBuffer == nil || oldBuffer.keyspace == keyspace {
+		return
+	}
+	oldBuffer.recordKeyspaceChange(alias, keyspace)
+}
+
+// IsBuffering returns true if requests for keyspace/shard are currently
+// buffered due to a failover. If so, it also returns the time when the
+// buffering started and the error which triggered it.
+// Dry-run bufferings are not reported because they do not hold back requests.
+func (b *Buffer) IsBuffering(keyspace, shard string) (active bool, since time.Time, reason string) {
+	b.mu.RLock()
+	sb, ok := b.buffers[topoproto.KeyspaceShardString(keyspace, shard)]
+	b.mu.RUnlock()
+	if !ok {
+		return false, time.Time{}, ""
+	}
+	return sb.isBuffering()
+}
+
+// BufferingState describes an ongoing buffering of a keyspace/shard.
+// See Buffer.ActiveBufferings().
+type BufferingState struct {
+	Keyspace string
+	Shard    string
+	// Since is the time when the buffering started.
+	Since time.Time
+	// Elapsed is the time since the start of the buffering.
+	Elapsed time.Duration
+	// Reason is the error which triggered the buffering.
+	Reason string
+}
+
+// ActiveBufferings returns all keyspace/shards which are currently buffering,
+// sorted by keyspace and shard.
+// Like IsBuffering(), dry-run bufferings are not reported.
+func (b *Buffer) ActiveBufferings() []BufferingState {
+	b.mu.RLock()
+	buffers := make([]*shardBuffer, 0, len(b.buffers))
+	for _, sb := range b.buffers {
+		buffers = append(buffers, sb)
+	}
+	b.mu.RUnlock()
+
+	now := b.clock.Now()
+	var result []BufferingState
+	for _, sb := range buffers {
+		active, since, reason := sb.isBuffering()
+		if !active {
+			continue
+		}
+		result = append(result, BufferingState{
+			Keyspace: sb.keyspace,
+			Shard:    sb.shard,
+			Since:    since,
+			Elapsed:  now.Sub(since),
+			Reason:   reason,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Keyspace != result[j].Keyspace {
+			return result[i].Keyspace < result[j].Keyspace
+		}
+		return result[i].Shard < result[j].Shard
+	})
+	return result
+}
+
+// ShardUtilization is the current buffer utilization of a keyspace/shard.
+// See Buffer.MostImpactedShard().
+type ShardUtilization struct {
+	Keyspace string
+	Shard    string
+	// UtilizationPercent is the number of buffered requests of the shard in
+	// percent of the size of its pool (see -buffer_pools).
+	UtilizationPercent int64
+}
+
+// MostImpactedShard returns the keyspace/shard with the highest current
+// buffer utilization. It's also shown on /bufferz. Ties are broken by keyspace
+// and shard name. It returns nil if no requests are buffered.
+func (b *Buffer) MostImpactedShard() *ShardUtilization {
+	b.mu.RLock()
+	buffers := make([]*shardBuffer, 0, len(b.buffers))
+	for _, sb := range b.buffers {
+		buffers = append(buffers, sb)
+	}
+	b.mu.RUnlock()
+
+	var worst *ShardUtilization
+	for _, sb := range buffers {
+		u := &ShardUtilization{
+			Keyspace:           sb.keyspace,
+			Shard:              sb.shard,
+			UtilizationPercent: sb.utilizationPercent(),
+		}
+		if u.UtilizationPercent == 0 {
+			continue
+		}
+		if worst == nil || u.UtilizationPercent > worst.UtilizationPercent ||
+			(u.UtilizationPercent == worst.UtilizationPercent && (u.Keyspace < worst.Keyspace || (u.Keyspace == worst.Keyspace && u.Shard < worst.Shard))) {
+			worst = u
+		}
+	}
+	return worst
+}
+
 // causedByFailover returns true if "err" was supposedly caused by a failover.
 // To simplify things, we've merged the detection for different MySQL flavors
 // in one function. Supported flavors: MariaDB, MySQL, Google internal.
@@ -288,8 +510,9 @@ func (b *Buffer) getOrCreateBuffer(keyspace, shard string) *shardBuffer {
 	// Look it up again because it could have been created in the meantime.
 	sb, ok = b.buffers[key]
 	if !ok {
-		sb = newShardBuffer(b.mode(keyspace, shard), keyspace, shard, b.now, b.bufferSizeSema)
+		sb = newShardBuffer(b.mode(keyspace, shard), keyspace, shard, b.cfg, b.vars, b.clock, b.events, b.persister, b.poolFor(keyspace))
 		b.buffers[key] = sb
+		b.watcher.watch(keyspace)
 	}
 	return sb
 }
@@ -303,6 +526,8 @@ func (b *Buffer) Shutdown() {
 }
 
 func (b *Buffer) shutdown() {
+	b.watcher.stop()
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -313,6 +538,9 @@ func (b *Buffer) shutdown() {
 }
 
 func (b *Buffer) waitForShutdown() {
+	// The watches must return first because they acquire "mu" as well.
+	b.watcher.wait()
+
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
@@ -20,7 +20,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -82,11 +84,11 @@ func TestBuffer(t *testing.T) {
 	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
 	// Dry-run mode will apply to other keyspaces and shards. Not tested here.
 	flag.Set("enable_buffer_dry_run", "true")
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 
 	// Create the buffer.
-	now := time.Now()
-	b := newWithNow(func() time.Time { return now })
+	clock := newFakeClock(time.Now())
+	b := NewWithClock(clock)
 
 	// Simulate that the current master reports its ExternallyReparentedTimestamp.
 	// vtgate sees this at startup. Additional periodic updates will be sent out
@@ -96,7 +98,7 @@ func TestBuffer(t *testing.T) {
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              oldMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 
 	// First request with failover error starts buffering.
@@ -122,11 +124,11 @@ func TestBuffer(t *testing.T) {
 	}
 
 	// Mimic the failover end.
-	now = now.Add(1 * time.Second)
+	clock.Advance(1 * time.Second)
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              newMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 
 	// Check that the drain is successful.
@@ -170,7 +172,7 @@ func TestBuffer(t *testing.T) {
 	}
 
 	// Second failover is buffered if enough time has passed.
-	now = now.Add(*minTimeBetweenFailovers)
+	clock.Advance(*minTimeBetweenFailovers)
 	stopped4 := issueRequest(context.Background(), t, b, failoverErr)
 	if err := waitForRequestsInFlight(b, 1); err != nil {
 		t.Fatal(err)
@@ -187,7 +189,7 @@ func TestBuffer(t *testing.T) {
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              oldMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 	if err := <-stopped4; err != nil {
 		t.Fatalf("request should have been buffered and not returned an error: %v", err)
@@ -282,7 +284,7 @@ func waitForState(b *Buffer, want bufferState) error {
 func waitForPoolSlots(b *Buffer, want int) error {
 	start := time.Now()
 	for {
-		got := b.bufferSizeSema.Size()
+		got := b.defaultPool.sema.Size()
 		if got == want {
 			return nil
 		}
@@ -299,7 +301,7 @@ func TestDryRun(t *testing.T) {
 	resetVariables()
 
 	flag.Set("enable_buffer_dry_run", "true")
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 
 	// Request does not get buffered.
@@ -332,6 +334,9 @@ func TestDryRun(t *testing.T) {
 	if got, want := stops.Counts()[statsKeyJoinedFailoverEndDetected], int64(1); got != want {
 		t.Fatalf("buffering stop was not tracked: got = %v, want = %v", got, want)
 	}
+	if got, want := stopsDryRun.Counts()[statsKeyJoinedFailoverEndDetected], int64(1); got != want {
+		t.Fatalf("dry-run buffering stop was not tracked: got = %v, want = %v", got, want)
+	}
 	if got, want := utilizationDryRunSum.Counts()[statsKeyJoined], int64(10); got != want {
 		t.Fatalf("wrong buffer utilization: got = %v, want = %v", got, want)
 	}
@@ -342,7 +347,7 @@ func TestDryRun(t *testing.T) {
 func TestPassthrough(t *testing.T) {
 	flag.Set("enable_buffer", "true")
 	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 
 	if retryDone, err := b.WaitForFailoverEnd(context.Background(), keyspace, shard, nil); err != nil || retryDone != nil {
@@ -366,9 +371,9 @@ func TestLastReparentTooRecent_BufferingSkipped(t *testing.T) {
 
 	flag.Set("enable_buffer", "true")
 	// Enable the buffer (no explicit whitelist i.e. it applies to everything).
-	defer resetFlagsForTesting()
-	now := time.Now()
-	b := newWithNow(func() time.Time { return now })
+	defer ResetFlagsForTesting()
+	clock := newFakeClock(time.Now())
+	b := NewWithClock(clock)
 
 	// Simulate that the old master notified us about its reparented timestamp
 	// very recently (time.Now()).
@@ -376,16 +381,16 @@ func TestLastReparentTooRecent_BufferingSkipped(t *testing.T) {
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              oldMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 
 	// Failover to new master. Its end is detected faster than the beginning.
 	// Do not start buffering.
-	now = now.Add(1 * time.Second)
+	clock.Advance(1 * time.Second)
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              newMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 
 	if retryDone, err := b.WaitForFailoverEnd(context.Background(), keyspace, shard, failoverErr); err != nil || retryDone != nil {
@@ -410,9 +415,9 @@ func TestLastReparentTooRecent_Buffering(t *testing.T) {
 
 	flag.Set("enable_buffer", "true")
 	// Enable the buffer (no explicit whitelist i.e. it applies to everything).
-	defer resetFlagsForTesting()
-	now := time.Now()
-	b := newWithNow(func() time.Time { return now })
+	defer ResetFlagsForTesting()
+	clock := newFakeClock(time.Now())
+	b := NewWithClock(clock)
 
 	// Simulate that the old master notified us about its reparented timestamp
 	// very recently (time.Now()).
@@ -420,21 +425,21 @@ func TestLastReparentTooRecent_Buffering(t *testing.T) {
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              oldMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 
 	// Failover to new master. Do not issue any requests before or after i.e.
 	// there was 0 QPS traffic and no buffering was started.
-	now = now.Add(1 * time.Second)
+	clock.Advance(1 * time.Second)
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              newMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 
 	// After we're past the --buffer_min_time_between_failovers threshold, go
 	// through a failover with non-zero QPS.
-	now = now.Add(*minTimeBetweenFailovers)
+	clock.Advance(*minTimeBetweenFailovers)
 	// We're seeing errors first.
 	stopped := issueRequest(context.Background(), t, b, failoverErr)
 	if err := waitForRequestsInFlight(b, 1); err != nil {
@@ -444,7 +449,7 @@ func TestLastReparentTooRecent_Buffering(t *testing.T) {
 	b.StatsUpdate(&discovery.TabletStats{
 		Tablet:                              newMaster,
 		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
-		TabletExternallyReparentedTimestamp: now.Unix(),
+		TabletExternallyReparentedTimestamp: clock.Now().Unix(),
 	})
 
 	// Check that the drain is successful.
@@ -469,7 +474,7 @@ func TestLastReparentTooRecent_Buffering(t *testing.T) {
 func TestPassthroughDuringDrain(t *testing.T) {
 	flag.Set("enable_buffer", "true")
 	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 
 	// Buffer one request.
@@ -515,7 +520,7 @@ func TestPassthroughDuringDrain(t *testing.T) {
 func TestPassthroughIgnoredKeyspaceOrShard(t *testing.T) {
 	flag.Set("enable_buffer", "true")
 	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 
 	ignoredKeyspace := "ignored_ks"
@@ -561,12 +566,12 @@ func testRequestCanceled(t *testing.T, explicitEnd bool) {
 	flag.Set("enable_buffer", "true")
 	// Enable buffering for the complete keyspace and not just a specific shard.
 	flag.Set("buffer_keyspace_shards", keyspace)
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 	if !explicitEnd {
 		// Set value after constructor to work-around hardcoded minimum values.
-		flag.Set("buffer_window", "100ms")
-		flag.Set("buffer_max_failover_duration", "100ms")
+		b.cfg.Window = 100 * time.Millisecond
+		b.cfg.MaxFailoverDuration = 100 * time.Millisecond
 	}
 
 	// Buffer 2 requests. The second will be canceled and the first will be drained.
@@ -635,7 +640,7 @@ func TestEviction(t *testing.T) {
 	flag.Set("enable_buffer", "true")
 	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
 	flag.Set("buffer_size", "2")
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 
 	stopped1 := issueRequest(context.Background(), t, b, failoverErr)
@@ -719,7 +724,7 @@ func TestEvictionNotPossible(t *testing.T) {
 		topoproto.KeyspaceShardString(keyspace, shard),
 		topoproto.KeyspaceShardString(keyspace, shard2)))
 	flag.Set("buffer_size", "1")
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 
 	// Make the buffer full (applies to all failovers).
@@ -738,9 +743,12 @@ func TestEvictionNotPossible(t *testing.T) {
 	if got, want := vterrors.Code(bufferErr), vtrpcpb.Code_UNAVAILABLE; got != want {
 		t.Fatalf("wrong error code for evicted buffered request. got = %v, want = %v", got, want)
 	}
-	if got, want := bufferErr.Error(), bufferFullError.Error(); !strings.Contains(got, want) {
+	if got, want := bufferErr.Error(), ErrBufferFull.Error(); !strings.Contains(got, want) {
 		t.Fatalf("evicted buffered request should return a different error message. got = %v, want substring = %v", got, want)
 	}
+	if bufferErr != ErrBufferFull {
+		t.Fatalf("buffer should have returned the typed error ErrBufferFull: %v", bufferErr)
+	}
 
 	// End of failover. Stop buffering.
 	b.StatsUpdate(&discovery.TabletStats{
@@ -764,6 +772,33 @@ func TestEvictionNotPossible(t *testing.T) {
 	}
 }
 
+func TestIsErrBufferFull(t *testing.T) {
+	// vtgate wraps the error before it's returned to the client.
+	wrap := func(err error) error {
+		wrapped := vterrors.Wrapf(err, "failed to automatically buffer and retry failed request during failover (original err (type=%T): %v)", failoverErr, failoverErr)
+		return vterrors.Wrapf(wrapped, "target: %s.%s.master", keyspace, shard)
+	}
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{ErrBufferFull, true},
+		{wrap(ErrBufferFull), true},
+		{wrap(softLimitError), true},
+		{wrap(perShardLimitError), true},
+		{entryEvictedError, false},
+		{contextCanceledError, false},
+		{failoverErr, false},
+		// An error with the same message is not ErrBufferFull.
+		{vterrors.New(vtrpcpb.Code_UNAVAILABLE, ErrBufferFull.Error()), false},
+	} {
+		if got := IsErrBufferFull(tc.err); got != tc.want {
+			t.Errorf("IsErrBufferFull(%v) = %v, want = %v", tc.err, got, tc.want)
+		}
+	}
+}
+
 func TestWindow(t *testing.T) {
 	resetVariables()
 	defer checkVariables(t)
@@ -773,10 +808,10 @@ func TestWindow(t *testing.T) {
 		topoproto.KeyspaceShardString(keyspace, shard),
 		topoproto.KeyspaceShardString(keyspace, shard2)))
 	flag.Set("buffer_size", "1")
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 	// Set value after constructor to work-around hardcoded minimum values.
-	flag.Set("buffer_window", "1ms")
+	b.cfg.Window = 1 * time.Millisecond
 
 	// Buffer one request.
 	t.Logf("first request exceeds its window")
@@ -793,7 +828,7 @@ func TestWindow(t *testing.T) {
 
 	// Increase the window and buffer a request again
 	// (queue becomes not empty a second time).
-	flag.Set("buffer_window", "10m")
+	b.cfg.Window = 10 * time.Minute
 
 	// This is a hack. The buffering semaphore gets released asynchronously.
 	// Sometimes the next issueRequest tries to acquire before that release
@@ -817,7 +852,7 @@ func TestWindow(t *testing.T) {
 	if err := isEvictedError(<-stopped2); err != nil {
 		t.Fatal(err)
 	}
-	// Block until the third request is buffered. Avoids data race with *window.
+	// Block until the third request is buffered. Avoids data race with b.cfg.Window.
 	if err := waitForRequestsInFlight(b, 1); err != nil {
 		t.Fatal(err)
 	}
@@ -828,7 +863,7 @@ func TestWindow(t *testing.T) {
 	}
 
 	// Reduce the window again.
-	flag.Set("buffer_window", "100ms")
+	b.cfg.Window = 100 * time.Millisecond
 
 	// Fourth request evicts the third
 	t.Logf("fourth request exceeds its window (and evicts the third)")
@@ -880,7 +915,7 @@ func TestShutdown(t *testing.T) {
 	defer checkVariables(t)
 
 	flag.Set("enable_buffer", "true")
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 	b := New()
 
 	// Buffer one request.
@@ -902,20 +937,944 @@ func TestShutdown(t *testing.T) {
 	}
 }
 
+// TestIsBuffering tests that Buffer.IsBuffering() reports the live state of
+// an ongoing failover.
+func TestIsBuffering(t *testing.T) {
+	resetVariables()
+	defer checkVariables(t)
+
+	flag.Set("enable_buffer", "true")
+	defer ResetFlagsForTesting()
+	clock := newFakeClock(time.Now())
+	b := NewWithClock(clock)
+
+	// Unknown shards are not buffering.
+	if active, _, _ := b.IsBuffering(keyspace, shard); active {
+		t.Fatalf("shard without any requests must not be reported as buffering")
+	}
+
+	// Buffer one request.
+	stopped := issueRequest(context.Background(), t, b, failoverErr)
+	if err := waitForRequestsInFlight(b, 1); err != nil {
+		t.Fatal(err)
+	}
+	active, since, reason := b.IsBuffering(keyspace, shard)
+	if !active {
+		t.Fatalf("shard should be reported as buffering")
+	}
+	if !since.Equal(clock.Now()) {
+		t.Fatalf("wrong buffering start time: got = %v, want = %v", since, clock.Now())
+	}
+	if want := failoverErr.Error(); !strings.Contains(reason, want) {
+		t.Fatalf("wrong buffering reason: got = %v, want substring = %v", reason, want)
+	}
+
+	// Stop buffering.
+	b.StatsUpdate(&discovery.TabletStats{
+		Tablet:                              newMaster,
+		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
+		TabletExternallyReparentedTimestamp: 1, // Use any value > 0.
+	})
+	if err := <-stopped; err != nil {
+		t.Fatalf("request should have been buffered and not returned an error: %v", err)
+	}
+	if err := waitForState(b, stateIdle); err != nil {
+		t.Fatal(err)
+	}
+	if active, _, _ := b.IsBuffering(keyspace, shard); active {
+		t.Fatalf("shard must not be reported as buffering after the failover ended")
+	}
+	if err := waitForPoolSlots(b, *size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestActiveBufferings tests that all buffering shards are listed.
+func TestActiveBufferings(t *testing.T) {
+	resetVariables()
+	defer checkVariables(t)
+
+	flag.Set("enable_buffer", "true")
+	defer ResetFlagsForTesting()
+	clock := newFakeClock(time.Now())
+	b := NewWithClock(clock)
+
+	if got := b.ActiveBufferings(); len(got) != 0 {
+		t.Fatalf("no shard should be buffering: %v", got)
+	}
+
+	// Start buffering for both shards.
+	var stopped []chan error
+	for _, s := range []string{shard, shard2} {
+		bufferingStopped := make(chan error, 1)
+		go func(s string) {
+			retryDone, err := b.WaitForFailoverEnd(context.Background(), keyspace, s, failoverErr)
+			if retryDone != nil {
+				retryDone()
+			}
+			bufferingStopped <- err
+		}(s)
+		stopped = append(stopped, bufferingStopped)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(b.ActiveBufferings()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("both shards should be buffering: %v", b.ActiveBufferings())
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	clock.Advance(2 * time.Second)
+	got := b.ActiveBufferings()
+	// The list is sorted and "-80" comes before "0".
+	for i, want := range []string{shard2, shard} {
+		if got[i].Keyspace != keyspace || got[i].Shard != want {
+			t.Fatalf("wrong shard at position %v: got = %v/%v, want = %v/%v", i, got[i].Keyspace, got[i].Shard, keyspace, want)
+		}
+		if got[i].Elapsed != 2*time.Second {
+			t.Fatalf("wrong elapsed time for shard %v: got = %v, want = %v", want, got[i].Elapsed, 2*time.Second)
+		}
+		if !strings.Contains(got[i].Reason, failoverErr.Error()) {
+			t.Fatalf("wrong buffering reason for shard %v: got = %v, want substring = %v", want, got[i].Reason, failoverErr)
+		}
+	}
+
+	// Stop buffering for both shards.
+	for _, s := range []string{shard, shard2} {
+		b.StatsUpdate(&discovery.TabletStats{
+			Tablet:                              newMaster,
+			Target:                              &querypb.Target{Keyspace: keyspace, Shard: s, TabletType: topodatapb.TabletType_MASTER},
+			TabletExternallyReparentedTimestamp: 1, // Use any value > 0.
+		})
+	}
+	for _, bufferingStopped := range stopped {
+		if err := <-bufferingStopped; err != nil {
+			t.Fatalf("request should have been buffered and not returned an error: %v", err)
+		}
+	}
+	if got := b.ActiveBufferings(); len(got) != 0 {
+		t.Fatalf("no shard should be buffering after the failovers ended: %v", got)
+	}
+	if err := waitForPoolSlots(b, *size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMostImpactedShard tests that the shard with the most buffered requests
+// is reported and that ties are broken by keyspace and shard name.
+func TestMostImpactedShard(t *testing.T) {
+	resetVariables()
+	defer checkVariables(t)
+
+	flag.Set("enable_buffer", "true")
+	defer ResetFlagsForTesting()
+	b := New()
+
+	if got := b.MostImpactedShard(); got != nil {
+		t.Fatalf("no shard should be impacted: %v", got)
+	}
+
+	// ks1/-80 and ks2/0 have the most buffered requests.
+	keyspace2 := "ks2"
+	var stopped []chan error
+	for _, c := range []struct {
+		keyspace string
+		shard    string
+		requests int
+	}{
+		{keyspace2, shard, 3},
+		{keyspace, shard, 2},
+		{keyspace, shard2, 3},
+	} {
+		for i := 0; i < c.requests; i++ {
+			bufferingStopped := make(chan error, 1)
+			go func(keyspace, shard string) {
+				retryDone, err := b.WaitForFailoverEnd(context.Background(), keyspace, shard, failoverErr)
+				if retryDone != nil {
+					retryDone()
+				}
+				bufferingStopped <- err
+			}(c.keyspace, c.shard)
+			stopped = append(stopped, bufferingStopped)
+		}
+		sb := b.getOrCreateBuffer(c.keyspace, c.shard)
+		deadline := time.Now().Add(10 * time.Second)
+		for sb.sizeForTesting() != c.requests {
+			if time.Now().After(deadline) {
+				t.Fatalf("wrong buffered requests for %v/%v: got = %v, want = %v", c.keyspace, c.shard, sb.sizeForTesting(), c.requests)
+			}
+			time.Sleep(1 * time.Millisecond)
+		}
+	}
+
+	want := &ShardUtilization{Keyspace: keyspace, Shard: shard2, UtilizationPercent: 30}
+	if got := b.MostImpactedShard(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong most impacted shard: got = %+v, want = %+v", got, want)
+	}
+
+	// Stop buffering for all shards.
+	b.Shutdown()
+	for _, bufferingStopped := range stopped {
+		if err := <-bufferingStopped; err != nil {
+			t.Fatalf("request should have been buffered and not returned an error: %v", err)
+		}
+	}
+	if got := b.MostImpactedShard(); got != nil {
+		t.Fatalf("no shard should be impacted after the failovers ended: %v", got)
+	}
+}
+
+// TestMaxDurationJitter tests that each shard gets its own randomized max
+// failover duration within the bounds of -buffer_max_duration_jitter.
+func TestMaxDurationJitter(t *testing.T) {
+	resetVariables()
+	defer checkVariables(t)
+
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_max_duration_jitter", "10s")
+	defer ResetFlagsForTesting()
+	b := New()
+
+	// Start buffering for both shards.
+	var stopped []chan error
+	for _, s := range []string{shard, shard2} {
+		bufferingStopped := make(chan error, 1)
+		go func(s string) {
+			retryDone, err := b.WaitForFailoverEnd(context.Background(), keyspace, s, failoverErr)
+			if retryDone != nil {
+				retryDone()
+			}
+			bufferingStopped <- err
+		}(s)
+		stopped = append(stopped, bufferingStopped)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(b.ActiveBufferings()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("both shards should be buffering: %v", b.ActiveBufferings())
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	// Verify the effective max failover duration of each shard.
+	maxMs := int64(*maxFailoverDuration / time.Millisecond)
+	minMs := int64((*maxFailoverDuration - *maxDurationJitter) / time.Millisecond)
+	caps := make(map[string]int64)
+	for _, s := range []string{shard, shard2} {
+		name := strings.Join([]string{keyspace, s}, ".")
+		got, ok := lastMaxFailoverDurationMs.Counts()[name]
+		if !ok {
+			t.Fatalf("no effective max failover duration recorded for shard: %v", name)
+		}
+		if got < minMs || got > maxMs {
+			t.Fatalf("effective max failover duration for shard %v is out of bounds: got = %v, want = [%v, %v]", name, got, minMs, maxMs)
+		}
+		caps[s] = got
+	}
+	if caps[shard] == caps[shard2] {
+		t.Fatalf("the jitter should result in different max failover durations per shard: %v", caps)
+	}
+
+	// Stop buffering for both shards.
+	for _, s := range []string{shard, shard2} {
+		b.StatsUpdate(&discovery.TabletStats{
+			Tablet:                              newMaster,
+			Target:                              &querypb.Target{Keyspace: keyspace, Shard: s, TabletType: topodatapb.TabletType_MASTER},
+			TabletExternallyReparentedTimestamp: 1, // Use any value > 0.
+		})
+	}
+	for _, bufferingStopped := range stopped {
+		if err := <-bufferingStopped; err != nil {
+			t.Fatalf("request should have been buffered and not returned an error: %v", err)
+		}
+	}
+	if err := waitForPoolSlots(b, *size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGlobalUtilization tests that the utilization is computed across all
+// shards.
+func TestGlobalUtilization(t *testing.T) {
+	resetVariables()
+	defer checkVariables(t)
+
+	flag.Set("enable_buffer", "true")
+	defer ResetFlagsForTesting()
+	b := New()
+
+	if got := globalUtilizationPercent.F(); got != 0 {
+		t.Fatalf("utilization should be 0 without buffered requests: got = %v", got)
+	}
+
+	// Buffer 2 requests for the first and 3 requests for the second shard.
+	var stopped []chan error
+	for _, r := range []struct {
+		shard    string
+		requests int
+	}{
+		{shard, 2},
+		{shard2, 3},
+	} {
+		for i := 0; i < r.requests; i++ {
+			bufferingStopped := make(chan error, 1)
+			go func(s string) {
+				retryDone, err := b.WaitForFailoverEnd(context.Background(), keyspace, s, failoverErr)
+				if retryDone != nil {
+					retryDone()
+				}
+				bufferingStopped <- err
+			}(r.shard)
+			stopped = append(stopped, bufferingStopped)
+		}
+	}
+	// 5 of 10 slots are in use.
+	deadline := time.Now().Add(10 * time.Second)
+	for globalUtilizationPercent.F() != 50 {
+		if time.Now().After(deadline) {
+			t.Fatalf("wrong global utilization: got = %v, want = %v", globalUtilizationPercent.F(), 50)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	// Stop buffering for both shards.
+	for _, s := range []string{shard, shard2} {
+		b.StatsUpdate(&discovery.TabletStats{
+			Tablet:                              newMaster,
+			Target:                              &querypb.Target{Keyspace: keyspace, Shard: s, TabletType: topodatapb.TabletType_MASTER},
+			TabletExternallyReparentedTimestamp: 1, // Use any value > 0.
+		})
+	}
+	for _, bufferingStopped := range stopped {
+		if err := <-bufferingStopped; err != nil {
+			t.Fatalf("request should have been buffered and not returned an error: %v", err)
+		}
+	}
+	if err := waitForPoolSlots(b, *size); err != nil {
+		t.Fatal(err)
+	}
+	if got := globalUtilizationPercent.F(); got != 0 {
+		t.Fatalf("utilization should be 0 after the drain: got = %v", got)
+	}
+}
+
+// TestKeyspaceChanged tests that the buffered requests are evicted when the
+// master of the shard reports a different keyspace during the failover.
+func TestKeyspaceChanged(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	h.startBuffering()
+	h.enqueue(1)
+
+	// The shard moved to another keyspace. Its master reports the new one.
+	h.b.StatsUpdate(&discovery.TabletStats{
+		Tablet:                              oldMaster,
+		Target:                              &querypb.Target{Keyspace: "other_keyspace", Shard: shard, TabletType: topodatapb.TabletType_MASTER},
+		TabletExternallyReparentedTimestamp: h.clock.Now().Unix(),
+	})
+	for i, stopped := range h.pending {
+		err := <-stopped
+		if got, want := vterrors.Code(err), vtrpcpb.Code_FAILED_PRECONDITION; got != want {
+			t.Fatalf("request %v returned the wrong error code: got = %v, want = %v, err: %v", i, got, want, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), "moved to keyspace other_keyspace") {
+			t.Fatalf("request %v returned an error which does not mention the new keyspace: %v", i, err)
+		}
+	}
+	h.pending = nil
+	if err := waitForState(h.b, stateIdle); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := takeStatsSnapshot()
+
+	if got, want := snapshot.requestsEvicted[statsKeyJoined+"."+string(evictedTopologyChanged)], int64(2); got != want {
+		t.Fatalf("wrong evicted requests count: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.stops[statsKeyJoined+"."+string(stopKeyspaceRemoved)], int64(1); got != want {
+		t.Fatalf("wrong stop reason count: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsDrained[statsKeyJoined], int64(0); got != want {
+		t.Fatalf("evicted requests must not be drained: got = %v, want = %v", got, want)
+	}
+}
+
+// TestDrainPriority tests that requests with a higher priority are drained
+// first and that requests with the same priority are drained in FIFO order.
+func TestDrainPriority(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	var mu sync.Mutex
+	var drained []string
+	var wg sync.WaitGroup
+	requests := []struct {
+		name     string
+		priority Priority
+	}{
+		{"normal1", PriorityNormal},
+		{"high1", PriorityHigh},
+		{"normal2", PriorityNormal},
+		{"high2", PriorityHigh},
+	}
+	for i, r := range requests {
+		wg.Add(1)
+		go func(name string, priority Priority) {
+			defer wg.Done()
+			ctx := NewContextWithPriority(context.Background(), priority)
+			retryDone, err := h.b.WaitForFailoverEnd(ctx, keyspace, shard, failoverErr)
+			if err != nil {
+				t.Errorf("request %v should have been buffered and not returned an error: %v", name, err)
+				return
+			}
+			mu.Lock()
+			drained = append(drained, name)
+			mu.Unlock()
+			retryDone()
+		}(r.name, r.priority)
+		// Wait for each request to guarantee the order of arrival.
+		if err := waitForRequestsInFlight(h.b, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	wg.Wait()
+	snapshot := h.drain()
+
+	if want := []string{"high1", "high2", "normal1", "normal2"}; !reflect.DeepEqual(drained, want) {
+		t.Fatalf("wrong drain order: got = %v, want = %v", drained, want)
+	}
+	if got, want := snapshot.requestsByPriority[statsKeyJoined+"."+PriorityHigh.String()], int64(2); got != want {
+		t.Fatalf("wrong count of buffered high priority requests: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsByPriority[statsKeyJoined+"."+PriorityNormal.String()], int64(2); got != want {
+		t.Fatalf("wrong count of buffered normal priority requests: got = %v, want = %v", got, want)
+	}
+}
+
+// TestPerShardLimit tests that a shard cannot buffer more requests than
+// -buffer_max_per_shard even though the pool has free slots.
+func TestPerShardLimit(t *testing.T) {
+	flag.Set("buffer_size", "4")
+	flag.Set("buffer_max_per_shard", "0.5")
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// Two requests reach the limit of the shard.
+	h.startBuffering()
+	h.enqueue(1)
+
+	// Further requests are skipped, including high priority ones.
+	for _, ctx := range []context.Context{context.Background(), NewContextWithPriority(context.Background(), PriorityHigh)} {
+		retryDone, err := h.b.WaitForFailoverEnd(ctx, keyspace, shard, failoverErr)
+		if err == nil || retryDone != nil {
+			t.Fatalf("request above the per shard limit should have been skipped: err: %v retryDone: %v", err, retryDone)
+		}
+		if got, want := err.Error(), perShardLimitError.Error(); !strings.Contains(got, want) {
+			t.Fatalf("skipped request should return a different error message. got = %v, want substring = %v", got, want)
+		}
+	}
+	if got, want := h.b.defaultPool.used(), 2; got != want {
+		t.Fatalf("the skipped requests must not use pool slots: got = %v, want = %v", got, want)
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	snapshot := h.drain()
+
+	if got, want := snapshot.requestsSkipped[statsKeyJoined+"."+string(skippedPerShardLimit)], int64(2); got != want {
+		t.Fatalf("wrong number of requests skipped due to the per shard limit: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsEvicted[statsKeyJoined+"."+evictedBufferFull], int64(0); got != want {
+		t.Fatalf("no request should have been evicted: got = %v, want = %v", got, want)
+	}
+}
+
+// TestSoftLimit tests that requests with a normal priority are skipped above
+// the soft limit while high priority requests are buffered until the buffer
+// is full.
+func TestSoftLimit(t *testing.T) {
+	flag.Set("buffer_size", "4")
+	flag.Set("buffer_soft_limit", "0.5")
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// Two normal requests fill the buffer up to the soft limit.
+	h.startBuffering()
+	h.enqueue(1)
+
+	// Above the soft limit, normal requests are skipped.
+	retryDone, err := h.b.WaitForFailoverEnd(context.Background(), keyspace, shard, failoverErr)
+	if err == nil || retryDone != nil {
+		t.Fatalf("normal request above the soft limit should have been skipped: err: %v retryDone: %v", err, retryDone)
+	}
+	if got, want := vterrors.Code(err), vtrpcpb.Code_UNAVAILABLE; got != want {
+		t.Fatalf("wrong error code for skipped request. got = %v, want = %v", got, want)
+	}
+	if got, want := err.Error(), softLimitError.Error(); !strings.Contains(got, want) {
+		t.Fatalf("skipped request should return a different error message. got = %v, want substring = %v", got, want)
+	}
+
+	// High priority requests are still buffered until the hard limit.
+	highCtx := NewContextWithPriority(context.Background(), PriorityHigh)
+	for i := 0; i < 2; i++ {
+		h.pending = append(h.pending, issueRequest(highCtx, t, h.b, failoverErr))
+	}
+	if err := waitForRequestsInFlight(h.b, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// At the hard limit, a high priority request evicts the oldest entry.
+	h.pending = append(h.pending, issueRequest(highCtx, t, h.b, failoverErr))
+	if err := isEvictedError(<-h.pending[0]); err != nil {
+		t.Fatalf("oldest request should have been evicted: %v", err)
+	}
+	h.pending = h.pending[1:]
+	if err := waitForRequestsInFlight(h.b, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	snapshot := h.drain()
+
+	if got, want := snapshot.requestsSkipped[statsKeyJoined+"."+skippedSoftLimit], int64(1); got != want {
+		t.Fatalf("wrong number of requests skipped due to the soft limit: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsEvicted[statsKeyJoined+"."+evictedBufferFull], int64(1); got != want {
+		t.Fatalf("wrong number of evicted requests: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsByPriority[statsKeyJoined+"."+PriorityHigh.String()], int64(3); got != want {
+		t.Fatalf("wrong count of buffered high priority requests: got = %v, want = %v", got, want)
+	}
+}
+
+// TestInTransaction tests that requests within a transaction are not
+// buffered and counted as skipped instead.
+func TestInTransaction(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	txCtx := NewContextInTransaction(context.Background())
+	// A request within a transaction does not start buffering.
+	if retryDone, err := h.b.WaitForFailoverEnd(txCtx, keyspace, shard, failoverErr); err != nil || retryDone != nil {
+		t.Fatalf("requests within a transaction must not be buffered. err: %v retryDone: %v", err, retryDone)
+	}
+	if got := starts.Counts()[statsKeyJoined]; got != 0 {
+		t.Fatalf("a request within a transaction must not start buffering: got = %v starts", got)
+	}
+
+	// During a failover, requests within a transaction pass through as well.
+	// Only the one which saw the failover is counted.
+	h.startBuffering()
+	for _, requestErr := range []error{failoverErr, nil} {
+		if retryDone, err := h.b.WaitForFailoverEnd(txCtx, keyspace, shard, requestErr); err != nil || retryDone != nil {
+			t.Fatalf("requests within a transaction must not be buffered. err: %v retryDone: %v", err, retryDone)
+		}
+	}
+	// Requests within a transaction are not counted for shards without
+	// buffering.
+	if _, err := h.b.WaitForFailoverEnd(txCtx, keyspace, shard2, failoverErr); err != nil {
+		t.Fatal(err)
+	}
+	if got := requestsSkipped.Counts()[fmt.Sprintf("%s.%s.%s", keyspace, shard2, skippedDisabled)]; got != 0 {
+		t.Fatalf("requests within a transaction must not be counted as disabled: got = %v", got)
+	}
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	snapshot := h.drain()
+
+	if got, want := snapshot.requestsSkipped[statsKeyJoined+"."+string(skippedInTransaction)], int64(2); got != want {
+		t.Fatalf("wrong number of requests skipped due to a transaction: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsBuffered[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("only the request without a transaction must be buffered: got = %v, want = %v", got, want)
+	}
+}
+
+// TestNoBuffer tests that requests whose client opted out are not buffered
+// and counted as skipped instead.
+func TestNoBuffer(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	noBufferCtx := NewContextNoBuffer(context.Background())
+	// An opted out request does not start buffering.
+	if retryDone, err := h.b.WaitForFailoverEnd(noBufferCtx, keyspace, shard, failoverErr); err != nil || retryDone != nil {
+		t.Fatalf("opted out requests must not be buffered. err: %v retryDone: %v", err, retryDone)
+	}
+	if got := starts.Counts()[statsKeyJoined]; got != 0 {
+		t.Fatalf("an opted out request must not start buffering: got = %v starts", got)
+	}
+
+	// During a failover, opted out requests pass through as well.
+	h.startBuffering()
+	if retryDone, err := h.b.WaitForFailoverEnd(noBufferCtx, keyspace, shard, nil /* err */); err != nil || retryDone != nil {
+		t.Fatalf("opted out requests must not be buffered. err: %v retryDone: %v", err, retryDone)
+	}
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	snapshot := h.drain()
+
+	if got, want := snapshot.requestsSkipped[statsKeyJoined+"."+string(skippedClientOptOut)], int64(2); got != want {
+		t.Fatalf("wrong number of requests skipped due to the client opt-out: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsBuffered[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("only the request without the opt-out must be buffered: got = %v, want = %v", got, want)
+	}
+}
+
+// TestHighUtilization tests that a sustained high utilization is reported
+// exactly once per period.
+func TestHighUtilization(t *testing.T) {
+	flag.Set("buffer_high_util_threshold", "0.5")
+	flag.Set("buffer_high_util_duration", "2s")
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// 6 of 10 slots are used. The period of high utilization starts.
+	h.startBuffering()
+	h.enqueue(5)
+	if got := highUtilizationEvents.Counts()[statsKeyJoined]; got != 0 {
+		t.Fatalf("high utilization must not be reported before -buffer_high_util_duration passed: got = %v events", got)
+	}
+
+	// After 3 seconds, the next request reports the period.
+	h.clock.Advance(3 * time.Second)
+	h.enqueue(1)
+	if got, want := highUtilizationEvents.Counts()[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("sustained high utilization was not reported: got = %v, want = %v", got, want)
+	}
+
+	// The same period is not reported again.
+	h.clock.Advance(3 * time.Second)
+	h.enqueue(1)
+	h.injectNewMaster(1 * time.Second)
+	h.drain()
+	if got, want := highUtilizationEvents.Counts()[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("high utilization must be reported only once per period: got = %v, want = %v", got, want)
+	}
+}
+
+// TestRecencyGrace tests that a failover which starts within
+// -buffer_recency_grace before -buffer_min_time_between_failovers passed is
+// buffered while an earlier one is skipped.
+func TestRecencyGrace(t *testing.T) {
+	flag.Set("buffer_recency_grace", "5s")
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	h.runFailover(1, 1*time.Second)
+
+	// Before the grace period, the request is skipped.
+	h.clock.Advance(*minTimeBetweenFailovers - 6*time.Second)
+	if retryDone, err := h.b.WaitForFailoverEnd(context.Background(), keyspace, shard, failoverErr); err != nil || retryDone != nil {
+		t.Fatalf("request before the grace period should have been skipped: err: %v retryDone: %v", err, retryDone)
+	}
+	if got, want := requestsSkipped.Counts()[statsKeyJoinedLastFailoverTooRecent], int64(1); got != want {
+		t.Fatalf("wrong number of skipped requests: got = %v, want = %v", got, want)
+	}
+
+	// At the start of the grace period, the request is buffered.
+	h.clock.Advance(1 * time.Second)
+	h.startBuffering()
+	if got, want := recencyGraceBuffered.Counts()[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("the grace period should have been tracked: got = %v, want = %v", got, want)
+	}
+	h.injectNewMaster(1 * time.Second)
+	h.drain()
+
+	if got, want := requestsSkipped.Counts()[statsKeyJoinedLastFailoverTooRecent], int64(1); got != want {
+		t.Fatalf("the request within the grace period must not be skipped: got = %v, want = %v", got, want)
+	}
+	if got, want := starts.Counts()[statsKeyJoined], int64(2); got != want {
+		t.Fatalf("wrong number of failovers: got = %v, want = %v", got, want)
+	}
+}
+
+// TestDrainInProgress tests that DrainInProgress is only true during the drain
+// and that requests which pass through in that time are delayed.
+func TestDrainInProgress(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+	h.b.cfg.DrainBackpressureDelay = 100 * time.Millisecond
+
+	passthrough := func(ctx context.Context) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if retryDone, err := h.b.WaitForFailoverEnd(ctx, keyspace, shard, nil); err != nil || retryDone != nil {
+				t.Errorf("request should have passed through: err: %v retryDone: %v", err, retryDone)
+			}
+		}()
+		return done
+	}
+
+	// Block the retry of the buffered request to keep the buffer in the
+	// DRAINING state.
+	markRetryDone := make(chan struct{})
+	stopped := issueRequestAndBlockRetry(context.Background(), t, h.b, failoverErr, markRetryDone)
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if h.b.DrainInProgress(keyspace, shard) {
+		t.Fatal("DrainInProgress must be false while buffering")
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	if err := waitForState(h.b, stateDraining); err != nil {
+		t.Fatal(err)
+	}
+	if !h.b.DrainInProgress(keyspace, shard) {
+		t.Fatal("DrainInProgress must be true during the drain")
+	}
+	if h.b.DrainInProgress(keyspace, shard2) {
+		t.Fatal("DrainInProgress must be false for other shards")
+	}
+
+	// The request is delayed until the clock reaches the delay.
+	delayed := passthrough(context.Background())
+	select {
+	case <-delayed:
+		t.Fatal("request must be delayed during the drain")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// Advance the clock until the request's timer fired. It may not have
+	// been created yet when the clock is advanced the first time.
+	for released := false; !released; {
+		h.clock.Advance(h.b.cfg.DrainBackpressureDelay)
+		select {
+		case <-delayed:
+			released = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The delay ends early if the request's context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	<-passthrough(ctx)
+
+	close(markRetryDone)
+	if err := <-stopped; err != nil {
+		t.Fatalf("request should have been buffered and not returned an error: %v", err)
+	}
+	if err := waitForState(h.b, stateIdle); err != nil {
+		t.Fatal(err)
+	}
+	if h.b.DrainInProgress(keyspace, shard) {
+		t.Fatal("DrainInProgress must be false after the drain")
+	}
+	// The request is not delayed after the drain.
+	<-passthrough(context.Background())
+
+	if got, want := drainBackpressureEvents.Counts()[statsKeyJoined], int64(2); got != want {
+		t.Fatalf("wrong number of backpressure events: got = %v, want = %v", got, want)
+	}
+}
+
+// TestRequestDuringDrain tests that a request which sees a failover error
+// during the drain is not buffered and retried immediately.
+func TestRequestDuringDrain(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// Block the retry of the buffered request to keep the buffer in the
+	// DRAINING state.
+	markRetryDone := make(chan struct{})
+	stopped := issueRequestAndBlockRetry(context.Background(), t, h.b, failoverErr, markRetryDone)
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+	h.injectNewMaster(1 * time.Second)
+	if err := waitForState(h.b, stateDraining); err != nil {
+		t.Fatal(err)
+	}
+
+	retryDone, err := h.b.WaitForFailoverEnd(context.Background(), keyspace, shard, failoverErr)
+	if err != nil || retryDone != nil {
+		t.Fatalf("request during the drain should not have been buffered: err: %v retryDone: %v", err, retryDone)
+	}
+	if got, want := requestsDuringDrain.Counts()[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("wrong number of requests during the drain: got = %v, want = %v", got, want)
+	}
+	// Requests without a failover error are not counted.
+	if _, err := h.b.WaitForFailoverEnd(context.Background(), keyspace, shard, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	close(markRetryDone)
+	if err := <-stopped; err != nil {
+		t.Fatalf("request should have been buffered and not returned an error: %v", err)
+	}
+	if err := waitForState(h.b, stateIdle); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := takeStatsSnapshot()
+	if got, want := snapshot.requestsBuffered[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("only the first request should have been buffered: got = %v, want = %v", got, want)
+	}
+	if got, want := requestsDuringDrain.Counts()[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("wrong number of requests during the drain: got = %v, want = %v", got, want)
+	}
+}
+
 // resetVariables resets the task level variables. The code does not reset these
 // with very failover.
+// TestEnqueueDequeueLatency tests that the time spent in the critical
+// sections is recorded for concurrent requests.
+func TestEnqueueDequeueLatency(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// The timings cannot be reset. Compare against the previous counts instead.
+	enqueued := enqueueLatency.Counts()[statsKeyJoined]
+	dequeued := dequeueLatency.Counts()[statsKeyJoined]
+
+	h.startBuffering()
+	// Buffer 5 more requests concurrently. 3 of them will be canceled.
+	h.enqueue(2)
+	var cancels []context.CancelFunc
+	var canceled []chan error
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+		canceled = append(canceled, issueRequest(ctx, t, h.b, failoverErr))
+	}
+	if err := waitForRequestsInFlight(h.b, 6); err != nil {
+		t.Fatal(err)
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	for _, stopped := range canceled {
+		if err := isCanceledError(<-stopped); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.injectNewMaster(1 * time.Second)
+	h.drain()
+
+	if got, want := enqueueLatency.Counts()[statsKeyJoined]-enqueued, int64(6); got != want {
+		t.Errorf("wrong number of recorded enqueues: got = %v, want = %v", got, want)
+	}
+	// Only the canceled requests were removed. The drain is not tracked.
+	if got, want := dequeueLatency.Counts()[statsKeyJoined]-dequeued, int64(3); got != want {
+		t.Errorf("wrong number of recorded dequeues: got = %v, want = %v", got, want)
+	}
+}
+
+// TestTimeBetweenFailovers tests that the gap between the starts of two
+// consecutive failovers is recorded.
+func TestTimeBetweenFailovers(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	h.runFailover(1, 1*time.Second)
+	if got := timeBetweenFailoversMs.Counts()[statsKeyJoined]; got != 0 {
+		t.Fatalf("no gap must be recorded for the first failover: got = %v", got)
+	}
+
+	// The next failover must not be too recent.
+	h.clock.Advance(*minTimeBetweenFailovers)
+	h.runFailover(1, 1*time.Second)
+	want := int64((1*time.Second + *minTimeBetweenFailovers) / time.Millisecond)
+	if got := timeBetweenFailoversMs.Counts()[statsKeyJoined]; got != want {
+		t.Fatalf("wrong time between failovers: got = %v, want = %v", got, want)
+	}
+}
+
+// TestRequestsPerFailover tests that the number of buffered requests of each
+// failover is observed in the "BufferRequestsPerFailover" histogram.
+func TestRequestsPerFailover(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	for i, requests := range []int{1, 3, 7, 4} {
+		if i > 0 {
+			// The next failover must not be too recent.
+			h.clock.Advance(*minTimeBetweenFailovers)
+		}
+		h.runFailover(requests, 1*time.Second)
+	}
+
+	want := map[string]int64{
+		"0":    0,
+		"1":    1,
+		"5":    2,
+		"10":   1,
+		"50":   0,
+		"100":  0,
+		"500":  0,
+		"1000": 0,
+		"inf":  0,
+	}
+	counts := requestsPerFailover.Counts()
+	for bucket, count := range want {
+		if got := counts[statsKeyJoined+"."+bucket]; got != count {
+			t.Errorf("wrong count for bucket %v: got = %v, want = %v", bucket, got, count)
+		}
+	}
+}
+
+// BenchmarkEnqueueDequeue measures the overhead of buffering a request which
+// is canceled immediately i.e. it is added to and removed from the queue.
+func BenchmarkEnqueueDequeue(b *testing.B) {
+	resetVariables()
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
+	defer ResetFlagsForTesting()
+	buf := New()
+	defer func() {
+		buf.shutdown()
+		buf.waitForShutdown()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			retryDone, _ := buf.WaitForFailoverEnd(ctx, keyspace, shard, failoverErr)
+			if retryDone != nil {
+				retryDone()
+			}
+		}
+	})
+}
+
 func resetVariables() {
 	starts.ResetAll()
 	stops.ResetAll()
 
+	failoverDurationTotalMs.ResetAll()
+	requestsInFlightMaxTotal.ResetAll()
+	requestsDryRunMaxTotal.ResetAll()
 	utilizationSum.ResetAll()
 	utilizationDryRunSum.ResetAll()
+	failoverDurationEWMA.ResetAll()
+	utilizationEWMA.ResetAll()
+	timeBetweenFailoversMs.ResetAll()
+	highUtilizationEvents.ResetAll()
+	recencyGraceBuffered.ResetAll()
+	requestsPerFailover.ResetAll()
 
 	requestsBuffered.ResetAll()
 	requestsBufferedDryRun.ResetAll()
 	requestsDrained.ResetAll()
 	requestsEvicted.ResetAll()
 	requestsSkipped.ResetAll()
+	stopsDryRun.ResetAll()
+	requestsEvictedDryRun.ResetAll()
+	requestsSkippedDryRun.ResetAll()
+	requestsByPriority.ResetAll()
+	requestsDuringDrain.ResetAll()
+	drainBackpressureEvents.ResetAll()
 }
 
 // checkVariables makes sure that the invariants described in variables.go
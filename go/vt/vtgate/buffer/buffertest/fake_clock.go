@@ -0,0 +1,40 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffertest
+
+import (
+	"time"
+
+	"vitess.io/vitess/go/vt/vtgate/buffer"
+	"vitess.io/vitess/go/vt/vtgate/buffer/internal/fakeclock"
+)
+
+// FakeClock is a buffer.Clock whose time only moves when Advance() is called.
+// Timers fire when the clock is advanced to or beyond their deadline.
+type FakeClock struct {
+	*fakeclock.Clock
+}
+
+// NewFakeClock returns a FakeClock which starts at "now".
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{fakeclock.New(now)}
+}
+
+// NewTimer is part of the buffer.Clock interface.
+func (c *FakeClock) NewTimer(d time.Duration) buffer.Timer {
+	return c.Clock.NewTimer(d)
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package buffertest contains a test harness which simulates a full failover
+// cycle through a vtgate buffer. It can be used by the tests of packages which
+// build on top of the buffer.
+package buffertest
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/discovery"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+	"vitess.io/vitess/go/vt/vterrors"
+	"vitess.io/vitess/go/vt/vtgate/buffer"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
+)
+
+// FailoverErr is the error with which the requests of the harness fail. The
+// buffer detects it as failover and starts buffering.
+var FailoverErr = vterrors.New(vtrpcpb.Code_FAILED_PRECONDITION,
+	"vttablet: rpc error: code = 9 desc = gRPCServerError: retry: operation not allowed in state SHUTTING_DOWN")
+
+// waitTimeout is how long the harness waits for the buffer to reach a state.
+const waitTimeout = 10 * time.Second
+
+// FailoverHarness drives a fake failover of one keyspace/shard. Tests can
+// drive each step on their own or use RunFailover() for the whole cycle.
+type FailoverHarness struct {
+	t        *testing.T
+	keyspace string
+	shard    string
+	// Buffer is the buffer under test. Buffering is enabled for
+	// keyspace/shard only.
+	Buffer *buffer.Buffer
+	// Clock is the clock of Buffer. It must only be advanced by the test
+	// goroutine.
+	Clock *FakeClock
+	// masterUID is the UID of the current master tablet.
+	masterUID uint32
+	// before is the stats of keyspace/shard at the creation of the harness.
+	before buffer.ShardStats
+	// pending has one channel per request which was issued and not
+	// drained yet.
+	pending []chan error
+}
+
+// NewFailoverHarness enables buffering for "keyspace/shard" and creates a new
+// buffer with a FakeClock. The caller must call Close().
+func NewFailoverHarness(t *testing.T, keyspace, shard string) *FailoverHarness {
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
+
+	h := &FailoverHarness{
+		t:         t,
+		keyspace:  keyspace,
+		shard:     shard,
+		Clock:     NewFakeClock(time.Now()),
+		masterUID: 100,
+	}
+	h.Buffer = buffer.NewWithClock(h.Clock)
+
+	// Let the buffer know the current master. vtgate sees this at startup.
+	h.reportMaster()
+	h.before = h.shardStats()
+	return h
+}
+
+// Close shuts down the buffer, verifies that no request is buffered anymore
+// and resets the buffer flags.
+func (h *FailoverHarness) Close() {
+	defer buffer.ResetFlagsForTesting()
+	h.Buffer.Shutdown()
+	if got := h.Buffer.InFlight(h.keyspace, h.shard); len(got) != 0 {
+		h.t.Fatalf("no request must be buffered after the shutdown: got = %v", got)
+	}
+}
+
+// StartBuffering issues the first request with FailoverErr and waits until
+// the buffer is buffering.
+func (h *FailoverHarness) StartBuffering() {
+	h.pending = append(h.pending, h.issueRequest())
+	if err := h.waitFor("buffering", func() bool {
+		active, _, _ := h.Buffer.IsBuffering(h.keyspace, h.shard)
+		return active
+	}); err != nil {
+		h.t.Fatal(err)
+	}
+	h.waitForRequestsInFlight()
+}
+
+// Enqueue buffers "count" more requests.
+func (h *FailoverHarness) Enqueue(count int) {
+	for i := 0; i < count; i++ {
+		h.pending = append(h.pending, h.issueRequest())
+	}
+	h.waitForRequestsInFlight()
+}
+
+// InjectNewMaster advances the clock by "failoverDuration" and reports a new
+// master which ends the failover.
+func (h *FailoverHarness) InjectNewMaster(failoverDuration time.Duration) {
+	h.Clock.Advance(failoverDuration)
+	h.masterUID++
+	h.reportMaster()
+}
+
+// Drain waits until all pending requests have returned without an error and
+// the buffer is idle again. It returns the stats of keyspace/shard since the
+// creation of the harness.
+func (h *FailoverHarness) Drain() buffer.ShardStats {
+	for i, stopped := range h.pending {
+		if err := <-stopped; err != nil {
+			h.t.Fatalf("request %v should have been buffered and not returned an error: %v", i, err)
+		}
+	}
+	h.pending = nil
+	if err := h.waitFor("idle", func() bool {
+		active, _, _ := h.Buffer.IsBuffering(h.keyspace, h.shard)
+		return !active && !h.Buffer.DrainInProgress(h.keyspace, h.shard)
+	}); err != nil {
+		h.t.Fatal(err)
+	}
+	return statsSince(h.before, h.shardStats())
+}
+
+// RunFailover runs a full failover cycle with "requests" buffered requests
+// and returns the stats after the drain. See Drain().
+func (h *FailoverHarness) RunFailover(requests int, failoverDuration time.Duration) buffer.ShardStats {
+	h.StartBuffering()
+	h.Enqueue(requests - 1)
+	h.InjectNewMaster(failoverDuration)
+	return h.Drain()
+}
+
+// reportMaster reports the master "masterUID" with the current time as
+// externally reparented timestamp.
+func (h *FailoverHarness) reportMaster() {
+	h.Buffer.StatsUpdate(&discovery.TabletStats{
+		Tablet: &topodatapb.Tablet{
+			Alias:    &topodatapb.TabletAlias{Cell: "cell1", Uid: h.masterUID},
+			Keyspace: h.keyspace,
+			Shard:    h.shard,
+			Type:     topodatapb.TabletType_MASTER,
+		},
+		Target:                              &querypb.Target{Keyspace: h.keyspace, Shard: h.shard, TabletType: topodatapb.TabletType_MASTER},
+		TabletExternallyReparentedTimestamp: h.Clock.Now().Unix(),
+	})
+}
+
+// issueRequest simulates executing a request which goes through the buffer.
+// If the buffering returned an error, it will be sent on the returned
+// channel. Otherwise, the channel is closed after the retry.
+func (h *FailoverHarness) issueRequest() chan error {
+	bufferingStopped := make(chan error, 1)
+	go func() {
+		defer close(bufferingStopped)
+		retryDone, err := h.Buffer.WaitForFailoverEnd(context.Background(), h.keyspace, h.shard, FailoverErr)
+		if err != nil {
+			bufferingStopped <- err
+			return
+		}
+		if retryDone != nil {
+			retryDone()
+		}
+	}()
+	return bufferingStopped
+}
+
+// waitForRequestsInFlight waits until all pending requests are buffered.
+func (h *FailoverHarness) waitForRequestsInFlight() {
+	if err := h.waitFor(fmt.Sprintf("%v requests in flight", len(h.pending)), func() bool {
+		return len(h.Buffer.InFlight(h.keyspace, h.shard)) == len(h.pending)
+	}); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// waitFor polls "cond" for up to waitTimeout. "what" describes the condition
+// in the returned error.
+func (h *FailoverHarness) waitFor(what string, cond func() bool) error {
+	start := time.Now()
+	for !cond() {
+		if time.Since(start) > waitTimeout {
+			return fmt.Errorf("buffer of %v did not reach the state: %v", topoproto.KeyspaceShardString(h.keyspace, h.shard), what)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	return nil
+}
+
+// shardStats returns the current stats of keyspace/shard.
+func (h *FailoverHarness) shardStats() buffer.ShardStats {
+	for _, s := range h.Buffer.StatsSnapshot().Shards {
+		if s.Keyspace == h.keyspace && s.Shard == h.shard {
+			return s
+		}
+	}
+	h.t.Fatalf("buffer has no stats for %v", topoproto.KeyspaceShardString(h.keyspace, h.shard))
+	return buffer.ShardStats{}
+}
+
+// statsSince returns the difference between the two stats of the same shard.
+func statsSince(before, after buffer.ShardStats) buffer.ShardStats {
+	after.Starts -= before.Starts
+	after.Buffered -= before.Buffered
+	after.Drained -= before.Drained
+	for reason, v := range before.StopsByReason {
+		after.StopsByReason[reason] -= v
+	}
+	for reason, v := range before.EvictedByReason {
+		after.EvictedByReason[reason] -= v
+	}
+	for reason, v := range before.SkippedByReason {
+		after.SkippedByReason[reason] -= v
+	}
+	return after
+}
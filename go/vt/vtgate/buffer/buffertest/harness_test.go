@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffertest
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFailoverHarness tests the happy path of a failover with the harness.
+func TestFailoverHarness(t *testing.T) {
+	h := NewFailoverHarness(t, "ks1", "0")
+	stats := h.RunFailover(3, 2*time.Second)
+	h.Close()
+
+	for _, c := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"Starts", stats.Starts, 1},
+		{"StopsByReason[NewMasterSeen]", stats.StopsByReason["NewMasterSeen"], 1},
+		{"Buffered", stats.Buffered, 3},
+		{"Drained", stats.Drained, 3},
+		{"EvictedByReason[WindowExceeded]", stats.EvictedByReason["WindowExceeded"], 0},
+	} {
+		if c.got != c.want {
+			t.Errorf("wrong %v: got = %v, want = %v", c.name, c.got, c.want)
+		}
+	}
+
+	// A second harness only reports the stats of its own failovers.
+	h2 := NewFailoverHarness(t, "ks1", "0")
+	defer h2.Close()
+	if got := h2.RunFailover(1, 1*time.Second).Buffered; got != 1 {
+		t.Errorf("wrong Buffered of the second harness: got = %v, want = %v", got, 1)
+	}
+}
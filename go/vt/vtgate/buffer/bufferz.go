@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+
+	"vitess.io/vitess/go/acl"
+	"vitess.io/vitess/go/vt/log"
+)
+
+// BufferzHandler is the debug UI path for the buffer status page.
+const BufferzHandler = "/bufferz"
+
+var bufferzTmpl = template.Must(template.New("bufferz").Parse(`<!DOCTYPE html>
+<style type="text/css">
+	table.gridtable {
+		font-family: verdana,arial,sans-serif;
+		font-size: 11px;
+		border-width: 1px;
+		border-collapse: collapse;
+	}
+	table.gridtable th, table.gridtable td {
+		border-width: 1px;
+		padding: 5px;
+		border-style: solid;
+	}
+	table.gridtable th {
+		background-color: #dedede;
+		text-align: left;
+	}
+</style>
+<h3>Configuration</h3>
+<table class="gridtable">
+{{with .Config}}
+	<tr><th>Enabled</th><td>{{.Enabled}}</td></tr>
+	<tr><th>Dry-run</th><td>{{.DryRun}}</td></tr>
+	<tr><th>Size</th><td>{{.Size}}</td></tr>
+	<tr><th>Window</th><td>{{.Window}}</td></tr>
+	<tr><th>Max Failover Duration</th><td>{{.MaxFailoverDuration}}</td></tr>
+	<tr><th>Min Time Between Failovers</th><td>{{.MinTimeBetweenFailovers}}</td></tr>
+	<tr><th>Drain Concurrency</th><td>{{.DrainConcurrency}}</td></tr>
+	<tr><th>Keyspaces</th><td>{{range .Keyspaces}}{{.}}<br>{{end}}</td></tr>
+	<tr><th>Shards</th><td>{{range .Shards}}{{.}}<br>{{end}}</td></tr>
+{{end}}
+</table>
+<h3>Most Impacted Shard</h3>
+{{with .MostImpactedShard}}
+<table class="gridtable">
+	<tr><th>Keyspace/Shard</th><td>{{.Keyspace}}/{{.Shard}}</td></tr>
+	<tr><th>Utilization</th><td>{{.UtilizationPercent}}%</td></tr>
+</table>
+{{else}}
+No requests are buffered.
+{{end}}
+<h3>Buffered Requests</h3>
+{{range .InFlight}}
+<h4>{{.Keyspace}}/{{.Shard}}</h4>
+<table class="gridtable">
+	<tr><th>Buffered At</th><th>Deadline</th><th>Priority</th><th>Query</th></tr>
+	{{range .Requests}}
+	<tr><td>{{.BufferedAt}}</td><td>{{.Deadline}}</td><td>{{.Priority}}</td><td>{{.Fingerprint}}</td></tr>
+	{{end}}
+</table>
+{{else}}
+No requests are buffered.
+{{end}}
+<h3>Stop and Skip Reasons</h3>
+{{range .Reasons}}
+<h4>{{.Keyspace}}/{{.Shard}}</h4>
+<table class="gridtable">
+	<tr><th></th><th>Lifetime</th><th>Last {{$.ReasonWindow}}</th></tr>
+	<tr><th>Stops</th><td>{{range .StopsLifetime}}{{.Reason}}: {{printf "%.1f" .Percent}}% ({{.Count}})<br>{{end}}</td><td>{{range .StopsRecent}}{{.Reason}}: {{printf "%.1f" .Percent}}% ({{.Count}})<br>{{end}}</td></tr>
+	<tr><th>Skips</th><td>{{range .SkipsLifetime}}{{.Reason}}: {{printf "%.1f" .Percent}}% ({{.Count}})<br>{{end}}</td><td>{{range .SkipsRecent}}{{.Reason}}: {{printf "%.1f" .Percent}}% ({{.Count}})<br>{{end}}</td></tr>
+</table>
+{{else}}
+No stops or skips were recorded.
+{{end}}
+`))
+
+// bufferzData holds everything which is shown on the /bufferz page.
+type bufferzData struct {
+	Config BufferConfig
+	// MostImpactedShard is the shard with the highest buffer utilization.
+	// It's nil if no requests are buffered.
+	MostImpactedShard *ShardUtilization
+	// InFlight has an entry for each shard with buffered requests.
+	InFlight []ShardRequestInfos
+	// Reasons has the breakdown of the stop and skip reasons of each shard
+	// which had at least one. ReasonWindow is the recent period.
+	Reasons      []ShardReasons
+	ReasonWindow time.Duration
+}
+
+// RegisterBufferzHandler exposes the status of this buffer at BufferzHandler.
+// It must be called at most once per process.
+func (b *Buffer) RegisterBufferzHandler() {
+	http.HandleFunc(BufferzHandler, func(w http.ResponseWriter, r *http.Request) {
+		bufferzHandler(b, w, r)
+	})
+}
+
+func bufferzHandler(b *Buffer, w http.ResponseWriter, r *http.Request) {
+	if err := acl.CheckAccessHTTP(r, acl.DEBUGGING); err != nil {
+		acl.SendError(w, err)
+		return
+	}
+
+	data := &bufferzData{
+		Config:            b.ConfigSnapshot(),
+		MostImpactedShard: b.MostImpactedShard(),
+		InFlight:          b.inFlightAll(),
+		Reasons:           b.reasonsAll(),
+		ReasonWindow:      reasonWindow,
+	}
+
+	if r.FormValue("format") == "json" {
+		js, err := json.Marshal(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+		return
+	}
+
+	if err := bufferzTmpl.Execute(w, data); err != nil {
+		log.Errorf("bufferz: couldn't execute template: %v", err)
+	}
+}
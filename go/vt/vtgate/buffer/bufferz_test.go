@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBufferzHandler(t *testing.T) {
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_keyspace_shards", "ks1,ks2/-80")
+	flag.Set("buffer_size", "23")
+	defer ResetFlagsForTesting()
+	b := New()
+
+	req, _ := http.NewRequest("GET", BufferzHandler, nil)
+	resp := httptest.NewRecorder()
+	bufferzHandler(b, resp, req)
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, want := range []string{
+		"<tr><th>Enabled</th><td>true</td></tr>",
+		"<tr><th>Size</th><td>23</td></tr>",
+		"<tr><th>Window</th><td>10s</td></tr>",
+		"<tr><th>Keyspaces</th><td>ks1<br></td></tr>",
+		"<tr><th>Shards</th><td>ks2/-80<br></td></tr>",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("bufferz page does not contain: %v\nbody:\n%s", want, body)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", BufferzHandler+"?format=json", nil)
+	resp = httptest.NewRecorder()
+	bufferzHandler(b, resp, req)
+	var got bufferzData
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatalf("bufferz did not return valid JSON: %v", err)
+	}
+	if got.Config.Size != 23 {
+		t.Fatalf("wrong size in JSON output: got = %v, want = %v", got.Config.Size, 23)
+	}
+}
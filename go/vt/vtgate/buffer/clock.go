@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import "time"
+
+// Clock is the source of the current time and of the timers which are used
+// by the buffer e.g. for the window and the max failover duration.
+// It's replaced in tests to make them deterministic. See NewWithClock() and
+// buffertest.FakeClock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer which fires after "d". If "d" is <= 0, the
+	// timer fires immediately.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of time.Timer which is used by the buffer.
+type Timer interface {
+	// C returns the channel on which the time is delivered when the timer
+	// fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. See time.Timer.Stop().
+	Stop() bool
+}
+
+// realClock is the clock of the production code.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{t: time.NewTimer(d)}
+}
+
+// realTimer wraps a time.Timer.
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r *realTimer) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r *realTimer) Stop() bool {
+	return r.t.Stop()
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/vtgate/buffer/internal/fakeclock"
+)
+
+// fakeClock adapts fakeclock.Clock to the Clock interface.
+type fakeClock struct {
+	*fakeclock.Clock
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{fakeclock.New(now)}
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	return c.Clock.NewTimer(d)
+}
+
+// TestMaxDurationFakeClock tests that the window and the max failover duration
+// are enforced deterministically when the clock is advanced.
+func TestMaxDurationFakeClock(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	stopped := issueRequest(context.Background(), t, h.b, failoverErr)
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The request is evicted exactly at the end of its window.
+	h.clock.Advance(*window - 1*time.Millisecond)
+	if got := h.b.getOrCreateBuffer(keyspace, shard).sizeForTesting(); got != 1 {
+		t.Fatalf("request must not be evicted before the window: got = %v requests buffered", got)
+	}
+	h.clock.Advance(1 * time.Millisecond)
+	if err := <-stopped; err != nil {
+		t.Fatalf("evicted request should not return an error: %v", err)
+	}
+	if err := waitForRequestsExceededWindow(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Buffering stops exactly at the max failover duration.
+	h.clock.Advance(*maxFailoverDuration - *window - 1*time.Millisecond)
+	if got := h.b.getOrCreateBuffer(keyspace, shard).stateForTesting(); got != stateBuffering {
+		t.Fatalf("buffering must not stop before the max failover duration: got = %v", got)
+	}
+	h.clock.Advance(1 * time.Millisecond)
+	if err := waitForState(h.b, stateIdle); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stops.Counts()[statsKeyJoined+"."+string(stopMaxFailoverDurationExceeded)], int64(1); got != want {
+		t.Fatalf("wrong number of stops due to the max failover duration: got = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"sync"
+	"time"
+
+	"vitess.io/vitess/go/stats"
+)
+
+// BufferEventType is the type of a BufferEvent.
+type BufferEventType string
+
+const (
+	// BufferEventStart is sent when a buffering (including a dry-run
+	// buffering) started. The reason is the error which triggered it.
+	BufferEventStart BufferEventType = "Start"
+	// BufferEventStop is sent when a buffering stopped. The reason is one of
+	// the stop reasons e.g. "NewMasterSeen".
+	BufferEventStop BufferEventType = "Stop"
+	// BufferEventEvict is sent when a buffered request was evicted. The reason
+	// is one of the evict reasons e.g. "WindowExceeded".
+	BufferEventEvict BufferEventType = "Evict"
+	// BufferEventHighUtilization is sent when the utilization of a shard
+	// stayed above -buffer_high_util_threshold for longer than
+	// -buffer_high_util_duration. The reason says for how long.
+	BufferEventHighUtilization BufferEventType = "HighUtilization"
+)
+
+// BufferEvent is a buffering lifecycle event. See Buffer.Subscribe().
+type BufferEvent struct {
+	Type     BufferEventType
+	Keyspace string
+	Shard    string
+	Reason   string
+	// Time is the time of the event as seen by the buffer.
+	Time time.Time
+}
+
+// subscriberChannelSize is the number of events which are queued for a
+// subscriber. Further events are dropped until the subscriber caught up.
+const subscriberChannelSize = 100
+
+// eventPublisher delivers the events to all subscribers. It's shared by all
+// shardBuffer instances.
+type eventPublisher struct {
+	// mu guards "subscribers". It is also held while sending to a channel to
+	// make sure that the channel is not closed concurrently.
+	mu          sync.Mutex
+	subscribers map[chan BufferEvent]bool
+	// dropped counts the events which were not delivered to a subscriber.
+	dropped *stats.Counter
+}
+
+func newEventPublisher(dropped *stats.Counter) *eventPublisher {
+	return &eventPublisher{
+		subscribers: make(map[chan BufferEvent]bool),
+		dropped:     dropped,
+	}
+}
+
+// Subscribe returns a channel which receives the start, stop and evict events
+// of all shards. The returned function must be called to unsubscribe. It
+// closes the channel.
+// The channel is bounded. If the subscriber does not keep up, events are
+// dropped and counted in "BufferSubscriberEventsDropped".
+func (b *Buffer) Subscribe() (<-chan BufferEvent, func()) {
+	return b.events.subscribe()
+}
+
+func (p *eventPublisher) subscribe() (<-chan BufferEvent, func()) {
+	c := make(chan BufferEvent, subscriberChannelSize)
+	p.mu.Lock()
+	p.subscribers[c] = true
+	p.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(p.subscribers, c)
+			close(c)
+		})
+	}
+	return c, cancel
+}
+
+// publish sends the event to all subscribers without blocking.
+func (p *eventPublisher) publish(e BufferEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c := range p.subscribers {
+		select {
+		case c <- e:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// publishEvent sends an event for this shard to all subscribers.
+func (sb *shardBuffer) publishEvent(eventType BufferEventType, reason string) {
+	sb.events.publish(BufferEvent{
+		Type:     eventType,
+		Keyspace: sb.keyspace,
+		Shard:    sb.shard,
+		Reason:   reason,
+		Time:     sb.clock.Now(),
+	})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestSubscribe(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	events, cancel := h.b.Subscribe()
+
+	// The first request starts buffering and is evicted after the window.
+	stopped := issueRequest(context.Background(), t, h.b, failoverErr)
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+	h.clock.Advance(*window)
+	if err := <-stopped; err != nil {
+		t.Fatalf("evicted request should not return an error: %v", err)
+	}
+	// The second request is drained after the failover.
+	stopped = issueRequest(context.Background(), t, h.b, failoverErr)
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+	h.injectNewMaster(1 * time.Second)
+	if err := <-stopped; err != nil {
+		t.Fatalf("request should have been buffered and not returned an error: %v", err)
+	}
+	if err := waitForState(h.b, stateIdle); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []BufferEvent{
+		{Type: BufferEventStart, Keyspace: keyspace, Shard: shard, Reason: failoverErr.Error()},
+		{Type: BufferEventEvict, Keyspace: keyspace, Shard: shard, Reason: evictedWindowExceeded},
+		{Type: BufferEventStop, Keyspace: keyspace, Shard: shard, Reason: string(stopFailoverEndDetected)},
+	} {
+		select {
+		case got := <-events:
+			got.Time = time.Time{}
+			if got != want {
+				t.Fatalf("wrong event: got = %+v, want = %+v", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("event was not delivered: %+v", want)
+		}
+	}
+
+	cancel()
+	if e, ok := <-events; ok {
+		t.Fatalf("channel must be closed after cancel: got event %+v", e)
+	}
+	// Canceling twice is a no-op.
+	cancel()
+}
+
+func TestSubscribeDropsEvents(t *testing.T) {
+	p := newEventPublisher(subscriberEventsDropped)
+	events, cancel := p.subscribe()
+	defer cancel()
+
+	dropped := subscriberEventsDropped.Get()
+	for i := 0; i < subscriberChannelSize+1; i++ {
+		p.publish(BufferEvent{Type: BufferEventStart, Keyspace: keyspace, Shard: shard})
+	}
+	if got, want := len(events), subscriberChannelSize; got != want {
+		t.Fatalf("wrong number of queued events: got = %v, want = %v", got, want)
+	}
+	if got, want := subscriberEventsDropped.Get()-dropped, int64(1); got != want {
+		t.Fatalf("wrong number of dropped events: got = %v, want = %v", got, want)
+	}
+}
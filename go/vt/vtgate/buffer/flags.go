@@ -20,6 +20,8 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 	"time"
 
@@ -32,51 +34,146 @@ var (
 
 	window                  = flag.Duration("buffer_window", 10*time.Second, "Duration for how long a request should be buffered at most.")
 	size                    = flag.Int("buffer_size", 10, "Maximum number of buffered requests in flight (across all ongoing failovers).")
+	softLimit               = flag.Float64("buffer_soft_limit", 1.0, "Fraction of -buffer_size above which only high priority requests are buffered. Requests with a lower priority are skipped instead. 1.0 disables the soft limit.")
+	maxPerShard             = flag.Float64("buffer_max_per_shard", 0, "If > 0, maximum number of buffered requests in flight per shard. Values < 1 are a fraction of the size of the shard's pool (-buffer_size or -buffer_pools), values >= 1 an absolute number. Requests above it are skipped. This way, a single shard cannot occupy the whole buffer. 0 disables the limit.")
+	maxBytes                = flag.Int64("buffer_max_bytes", 0, "If > 0, hard cap on the total size (in bytes) of all buffered requests. A request which would exceed it evicts buffered requests of its shard (see -buffer_full_policy). 0 disables the cap.")
+	fullPolicy              = flag.String("buffer_full_policy", fullPolicyEvictOldest, "Which buffered request is evicted when the buffer is full (-buffer_size or -buffer_max_bytes): evict_oldest or evict_largest. evict_largest reclaims the most memory fastest.")
 	maxFailoverDuration     = flag.Duration("buffer_max_failover_duration", 20*time.Second, "Stop buffering completely if a failover takes longer than this duration.")
+	maxDurationJitter       = flag.Duration("buffer_max_duration_jitter", 0, "If > 0, the -buffer_max_failover_duration of each failover is randomly shortened by up to this duration. This spreads out the force-stops of shards which started buffering at the same time.")
 	minTimeBetweenFailovers = flag.Duration("buffer_min_time_between_failovers", 1*time.Minute, "Minimum time between the end of a failover and the start of the next one (tracked per shard). Faster consecutive failovers will not trigger buffering.")
+	recencyGrace            = flag.Duration("buffer_recency_grace", 0, "If > 0, a failover which starts within this duration before -buffer_min_time_between_failovers has passed is buffered instead of skipped. This avoids skipping failovers which start just at the boundary. \"BufferRecencyGraceBuffered\" counts them. 0 disables the grace period.")
 
-	drainConcurrency = flag.Int("buffer_drain_concurrency", 1, "Maximum number of requests retried simultaneously. More concurrency will increase the load on the MASTER vttablet when draining the buffer.")
+	highUtilThreshold = flag.Float64("buffer_high_util_threshold", 0, "If > 0, fraction of the pool slots above which the buffered requests of a shard count as high utilization. If the utilization stays above it for longer than -buffer_high_util_duration, \"BufferHighUtilizationEvents\" is increased once. This indicates that the buffer is too small. 0 disables the detection.")
+	highUtilDuration  = flag.Duration("buffer_high_util_duration", 5*time.Second, "How long the utilization of a shard must stay above -buffer_high_util_threshold until it is reported.")
+
+	ewmaAlpha = flag.Float64("buffer_ewma_alpha", 0.3, "Smoothing factor of the exponentially weighted moving averages of the failover duration and the buffer utilization. Must be > 0 and <= 1. Higher values give more weight to recent failovers.")
+
+	pools         = flag.String("buffer_pools", "", "Comma-separated list of name:size entries. Each entry defines a pool with its own number of buffer slots. Keyspaces assigned to a pool (see -buffer_keyspace_pools) can only use the slots of their pool. All other keyspaces share the -buffer_size slots.")
+	keyspacePools = flag.String("buffer_keyspace_pools", "", "Comma-separated list of keyspace:pool entries which assign a keyspace to a pool defined in -buffer_pools.")
+
+	drainConcurrency       = flag.Int("buffer_drain_concurrency", 1, "Maximum number of requests retried simultaneously. More concurrency will increase the load on the MASTER vttablet when draining the buffer.")
+	drainBackpressureDelay = flag.Duration("buffer_drain_backpressure_delay", 0, "If > 0, new requests which are not buffered are delayed by up to this duration while the buffer of their shard is draining. This reduces the load on the new MASTER vttablet while it receives the retried requests. \"BufferDrainBackpressureEvents\" counts the delayed requests. 0 disables the delay.")
 
 	shards = flag.String("buffer_keyspace_shards", "", "If not empty, limit buffering to these entries (comma separated). Entry format: keyspace or keyspace/shard. Requires --enable_buffer=true.")
+
+	persistLastFailoverStats = flag.Bool("buffer_persist_last_failover_stats", false, "Persist the \"BufferLast*\" stats of each shard in the topology of the local cell and restore them at startup. This way, the stats of the last failover survive a vtgate restart. Each failover causes a topology write.")
+
+	allowSyntheticFailover = flag.Bool("buffer_allow_synthetic_failover", false, "Allow synthetic failovers (see Buffer.TriggerSyntheticFailover()) which buffer requests without an actual reparent. Only use this in test environments.")
 )
 
-func resetFlagsForTesting() {
-	// Set all flags to their default value.
-	flag.Set("enable_buffer", "false")
-	flag.Set("enable_buffer_dry_run", "false")
-	flag.Set("buffer_size", "10")
-	flag.Set("buffer_window", "10s")
-	flag.Set("buffer_keyspace_shards", "")
-	flag.Set("buffer_max_failover_duration", "20s")
-	flag.Set("buffer_min_time_between_failovers", "1m")
+// ResetFlagsForTesting sets all buffer flags to their default value.
+// It's meant for tests which change the flags.
+func ResetFlagsForTesting() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if isBufferFlag(f.Name) {
+			flag.Set(f.Name, f.DefValue)
+		}
+	})
+}
+
+// isBufferFlag returns true if "name" is one of the flags of this file.
+func isBufferFlag(name string) bool {
+	return name == "enable_buffer" || name == "enable_buffer_dry_run" || strings.HasPrefix(name, "buffer_")
+}
+
+// configFromFlags returns the configuration specified by the flags. It's
+// called when the buffer is created. The returned error is the first invalid
+// flag or combination of flags. See verifyConfig().
+func configFromFlags() (BufferConfig, error) {
+	poolSizes, keyspacePools, err := parsePools(*pools, *keyspacePools)
+	if err != nil {
+		return BufferConfig{}, err
+	}
+	keyspaces, shards := keyspaceShardsToSets(*shards)
+	cfg := BufferConfig{
+		Enabled:                  *enabled,
+		DryRun:                   *enabledDryRun,
+		Size:                     *size,
+		SoftLimit:                *softLimit,
+		Window:                   *window,
+		MaxFailoverDuration:      *maxFailoverDuration,
+		MaxDurationJitter:        *maxDurationJitter,
+		MinTimeBetweenFailovers:  *minTimeBetweenFailovers,
+		DrainConcurrency:         *drainConcurrency,
+		DrainBackpressureDelay:   *drainBackpressureDelay,
+		EWMAAlpha:                *ewmaAlpha,
+		Keyspaces:                setToSortedList(keyspaces),
+		Shards:                   setToSortedList(shards),
+		AllowSyntheticFailover:   *allowSyntheticFailover,
+		PersistLastFailoverStats: *persistLastFailoverStats,
+		PoolSizes:                poolSizes,
+		KeyspacePools:            keyspacePools,
+		MaxBytes:                 *maxBytes,
+		FullPolicy:               *fullPolicy,
+		HighUtilThreshold:        *highUtilThreshold,
+		HighUtilDuration:         *highUtilDuration,
+		MaxPerShard:              *maxPerShard,
+		RecencyGrace:             *recencyGrace,
+	}
+	if err := verifyConfig(cfg); err != nil {
+		return BufferConfig{}, err
+	}
+	return cfg, nil
 }
 
-func verifyFlags() error {
-	if *window < 1*time.Second {
-		return fmt.Errorf("-buffer_window must be >= 1s (specified value: %v)", *window)
+// verifyConfig checks each value of "cfg" and then their combination with
+// validateConfig(). The errors refer to the flags which set the values.
+func verifyConfig(cfg BufferConfig) error {
+	if cfg.Window < 1*time.Second {
+		return fmt.Errorf("-buffer_window must be >= 1s (specified value: %v)", cfg.Window)
+	}
+	if cfg.MaxDurationJitter < 0 {
+		return fmt.Errorf("-buffer_max_duration_jitter must be >= 0 (specified value: %v)", cfg.MaxDurationJitter)
+	}
+	if cfg.RecencyGrace < 0 {
+		return fmt.Errorf("-buffer_recency_grace must be >= 0 (specified value: %v)", cfg.RecencyGrace)
+	}
+	if cfg.Size < 1 {
+		return fmt.Errorf("-buffer_size must be >= 1 (specified value: %d)", cfg.Size)
 	}
-	if *window > *maxFailoverDuration {
-		return fmt.Errorf("-buffer_window must be <= -buffer_max_failover_duration: %v vs. %v", *window, *maxFailoverDuration)
+	if cfg.SoftLimit <= 0 || cfg.SoftLimit > 1 {
+		return fmt.Errorf("-buffer_soft_limit must be > 0 and <= 1 (specified value: %v)", cfg.SoftLimit)
 	}
-	if *size < 1 {
-		return fmt.Errorf("-buffer_size must be >= 1 (specified value: %d)", *size)
+	if cfg.MaxPerShard < 0 || (cfg.MaxPerShard > 1 && cfg.MaxPerShard != math.Trunc(cfg.MaxPerShard)) {
+		return fmt.Errorf("-buffer_max_per_shard must be >= 0 and either a fraction < 1 or a whole number (specified value: %v)", cfg.MaxPerShard)
 	}
-	if *minTimeBetweenFailovers < *maxFailoverDuration*time.Duration(2) {
-		return fmt.Errorf("-buffer_min_time_between_failovers should be at least twice the length of -buffer_max_failover_duration: %v vs. %v", *minTimeBetweenFailovers, *maxFailoverDuration)
+	if cfg.MaxBytes < 0 {
+		return fmt.Errorf("-buffer_max_bytes must be >= 0 (specified value: %d)", cfg.MaxBytes)
+	}
+	if cfg.FullPolicy != fullPolicyEvictOldest && cfg.FullPolicy != fullPolicyEvictLargest {
+		return fmt.Errorf("-buffer_full_policy must be %v or %v (specified value: %v)", fullPolicyEvictOldest, fullPolicyEvictLargest, cfg.FullPolicy)
+	}
+	if cfg.HighUtilThreshold < 0 || cfg.HighUtilThreshold > 1 {
+		return fmt.Errorf("-buffer_high_util_threshold must be >= 0 and <= 1 (specified value: %v)", cfg.HighUtilThreshold)
+	}
+	if cfg.HighUtilThreshold > 0 && cfg.HighUtilDuration <= 0 {
+		return fmt.Errorf("-buffer_high_util_duration must be > 0 if -buffer_high_util_threshold is set (specified value: %v)", cfg.HighUtilDuration)
+	}
+	if cfg.EWMAAlpha <= 0 || cfg.EWMAAlpha > 1 {
+		return fmt.Errorf("-buffer_ewma_alpha must be > 0 and <= 1 (specified value: %v)", cfg.EWMAAlpha)
 	}
 
-	if *drainConcurrency < 1 {
-		return fmt.Errorf("-buffer_drain_concurrency must be >= 1 (specified value: %d)", *drainConcurrency)
+	if cfg.DrainConcurrency < 1 {
+		return fmt.Errorf("-buffer_drain_concurrency must be >= 1 (specified value: %d)", cfg.DrainConcurrency)
+	}
+	if cfg.DrainBackpressureDelay < 0 {
+		return fmt.Errorf("-buffer_drain_backpressure_delay must be >= 0 (specified value: %v)", cfg.DrainBackpressureDelay)
 	}
 
-	if *shards != "" && !*enabled {
-		return fmt.Errorf("-buffer_keyspace_shards=%v also requires that -enable_buffer is set", *shards)
+	// Parse the pools again to check them as if they were set by the flags.
+	if _, _, err := parsePools(poolsToFlags(cfg.PoolSizes, cfg.KeyspacePools)); err != nil {
+		return err
 	}
-	if *enabled && *enabledDryRun && *shards == "" {
+
+	keyspaceShards := strings.Join(append(append([]string{}, cfg.Keyspaces...), cfg.Shards...), ",")
+	if keyspaceShards != "" && !cfg.Enabled {
+		return fmt.Errorf("-buffer_keyspace_shards=%v also requires that -enable_buffer is set", keyspaceShards)
+	}
+	if cfg.Enabled && cfg.DryRun && keyspaceShards == "" {
 		return errors.New("both the dry-run mode and actual buffering is enabled. To avoid ambiguity, keyspaces and shards for actual buffering must be explicitly listed in --buffer_keyspace_shards")
 	}
 
-	keyspaces, shards := keyspaceShardsToSets(*shards)
+	keyspaces, shards := keyspaceShardsToSets(keyspaceShards)
 	for s := range shards {
 		keyspace, _, err := topoproto.ParseKeyspaceShard(s)
 		if err != nil {
@@ -87,9 +184,109 @@ func verifyFlags() error {
 		}
 	}
 
+	return validateConfig(cfg)
+}
+
+// validateConfig checks the invariants between the flags. A violation would
+// not crash the buffer, but make it behave in surprising ways e.g. never
+// buffer low priority requests. The returned error says how to fix it.
+func validateConfig(cfg BufferConfig) error {
+	if cfg.Window > cfg.MaxFailoverDuration {
+		return fmt.Errorf("-buffer_window must be <= -buffer_max_failover_duration: %v vs. %v Otherwise, requests are never evicted because their window was exceeded. Decrease -buffer_window or increase -buffer_max_failover_duration", cfg.Window, cfg.MaxFailoverDuration)
+	}
+	if cfg.Window > cfg.MaxFailoverDuration-cfg.MaxDurationJitter {
+		return fmt.Errorf("-buffer_window must be <= -buffer_max_failover_duration minus -buffer_max_duration_jitter: %v vs. %v - %v Decrease -buffer_window or -buffer_max_duration_jitter", cfg.Window, cfg.MaxFailoverDuration, cfg.MaxDurationJitter)
+	}
+	if cfg.MinTimeBetweenFailovers < cfg.MaxFailoverDuration*time.Duration(2) {
+		return fmt.Errorf("-buffer_min_time_between_failovers should be at least twice the length of -buffer_max_failover_duration: %v vs. %v Otherwise, a failover which was force-stopped may start buffering again right away. Increase -buffer_min_time_between_failovers to at least %v", cfg.MinTimeBetweenFailovers, cfg.MaxFailoverDuration, 2*cfg.MaxFailoverDuration)
+	}
+
+	if cfg.RecencyGrace >= cfg.MinTimeBetweenFailovers {
+		return fmt.Errorf("-buffer_recency_grace must be < -buffer_min_time_between_failovers: %v vs. %v Otherwise, every failover is buffered regardless of the last one. Decrease -buffer_recency_grace", cfg.RecencyGrace, cfg.MinTimeBetweenFailovers)
+	}
+
+	sizes := map[string]int{defaultPoolName: cfg.Size}
+	names := []string{defaultPoolName}
+	for name, size := range cfg.PoolSizes {
+		sizes[name] = size
+		names = append(names, name)
+	}
+	// Sort the pools to report the same violation every time.
+	sort.Strings(names)
+	for _, name := range names {
+		size := sizes[name]
+		// Requests with a lower priority may use the slots up to the first one
+		// at or above the soft limit.
+		if cfg.SoftLimit < 1 && int(math.Ceil(cfg.SoftLimit*float64(size))) >= size {
+			return fmt.Errorf("-buffer_soft_limit=%v reserves none of the %v slots of pool %v for high priority requests. Decrease -buffer_soft_limit to at most %v or increase the size of the pool to at least %v", cfg.SoftLimit, size, name, float64(size-1)/float64(size), int(math.Ceil(1/(1-cfg.SoftLimit))))
+		}
+	}
 	return nil
 }
 
+// BufferConfig is a snapshot of the effective buffer configuration.
+// See Buffer.ConfigSnapshot().
+type BufferConfig struct {
+	Enabled                 bool
+	DryRun                  bool
+	Size                    int
+	SoftLimit               float64
+	Window                  time.Duration
+	MaxFailoverDuration     time.Duration
+	MaxDurationJitter       time.Duration
+	MinTimeBetweenFailovers time.Duration
+	DrainConcurrency        int
+	EWMAAlpha               float64
+	// Keyspaces and Shards list the entries to which actual buffering is
+	// limited. If both are empty (and Enabled is true), all shards are buffered.
+	Keyspaces []string
+	Shards    []string
+	// AllowSyntheticFailover is true if TriggerSyntheticFailover() may be used.
+	AllowSyntheticFailover bool
+	// PersistLastFailoverStats is true if the last failover stats are written
+	// to the topology. See SetTopoServer().
+	PersistLastFailoverStats bool
+	// PoolSizes has the number of slots of each pool defined in -buffer_pools.
+	PoolSizes map[string]int
+	// KeyspacePools has the pool of each keyspace which is assigned to one.
+	KeyspacePools map[string]string
+	// MaxBytes is the cap on the total size of all buffered requests (0 if
+	// disabled). FullPolicy selects the request to evict if the buffer is full.
+	MaxBytes   int64
+	FullPolicy string
+	// HighUtilThreshold is the fraction of the pool slots above which a
+	// shard counts as highly utilized (0 if disabled). It must stay above it
+	// for HighUtilDuration until it is reported.
+	HighUtilThreshold float64
+	HighUtilDuration  time.Duration
+	// MaxPerShard limits the buffered requests of a single shard (0 if
+	// disabled). Values < 1 are a fraction of the size of the shard's pool.
+	MaxPerShard float64
+	// RecencyGrace is the period before the end of MinTimeBetweenFailovers in
+	// which a new failover is buffered anyway (0 if disabled).
+	RecencyGrace time.Duration
+	// DrainBackpressureDelay is the maximum delay of a new request which is
+	// not buffered during the drain of its shard (0 if disabled).
+	DrainBackpressureDelay time.Duration
+}
+
+// ConfigSnapshot returns the configuration which is in effect. It was read
+// from the flags when the buffer was created.
+func (b *Buffer) ConfigSnapshot() BufferConfig {
+	cfg := *b.cfg
+	cfg.Keyspaces = append([]string{}, b.cfg.Keyspaces...)
+	cfg.Shards = append([]string{}, b.cfg.Shards...)
+	cfg.PoolSizes = make(map[string]int)
+	for name, size := range b.cfg.PoolSizes {
+		cfg.PoolSizes[name] = size
+	}
+	cfg.KeyspacePools = make(map[string]string)
+	for keyspace, pool := range b.cfg.KeyspacePools {
+		cfg.KeyspacePools[keyspace] = pool
+	}
+	return cfg
+}
+
 // keyspaceShardsToSets converts a comma separated list of keyspace[/shard]
 // entries to two sets: keyspaces (if the shard is not specified) and shards (if
 // both keyspace and shard is specified).
@@ -110,14 +307,12 @@ func keyspaceShardsToSets(list string) (map[string]bool, map[string]bool) {
 	return keyspaces, shards
 }
 
-// setToString joins the set to a ", " separated string.
-func setToString(set map[string]bool) string {
-	result := ""
+// setToSortedList returns the items of the set as sorted list.
+func setToSortedList(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
 	for item := range set {
-		if result != "" {
-			result += ", "
-		}
-		result += item
+		list = append(list, item)
 	}
-	return result
+	sort.Strings(list)
+	return list
 }
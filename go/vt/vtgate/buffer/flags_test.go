@@ -18,37 +18,239 @@ package buffer
 
 import (
 	"flag"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestVerifyFlags(t *testing.T) {
 	// Verify that the non-allowed (non-trivial) flag combinations are caught.
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 
 	flag.Set("buffer_keyspace_shards", "ks1/0")
-	if err := verifyFlags(); err == nil || !strings.Contains(err.Error(), "also requires that") {
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "also requires that") {
 		t.Fatalf("List of shards requires --enable_buffer. err: %v", err)
 	}
 
-	resetFlagsForTesting()
+	ResetFlagsForTesting()
 	flag.Set("enable_buffer", "true")
 	flag.Set("enable_buffer_dry_run", "true")
-	if err := verifyFlags(); err == nil || !strings.Contains(err.Error(), "To avoid ambiguity") {
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "To avoid ambiguity") {
 		t.Fatalf("Dry-run and non-dry-run mode together require an explicit list of shards for actual buffering. err: %v", err)
 	}
 
-	resetFlagsForTesting()
+	ResetFlagsForTesting()
 	flag.Set("enable_buffer", "true")
 	flag.Set("buffer_keyspace_shards", "ks1//0")
-	if err := verifyFlags(); err == nil || !strings.Contains(err.Error(), "invalid shard path") {
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "invalid shard path") {
 		t.Fatalf("Invalid shard names are not allowed. err: %v", err)
 	}
 
-	resetFlagsForTesting()
+	ResetFlagsForTesting()
+	flag.Set("buffer_soft_limit", "1.5")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_soft_limit must be") {
+		t.Fatalf("The soft limit must be a fraction of the buffer size. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_recency_grace", "-1s")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_recency_grace must be") {
+		t.Fatalf("The recency grace must not be negative. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_max_per_shard", "2.5")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_max_per_shard must be") {
+		t.Fatalf("The max per shard must be a fraction or a whole number. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_max_bytes", "-1")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_max_bytes must be") {
+		t.Fatalf("The max bytes must not be negative. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_full_policy", "evict_newest")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_full_policy must be") {
+		t.Fatalf("Unknown full policies are not allowed. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_high_util_threshold", "1.5")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_high_util_threshold must be") {
+		t.Fatalf("The high utilization threshold must be a fraction of the pool size. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_high_util_threshold", "0.8")
+	flag.Set("buffer_high_util_duration", "0")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_high_util_duration must be") {
+		t.Fatalf("The high utilization duration must be set with the threshold. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_ewma_alpha", "0")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "-buffer_ewma_alpha must be") {
+		t.Fatalf("The EWMA alpha must be within (0, 1]. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_max_duration_jitter", "15s")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "minus -buffer_max_duration_jitter") {
+		t.Fatalf("The jitter must not shorten the max failover duration below the buffer window. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
 	flag.Set("enable_buffer", "true")
 	flag.Set("buffer_keyspace_shards", "ks1,ks1/0")
-	if err := verifyFlags(); err == nil || !strings.Contains(err.Error(), "has overlapping entries") {
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "has overlapping entries") {
 		t.Fatalf("Listed keyspaces and shards must not overlap. err: %v", err)
 	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_pools", "p1:0")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "invalid size") {
+		t.Fatalf("Pools must have at least one slot. err: %v", err)
+	}
+
+	ResetFlagsForTesting()
+	flag.Set("buffer_pools", "p1:5")
+	flag.Set("buffer_keyspace_pools", "ks1:p2")
+	if _, err := configFromFlags(); err == nil || !strings.Contains(err.Error(), "not defined in -buffer_pools") {
+		t.Fatalf("Keyspaces can only be assigned to defined pools. err: %v", err)
+	}
+}
+
+func TestResetFlagsForTesting(t *testing.T) {
+	defer ResetFlagsForTesting()
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_window", "5s")
+	flag.Set("buffer_soft_limit", "0.5")
+	flag.Set("buffer_drain_concurrency", "4")
+	flag.Set("buffer_pools", "p1:5")
+
+	ResetFlagsForTesting()
+	flags := 0
+	flag.VisitAll(func(f *flag.Flag) {
+		if !isBufferFlag(f.Name) {
+			return
+		}
+		flags++
+		if got, want := f.Value.String(), f.DefValue; got != want {
+			t.Errorf("flag -%v was not reset: got = %v, want = %v", f.Name, got, want)
+		}
+	})
+	if flags == 0 {
+		t.Fatal("no buffer flags found")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := BufferConfig{
+		Size:                    10,
+		SoftLimit:               1.0,
+		Window:                  10 * time.Second,
+		MaxFailoverDuration:     20 * time.Second,
+		MinTimeBetweenFailovers: 1 * time.Minute,
+		DrainConcurrency:        1,
+	}
+	if err := validateConfig(valid); err != nil {
+		t.Fatalf("default configuration must be valid: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		modify  func(cfg *BufferConfig)
+		wantErr string
+	}{
+		{
+			name:    "window longer than the max failover duration",
+			modify:  func(cfg *BufferConfig) { cfg.Window = 30 * time.Second },
+			wantErr: "Decrease -buffer_window or increase -buffer_max_failover_duration",
+		},
+		{
+			name:    "jitter shortens the max failover duration below the window",
+			modify:  func(cfg *BufferConfig) { cfg.MaxDurationJitter = 15 * time.Second },
+			wantErr: "Decrease -buffer_window or -buffer_max_duration_jitter",
+		},
+		{
+			name:    "min time between failovers shorter than twice the max failover duration",
+			modify:  func(cfg *BufferConfig) { cfg.MinTimeBetweenFailovers = 30 * time.Second },
+			wantErr: "Increase -buffer_min_time_between_failovers to at least 40s",
+		},
+		{
+			name:    "recency grace covers the whole min time between failovers",
+			modify:  func(cfg *BufferConfig) { cfg.RecencyGrace = 1 * time.Minute },
+			wantErr: "Decrease -buffer_recency_grace",
+		},
+		{
+			name: "soft limit reserves no slot",
+			modify: func(cfg *BufferConfig) {
+				cfg.Size = 2
+				cfg.SoftLimit = 0.75
+			},
+			wantErr: "Decrease -buffer_soft_limit to at most 0.5 or increase the size of the pool to at least 4",
+		},
+		{
+			name: "soft limit reserves no slot of a pool",
+			modify: func(cfg *BufferConfig) {
+				cfg.SoftLimit = 0.5
+				cfg.PoolSizes = map[string]int{"p1": 1}
+			},
+			wantErr: "none of the 1 slots of pool p1",
+		},
+	}
+	for _, tc := range testCases {
+		cfg := valid
+		tc.modify(&cfg)
+		if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%v: wrong error: got = %v, want substring = %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	// A drain concurrency greater than the pool sizes is harmless. The drain
+	// just never uses all of its Go routines.
+	cfg := valid
+	cfg.DrainConcurrency = 11
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("drain concurrency greater than the buffer size must be allowed: %v", err)
+	}
+}
+
+func TestConfigSnapshot(t *testing.T) {
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_keyspace_shards", "ks2,ks1,ks3/-80")
+	flag.Set("buffer_size", "23")
+	flag.Set("buffer_window", "5s")
+	flag.Set("buffer_pools", "p1:5")
+	flag.Set("buffer_keyspace_pools", "ks2:p1")
+	flag.Set("buffer_max_failover_duration", "30s")
+	defer ResetFlagsForTesting()
+	b := New()
+	// Flags which are changed after the construction must not be reflected.
+	flag.Set("buffer_max_failover_duration", "25s")
+
+	want := BufferConfig{
+		Enabled:                 true,
+		DryRun:                  false,
+		Size:                    23,
+		SoftLimit:               1.0,
+		Window:                  5 * time.Second,
+		MaxFailoverDuration:     30 * time.Second,
+		MaxDurationJitter:       0,
+		MinTimeBetweenFailovers: 1 * time.Minute,
+		DrainConcurrency:        1,
+		EWMAAlpha:               0.3,
+		Keyspaces:               []string{"ks1", "ks2"},
+		Shards:                  []string{"ks3/-80"},
+		PoolSizes:               map[string]int{"p1": 5},
+		KeyspacePools:           map[string]string{"ks2": "p1"},
+		FullPolicy:              fullPolicyEvictOldest,
+		HighUtilDuration:        5 * time.Second,
+	}
+	if got := b.ConfigSnapshot(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong config snapshot: got = %#v, want = %#v", got, want)
+	}
 }
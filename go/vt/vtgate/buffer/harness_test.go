@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/discovery"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+// This file contains the white-box variant of buffertest.FailoverHarness for
+// the tests of this package. They cannot use buffertest because it imports
+// this package. Unlike buffertest, it resets the stats variables, returns all
+// of them as a snapshot and checks their invariants when it's closed.
+
+// statsSnapshot is a copy of all stats variables at a given point in time.
+// The map keys are the joined stats keys e.g. "ks1.0" or "ks1.0.Reason".
+type statsSnapshot struct {
+	starts                 map[string]int64
+	stops                  map[string]int64
+	failoverDurationSumMs  map[string]int64
+	utilizationSum         map[string]int64
+	utilizationDryRunSum   map[string]int64
+	requestsBuffered       map[string]int64
+	requestsBufferedDryRun map[string]int64
+	requestsDrained        map[string]int64
+	requestsEvicted        map[string]int64
+	requestsSkipped        map[string]int64
+	requestsByPriority     map[string]int64
+}
+
+// takeStatsSnapshot returns the current values of all stats variables.
+func takeStatsSnapshot() *statsSnapshot {
+	return &statsSnapshot{
+		starts:                 starts.Counts(),
+		stops:                  stops.Counts(),
+		failoverDurationSumMs:  failoverDurationSumMs.Counts(),
+		utilizationSum:         utilizationSum.Counts(),
+		utilizationDryRunSum:   utilizationDryRunSum.Counts(),
+		requestsBuffered:       requestsBuffered.Counts(),
+		requestsBufferedDryRun: requestsBufferedDryRun.Counts(),
+		requestsDrained:        requestsDrained.Counts(),
+		requestsEvicted:        requestsEvicted.Counts(),
+		requestsSkipped:        requestsSkipped.Counts(),
+		requestsByPriority:     requestsByPriority.Counts(),
+	}
+}
+
+// failoverHarness drives a fake failover of "keyspace/shard".
+type failoverHarness struct {
+	t *testing.T
+	b *Buffer
+	// clock is the buffer's clock. It's only advanced by the test goroutine.
+	clock *fakeClock
+	// pending has one channel per request which was issued and not
+	// drained yet.
+	pending []chan error
+}
+
+// newFailoverHarness resets all variables, enables buffering for
+// "keyspace/shard" and creates a new buffer. The caller must call close().
+func newFailoverHarness(t *testing.T) *failoverHarness {
+	resetVariables()
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_keyspace_shards", topoproto.KeyspaceShardString(keyspace, shard))
+
+	h := &failoverHarness{
+		t:     t,
+		clock: newFakeClock(time.Now()),
+	}
+	h.b = NewWithClock(h.clock)
+
+	// Let the buffer know the current master. vtgate sees this at startup.
+	h.b.StatsUpdate(&discovery.TabletStats{
+		Tablet:                              oldMaster,
+		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
+		TabletExternallyReparentedTimestamp: h.clock.Now().Unix(),
+	})
+	return h
+}
+
+// close shuts down the buffer, verifies that all pool slots were returned
+// and that the stats invariants hold.
+func (h *failoverHarness) close() {
+	defer ResetFlagsForTesting()
+	h.b.shutdown()
+	h.b.waitForShutdown()
+	if err := waitForPoolSlots(h.b, *size); err != nil {
+		h.t.Fatal(err)
+	}
+	checkVariables(h.t)
+}
+
+// startBuffering issues the first request with a failover error and waits
+// until the buffer is BUFFERING.
+func (h *failoverHarness) startBuffering() {
+	h.pending = append(h.pending, issueRequest(context.Background(), h.t, h.b, failoverErr))
+	if err := waitForState(h.b, stateBuffering); err != nil {
+		h.t.Fatal(err)
+	}
+	if err := waitForRequestsInFlight(h.b, len(h.pending)); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// enqueue buffers "count" more requests.
+func (h *failoverHarness) enqueue(count int) {
+	for i := 0; i < count; i++ {
+		h.pending = append(h.pending, issueRequest(context.Background(), h.t, h.b, failoverErr))
+	}
+	if err := waitForRequestsInFlight(h.b, len(h.pending)); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// injectNewMaster advances the clock by "failoverDuration" and reports the
+// new master which ends the failover.
+func (h *failoverHarness) injectNewMaster(failoverDuration time.Duration) {
+	h.clock.Advance(failoverDuration)
+	h.b.StatsUpdate(&discovery.TabletStats{
+		Tablet:                              newMaster,
+		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
+		TabletExternallyReparentedTimestamp: h.clock.Now().Unix(),
+	})
+}
+
+// drain waits until all pending requests have returned without an error and
+// the buffer is IDLE again. It returns the stats at that point.
+func (h *failoverHarness) drain() *statsSnapshot {
+	for i, stopped := range h.pending {
+		if err := <-stopped; err != nil {
+			h.t.Fatalf("request %v should have been buffered and not returned an error: %v", i, err)
+		}
+	}
+	h.pending = nil
+	if err := waitForState(h.b, stateIdle); err != nil {
+		h.t.Fatal(err)
+	}
+	return takeStatsSnapshot()
+}
+
+// runFailover runs a full failover cycle with "requests" buffered requests
+// and returns the stats after the drain.
+func (h *failoverHarness) runFailover(requests int, failoverDuration time.Duration) *statsSnapshot {
+	h.startBuffering()
+	h.enqueue(requests - 1)
+	h.injectNewMaster(failoverDuration)
+	return h.drain()
+}
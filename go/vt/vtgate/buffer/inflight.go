@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/sqlparser"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+)
+
+// maxInFlightRequests caps the number of entries returned by InFlight().
+const maxInFlightRequests = 100
+
+const (
+	// fingerprintUnknown is used for requests without a query in the context.
+	fingerprintUnknown = "<unknown>"
+	// fingerprintUnparsable is used if the query could not be redacted. The
+	// query itself is never returned in that case.
+	fingerprintUnparsable = "<unparsable>"
+)
+
+// RequestInfo describes a request which is currently buffered.
+type RequestInfo struct {
+	// Fingerprint is the query with all literals replaced by bind variables.
+	Fingerprint string
+	// BufferedAt is the time when the request was added to the buffer.
+	BufferedAt time.Time
+	// Deadline is the time when the request will be evicted from the buffer
+	// because it exceeded the buffering window.
+	Deadline time.Time
+	// Priority is the drain priority of the request.
+	Priority Priority
+}
+
+// ShardRequestInfos lists the buffered requests of a shard.
+type ShardRequestInfos struct {
+	Keyspace string
+	Shard    string
+	Requests []RequestInfo
+}
+
+type queryKey int
+
+// NewContextWithQuery returns a context which carries the query of the
+// request. It's only used to show the redacted query for buffered requests.
+func NewContextWithQuery(ctx context.Context, sql string) context.Context {
+	return context.WithValue(ctx, queryKey(0), sql)
+}
+
+// queryFromContext returns the query from the context or "" if it has none.
+func queryFromContext(ctx context.Context) string {
+	sql, _ := ctx.Value(queryKey(0)).(string)
+	return sql
+}
+
+// fingerprint returns "sql" with all literals redacted.
+func fingerprint(sql string) string {
+	if sql == "" {
+		return fingerprintUnknown
+	}
+	redacted, err := sqlparser.RedactSQLQuery(sql)
+	if err != nil {
+		return fingerprintUnparsable
+	}
+	return redacted
+}
+
+// InFlight returns the requests which are currently buffered for
+// keyspace/shard in the order of arrival. At most maxInFlightRequests entries
+// are returned. All literals in the queries are redacted.
+func (b *Buffer) InFlight(keyspace, shard string) []RequestInfo {
+	b.mu.RLock()
+	sb, ok := b.buffers[topoproto.KeyspaceShardString(keyspace, shard)]
+	b.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return sb.inFlight()
+}
+
+// inFlightAll returns the buffered requests for all shards which currently
+// have at least one buffered request. The list is sorted by keyspace/shard.
+func (b *Buffer) inFlightAll() []ShardRequestInfos {
+	b.mu.RLock()
+	buffers := make([]*shardBuffer, 0, len(b.buffers))
+	for _, sb := range b.buffers {
+		buffers = append(buffers, sb)
+	}
+	b.mu.RUnlock()
+
+	var result []ShardRequestInfos
+	for _, sb := range buffers {
+		requests := sb.inFlight()
+		if len(requests) == 0 {
+			continue
+		}
+		result = append(result, ShardRequestInfos{
+			Keyspace: sb.keyspace,
+			Shard:    sb.shard,
+			Requests: requests,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Keyspace != result[j].Keyspace {
+			return result[i].Keyspace < result[j].Keyspace
+		}
+		return result[i].Shard < result[j].Shard
+	})
+	return result
+}
+
+func (sb *shardBuffer) inFlight() []RequestInfo {
+	sb.mu.RLock()
+	queue := sb.queue
+	if len(queue) > maxInFlightRequests {
+		queue = queue[:maxInFlightRequests]
+	}
+	requests := make([]RequestInfo, 0, len(queue))
+	for _, e := range queue {
+		requests = append(requests, RequestInfo{
+			// Redact the query below, outside of the lock.
+			Fingerprint: e.query,
+			BufferedAt:  e.bufferedAt,
+			Deadline:    e.deadline,
+			Priority:    e.priority,
+		})
+	}
+	sb.mu.RUnlock()
+
+	for i := range requests {
+		requests[i].Fingerprint = fingerprint(requests[i].Fingerprint)
+	}
+	return requests
+}
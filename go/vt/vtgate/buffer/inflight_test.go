@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestInFlight(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	if got := h.b.InFlight(keyspace, shard); len(got) != 0 {
+		t.Fatalf("no requests should be buffered: %v", got)
+	}
+
+	queries := []string{
+		"select * from t where id = 1 and name = 'secret'",
+		"not a valid 'secret' query",
+		"",
+	}
+	for i, sql := range queries {
+		ctx := context.Background()
+		if sql != "" {
+			ctx = NewContextWithQuery(ctx, sql)
+		}
+		h.pending = append(h.pending, issueRequest(ctx, t, h.b, failoverErr))
+		if err := waitForRequestsInFlight(h.b, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := h.b.InFlight(keyspace, shard)
+	if len(got) != len(queries) {
+		t.Fatalf("wrong number of buffered requests: got = %v, want = %v", len(got), len(queries))
+	}
+	for i, want := range []string{
+		"select * from t where id = :redacted1 and name = :redacted2",
+		fingerprintUnparsable,
+		fingerprintUnknown,
+	} {
+		if got[i].Fingerprint != want {
+			t.Errorf("wrong fingerprint for request %v: got = %v, want = %v", i, got[i].Fingerprint, want)
+		}
+		if !got[i].BufferedAt.Equal(h.clock.Now()) {
+			t.Errorf("wrong buffering time for request %v: got = %v, want = %v", i, got[i].BufferedAt, h.clock.Now())
+		}
+		if want := h.clock.Now().Add(*window); !got[i].Deadline.Equal(want) {
+			t.Errorf("wrong deadline for request %v: got = %v, want = %v", i, got[i].Deadline, want)
+		}
+	}
+
+	// The redacted queries are shown on /bufferz.
+	req, _ := http.NewRequest("GET", BufferzHandler, nil)
+	resp := httptest.NewRecorder()
+	bufferzHandler(h.b, resp, req)
+	body, _ := ioutil.ReadAll(resp.Body)
+	if want := "<h4>ks1/0</h4>"; !strings.Contains(string(body), want) {
+		t.Fatalf("bufferz page does not contain: %v\nbody:\n%s", want, body)
+	}
+	if strings.Contains(string(body), "secret") {
+		t.Fatalf("bufferz page must not contain literals:\n%s", body)
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	h.drain()
+	if got := h.b.InFlight(keyspace, shard); len(got) != 0 {
+		t.Fatalf("no requests should be buffered after the drain: %v", got)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeclock provides a clock for the tests of package buffer and
+// package buffertest. It does not import package buffer to avoid an import
+// cycle with the internal tests of package buffer. Both packages adapt
+// Clock to the buffer.Clock interface.
+package fakeclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a clock whose time only moves when Advance() is called.
+// Timers fire when the clock is advanced to or beyond their deadline.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*Timer]bool
+}
+
+// New returns a Clock which starts at "now".
+func New(now time.Time) *Clock {
+	return &Clock{
+		now:    now,
+		timers: make(map[*Timer]bool),
+	}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer which fires when the clock is advanced by "d". If
+// "d" is <= 0, the timer fires immediately.
+func (c *Clock) NewTimer(d time.Duration) *Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &Timer{
+		clock:    c,
+		deadline: c.now.Add(d),
+		c:        make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers[t] = true
+	return t
+}
+
+// Advance moves the clock forward by "d" and fires all timers which expired.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.deadline.After(c.now) {
+			t.c <- c.now
+			delete(c.timers, t)
+		}
+	}
+}
+
+// Timer is a timer of Clock.
+type Timer struct {
+	clock    *Clock
+	deadline time.Time
+	c        chan time.Time
+}
+
+// C returns the channel on which the time is delivered when the timer fires.
+func (t *Timer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop prevents the timer from firing. It returns false if the timer already
+// fired or was stopped.
+func (t *Timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
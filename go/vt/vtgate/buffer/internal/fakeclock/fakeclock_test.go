@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeclock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Now()
+	c := New(start)
+
+	t1 := c.NewTimer(1 * time.Second)
+	t2 := c.NewTimer(2 * time.Second)
+	t3 := c.NewTimer(3 * time.Second)
+	if !t3.Stop() {
+		t.Fatal("active timer must be stoppable")
+	}
+
+	c.Advance(1 * time.Second)
+	if got, want := c.Now(), start.Add(1*time.Second); !got.Equal(want) {
+		t.Fatalf("wrong time: got = %v, want = %v", got, want)
+	}
+	select {
+	case <-t1.C():
+	default:
+		t.Fatal("timer should have fired at its deadline")
+	}
+	select {
+	case <-t2.C():
+		t.Fatal("timer must not fire before its deadline")
+	default:
+	}
+
+	c.Advance(5 * time.Second)
+	select {
+	case <-t2.C():
+	default:
+		t.Fatal("timer should have fired after its deadline")
+	}
+	select {
+	case <-t3.C():
+		t.Fatal("stopped timer must not fire")
+	default:
+	}
+	if t1.Stop() {
+		t.Fatal("fired timer must not be reported as active")
+	}
+
+	select {
+	case <-c.NewTimer(0).C():
+	default:
+		t.Fatal("timer without a duration should fire immediately")
+	}
+}
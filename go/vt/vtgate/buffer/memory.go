@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"golang.org/x/net/context"
+)
+
+// This file contains the accounting of the memory which is used by buffered
+// requests (-buffer_max_bytes) and the policy which selects the request to
+// evict when the buffer is full (-buffer_full_policy).
+
+const (
+	// fullPolicyEvictOldest evicts the request which was buffered first.
+	fullPolicyEvictOldest = "evict_oldest"
+	// fullPolicyEvictLargest evicts the largest request (see
+	// NewContextWithRequestSize()) to reclaim the most memory fastest.
+	// Requests of the same size are evicted oldest first.
+	fullPolicyEvictLargest = "evict_largest"
+)
+
+type requestSizeKey int
+
+// NewContextWithRequestSize returns a context which carries the size (in
+// bytes) of the request e.g. of its query and bind variables. The size counts
+// against -buffer_max_bytes while the request is buffered.
+func NewContextWithRequestSize(ctx context.Context, bytes int64) context.Context {
+	return context.WithValue(ctx, requestSizeKey(0), bytes)
+}
+
+// requestSizeFromContext returns the size of the request or 0 if the context
+// has none.
+func requestSizeFromContext(ctx context.Context) int64 {
+	bytes, _ := ctx.Value(requestSizeKey(0)).(int64)
+	return bytes
+}
+
+// tryReserveBytes adds "bytes" to "bytesInUse" if the total stays within
+// -buffer_max_bytes. It returns false otherwise.
+func (sb *shardBuffer) tryReserveBytes(bytes int64) bool {
+	if sb.cfg.MaxBytes <= 0 {
+		// Disabled.
+		sb.vars.bytesInUse.Add(bytes)
+		return true
+	}
+	for {
+		used := sb.vars.bytesInUse.Get()
+		if used+bytes > sb.cfg.MaxBytes {
+			return false
+		}
+		if sb.vars.bytesInUse.CompareAndSwap(used, used+bytes) {
+			return true
+		}
+	}
+}
+
+// evictionCandidateLocked returns the index of the queue entry which should
+// be evicted next according to -buffer_full_policy.
+// The queue must not be empty.
+func (sb *shardBuffer) evictionCandidateLocked() int {
+	if sb.cfg.FullPolicy != fullPolicyEvictLargest {
+		return 0
+	}
+	candidate := 0
+	for i, e := range sb.queue {
+		if e.size > sb.queue[candidate].size {
+			candidate = i
+		}
+	}
+	return candidate
+}
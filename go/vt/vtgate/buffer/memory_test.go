@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestMaxBytesFullPolicy tests that a request which would exceed
+// -buffer_max_bytes evicts buffered requests of its shard in the order of
+// -buffer_full_policy.
+func TestMaxBytesFullPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		// wantEvicted has the names of the requests which must be evicted.
+		wantEvicted map[string]bool
+		wantBytes   int64
+	}{
+		{
+			// The largest request alone makes room for the new request.
+			policy:      fullPolicyEvictLargest,
+			wantEvicted: map[string]bool{"large": true},
+			wantBytes:   150,
+		},
+		{
+			// The first request has no size and does not make room.
+			policy:      fullPolicyEvictOldest,
+			wantEvicted: map[string]bool{"first": true, "small": true},
+			wantBytes:   170,
+		},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			flag.Set("buffer_max_bytes", "250")
+			flag.Set("buffer_full_policy", tc.policy)
+			h := newFailoverHarness(t)
+			defer h.close()
+
+			h.startBuffering()
+			requests := map[string]chan error{"first": h.pending[0]}
+			h.pending = nil
+			for i, r := range []struct {
+				name  string
+				bytes int64
+			}{
+				{"small", 100},
+				{"large", 120},
+			} {
+				requests[r.name] = issueRequest(NewContextWithRequestSize(context.Background(), r.bytes), t, h.b, failoverErr)
+				if err := waitForRequestsInFlight(h.b, i+2); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := bytesInUse.Get(), int64(220); got != want {
+				t.Fatalf("wrong bytes in use: got = %v, want = %v", got, want)
+			}
+
+			// 220 + 50 bytes exceed the cap.
+			requests["new"] = issueRequest(NewContextWithRequestSize(context.Background(), 50), t, h.b, failoverErr)
+			for name := range tc.wantEvicted {
+				if err := isEvictedError(<-requests[name]); err != nil {
+					t.Fatalf("request %v: %v", name, err)
+				}
+				delete(requests, name)
+			}
+			if err := waitForRequestsInFlight(h.b, 4-len(tc.wantEvicted)); err != nil {
+				t.Fatal(err)
+			}
+			if got := bytesInUse.Get(); got != tc.wantBytes {
+				t.Fatalf("wrong bytes in use after the eviction: got = %v, want = %v", got, tc.wantBytes)
+			}
+
+			for _, stopped := range requests {
+				h.pending = append(h.pending, stopped)
+			}
+			h.injectNewMaster(1 * time.Second)
+			snapshot := h.drain()
+			if got, want := snapshot.requestsEvicted[statsKeyJoined+"."+string(evictedMaxBytes)], int64(len(tc.wantEvicted)); got != want {
+				t.Errorf("wrong evicted requests count: got = %v, want = %v", got, want)
+			}
+			if got := bytesInUse.Get(); got != 0 {
+				t.Errorf("all bytes must be returned after the drain: got = %v", got)
+			}
+		})
+	}
+}
+
+// TestMaxBytesRequestTooLarge tests that a request which is larger than
+// -buffer_max_bytes is not buffered and does not evict buffered requests.
+func TestMaxBytesRequestTooLarge(t *testing.T) {
+	flag.Set("buffer_max_bytes", "100")
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	h.startBuffering()
+	small := issueRequest(NewContextWithRequestSize(context.Background(), 60), t, h.b, failoverErr)
+	if err := waitForRequestsInFlight(h.b, 2); err != nil {
+		t.Fatal(err)
+	}
+	h.pending = append(h.pending, small)
+
+	stopped := issueRequest(NewContextWithRequestSize(context.Background(), 101), t, h.b, failoverErr)
+	if err := <-stopped; err != ErrBufferFull {
+		t.Fatalf("request should have been skipped: got = %v, want = %v", err, ErrBufferFull)
+	}
+	if got, want := requestsSkipped.Counts()[statsKeyJoined+"."+string(skippedMaxBytes)], int64(1); got != want {
+		t.Errorf("wrong skipped requests count: got = %v, want = %v", got, want)
+	}
+	if got, want := bytesInUse.Get(), int64(60); got != want {
+		t.Errorf("wrong bytes in use: got = %v, want = %v", got, want)
+	}
+	if err := waitForRequestsInFlight(h.b, 2); err != nil {
+		t.Fatalf("the buffered requests must not be evicted: %v", err)
+	}
+
+	h.injectNewMaster(1 * time.Second)
+	snapshot := h.drain()
+	if got := snapshot.requestsEvicted[statsKeyJoined+"."+string(evictedMaxBytes)]; got != 0 {
+		t.Errorf("no request must be evicted: got = %v", got)
+	}
+}
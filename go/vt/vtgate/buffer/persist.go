@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+)
+
+// This file contains the persistence of the "BufferLast*" stats in the
+// topology (-buffer_persist_last_failover_stats). Without it, the stats are
+// lost when vtgate restarts.
+
+// lastFailoverStatsPath is the directory in the topology of the local cell
+// which has one file per shard: <lastFailoverStatsPath>/<keyspace>/<shard>.
+const lastFailoverStatsPath = "buffer_last_failover_stats"
+
+// lastFailoverStats has the values of the "BufferLast*" stats of a shard.
+// It is stored as JSON.
+type lastFailoverStats struct {
+	FailoverDurationMs    int64
+	RequestsInFlightMax   int64
+	RequestsDryRunMax     int64
+	MaxFailoverDurationMs int64
+}
+
+// statsPersister writes the stats of the last failover to the topology.
+// It's shared by all shardBuffer instances and does nothing until
+// Buffer.SetTopoServer() was called.
+type statsPersister struct {
+	// mu guards "conn".
+	mu sync.Mutex
+	// conn is the topology connection of the local cell. It is nil if the
+	// persistence is disabled.
+	conn topo.Conn
+}
+
+func newStatsPersister() *statsPersister {
+	return &statsPersister{}
+}
+
+// SetTopoServer watches the SrvKeyspace objects of "cell" to stop the
+// buffering of deleted keyspaces and shards (see topologyWatcher).
+// It also enables the persistence of the last failover stats if
+// -buffer_persist_last_failover_stats is set. The stats are written to the
+// topology of "cell" and the previously persisted values are restored.
+// It must be called before the first failover.
+func (b *Buffer) SetTopoServer(ctx context.Context, ts *topo.Server, cell string) error {
+	b.watcher.start(ts, cell)
+
+	if !b.cfg.PersistLastFailoverStats {
+		return nil
+	}
+	conn, err := ts.ConnForCell(ctx, cell)
+	if err != nil {
+		return fmt.Errorf("failed to get the topology connection for cell %v: %v", cell, err)
+	}
+	if err := restoreLastFailoverStats(ctx, conn, b.vars); err != nil {
+		return err
+	}
+	b.persister.setConn(conn)
+	return nil
+}
+
+func (p *statsPersister) setConn(conn topo.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn = conn
+}
+
+func (p *statsPersister) getConn() topo.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn
+}
+
+// saveLastFailoverStats writes the stats of keyspace/shard. Errors are only
+// logged because the stats are not essential.
+func saveLastFailoverStats(conn topo.Conn, keyspace, shard string, stats lastFailoverStats) {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		log.Warningf("Failed to marshal the last failover stats of shard: %s: %v", topoproto.KeyspaceShardString(keyspace, shard), err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *topo.RemoteOperationTimeout)
+	defer cancel()
+	if _, err := conn.Update(ctx, path.Join(lastFailoverStatsPath, keyspace, shard), data, nil /* version */); err != nil {
+		log.Warningf("Failed to persist the last failover stats of shard: %s: %v", topoproto.KeyspaceShardString(keyspace, shard), err)
+	}
+}
+
+// persistLastFailoverStatsLocked writes the current "BufferLast*" stats of
+// this shard in the background. It's a no-op if the persistence is disabled.
+func (sb *shardBuffer) persistLastFailoverStatsLocked() {
+	conn := sb.persister.getConn()
+	if conn == nil {
+		return
+	}
+	stats := lastFailoverStats{
+		FailoverDurationMs:    sb.vars.lastFailoverDurationMs.Counts()[sb.statsKeyJoined],
+		RequestsInFlightMax:   sb.vars.lastRequestsInFlightMax.Counts()[sb.statsKeyJoined],
+		RequestsDryRunMax:     sb.vars.lastRequestsDryRunMax.Counts()[sb.statsKeyJoined],
+		MaxFailoverDurationMs: sb.vars.lastMaxFailoverDurationMs.Counts()[sb.statsKeyJoined],
+	}
+	// Use a new Go routine to not block on the topology while holding the lock.
+	sb.wg.Add(1)
+	go func() {
+		defer sb.wg.Done()
+		saveLastFailoverStats(conn, sb.keyspace, sb.shard, stats)
+	}()
+}
+
+// restoreLastFailoverStats sets the "BufferLast*" stats in "vars" to the
+// values which were persisted in the topology.
+func restoreLastFailoverStats(ctx context.Context, conn topo.Conn, vars *variables) error {
+	keyspaces, err := conn.ListDir(ctx, lastFailoverStatsPath, false /* full */)
+	if err != nil {
+		if topo.IsErrType(err, topo.NoNode) {
+			// Nothing was persisted yet.
+			return nil
+		}
+		return fmt.Errorf("failed to list the persisted last failover stats: %v", err)
+	}
+	for _, keyspace := range keyspaces {
+		shards, err := conn.ListDir(ctx, path.Join(lastFailoverStatsPath, keyspace.Name), false /* full */)
+		if err != nil {
+			return fmt.Errorf("failed to list the persisted last failover stats of keyspace: %v: %v", keyspace.Name, err)
+		}
+		for _, shard := range shards {
+			data, _, err := conn.Get(ctx, path.Join(lastFailoverStatsPath, keyspace.Name, shard.Name))
+			if err != nil {
+				return fmt.Errorf("failed to read the persisted last failover stats of shard: %v: %v", topoproto.KeyspaceShardString(keyspace.Name, shard.Name), err)
+			}
+			var stats lastFailoverStats
+			if err := json.Unmarshal(data, &stats); err != nil {
+				return fmt.Errorf("failed to parse the persisted last failover stats of shard: %v: %v", topoproto.KeyspaceShardString(keyspace.Name, shard.Name), err)
+			}
+			statsKey := []string{keyspace.Name, shard.Name}
+			vars.lastFailoverDurationMs.Set(statsKey, stats.FailoverDurationMs)
+			vars.lastRequestsInFlightMax.Set(statsKey, stats.RequestsInFlightMax)
+			vars.lastRequestsDryRunMax.Set(statsKey, stats.RequestsDryRunMax)
+			vars.lastMaxFailoverDurationMs.Set(statsKey, stats.MaxFailoverDurationMs)
+		}
+	}
+	log.Infof("Restored the last failover stats from the topology.")
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo/memorytopo"
+)
+
+// resetLastFailoverStats resets the "BufferLast*" stats. This simulates a
+// vtgate restart.
+func resetLastFailoverStats() {
+	for _, g := range lastFailoverGauges {
+		g.ResetAll()
+	}
+}
+
+func TestPersistLastFailoverStats(t *testing.T) {
+	ctx := context.Background()
+	ts := memorytopo.NewServer("cell1")
+	resetLastFailoverStats()
+
+	flag.Set("buffer_persist_last_failover_stats", "true")
+	h := newFailoverHarness(t)
+	if err := h.b.SetTopoServer(ctx, ts, "cell1"); err != nil {
+		t.Fatal(err)
+	}
+	h.runFailover(2, 2*time.Second)
+	// close() waits for the topology write.
+	h.close()
+
+	// Restart vtgate. Without the flag, nothing is restored.
+	resetLastFailoverStats()
+	b := NewWithClock(newFakeClock(time.Now()))
+	if err := b.SetTopoServer(ctx, ts, "cell1"); err != nil {
+		t.Fatal(err)
+	}
+	b.Shutdown()
+	if got, ok := lastFailoverDurationMs.Counts()[statsKeyJoined]; ok {
+		t.Fatalf("stats must not be restored without -buffer_persist_last_failover_stats: got = %v", got)
+	}
+
+	// Restart vtgate again with the flag.
+	flag.Set("buffer_persist_last_failover_stats", "true")
+	defer ResetFlagsForTesting()
+	b = NewWithClock(newFakeClock(time.Now()))
+	defer b.Shutdown()
+	if err := b.SetTopoServer(ctx, ts, "cell1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"BufferLastFailoverDurationMs", lastFailoverDurationMs.Counts()[statsKeyJoined], 2000},
+		{"BufferLastRequestsInFlightMax", lastRequestsInFlightMax.Counts()[statsKeyJoined], 2},
+		{"BufferLastRequestsDryRunMax", lastRequestsDryRunMax.Counts()[statsKeyJoined], 0},
+		{"BufferLastMaxFailoverDurationMs", lastMaxFailoverDurationMs.Counts()[statsKeyJoined], 20000},
+	} {
+		if c.got != c.want {
+			t.Errorf("wrong %v after the restart: got = %v, want = %v", c.name, c.got, c.want)
+		}
+	}
+}
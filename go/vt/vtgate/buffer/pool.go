@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"vitess.io/vitess/go/sync2"
+)
+
+// This file contains the buffer pools (-buffer_pools). By default, all shards
+// share the -buffer_size slots. A keyspace which is assigned to a pool
+// (-buffer_keyspace_pools) can only use the slots of its pool instead. This
+// way, a failover of a noisy keyspace cannot starve the other keyspaces.
+
+// defaultPoolName is the name of the pool with the -buffer_size slots which is
+// used by all keyspaces which are not assigned to a pool.
+const defaultPoolName = "default"
+
+// bufferPool is a set of buffer slots shared by multiple shards.
+type bufferPool struct {
+	// Immutable fields set at construction.
+	name string
+	size int
+	// sema limits how many requests can be buffered in this pool.
+	sema *sync2.Semaphore
+	// slotsInUse is the number of slots which are currently used.
+	slotsInUse sync2.AtomicInt64
+	// vars has the stats variables of the buffer.
+	vars *variables
+}
+
+func newBufferPool(name string, size int, vars *variables) *bufferPool {
+	vars.poolSize.Set(name, int64(size))
+	vars.poolUtilizationPercent.Set(name, 0)
+	return &bufferPool{
+		name: name,
+		size: size,
+		sema: sync2.NewSemaphore(size, 0),
+		vars: vars,
+	}
+}
+
+// tryAcquire returns true if a slot could be taken from the pool.
+func (p *bufferPool) tryAcquire() bool {
+	if !p.sema.TryAcquire() {
+		return false
+	}
+	p.updateSlotsInUse(1)
+	return true
+}
+
+// release returns a slot to the pool.
+func (p *bufferPool) release() {
+	p.updateSlotsInUse(-1)
+	p.sema.Release()
+}
+
+func (p *bufferPool) updateSlotsInUse(delta int64) {
+	p.vars.slotsInUse.Add(delta)
+	used := p.slotsInUse.Add(delta)
+	p.vars.poolUtilizationPercent.Set(p.name, used*100/int64(p.size))
+}
+
+// used returns the number of slots which are currently taken.
+func (p *bufferPool) used() int {
+	return p.size - p.sema.Size()
+}
+
+// parsePools parses -buffer_pools and -buffer_keyspace_pools.
+// It returns the size of each pool and the pool of each keyspace.
+func parsePools(pools, keyspacePools string) (map[string]int, map[string]string, error) {
+	sizes := make(map[string]int)
+	if pools != "" {
+		for _, item := range strings.Split(pools, ",") {
+			parts := strings.Split(item, ":")
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, nil, fmt.Errorf("-buffer_pools has an invalid entry: %v (format: name:size)", item)
+			}
+			name := parts[0]
+			if name == defaultPoolName {
+				return nil, nil, fmt.Errorf("-buffer_pools must not define the pool %v which has the -buffer_size slots", defaultPoolName)
+			}
+			if _, ok := sizes[name]; ok {
+				return nil, nil, fmt.Errorf("-buffer_pools has duplicate entries for the pool: %v", name)
+			}
+			size, err := strconv.Atoi(parts[1])
+			if err != nil || size < 1 {
+				return nil, nil, fmt.Errorf("-buffer_pools has an invalid size for the pool: %v (must be >= 1)", item)
+			}
+			sizes[name] = size
+		}
+	}
+
+	assignments := make(map[string]string)
+	if keyspacePools != "" {
+		for _, item := range strings.Split(keyspacePools, ",") {
+			parts := strings.Split(item, ":")
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, nil, fmt.Errorf("-buffer_keyspace_pools has an invalid entry: %v (format: keyspace:pool)", item)
+			}
+			keyspace, pool := parts[0], parts[1]
+			if _, ok := sizes[pool]; !ok {
+				return nil, nil, fmt.Errorf("-buffer_keyspace_pools assigns keyspace %v to the pool %v which is not defined in -buffer_pools", keyspace, pool)
+			}
+			if _, ok := assignments[keyspace]; ok {
+				return nil, nil, fmt.Errorf("-buffer_keyspace_pools has duplicate entries for the keyspace: %v", keyspace)
+			}
+			assignments[keyspace] = pool
+		}
+	}
+	return sizes, assignments, nil
+}
+
+// poolsToFlags converts the result of parsePools() back to the values of
+// -buffer_pools and -buffer_keyspace_pools.
+// The entries are sorted to get deterministic values.
+func poolsToFlags(sizes map[string]int, assignments map[string]string) (string, string) {
+	pools := make([]string, 0, len(sizes))
+	for name, size := range sizes {
+		pools = append(pools, fmt.Sprintf("%v:%v", name, size))
+	}
+	sort.Strings(pools)
+	keyspacePools := make([]string, 0, len(assignments))
+	for keyspace, pool := range assignments {
+		keyspacePools = append(keyspacePools, keyspace+":"+pool)
+	}
+	sort.Strings(keyspacePools)
+	return strings.Join(pools, ","), strings.Join(keyspacePools, ",")
+}
+
+// poolFor returns the pool of "keyspace".
+func (b *Buffer) poolFor(keyspace string) *bufferPool {
+	if p, ok := b.pools[b.keyspacePools[keyspace]]; ok {
+		return p
+	}
+	return b.defaultPool
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/discovery"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+// waitForPoolUtilization waits up to 10s that the utilization of "pool"
+// reached "want".
+func waitForPoolUtilization(pool string, want int64) error {
+	start := time.Now()
+	for {
+		got := poolUtilizationPercent.Counts()[pool]
+		if got == want {
+			return nil
+		}
+
+		if time.Since(start) > 10*time.Second {
+			return fmt.Errorf("wrong utilization of pool %v: got = %v, want = %v", pool, got, want)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+// waitForGlobalUtilization waits up to 10s that the utilization across all
+// pools reached "want".
+func waitForGlobalUtilization(want int64) error {
+	start := time.Now()
+	for {
+		got := globalUtilizationPercent.F()
+		if got == want {
+			return nil
+		}
+
+		if time.Since(start) > 10*time.Second {
+			return fmt.Errorf("wrong global utilization: got = %v, want = %v", got, want)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+// TestPoolIsolation tests that a keyspace which is assigned to a pool can
+// buffer requests while the default pool is fully used by another keyspace,
+// and vice versa.
+func TestPoolIsolation(t *testing.T) {
+	resetVariables()
+	defer checkVariables(t)
+
+	keyspace2 := "ks2"
+	flag.Set("enable_buffer", "true")
+	flag.Set("buffer_size", "1")
+	flag.Set("buffer_pools", "pool2:1")
+	flag.Set("buffer_keyspace_pools", keyspace2+":pool2")
+	defer ResetFlagsForTesting()
+	b := New()
+	defer b.Shutdown()
+
+	// Use the whole default pool.
+	stoppedFirstKeyspace := issueRequest(context.Background(), t, b, failoverErr)
+	if err := waitForRequestsInFlight(b, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForPoolUtilization(defaultPoolName, 100); err != nil {
+		t.Fatal(err)
+	}
+	// The global utilization counts the slots of all pools: 1 of 2.
+	if err := waitForGlobalUtilization(50); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second keyspace can still buffer because it has its own pool.
+	stoppedSecondKeyspace := make(chan error, 1)
+	go func() {
+		retryDone, err := b.WaitForFailoverEnd(context.Background(), keyspace2, shard, failoverErr)
+		if retryDone != nil {
+			retryDone()
+		}
+		stoppedSecondKeyspace <- err
+	}()
+	if err := waitForPoolUtilization("pool2", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForGlobalUtilization(100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Its pool is full now. Another shard of the keyspace cannot buffer.
+	if _, err := b.WaitForFailoverEnd(context.Background(), keyspace2, shard2, failoverErr); err != ErrBufferFull {
+		t.Fatalf("pool2 should be full: got = %v, want = %v", err, ErrBufferFull)
+	}
+
+	// End both failovers.
+	for _, ks := range []string{keyspace, keyspace2} {
+		b.StatsUpdate(&discovery.TabletStats{
+			Tablet:                              newMaster,
+			Target:                              &querypb.Target{Keyspace: ks, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
+			TabletExternallyReparentedTimestamp: 1, // Use any value > 0.
+		})
+	}
+	if err := <-stoppedFirstKeyspace; err != nil {
+		t.Fatalf("request of the first keyspace should have been buffered and not returned an error: %v", err)
+	}
+	if err := <-stoppedSecondKeyspace; err != nil {
+		t.Fatalf("request of the second keyspace should have been buffered and not returned an error: %v", err)
+	}
+	for _, pool := range []string{defaultPoolName, "pool2"} {
+		if err := waitForPoolUtilization(pool, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := waitForGlobalUtilization(0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := poolSize.Counts()["pool2"], int64(1); got != want {
+		t.Errorf("wrong BufferPoolSize of pool2: got = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+// Priority is an optional hint for a buffered request. During the drain,
+// requests with a higher priority are retried first. Requests with the same
+// priority are retried in the order they were buffered.
+type Priority int
+
+const (
+	// PriorityNormal is the default for all requests.
+	PriorityNormal Priority = 0
+	// PriorityHigh should be used for requests from latency-sensitive clients.
+	PriorityHigh Priority = 1
+)
+
+// priorities is the list of all known priorities. It's used to initialize
+// the "requestsByPriority" variable.
+var priorities = []Priority{PriorityNormal, PriorityHigh}
+
+// String returns the name of the priority. It's used as "Priority" label.
+func (p Priority) String() string {
+	switch p {
+	case PriorityNormal:
+		return "Normal"
+	case PriorityHigh:
+		return "High"
+	}
+	return strconv.Itoa(int(p))
+}
+
+type priorityKey int
+
+// NewContextWithPriority returns a context which carries the priority for
+// the buffering of the request.
+func NewContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey(0), p)
+}
+
+// priorityFromContext returns the priority from the context or
+// PriorityNormal if it has none.
+func priorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey(0)).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+type inTransactionKey int
+
+// NewContextInTransaction returns a context which marks the request as part
+// of a multi-statement transaction. Such requests are not buffered because
+// the transaction cannot be continued on the new master. If such a request
+// failed due to a failover, it's counted as skipped with the reason
+// "InTransaction" instead.
+func NewContextInTransaction(ctx context.Context) context.Context {
+	return context.WithValue(ctx, inTransactionKey(0), true)
+}
+
+// inTransactionFromContext returns true if the request is part of a
+// transaction.
+func inTransactionFromContext(ctx context.Context) bool {
+	inTransaction, _ := ctx.Value(inTransactionKey(0)).(bool)
+	return inTransaction
+}
+
+type noBufferKey int
+
+// NewContextNoBuffer returns a context which marks the request as not to be
+// buffered. Clients which prefer to fail fast during a failover (e.g. health
+// probes) can use it. vtgate uses it for queries with the comment directive
+// "/*vt+ NO_BUFFER */". Such requests are counted as skipped with the reason
+// "ClientOptOut".
+func NewContextNoBuffer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noBufferKey(0), true)
+}
+
+// noBufferFromContext returns true if the client opted out of buffering.
+func noBufferFromContext(ctx context.Context) bool {
+	noBuffer, _ := ctx.Value(noBufferKey(0)).(bool)
+	return noBuffer
+}
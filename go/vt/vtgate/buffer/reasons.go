@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// This file computes the percentage breakdown of the stop and skip reasons
+// per shard which is shown on /bufferz.
+// The recent breakdown does not include the "Disabled" skips. They happen for
+// every MASTER request of a shard without buffering and are not related to a
+// failover. The lifetime breakdown is computed from the stats and has them.
+
+const (
+	// reasonWindow is the recent period for which the breakdown is computed
+	// in addition to the lifetime of the process.
+	reasonWindow = 1 * time.Hour
+	// reasonBucketDuration is the granularity of reasonHistory.
+	reasonBucketDuration = 1 * time.Minute
+)
+
+// reasonHistory counts reasons in buckets of reasonBucketDuration. Buckets
+// older than reasonWindow are dropped. The zero value is ready to use.
+type reasonHistory struct {
+	mu      sync.Mutex
+	buckets []reasonBucket
+}
+
+type reasonBucket struct {
+	start  time.Time
+	counts map[string]int64
+}
+
+// add counts "reason" at "now".
+func (h *reasonHistory) add(now time.Time, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pruneLocked(now)
+	if len(h.buckets) == 0 || now.Sub(h.buckets[len(h.buckets)-1].start) >= reasonBucketDuration {
+		h.buckets = append(h.buckets, reasonBucket{start: now, counts: make(map[string]int64)})
+	}
+	h.buckets[len(h.buckets)-1].counts[reason]++
+}
+
+// counts returns the number of each reason within reasonWindow before "now".
+func (h *reasonHistory) counts(now time.Time) map[string]int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pruneLocked(now)
+	result := make(map[string]int64)
+	for _, b := range h.buckets {
+		for reason, count := range b.counts {
+			result[reason] += count
+		}
+	}
+	return result
+}
+
+// pruneLocked drops the buckets which are completely outside the window.
+func (h *reasonHistory) pruneLocked(now time.Time) {
+	i := 0
+	for i < len(h.buckets) && now.Sub(h.buckets[i].start) >= reasonWindow {
+		i++
+	}
+	h.buckets = h.buckets[i:]
+}
+
+// ReasonShare is the share of one reason among all stops or skips.
+type ReasonShare struct {
+	Reason  string
+	Count   int64
+	Percent float64
+}
+
+// ShardReasons has the breakdown of the stop and skip reasons of a shard
+// over the lifetime of the process and over the last reasonWindow.
+type ShardReasons struct {
+	Keyspace      string
+	Shard         string
+	StopsLifetime []ReasonShare
+	StopsRecent   []ReasonShare
+	SkipsLifetime []ReasonShare
+	SkipsRecent   []ReasonShare
+}
+
+// reasonShares converts the counts into shares, sorted by reason. Reasons
+// with a count of 0 are omitted.
+func reasonShares(counts map[string]int64) []ReasonShare {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	var result []ReasonShare
+	for reason, count := range counts {
+		if count == 0 {
+			continue
+		}
+		result = append(result, ReasonShare{
+			Reason:  reason,
+			Count:   count,
+			Percent: float64(count) * 100 / float64(total),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Reason < result[j].Reason
+	})
+	return result
+}
+
+// reasonsAll returns the breakdown of all shards which had at least one stop
+// or skip, sorted by keyspace and shard.
+func (b *Buffer) reasonsAll() []ShardReasons {
+	b.mu.RLock()
+	buffers := make([]*shardBuffer, 0, len(b.buffers))
+	for _, sb := range b.buffers {
+		buffers = append(buffers, sb)
+	}
+	b.mu.RUnlock()
+
+	stopCounts := b.vars.stops.Counts()
+	skipCounts := b.vars.requestsSkipped.Counts()
+	var result []ShardReasons
+	for _, sb := range buffers {
+		stopsLifetime := make(map[string]int64)
+		for _, reason := range stopReasons {
+			stopsLifetime[string(reason)] = stopCounts[sb.statsKeyJoined+"."+string(reason)]
+		}
+		skipsLifetime := make(map[string]int64)
+		for _, reason := range skippedReasons {
+			skipsLifetime[string(reason)] = skipCounts[sb.statsKeyJoined+"."+string(reason)]
+		}
+		now := sb.clock.Now()
+		r := ShardReasons{
+			Keyspace:      sb.keyspace,
+			Shard:         sb.shard,
+			StopsLifetime: reasonShares(stopsLifetime),
+			StopsRecent:   reasonShares(sb.stopHistory.counts(now)),
+			SkipsLifetime: reasonShares(skipsLifetime),
+			SkipsRecent:   reasonShares(sb.skipHistory.counts(now)),
+		}
+		if len(r.StopsLifetime) == 0 && len(r.SkipsLifetime) == 0 {
+			continue
+		}
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Keyspace != result[j].Keyspace {
+			return result[i].Keyspace < result[j].Keyspace
+		}
+		return result[i].Shard < result[j].Shard
+	})
+	return result
+}
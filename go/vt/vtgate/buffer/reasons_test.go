@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestReasonHistory(t *testing.T) {
+	var h reasonHistory
+	start := time.Now()
+	h.add(start, "a")
+	h.add(start.Add(30*time.Second), "a")
+	h.add(start.Add(2*time.Minute), "b")
+
+	got := h.counts(start.Add(2 * time.Minute))
+	if got["a"] != 2 || got["b"] != 1 {
+		t.Fatalf("wrong counts within the window: %v", got)
+	}
+	// The first bucket falls out of the window.
+	got = h.counts(start.Add(reasonWindow + 1*time.Minute))
+	if got["a"] != 0 || got["b"] != 1 {
+		t.Fatalf("wrong counts after the first bucket left the window: %v", got)
+	}
+}
+
+// checkSharesSum fails the test if the shares do not sum up to ~100%.
+func checkSharesSum(t *testing.T, desc string, shares []ReasonShare) {
+	sum := 0.0
+	for _, s := range shares {
+		sum += s.Percent
+	}
+	if math.Abs(sum-100) > 0.01 {
+		t.Fatalf("%v: shares must sum up to 100%%: got = %v (%v)", desc, sum, shares)
+	}
+}
+
+func TestReasons(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// 1 request within a transaction is skipped.
+	txCtx := NewContextInTransaction(context.Background())
+	if _, err := h.b.WaitForFailoverEnd(txCtx, keyspace, shard, failoverErr); err != nil {
+		t.Fatal(err)
+	}
+	// The failover ends when the new master is seen.
+	h.runFailover(1, 1*time.Second)
+	// 3 requests are skipped because the failover was too recent.
+	for i := 0; i < 3; i++ {
+		if _, err := h.b.WaitForFailoverEnd(context.Background(), keyspace, shard, failoverErr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reasons := h.b.reasonsAll()
+	if len(reasons) != 1 {
+		t.Fatalf("only %v/%v should have reasons: got = %v", keyspace, shard, reasons)
+	}
+	r := reasons[0]
+	for _, tc := range []struct {
+		desc   string
+		shares []ReasonShare
+		want   []ReasonShare
+	}{
+		{"stops (lifetime)", r.StopsLifetime, []ReasonShare{{string(stopFailoverEndDetected), 1, 100}}},
+		{"stops (recent)", r.StopsRecent, []ReasonShare{{string(stopFailoverEndDetected), 1, 100}}},
+		{"skips (lifetime)", r.SkipsLifetime, []ReasonShare{{string(skippedInTransaction), 1, 25}, {string(skippedLastFailoverTooRecent), 3, 75}}},
+		{"skips (recent)", r.SkipsRecent, []ReasonShare{{string(skippedInTransaction), 1, 25}, {string(skippedLastFailoverTooRecent), 3, 75}}},
+	} {
+		checkSharesSum(t, tc.desc, tc.shares)
+		if len(tc.shares) != len(tc.want) {
+			t.Fatalf("%v: wrong shares: got = %v, want = %v", tc.desc, tc.shares, tc.want)
+		}
+		for i := range tc.want {
+			if tc.shares[i] != tc.want[i] {
+				t.Fatalf("%v: wrong shares: got = %v, want = %v", tc.desc, tc.shares, tc.want)
+			}
+		}
+	}
+
+	req, _ := http.NewRequest("GET", BufferzHandler, nil)
+	resp := httptest.NewRecorder()
+	bufferzHandler(h.b, resp, req)
+	body, _ := ioutil.ReadAll(resp.Body)
+	if want := "LastFailoverTooRecent: 75.0% (3)"; !strings.Contains(string(body), want) {
+		t.Fatalf("bufferz page does not contain: %v\nbody:\n%s", want, body)
+	}
+
+	// After the window, only the lifetime breakdown is left.
+	h.clock.Advance(reasonWindow)
+	r = h.b.reasonsAll()[0]
+	if len(r.StopsRecent) != 0 || len(r.SkipsRecent) != 0 {
+		t.Fatalf("the recent breakdown should be empty after the window: %v", r)
+	}
+	checkSharesSum(t, "skips (lifetime) after the window", r.SkipsLifetime)
+}
+
+// TestReasonsDisabled tests that the skips of a shard without buffering are
+// only part of the lifetime breakdown.
+func TestReasonsDisabled(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	if _, err := h.b.WaitForFailoverEnd(context.Background(), keyspace, shard2, nil /* err */); err != nil {
+		t.Fatal(err)
+	}
+
+	reasons := h.b.reasonsAll()
+	if len(reasons) != 1 || reasons[0].Shard != shard2 {
+		t.Fatalf("only %v/%v should have reasons: got = %v", keyspace, shard2, reasons)
+	}
+	r := reasons[0]
+	if want := []ReasonShare{{string(skippedDisabled), 1, 100}}; len(r.SkipsLifetime) != 1 || r.SkipsLifetime[0] != want[0] {
+		t.Fatalf("wrong lifetime skips: got = %v, want = %v", r.SkipsLifetime, want)
+	}
+	if len(r.SkipsRecent) != 0 {
+		t.Fatalf("disabled skips must not be part of the recent breakdown: %v", r.SkipsRecent)
+	}
+}
@@ -18,19 +18,22 @@ package buffer
 
 import (
 	"fmt"
+	"math/rand"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/net/context"
 
-	"vitess.io/vitess/go/sync2"
 	"vitess.io/vitess/go/vt/log"
 	"vitess.io/vitess/go/vt/logutil"
 	"vitess.io/vitess/go/vt/topo/topoproto"
 	"vitess.io/vitess/go/vt/vterrors"
 
 	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
 )
 
 // bufferState represents the different states a shardBuffer object can be in.
@@ -59,14 +62,27 @@ type shardBuffer struct {
 	mode     bufferMode
 	keyspace string
 	shard    string
-	now      func() time.Time
-	// bufferSizeSema is the shared pool of slots. See "Buffer.bufferSizeSema".
-	bufferSizeSema *sync2.Semaphore
+	clock    Clock
+	// cfg is the configuration of the buffer. See "Buffer.cfg".
+	cfg *BufferConfig
+	// vars has the stats variables. See "Buffer.vars".
+	vars *variables
+	// events is the shared publisher of the lifecycle events.
+	events *eventPublisher
+	// persister is the shared writer of the last failover stats.
+	persister *statsPersister
+	// pool has the slots which this shard shares with the other shards of
+	// the pool. See "Buffer.defaultPool".
+	pool *bufferPool
 	// statsKey is used to update the stats variables.
 	statsKey []string
 	// statsKeyJoined is all elements of "statsKey" in one string, joined by ".".
 	statsKeyJoined string
 	logTooRecent   *logutil.ThrottledLogger
+	// stopHistory and skipHistory count the stop and skip reasons of the
+	// recent past for /bufferz. They have their own locks.
+	stopHistory reasonHistory
+	skipHistory reasonHistory
 
 	// mu guards the fields below.
 	mu    sync.RWMutex
@@ -83,6 +99,9 @@ type shardBuffer struct {
 	externallyReparented int64
 	// lastStart is the last time we saw the start of a failover.
 	lastStart time.Time
+	// lastStartReason is the error which triggered the start of the last
+	// failover.
+	lastStartReason string
 	// lastEnd is the last time we saw the end of a failover.
 	lastEnd time.Time
 	// lastReparent is the last time we saw that the tablet alias of the MASTER
@@ -90,6 +109,27 @@ type shardBuffer struct {
 	lastReparent time.Time
 	// currentMaster is tracked to determine when to update "lastReparent".
 	currentMaster *topodatapb.TabletAlias
+	// maxFailoverDuration is the effective max duration of the current (or
+	// last) failover. It is -buffer_max_failover_duration minus a random jitter
+	// of up to -buffer_max_duration_jitter.
+	maxFailoverDuration time.Duration
+	// durationEWMA and utilizationEWMA are the moving averages which are
+	// published as "failoverDurationEWMA" and "utilizationEWMA".
+	durationEWMA    movingAverage
+	utilizationEWMA movingAverage
+	// highUtilSince is the time since which the utilization is above
+	// -buffer_high_util_threshold. It's zero if it's not.
+	// highUtilReported is true if the current period of high utilization
+	// was already reported.
+	highUtilSince    time.Time
+	highUtilReported bool
+	// requestsThisFailover is the number of requests which were buffered
+	// during the current (or last) failover. It's observed in
+	// "requestsPerFailover" when the failover ends.
+	requestsThisFailover int64
+	// bufferedHook is called after a request was buffered. It's nil except
+	// for Simulate() which waits for the simulated requests with it.
+	bufferedHook func()
 	// timeoutThread will be set while a failover is in progress and the object is
 	// in the BUFFERING state.
 	timeoutThread *timeoutThread
@@ -113,6 +153,18 @@ type entry struct {
 	// err is set if the buffering failed e.g. when the entry was evicted.
 	err error
 
+	// priority determines the order during the drain.
+	priority Priority
+	// size is the size of the request (in bytes) which counts against
+	// -buffer_max_bytes.
+	size int64
+
+	// query is the original query of the request (may be empty). It's only
+	// used to show the redacted query on /bufferz.
+	query string
+	// bufferedAt is the time when the entry was added to the buffer.
+	bufferedAt time.Time
+
 	// bufferCtx wraps the request ctx and is used to track the retry of a
 	// request during the drain phase. Once the retry is done, the caller
 	// must cancel this context (by calling bufferCancel).
@@ -120,16 +172,20 @@ type entry struct {
 	bufferCancel func()
 }
 
-func newShardBuffer(mode bufferMode, keyspace, shard string, now func() time.Time, bufferSizeSema *sync2.Semaphore) *shardBuffer {
+func newShardBuffer(mode bufferMode, keyspace, shard string, cfg *BufferConfig, vars *variables, clock Clock, events *eventPublisher, persister *statsPersister, pool *bufferPool) *shardBuffer {
 	statsKey := []string{keyspace, shard}
-	initVariablesForShard(statsKey)
+	vars.initForShard(statsKey, mode)
 
 	return &shardBuffer{
 		mode:           mode,
 		keyspace:       keyspace,
 		shard:          shard,
-		now:            now,
-		bufferSizeSema: bufferSizeSema,
+		clock:          clock,
+		cfg:            cfg,
+		vars:           vars,
+		events:         events,
+		persister:      persister,
+		pool:           pool,
 		statsKey:       statsKey,
 		statsKeyJoined: fmt.Sprintf("%s.%s", keyspace, shard),
 		logTooRecent:   logutil.NewThrottledLogger(fmt.Sprintf("FailoverTooRecent-%v", topoproto.KeyspaceShardString(keyspace, shard)), 5*time.Second),
@@ -147,21 +203,56 @@ func (sb *shardBuffer) waitForFailoverEnd(ctx context.Context, keyspace, shard s
 	// Other errors must be filtered at higher layers.
 	failoverDetected := err != nil
 
+	var skipReason skippedReason
+	switch {
+	case inTransactionFromContext(ctx):
+		if !failoverDetected {
+			// The request did not see the failover. It's not accounted for.
+			return nil, nil
+		}
+		skipReason = skippedInTransaction
+	case noBufferFromContext(ctx):
+		skipReason = skippedClientOptOut
+	}
+	if skipReason != "" {
+		// Requests within a transaction or of clients which opted out are
+		// never buffered. Only account for them if they would have been
+		// buffered otherwise.
+		sb.mu.RLock()
+		shouldBuffer := sb.shouldBufferLocked(failoverDetected)
+		sb.mu.RUnlock()
+		if shouldBuffer {
+			sb.recordSkipped(skipReason)
+		}
+		return nil, nil
+	}
+
 	// Fast path (read lock): Check if we should NOT buffer a request.
 	sb.mu.RLock()
 	if !sb.shouldBufferLocked(failoverDetected) {
 		// No buffering required. Return early.
+		draining := sb.drainInProgressLocked()
 		sb.mu.RUnlock()
+		if draining {
+			sb.passthroughDuringDrain(ctx, failoverDetected)
+		}
 		return nil, nil
 	}
 	sb.mu.RUnlock()
 
 	// Buffering required. Acquire write lock.
+	// The latency is measured with the real time and not "sb.clock" because it
+	// tracks the actual lock contention.
+	enqueueStart := time.Now()
 	sb.mu.Lock()
 	// Re-check state because it could have changed in the meantime.
 	if !sb.shouldBufferLocked(failoverDetected) {
 		// Buffering no longer required. Return early.
+		draining := sb.drainInProgressLocked()
 		sb.mu.Unlock()
+		if draining {
+			sb.passthroughDuringDrain(ctx, failoverDetected)
+		}
 		return nil, nil
 	}
 
@@ -171,13 +262,17 @@ func (sb *shardBuffer) waitForFailoverEnd(ctx context.Context, keyspace, shard s
 		// a) buffering was stopped recently
 		// OR
 		// b) we did not buffer, but observed a reparent very recently
-		now := sb.now()
+		now := sb.clock.Now()
 
 		// a) Buffering was stopped recently.
 		// This can happen when we stop buffering while MySQL is not ready yet
 		// (read-only mode is not cleared yet on the new master).
 		lastBufferingStopped := now.Sub(sb.lastEnd)
-		if !sb.lastEnd.IsZero() && lastBufferingStopped < *minTimeBetweenFailovers {
+		tooRecent := !sb.lastEnd.IsZero() && lastBufferingStopped < sb.cfg.MinTimeBetweenFailovers
+		// A failover which starts just before the end of the minimum time is
+		// buffered anyway (-buffer_recency_grace).
+		inRecencyGrace := tooRecent && sb.cfg.MinTimeBetweenFailovers-lastBufferingStopped <= sb.cfg.RecencyGrace
+		if tooRecent && !inRecencyGrace {
 			sb.mu.Unlock()
 			msg := "NOT starting buffering"
 			if sb.mode == bufferDryRun {
@@ -186,10 +281,9 @@ func (sb *shardBuffer) waitForFailoverEnd(ctx context.Context, keyspace, shard s
 
 			sb.logTooRecent.Infof("%v for shard: %s because the last failover which triggered buffering is too recent (%v < %v)."+
 				" (A failover was detected by this seen error: %v.)",
-				msg, topoproto.KeyspaceShardString(keyspace, shard), lastBufferingStopped, *minTimeBetweenFailovers, err)
+				msg, topoproto.KeyspaceShardString(keyspace, shard), lastBufferingStopped, sb.cfg.MinTimeBetweenFailovers, err)
 
-			statsKeyWithReason := append(sb.statsKey, string(skippedLastFailoverTooRecent))
-			requestsSkipped.Add(statsKeyWithReason, 1)
+			sb.recordSkipped(skippedLastFailoverTooRecent)
 			return nil, nil
 		}
 
@@ -198,8 +292,11 @@ func (sb *shardBuffer) waitForFailoverEnd(ctx context.Context, keyspace, shard s
 		// request failure caused by the reparent. This is possible if the QPS is
 		// very low. If we do not skip buffering here, we would start buffering but
 		// not stop because we already observed the promotion of the new master.
+		// The reparent which ended the last failover does not count within the
+		// grace period.
 		lastReparentAgo := now.Sub(sb.lastReparent)
-		if !sb.lastReparent.IsZero() && lastReparentAgo < *minTimeBetweenFailovers {
+		reparentEndedLastFailover := inRecencyGrace && !sb.lastReparent.After(sb.lastEnd)
+		if !sb.lastReparent.IsZero() && lastReparentAgo < sb.cfg.MinTimeBetweenFailovers && !reparentEndedLastFailover {
 			sb.mu.Unlock()
 			msg := "NOT starting buffering"
 			if sb.mode == bufferDryRun {
@@ -208,33 +305,96 @@ func (sb *shardBuffer) waitForFailoverEnd(ctx context.Context, keyspace, shard s
 
 			sb.logTooRecent.Infof("%v for shard: %s because the last reparent is too recent (%v < %v)."+
 				" (A failover was detected by this seen error: %v.)",
-				msg, topoproto.KeyspaceShardString(keyspace, shard), lastReparentAgo, *minTimeBetweenFailovers, err)
+				msg, topoproto.KeyspaceShardString(keyspace, shard), lastReparentAgo, sb.cfg.MinTimeBetweenFailovers, err)
 
-			statsKeyWithReason := append(sb.statsKey, string(skippedLastReparentTooRecent))
-			requestsSkipped.Add(statsKeyWithReason, 1)
+			sb.recordSkipped(skippedLastReparentTooRecent)
 			return nil, nil
 		}
 
+		if inRecencyGrace {
+			sb.vars.recencyGraceBuffered.Add(sb.statsKey, 1)
+			log.Infof("Buffering for shard: %s although the last failover which triggered buffering is too recent (%v < %v) because it's within -buffer_recency_grace=%v.",
+				topoproto.KeyspaceShardString(keyspace, shard), lastBufferingStopped, sb.cfg.MinTimeBetweenFailovers, sb.cfg.RecencyGrace)
+		}
+
 		sb.startBufferingLocked(err)
 	}
 
 	if sb.mode == bufferDryRun {
-		sb.mu.Unlock()
 		// Dry-run. Do not actually buffer the request and return early.
-		lastRequestsDryRunMax.Add(sb.statsKey, 1)
-		requestsBufferedDryRun.Add(sb.statsKey, 1)
+		sb.vars.lastRequestsDryRunMax.Add(sb.statsKey, 1)
+		sb.vars.requestsBufferedDryRun.Add(sb.statsKey, 1)
+		if sb.vars.lastRequestsDryRunMax.Counts()[sb.statsKeyJoined] > int64(sb.pool.size) {
+			// The buffer would have been full and the oldest request evicted.
+			sb.vars.requestsEvictedDryRun.Add(append(sb.statsKey, evictedBufferFull), 1)
+		}
+		sb.mu.Unlock()
 		return nil, nil
 	}
 
 	// Buffer request.
 	entry, err := sb.bufferRequestLocked(ctx)
 	sb.mu.Unlock()
+	sb.vars.enqueueLatency.Record(sb.statsKey, enqueueStart)
 	if err != nil {
 		return nil, err
 	}
 	return sb.wait(ctx, entry)
 }
 
+// recordSkipped counts a request which was not buffered for "reason". The
+// skip is also added to the recent skips on /bufferz. Skips of shards for which
+// buffering is disabled are counted directly instead (see
+// Buffer.WaitForFailoverEnd()).
+func (sb *shardBuffer) recordSkipped(reason skippedReason) {
+	statsKeyWithReason := append(sb.statsKey, string(reason))
+	sb.vars.requestsSkipped.Add(statsKeyWithReason, 1)
+	if sb.mode == bufferDryRun {
+		sb.vars.requestsSkippedDryRun.Add(statsKeyWithReason, 1)
+	}
+	sb.skipHistory.add(sb.clock.Now(), string(reason))
+}
+
+// aboveSoftLimit returns true if the number of used slots in the pool of this
+// shard reached -buffer_soft_limit.
+func (sb *shardBuffer) aboveSoftLimit() bool {
+	if sb.cfg.SoftLimit >= 1 {
+		// Disabled. A full buffer is handled by the eviction instead.
+		return false
+	}
+	return float64(sb.pool.used()) >= sb.cfg.SoftLimit*float64(sb.pool.size)
+}
+
+// perShardLimit returns the maximum number of buffered requests of this shard
+// as defined by -buffer_max_per_shard. 0 means no limit.
+func (sb *shardBuffer) perShardLimit() int {
+	switch {
+	case sb.cfg.MaxPerShard <= 0:
+		return 0
+	case sb.cfg.MaxPerShard >= 1:
+		return int(sb.cfg.MaxPerShard)
+	}
+	// Always allow at least one request per shard.
+	limit := int(sb.cfg.MaxPerShard * float64(sb.pool.size))
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// passthroughDuringDrain handles a request which arrived during the drain.
+// Such requests are never buffered and go to the new master right away.
+func (sb *shardBuffer) passthroughDuringDrain(ctx context.Context, failoverDetected bool) {
+	if failoverDetected {
+		// The request failed against the old master before the failover end
+		// was detected. The new master is already known and vtgate will retry
+		// the request immediately.
+		sb.vars.requestsDuringDrain.Add(sb.statsKey, 1)
+		return
+	}
+	sb.signalDrainBackpressure(ctx)
+}
+
 // shouldBufferLocked returns true if the current request should be buffered
 // (based on the current state and whether the request detected a failover).
 func (sb *shardBuffer) shouldBufferLocked(failoverDetected bool) bool {
@@ -261,14 +421,28 @@ func (sb *shardBuffer) shouldBufferLocked(failoverDetected bool) bool {
 
 func (sb *shardBuffer) startBufferingLocked(err error) {
 	// Reset monitoring data from previous failover.
-	lastRequestsInFlightMax.Set(sb.statsKey, 0)
-	lastRequestsDryRunMax.Set(sb.statsKey, 0)
-	failoverDurationSumMs.Reset(sb.statsKey)
+	sb.vars.lastRequestsInFlightMax.Set(sb.statsKey, 0)
+	sb.vars.lastRequestsDryRunMax.Set(sb.statsKey, 0)
+	sb.vars.failoverDurationSumMs.Reset(sb.statsKey)
 
-	sb.lastStart = sb.now()
+	now := sb.clock.Now()
+	if !sb.lastStart.IsZero() {
+		sb.vars.timeBetweenFailoversMs.Set(sb.statsKey, int64(now.Sub(sb.lastStart)/time.Millisecond))
+	}
+	sb.lastStart = now
+	sb.lastStartReason = fmt.Sprintf("%v", err)
 	sb.logErrorIfStateNotLocked(stateIdle)
 	sb.state = stateBuffering
 	sb.queue = make([]*entry, 0)
+	sb.highUtilSince = time.Time{}
+	sb.highUtilReported = false
+	sb.requestsThisFailover = 0
+
+	sb.maxFailoverDuration = sb.cfg.MaxFailoverDuration
+	if sb.cfg.MaxDurationJitter > 0 {
+		sb.maxFailoverDuration -= time.Duration(rand.Int63n(int64(sb.cfg.MaxDurationJitter) + 1))
+	}
+	sb.vars.lastMaxFailoverDurationMs.Set(sb.statsKey, int64(sb.maxFailoverDuration/time.Millisecond))
 
 	sb.timeoutThread = newTimeoutThread(sb)
 	sb.timeoutThread.start()
@@ -276,9 +450,10 @@ func (sb *shardBuffer) startBufferingLocked(err error) {
 	if sb.mode == bufferDryRun {
 		msg = "Dry-run: Would have started buffering"
 	}
-	starts.Add(sb.statsKey, 1)
-	log.Infof("%v for shard: %s (window: %v, size: %v, max failover duration: %v) (A failover was detected by this seen error: %v.)",
-		msg, topoproto.KeyspaceShardString(sb.keyspace, sb.shard), *window, *size, *maxFailoverDuration, err)
+	sb.vars.starts.Add(sb.statsKey, 1)
+	sb.publishEvent(BufferEventStart, sb.lastStartReason)
+	log.Infof("%v for shard: %s (window: %v, size: %v, pool: %v, max failover duration: %v) (A failover was detected by this seen error: %v.)",
+		msg, topoproto.KeyspaceShardString(sb.keyspace, sb.shard), sb.cfg.Window, sb.pool.size, sb.pool.name, sb.maxFailoverDuration, err)
 }
 
 // logErrorIfStateNotLocked logs an error if the current state is not "state".
@@ -299,41 +474,81 @@ func (sb *shardBuffer) logErrorIfStateNotLocked(state bufferState) {
 // give up their spot in the buffer. It also holds the "bufferCancel" function.
 // If buffering fails e.g. due to a full buffer, an error is returned.
 func (sb *shardBuffer) bufferRequestLocked(ctx context.Context) (*entry, error) {
-	if !sb.bufferSizeSema.TryAcquire() {
-		// Buffer is full. Evict the oldest entry and buffer this request instead.
+	priority := priorityFromContext(ctx)
+	if priority < PriorityHigh && sb.aboveSoftLimit() {
+		// Keep the remaining slots for high priority requests.
+		sb.recordSkipped(skippedSoftLimit)
+		return nil, softLimitError
+	}
+	if limit := sb.perShardLimit(); limit > 0 && len(sb.queue) >= limit {
+		// Leave the remaining slots of the pool to the other shards.
+		sb.recordSkipped(skippedPerShardLimit)
+		return nil, perShardLimitError
+	}
+
+	size := requestSizeFromContext(ctx)
+	if sb.cfg.MaxBytes > 0 && size > sb.cfg.MaxBytes {
+		// The request does not fit even into an empty buffer. Skip it without
+		// evicting any buffered requests for it.
+		sb.recordSkipped(skippedMaxBytes)
+		return nil, ErrBufferFull
+	}
+
+	if !sb.pool.tryAcquire() {
+		// Buffer is full. Evict an entry (see -buffer_full_policy) and buffer
+		// this request instead.
 		if len(sb.queue) == 0 {
-			// Overall buffer is full, but this shard's queue is empty. That means
-			// there is at least one other shard failing over as well which consumes
-			// the whole buffer.
-			statsKeyWithReason := append(sb.statsKey, string(skippedBufferFull))
-			requestsSkipped.Add(statsKeyWithReason, 1)
-			return nil, bufferFullError
+			// The pool is full, but this shard's queue is empty. That means
+			// there is at least one other shard of the pool failing over as well
+			// which consumes the whole pool.
+			sb.recordSkipped(skippedBufferFull)
+			return nil, ErrBufferFull
 		}
 
-		e := sb.queue[0]
 		// Evict the entry. Do not release its slot in the buffer and reuse it for
 		// this new request.
 		// NOTE: We keep the lock to avoid racing with drain().
 		// NOTE: We're not waiting until the request finishes and instead reuse its
 		// slot immediately, i.e. the number of evicted requests + drained requests
 		// can be bigger than the buffer size.
-		sb.unblockAndWait(e, entryEvictedError, false /* releaseSlot */, false /* blockingWait */)
-		sb.queue = sb.queue[1:]
-		statsKeyWithReason := append(sb.statsKey, evictedBufferFull)
-		requestsEvicted.Add(statsKeyWithReason, 1)
+		sb.evictLocked(sb.evictionCandidateLocked(), evictedBufferFull, false /* releaseSlot */)
 	}
 
+	for !sb.tryReserveBytes(size) {
+		if len(sb.queue) == 0 {
+			// The request does not fit even though this shard has no buffered
+			// requests left. Other shards use the remaining bytes.
+			sb.pool.release()
+			sb.recordSkipped(skippedMaxBytes)
+			return nil, ErrBufferFull
+		}
+		// Unlike above, the slot of the evicted entry is released because this
+		// request has one already.
+		sb.evictLocked(sb.evictionCandidateLocked(), evictedMaxBytes, true /* releaseSlot */)
+	}
+
+	now := sb.clock.Now()
 	e := &entry{
-		done:     make(chan struct{}),
-		deadline: sb.now().Add(*window),
+		done:       make(chan struct{}),
+		deadline:   now.Add(sb.cfg.Window),
+		priority:   priority,
+		size:       size,
+		query:      queryFromContext(ctx),
+		bufferedAt: now,
 	}
 	e.bufferCtx, e.bufferCancel = context.WithCancel(ctx)
 	sb.queue = append(sb.queue, e)
 
-	if max := lastRequestsInFlightMax.Counts()[sb.statsKeyJoined]; max < int64(len(sb.queue)) {
-		lastRequestsInFlightMax.Set(sb.statsKey, int64(len(sb.queue)))
+	if max := sb.vars.lastRequestsInFlightMax.Counts()[sb.statsKeyJoined]; max < int64(len(sb.queue)) {
+		sb.vars.lastRequestsInFlightMax.Set(sb.statsKey, int64(len(sb.queue)))
+	}
+	sb.vars.requestsBuffered.Add(sb.statsKey, 1)
+	sb.requestsThisFailover++
+	sb.vars.requestsByPriority.Add(append(sb.statsKey, e.priority.String()), 1)
+	sb.checkHighUtilizationLocked()
+	if sb.bufferedHook != nil {
+		sb.bufferedHook()
 	}
-	requestsBuffered.Add(sb.statsKey, 1)
 
 	if len(sb.queue) == 1 {
 		sb.timeoutThread.notifyQueueNotEmpty()
@@ -341,6 +556,54 @@ func (sb *shardBuffer) bufferRequestLocked(ctx context.Context) (*entry, error)
 	return e, nil
 }
 
+// evictLocked evicts the entry at index "i" of the queue. The request sees
+// entryEvictedError.
+func (sb *shardBuffer) evictLocked(i int, reason evictedReason, releaseSlot bool) {
+	sb.evictWithErrLocked(i, reason, entryEvictedError, releaseSlot)
+}
+
+// evictWithErrLocked is like evictLocked(), but the evicted request sees
+// "err" instead of the default eviction error.
+func (sb *shardBuffer) evictWithErrLocked(i int, reason evictedReason, err error, releaseSlot bool) {
+	e := sb.queue[i]
+	sb.unblockAndWait(e, err, releaseSlot, false /* blockingWait */)
+	sb.queue = append(sb.queue[:i], sb.queue[i+1:]...)
+	statsKeyWithReason := append(sb.statsKey, string(reason))
+	sb.vars.requestsEvicted.Add(statsKeyWithReason, 1)
+	sb.publishEvent(BufferEventEvict, string(reason))
+	sb.checkHighUtilizationLocked()
+}
+
+// checkHighUtilizationLocked tracks how long the utilization of the shard is
+// above -buffer_high_util_threshold. It must be called whenever the queue
+// grew or shrank. Once the utilization stayed above the threshold for longer
+// than -buffer_high_util_duration, the period is reported once.
+func (sb *shardBuffer) checkHighUtilizationLocked() {
+	if sb.cfg.HighUtilThreshold <= 0 {
+		return
+	}
+	if float64(len(sb.queue)) <= sb.cfg.HighUtilThreshold*float64(sb.pool.size) {
+		sb.highUtilSince = time.Time{}
+		sb.highUtilReported = false
+		return
+	}
+
+	now := sb.clock.Now()
+	if sb.highUtilSince.IsZero() {
+		sb.highUtilSince = now
+		return
+	}
+	if sb.highUtilReported || now.Sub(sb.highUtilSince) <= sb.cfg.HighUtilDuration {
+		return
+	}
+	sb.highUtilReported = true
+	sb.vars.highUtilizationEvents.Add(sb.statsKey, 1)
+	d := now.Sub(sb.highUtilSince)
+	sb.publishEvent(BufferEventHighUtilization, fmt.Sprintf("utilization above %v%% for %v", sb.cfg.HighUtilThreshold*100, d))
+	log.Warningf("Buffer utilization of shard: %s stayed above %v%% of the %v slots of pool %v for %v. Consider increasing the size of the buffer.",
+		topoproto.KeyspaceShardString(sb.keyspace, sb.shard), sb.cfg.HighUtilThreshold*100, sb.pool.size, sb.pool.name, d)
+}
+
 // unblockAndWait unblocks a blocked request.
 // If releaseSlot is true, the buffer semaphore will be decreased by 1 when
 // the request retried and finished.
@@ -354,6 +617,8 @@ func (sb *shardBuffer) unblockAndWait(e *entry, err error, releaseSlot, blocking
 	e.err = err
 	// Tell blocked request to stop waiting.
 	close(e.done)
+	// The request is no longer buffered and its size does not count anymore.
+	sb.vars.bytesInUse.Add(-e.size)
 
 	if blockingWait {
 		sb.waitForRequestFinish(e, releaseSlot, false /* async */)
@@ -376,7 +641,7 @@ func (sb *shardBuffer) waitForRequestFinish(e *entry, releaseSlot, async bool) {
 	// the buffer full eviction or the timeout thread does not block on us.
 	// This way, the request's slot can only be reused after the request finished.
 	if releaseSlot {
-		sb.bufferSizeSema.Release()
+		sb.pool.release()
 	}
 }
 
@@ -406,6 +671,9 @@ func (sb *shardBuffer) oldestEntry() *entry {
 // evictOldestEntry is used by timeoutThread to evict the head entry of the
 // queue if it exceeded its buffering window.
 func (sb *shardBuffer) evictOldestEntry(e *entry) {
+	// The deferred calls run in reverse order i.e. the latency includes the
+	// unlock.
+	defer sb.vars.dequeueLatency.Record(sb.statsKey, time.Now())
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
 
@@ -424,12 +692,17 @@ func (sb *shardBuffer) evictOldestEntry(e *entry) {
 	sb.unblockAndWait(e, nil /* err */, true /* releaseSlot */, false /* blockingWait */)
 	sb.queue = sb.queue[1:]
 	statsKeyWithReason := append(sb.statsKey, evictedWindowExceeded)
-	requestsEvicted.Add(statsKeyWithReason, 1)
+	sb.vars.requestsEvicted.Add(statsKeyWithReason, 1)
+	sb.publishEvent(BufferEventEvict, evictedWindowExceeded)
+	sb.checkHighUtilizationLocked()
 }
 
 // remove must be called when the request was canceled from outside and not
 // internally.
 func (sb *shardBuffer) remove(toRemove *entry) {
+	// The deferred calls run in reverse order i.e. the latency includes the
+	// unlock.
+	defer sb.vars.dequeueLatency.Record(sb.statsKey, time.Now())
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
 
@@ -460,7 +733,9 @@ func (sb *shardBuffer) remove(toRemove *entry) {
 
 			// Track it as "ContextDone" eviction.
 			statsKeyWithReason := append(sb.statsKey, string(evictedContextDone))
-			requestsEvicted.Add(statsKeyWithReason, 1)
+			sb.vars.requestsEvicted.Add(statsKeyWithReason, 1)
+			sb.publishEvent(BufferEventEvict, string(evictedContextDone))
+			sb.checkHighUtilizationLocked()
 			return
 		}
 	}
@@ -492,44 +767,121 @@ func (sb *shardBuffer) recordExternallyReparentedTimestamp(timestamp int64, alia
 	sb.externallyReparented = timestamp
 	if !topoproto.TabletAliasEqual(alias, sb.currentMaster) {
 		if sb.currentMaster != nil {
-			sb.lastReparent = sb.now()
+			sb.lastReparent = sb.clock.Now()
 		}
 		sb.currentMaster = alias
 	}
 	sb.stopBufferingLocked(stopFailoverEndDetected, "failover end detected")
 }
 
+// recordShardRemoved stops a pending buffering because the shard no longer
+// serves MASTER traffic e.g. because it or its keyspace was deleted. There
+// will be no new master which would end the failover. The buffered requests
+// fail with a FAILED_PRECONDITION error which makes vtgate re-resolve the
+// shard of each request.
+func (sb *shardBuffer) recordShardRemoved(details string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	drainErr := vterrors.Errorf(vtrpcpb.Code_FAILED_PRECONDITION, "%v: %v Retry the request", topoproto.KeyspaceShardString(sb.keyspace, sb.shard), details)
+	sb.stopBufferingWithErrLocked(stopKeyspaceRemoved, details, drainErr)
+}
+
+// recordKeyspaceChange handles the move of the shard to "newKeyspace" e.g.
+// due to a topology reconfiguration. "alias" is the master which now reports
+// the new keyspace. The buffered requests are evicted because their stats key
+// is stale and no new master would end the failover in this keyspace.
+// Instead, they fail with a FAILED_PRECONDITION error which makes vtgate
+// re-resolve the keyspace of each request.
+func (sb *shardBuffer) recordKeyspaceChange(alias *topodatapb.TabletAlias, newKeyspace string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if !topoproto.TabletAliasEqual(alias, sb.currentMaster) {
+		// Not the master we know of.
+		return
+	}
+	// The master is gone from this shard. Do not track it as reparent when
+	// it comes back.
+	sb.currentMaster = nil
+	if sb.state != stateBuffering {
+		return
+	}
+
+	details := fmt.Sprintf("master %v moved to keyspace %v", topoproto.TabletAliasString(alias), newKeyspace)
+	evictErr := vterrors.Errorf(vtrpcpb.Code_FAILED_PRECONDITION, "%v: %v Retry the request", topoproto.KeyspaceShardString(sb.keyspace, sb.shard), details)
+	for len(sb.queue) > 0 {
+		sb.evictWithErrLocked(0, evictedTopologyChanged, evictErr, true /* releaseSlot */)
+	}
+	sb.stopBufferingLocked(stopKeyspaceRemoved, details)
+}
+
+// recordReshardCutover stops a pending buffering because the shard was
+// replaced by "destinationShards" during a resharding i.e. the MASTER traffic
+// was migrated to the destination shards. There will be no new master for the
+// shard which would end the failover.
+// The buffered requests are not retried on the shard. Instead, they fail with
+// a FAILED_PRECONDITION error. This makes vtgate re-resolve the shards of each
+// request and retry it on the destination shards.
+func (sb *shardBuffer) recordReshardCutover(destinationShards []string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	details := fmt.Sprintf("shard was resharded into the destination shards: %v", strings.Join(destinationShards, ","))
+	drainErr := vterrors.Errorf(vtrpcpb.Code_FAILED_PRECONDITION, "%v: %v Retry the request on the destination shards", topoproto.KeyspaceShardString(sb.keyspace, sb.shard), details)
+	sb.stopBufferingWithErrLocked(stopReshardCutover, details, drainErr)
+}
+
 func (sb *shardBuffer) stopBufferingDueToMaxDuration() {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
 
 	sb.stopBufferingLocked(stopMaxFailoverDurationExceeded,
-		fmt.Sprintf("stopping buffering because failover did not finish in time (%v)", *maxFailoverDuration))
+		fmt.Sprintf("stopping buffering because failover did not finish in time (%v)", sb.maxFailoverDuration))
 }
 
 func (sb *shardBuffer) stopBufferingLocked(reason stopReason, details string) {
+	sb.stopBufferingWithErrLocked(reason, details, nil /* drainErr */)
+}
+
+// stopBufferingWithErrLocked stops buffering. If "drainErr" is not nil, the
+// buffered requests are not retried and see the error instead.
+func (sb *shardBuffer) stopBufferingWithErrLocked(reason stopReason, details string, drainErr error) {
 	if sb.state != stateBuffering {
 		return
 	}
 
 	// Stop buffering.
-	sb.lastEnd = sb.now()
+	sb.lastEnd = sb.clock.Now()
 	d := sb.lastEnd.Sub(sb.lastStart)
 
 	statsKeyWithReason := append(sb.statsKey, string(reason))
-	stops.Add(statsKeyWithReason, 1)
+	sb.vars.stops.Add(statsKeyWithReason, 1)
+	if sb.mode == bufferDryRun {
+		sb.vars.stopsDryRun.Add(statsKeyWithReason, 1)
+	}
+	sb.stopHistory.add(sb.lastEnd, string(reason))
+	sb.publishEvent(BufferEventStop, string(reason))
 
-	lastFailoverDurationMs.Set(sb.statsKey, int64(d/time.Millisecond))
-	failoverDurationSumMs.Add(sb.statsKey, int64(d/time.Millisecond))
+	sb.vars.lastFailoverDurationMs.Set(sb.statsKey, int64(d/time.Millisecond))
+	sb.vars.failoverDurationSumMs.Add(sb.statsKey, int64(d/time.Millisecond))
+	sb.vars.failoverDurationTotalMs.Add(sb.statsKey, int64(d/time.Millisecond))
+	sb.vars.failoverDurationEWMA.Set(sb.statsKey, int64(sb.durationEWMA.add(float64(d/time.Millisecond), sb.cfg.EWMAAlpha)))
 	if sb.mode == bufferDryRun {
 		utilDryRunMax := int64(
-			float64(lastRequestsDryRunMax.Counts()[sb.statsKeyJoined]) / float64(*size) * 100.0)
-		utilizationDryRunSum.Add(sb.statsKey, utilDryRunMax)
+			float64(sb.vars.lastRequestsDryRunMax.Counts()[sb.statsKeyJoined]) / float64(sb.pool.size) * 100.0)
+		sb.vars.requestsDryRunMaxTotal.Add(sb.statsKey, sb.vars.lastRequestsDryRunMax.Counts()[sb.statsKeyJoined])
+		sb.vars.utilizationDryRunSum.Add(sb.statsKey, utilDryRunMax)
+		sb.vars.utilizationEWMA.Set(sb.statsKey, int64(sb.utilizationEWMA.add(float64(utilDryRunMax), sb.cfg.EWMAAlpha)))
 	} else {
 		utilMax := int64(
-			float64(lastRequestsInFlightMax.Counts()[sb.statsKeyJoined]) / float64(*size) * 100.0)
-		utilizationSum.Add(sb.statsKey, utilMax)
+			float64(sb.vars.lastRequestsInFlightMax.Counts()[sb.statsKeyJoined]) / float64(sb.pool.size) * 100.0)
+		sb.vars.requestsInFlightMaxTotal.Add(sb.statsKey, sb.vars.lastRequestsInFlightMax.Counts()[sb.statsKeyJoined])
+		sb.vars.utilizationSum.Add(sb.statsKey, utilMax)
+		sb.vars.utilizationEWMA.Set(sb.statsKey, int64(sb.utilizationEWMA.add(float64(utilMax), sb.cfg.EWMAAlpha)))
+		sb.vars.requestsPerFailover.Add(append(sb.statsKey, requestsPerFailoverBucket(sb.requestsThisFailover)), 1)
 	}
+	sb.persistLastFailoverStatsLocked()
 
 	sb.logErrorIfStateNotLocked(stateBuffering)
 	sb.state = stateDraining
@@ -546,24 +898,44 @@ func (sb *shardBuffer) stopBufferingLocked(reason stopReason, details string) {
 
 	// Start the drain. (Use a new Go routine to release the lock.)
 	sb.wg.Add(1)
-	go sb.drain(q)
+	go sb.drain(q, drainErr)
 }
 
-func (sb *shardBuffer) drain(q []*entry) {
+func (sb *shardBuffer) drain(q []*entry, err error) {
 	defer sb.wg.Done()
 
 	// stop must be called outside of the lock because the thread may access
 	// shardBuffer as well e.g. to get the current oldest entry.
 	sb.timeoutThread.stop()
 
-	start := sb.now()
-	// TODO(mberlin): Parallelize the drain by pumping the data through a channel.
+	// Retry requests with a higher priority first. The sort is stable i.e.
+	// requests with the same priority are retried in the order of arrival.
+	sort.SliceStable(q, func(i, j int) bool {
+		return q[i].priority > q[j].priority
+	})
+
+	start := sb.clock.Now()
+	// Pump the entries through a channel to up to "drainConcurrency" Go
+	// routines. Each Go routine blocks until its retried request finished.
+	entries := make(chan *entry)
+	var wg sync.WaitGroup
+	for i := 0; i < sb.cfg.DrainConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range entries {
+				sb.unblockAndWait(e, err, true /* releaseSlot */, true /* blockingWait */)
+			}
+		}()
+	}
 	for _, e := range q {
-		sb.unblockAndWait(e, nil /* err */, true /* releaseSlot */, true /* blockingWait */)
+		entries <- e
 	}
-	d := sb.now().Sub(start)
+	close(entries)
+	wg.Wait()
+	d := sb.clock.Now().Sub(start)
 	log.Infof("Draining finished for shard: %s Took: %v for: %d requests.", topoproto.KeyspaceShardString(sb.keyspace, sb.shard), d, len(q))
-	requestsDrained.Add(sb.statsKey, int64(len(q)))
+	sb.vars.requestsDrained.Add(sb.statsKey, int64(len(q)))
 
 	// Draining is done. Change state from "draining" to "idle".
 	sb.mu.Lock()
@@ -573,6 +945,37 @@ func (sb *shardBuffer) drain(q []*entry) {
 	sb.timeoutThread = nil
 }
 
+// isBuffering returns true if the shard is currently buffering requests and,
+// if so, when buffering started and the error which triggered it.
+func (sb *shardBuffer) isBuffering() (bool, time.Time, string) {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+
+	if sb.mode != bufferEnabled || sb.state != stateBuffering {
+		return false, time.Time{}, ""
+	}
+	return true, sb.lastStart, sb.lastStartReason
+}
+
+// drainInProgress returns true if the shard is currently draining.
+func (sb *shardBuffer) drainInProgress() bool {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return sb.drainInProgressLocked()
+}
+
+func (sb *shardBuffer) drainInProgressLocked() bool {
+	return sb.mode == bufferEnabled && sb.state == stateDraining
+}
+
+// utilizationPercent returns the share (in %) of the pool slots which are
+// currently used by the buffered requests of this shard.
+func (sb *shardBuffer) utilizationPercent() int64 {
+	sb.mu.RLock()
+	defer sb.mu.RUnlock()
+	return int64(len(sb.queue)) * 100 / int64(sb.pool.size)
+}
+
 func (sb *shardBuffer) shutdown() {
 	sb.mu.Lock()
 	sb.stopBufferingLocked(stopShutdown, "shutdown")
@@ -0,0 +1,298 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/discovery"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+	"vitess.io/vitess/go/vt/vterrors"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
+)
+
+// RequestEvent is an event of a recorded failover trace. See Simulate().
+type RequestEvent struct {
+	// At is the time of the event relative to the start of the trace.
+	At       time.Duration
+	Keyspace string
+	Shard    string
+	// FailoverError is true if the request failed due to the failover. Only
+	// such requests can start buffering. Once buffering started, all requests
+	// for the shard are buffered.
+	FailoverError bool
+	// FailoverEnd is true if the event is not a request but the end of the
+	// failover i.e. the new master was seen.
+	FailoverEnd bool
+}
+
+var simulatedFailoverError = vterrors.New(vtrpcpb.Code_FAILED_PRECONDITION, "operation not allowed in state NOT_SERVING (simulated failover)")
+
+// Simulate replays a recorded failover trace against a new buffer with the
+// configuration "cfg" and returns the resulting stats. It can be used to
+// tune the buffer flags offline.
+//
+// The real buffering algorithm is run with a simulated clock. The events are
+// processed one after another: Before the next event, the clock is advanced
+// to its time and all buffered requests which exceeded the window and all
+// failovers which exceeded the max duration in the meantime are evicted
+// respectively stopped. Buffered requests are retried instantly. After the
+// last event, the simulation continues until all failovers have ended.
+// Therefore, the result is deterministic unless cfg.MaxDurationJitter is set.
+//
+// An error is returned if "cfg" is invalid (see verifyConfig()). An empty
+// cfg.FullPolicy defaults to evict_oldest. Simulate neither reads nor changes
+// the flags and does not update the exported stats. Therefore, it can be
+// run concurrently and in a process which buffers requests.
+func Simulate(trace []RequestEvent, cfg BufferConfig) (BufferStats, error) {
+	if cfg.FullPolicy == "" {
+		cfg.FullPolicy = fullPolicyEvictOldest
+	}
+	if err := verifyConfig(cfg); err != nil {
+		return BufferStats{}, err
+	}
+
+	events := make([]RequestEvent, len(trace))
+	copy(events, trace)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At < events[j].At
+	})
+
+	s := newSimulation(&cfg)
+	defer s.b.Shutdown()
+	for _, e := range events {
+		s.addShard(e.Keyspace, e.Shard)
+	}
+	before := s.b.StatsSnapshot()
+
+	for _, e := range events {
+		s.runTimeouts(e.At, true /* limited */)
+		if e.FailoverEnd {
+			s.reportMaster(e.Keyspace, e.Shard)
+			continue
+		}
+		s.request(e)
+	}
+	s.runTimeouts(0, false /* limited */)
+
+	return statsSince(before, s.b.StatsSnapshot()), nil
+}
+
+// simulation drives a Buffer for Simulate(). It's also the clock of the
+// buffer.
+type simulation struct {
+	b *Buffer
+	// buffers has all simulated shards, sorted by keyspace and shard.
+	buffers []*shardBuffer
+	// timestamp is the last reported externally reparented timestamp. It is
+	// also used as the UID of the reported master.
+	timestamp int64
+	// buffered receives a value when a simulated request was buffered.
+	buffered chan struct{}
+
+	// mu guards "now".
+	mu    sync.Mutex
+	start time.Time
+	now   time.Time
+}
+
+func newSimulation(cfg *BufferConfig) *simulation {
+	start := time.Now()
+	s := &simulation{
+		buffered: make(chan struct{}, 1),
+		start:    start,
+		now:      start,
+	}
+	s.b = newBuffer(s, cfg, newUnpublishedVariables())
+	return s
+}
+
+// Now implements the Clock interface.
+func (s *simulation) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+// NewTimer implements the Clock interface. The returned timer never fires.
+// Instead, runTimeouts() runs the timeouts in the order of their time.
+func (s *simulation) NewTimer(d time.Duration) Timer {
+	return simulationTimer{}
+}
+
+func (s *simulation) setTime(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.After(s.now) {
+		s.now = now
+	}
+}
+
+// addShard creates the buffer for the shard, if not done yet, and reports its
+// current master. vtgate sees the master at startup as well.
+func (s *simulation) addShard(keyspace, shard string) {
+	key := topoproto.KeyspaceShardString(keyspace, shard)
+	for _, sb := range s.buffers {
+		if topoproto.KeyspaceShardString(sb.keyspace, sb.shard) == key {
+			return
+		}
+	}
+	sb := s.b.getOrCreateBuffer(keyspace, shard)
+	sb.mu.Lock()
+	sb.bufferedHook = func() {
+		s.buffered <- struct{}{}
+	}
+	sb.mu.Unlock()
+	s.buffers = append(s.buffers, sb)
+	sort.Slice(s.buffers, func(i, j int) bool {
+		if s.buffers[i].keyspace != s.buffers[j].keyspace {
+			return s.buffers[i].keyspace < s.buffers[j].keyspace
+		}
+		return s.buffers[i].shard < s.buffers[j].shard
+	})
+	s.reportMaster(keyspace, shard)
+}
+
+// reportMaster reports a new master for the shard. This ends an ongoing
+// failover.
+func (s *simulation) reportMaster(keyspace, shard string) {
+	s.timestamp++
+	s.b.StatsUpdate(&discovery.TabletStats{
+		Tablet:                              &topodatapb.Tablet{Alias: &topodatapb.TabletAlias{Cell: "simulation", Uid: uint32(s.timestamp)}},
+		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
+		TabletExternallyReparentedTimestamp: s.timestamp,
+	})
+	s.settle()
+}
+
+// request runs the request "e" and blocks until it either returned or was
+// buffered.
+func (s *simulation) request(e RequestEvent) {
+	var err error
+	if e.FailoverError {
+		err = simulatedFailoverError
+	}
+
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		retryDone, _ := s.b.WaitForFailoverEnd(context.Background(), e.Keyspace, e.Shard, err)
+		if retryDone != nil {
+			// The retry succeeds instantly.
+			retryDone()
+		}
+	}()
+
+	select {
+	case <-returned:
+	case <-s.buffered:
+	}
+	s.settle()
+}
+
+// runTimeouts evicts all buffered requests which exceeded the window and
+// stops all failovers which exceeded the max duration, in the order of their
+// timeout. If "limited" is true, only timeouts up to the trace time "until"
+// are run and the clock is advanced to it. Otherwise, all timeouts are run.
+func (s *simulation) runTimeouts(until time.Duration, limited bool) {
+	for {
+		sb, at, e, ok := s.nextTimeout()
+		if !ok || (limited && at.After(s.start.Add(until))) {
+			break
+		}
+		s.setTime(at)
+		if e != nil {
+			sb.evictOldestEntry(e)
+		} else {
+			sb.stopBufferingDueToMaxDuration()
+		}
+		s.settle()
+	}
+	if limited {
+		s.setTime(s.start.Add(until))
+	}
+}
+
+// nextTimeout returns the earliest timeout across all shards. If "e" is not
+// nil, the timeout is the window of the buffered request "e". Otherwise, it
+// is the max duration of the failover.
+func (s *simulation) nextTimeout() (next *shardBuffer, at time.Time, e *entry, ok bool) {
+	for _, sb := range s.buffers {
+		sb.mu.RLock()
+		if sb.state == stateBuffering {
+			sbAt := sb.lastStart.Add(sb.maxFailoverDuration)
+			var sbEntry *entry
+			if len(sb.queue) > 0 && sb.queue[0].deadline.Before(sbAt) {
+				sbAt = sb.queue[0].deadline
+				sbEntry = sb.queue[0]
+			}
+			if !ok || sbAt.Before(at) {
+				next, at, e, ok = sb, sbAt, sbEntry, true
+			}
+		}
+		sb.mu.RUnlock()
+	}
+	return next, at, e, ok
+}
+
+// simulationTimer is a timer which never fires.
+type simulationTimer struct{}
+
+func (simulationTimer) C() <-chan time.Time {
+	return nil
+}
+
+func (simulationTimer) Stop() bool {
+	return true
+}
+
+// settle blocks until all Go routines, which were started by the last
+// action, are done e.g. the drain or the release of an evicted slot.
+// Buffered requests which wait for the end of the failover are not tracked.
+func (s *simulation) settle() {
+	for _, sb := range s.buffers {
+		sb.waitForShutdown()
+	}
+}
+
+// statsSince returns the difference between the two stats. Both must list
+// the same shards.
+func statsSince(before, after BufferStats) BufferStats {
+	for i := range after.Shards {
+		a, b := &after.Shards[i], before.Shards[i]
+		a.Starts -= b.Starts
+		a.Buffered -= b.Buffered
+		a.Drained -= b.Drained
+		for reason, v := range b.StopsByReason {
+			a.StopsByReason[reason] -= v
+		}
+		for reason, v := range b.EvictedByReason {
+			a.EvictedByReason[reason] -= v
+		}
+		for reason, v := range b.SkippedByReason {
+			a.SkippedByReason[reason] -= v
+		}
+	}
+	return after
+}
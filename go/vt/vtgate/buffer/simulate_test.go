@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	resetVariables()
+	defer checkVariables(t)
+
+	cfg := BufferConfig{
+		Enabled:                 true,
+		Size:                    3,
+		SoftLimit:               1.0,
+		Window:                  1 * time.Second,
+		MaxFailoverDuration:     3 * time.Second,
+		MinTimeBetweenFailovers: 6 * time.Second,
+		DrainConcurrency:        1,
+		EWMAAlpha:               0.3,
+	}
+	trace := []RequestEvent{
+		// shard2 starts buffering but never sees the end of the failover.
+		{At: 0, Keyspace: keyspace, Shard: shard2, FailoverError: true},
+		{At: 0, Keyspace: keyspace, Shard: shard, FailoverError: true},
+		{At: 100 * time.Millisecond, Keyspace: keyspace, Shard: shard},
+		// The buffer is full. The first request of shard is evicted.
+		{At: 200 * time.Millisecond, Keyspace: keyspace, Shard: shard},
+		// All previous requests exceeded the window by now.
+		{At: 1500 * time.Millisecond, Keyspace: keyspace, Shard: shard},
+		{At: 2 * time.Second, Keyspace: keyspace, Shard: shard, FailoverEnd: true},
+		// The last failover is too recent.
+		{At: 2500 * time.Millisecond, Keyspace: keyspace, Shard: shard, FailoverError: true},
+	}
+
+	newShardStats := func(shard string) ShardStats {
+		s := ShardStats{
+			Keyspace:        keyspace,
+			Shard:           shard,
+			StopsByReason:   make(map[string]int64),
+			EvictedByReason: make(map[string]int64),
+			SkippedByReason: make(map[string]int64),
+		}
+		for _, r := range stopReasons {
+			s.StopsByReason[string(r)] = 0
+		}
+		for _, r := range evictReasons {
+			s.EvictedByReason[string(r)] = 0
+		}
+		for _, r := range skippedReasons {
+			s.SkippedByReason[string(r)] = 0
+		}
+		return s
+	}
+	// The list is sorted and "-80" comes before "0".
+	want := BufferStats{Shards: []ShardStats{newShardStats(shard2), newShardStats(shard)}}
+	want.Shards[0].Starts = 1
+	want.Shards[0].StopsByReason[string(stopMaxFailoverDurationExceeded)] = 1
+	want.Shards[0].Buffered = 1
+	want.Shards[0].EvictedByReason[string(evictedWindowExceeded)] = 1
+	want.Shards[1].Starts = 1
+	want.Shards[1].StopsByReason[string(stopFailoverEndDetected)] = 1
+	want.Shards[1].Buffered = 4
+	want.Shards[1].Drained = 1
+	want.Shards[1].EvictedByReason[string(evictedBufferFull)] = 1
+	want.Shards[1].EvictedByReason[string(evictedWindowExceeded)] = 2
+	want.Shards[1].SkippedByReason[string(skippedLastFailoverTooRecent)] = 1
+
+	// The result must be the same for each run.
+	for i := 0; i < 3; i++ {
+		got, err := Simulate(trace, cfg)
+		if err != nil {
+			t.Fatalf("run %v: Simulate() failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %v: wrong simulation result:\ngot  = %+v\nwant = %+v", i, got, want)
+		}
+	}
+
+	// Neither the flags nor the exported stats may be changed.
+	if *enabled || *size != 10 || *window != 10*time.Second {
+		t.Fatalf("flags were changed: enabled: %v size: %v window: %v", *enabled, *size, *window)
+	}
+	if got := starts.Counts(); len(got) != 0 {
+		t.Fatalf("exported stats were changed: starts: %v", got)
+	}
+}
+
+func TestSimulate_InvalidConfig(t *testing.T) {
+	valid := BufferConfig{
+		Enabled:                 true,
+		Size:                    3,
+		SoftLimit:               1.0,
+		Window:                  1 * time.Second,
+		MaxFailoverDuration:     3 * time.Second,
+		MinTimeBetweenFailovers: 6 * time.Second,
+		DrainConcurrency:        1,
+		EWMAAlpha:               0.3,
+	}
+	trace := []RequestEvent{{At: 0, Keyspace: keyspace, Shard: shard, FailoverError: true}}
+
+	// Rejected by validateConfig().
+	cfg := valid
+	cfg.Window = 5 * time.Second
+	if _, err := Simulate(trace, cfg); err == nil || !strings.Contains(err.Error(), "-buffer_window must be <= -buffer_max_failover_duration") {
+		t.Fatalf("Simulate() with a window larger than the max failover duration must fail: %v", err)
+	}
+	// Rejected by verifyConfig().
+	cfg = valid
+	cfg.Size = 0
+	if _, err := Simulate(trace, cfg); err == nil || !strings.Contains(err.Error(), "-buffer_size must be >= 1") {
+		t.Fatalf("Simulate() with a size of 0 must fail: %v", err)
+	}
+
+	// The flags must not be changed.
+	if *enabled || *size != 10 {
+		t.Fatalf("flags were changed: enabled: %v size: %v", *enabled, *size)
+	}
+}
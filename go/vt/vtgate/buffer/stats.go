@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"sort"
+	"strings"
+)
+
+// BufferStats is a summary of the buffer stats variables.
+// See Buffer.StatsSnapshot().
+type BufferStats struct {
+	// Shards has an entry for each keyspace/shard which was seen by the
+	// buffer, sorted by keyspace and shard.
+	Shards []ShardStats
+}
+
+// ShardStats has the buffer stats of a keyspace/shard.
+// The values are cumulative i.e. they are not reset between failovers.
+type ShardStats struct {
+	Keyspace string
+	Shard    string
+	// Starts is the number of started failovers (buffering).
+	Starts int64
+	// StopsByReason has the number of stopped failovers per stop reason.
+	StopsByReason map[string]int64
+	// Buffered is the number of buffered requests.
+	Buffered int64
+	// Drained is the number of requests which were retried after a failover.
+	Drained int64
+	// EvictedByReason has the number of evicted requests per evict reason.
+	EvictedByReason map[string]int64
+	// SkippedByReason has the number of requests which were not buffered, per
+	// skip reason.
+	SkippedByReason map[string]int64
+}
+
+// StatsSnapshot returns the current values of the buffer stats variables
+// grouped by keyspace/shard. Unlike the exported variables, the result does
+// not require parsing the joined variable names.
+func (b *Buffer) StatsSnapshot() BufferStats {
+	b.mu.RLock()
+	statsKeys := make([][]string, 0, len(b.buffers))
+	for _, sb := range b.buffers {
+		statsKeys = append(statsKeys, sb.statsKey)
+	}
+	b.mu.RUnlock()
+	sort.Slice(statsKeys, func(i, j int) bool {
+		if statsKeys[i][0] != statsKeys[j][0] {
+			return statsKeys[i][0] < statsKeys[j][0]
+		}
+		return statsKeys[i][1] < statsKeys[j][1]
+	})
+
+	startsCounts := b.vars.starts.Counts()
+	stopsCounts := b.vars.stops.Counts()
+	bufferedCounts := b.vars.requestsBuffered.Counts()
+	drainedCounts := b.vars.requestsDrained.Counts()
+	evictedCounts := b.vars.requestsEvicted.Counts()
+	skippedCounts := b.vars.requestsSkipped.Counts()
+
+	result := BufferStats{
+		Shards: make([]ShardStats, 0, len(statsKeys)),
+	}
+	for _, statsKey := range statsKeys {
+		name := strings.Join(statsKey, ".")
+		s := ShardStats{
+			Keyspace:        statsKey[0],
+			Shard:           statsKey[1],
+			Starts:          startsCounts[name],
+			StopsByReason:   make(map[string]int64),
+			Buffered:        bufferedCounts[name],
+			Drained:         drainedCounts[name],
+			EvictedByReason: make(map[string]int64),
+			SkippedByReason: make(map[string]int64),
+		}
+		for _, reason := range stopReasons {
+			s.StopsByReason[string(reason)] = stopsCounts[name+"."+string(reason)]
+		}
+		for _, reason := range evictReasons {
+			s.EvictedByReason[string(reason)] = evictedCounts[name+"."+string(reason)]
+		}
+		for _, reason := range skippedReasons {
+			s.SkippedByReason[string(reason)] = skippedCounts[name+"."+string(reason)]
+		}
+		result.Shards = append(result.Shards, s)
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// Buffering is not enabled for shard2. Its request is skipped.
+	if retryDone, err := h.b.WaitForFailoverEnd(context.Background(), keyspace, shard2, failoverErr); err != nil || retryDone != nil {
+		t.Fatalf("request for shard %v should have been passed through: retryDone: %v err: %v", shard2, retryDone, err)
+	}
+	snapshot := h.runFailover(3, 2*time.Second)
+
+	got := h.b.StatsSnapshot()
+	if len(got.Shards) != 2 {
+		t.Fatalf("wrong number of shards: got = %v, want = 2: %+v", len(got.Shards), got)
+	}
+	// The list is sorted and "-80" comes before "0".
+	skipped, failedOver := got.Shards[0], got.Shards[1]
+	if skipped.Keyspace != keyspace || skipped.Shard != shard2 || failedOver.Keyspace != keyspace || failedOver.Shard != shard {
+		t.Fatalf("wrong shards: %+v", got)
+	}
+	if got, want := skipped.SkippedByReason[string(skippedDisabled)], int64(1); got != want {
+		t.Fatalf("wrong number of skipped requests for shard %v: got = %v, want = %v", shard2, got, want)
+	}
+	if skipped.Starts != 0 || skipped.Buffered != 0 {
+		t.Fatalf("shard %v must not have buffered: %+v", shard2, skipped)
+	}
+
+	// The snapshot must match the underlying counters.
+	for _, c := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"Starts", failedOver.Starts, snapshot.starts[statsKeyJoined]},
+		{"Buffered", failedOver.Buffered, snapshot.requestsBuffered[statsKeyJoined]},
+		{"Drained", failedOver.Drained, snapshot.requestsDrained[statsKeyJoined]},
+	} {
+		if c.got != c.want {
+			t.Errorf("wrong %v: got = %v, want = %v", c.name, c.got, c.want)
+		}
+	}
+	for _, r := range stopReasons {
+		if got, want := failedOver.StopsByReason[string(r)], snapshot.stops[statsKeyJoined+"."+string(r)]; got != want {
+			t.Errorf("wrong stops for reason %v: got = %v, want = %v", r, got, want)
+		}
+	}
+	for _, r := range evictReasons {
+		if got, want := failedOver.EvictedByReason[string(r)], snapshot.requestsEvicted[statsKeyJoined+"."+string(r)]; got != want {
+			t.Errorf("wrong evictions for reason %v: got = %v, want = %v", r, got, want)
+		}
+	}
+	for _, r := range skippedReasons {
+		if got, want := failedOver.SkippedByReason[string(r)], snapshot.requestsSkipped[statsKeyJoined+"."+string(r)]; got != want {
+			t.Errorf("wrong skips for reason %v: got = %v, want = %v", r, got, want)
+		}
+	}
+	if failedOver.Starts != 1 || failedOver.StopsByReason[string(stopFailoverEndDetected)] != 1 || failedOver.Buffered != 3 {
+		t.Fatalf("snapshot does not reflect the failover: %+v", failedOver)
+	}
+}
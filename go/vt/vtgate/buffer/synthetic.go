@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+	"vitess.io/vitess/go/vt/vterrors"
+
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
+)
+
+// syntheticFailoverError is reported as the reason of a synthetic failover.
+var syntheticFailoverError = vterrors.New(vtrpcpb.Code_FAILED_PRECONDITION, "operation not allowed in state NOT_SERVING (synthetic failover)")
+
+// TriggerSyntheticFailover runs a failover buffering cycle for keyspace/shard
+// without an actual reparent: It starts buffering, keeps buffering for
+// "duration" and then stops buffering as if the new master was seen.
+// It blocks until the buffering was stopped. The drain of the buffered
+// requests continues in the background.
+//
+// It's meant for end-to-end tests in staging environments and requires
+// -buffer_allow_synthetic_failover. Unlike a detected failover,
+// -buffer_min_time_between_failovers is not enforced. The buffering is still
+// stopped early if it exceeds -buffer_max_failover_duration.
+func (b *Buffer) TriggerSyntheticFailover(keyspace, shard string, duration time.Duration) error {
+	if !b.cfg.AllowSyntheticFailover {
+		return errors.New("synthetic failovers are not allowed. Set -buffer_allow_synthetic_failover to enable them")
+	}
+	sb := b.getOrCreateBuffer(keyspace, shard)
+	if sb == nil {
+		return errors.New("buffer is shut down")
+	}
+	if sb.disabled() {
+		return fmt.Errorf("buffering is not enabled for shard: %s", topoproto.KeyspaceShardString(keyspace, shard))
+	}
+	// The timer is created first such that the duration is measured from
+	// the start of the buffering at the latest.
+	t := b.clock.NewTimer(duration)
+	if err := sb.startSyntheticFailover(); err != nil {
+		t.Stop()
+		return err
+	}
+	<-t.C()
+	sb.stopSyntheticFailover()
+	return nil
+}
+
+func (sb *shardBuffer) startSyntheticFailover() error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if sb.state != stateIdle {
+		return fmt.Errorf("cannot start a synthetic failover for shard: %s because the buffer is in state %v", topoproto.KeyspaceShardString(sb.keyspace, sb.shard), sb.state)
+	}
+	log.Infof("Starting a synthetic failover for shard: %s", topoproto.KeyspaceShardString(sb.keyspace, sb.shard))
+	sb.startBufferingLocked(syntheticFailoverError)
+	return nil
+}
+
+// stopSyntheticFailover stops the buffering like a new master would. Unlike
+// recordExternallyReparentedTimestamp(), the tracked master is not changed.
+func (sb *shardBuffer) stopSyntheticFailover() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.stopBufferingLocked(stopFailoverEndDetected, "synthetic failover end")
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTriggerSyntheticFailover(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// Synthetic failovers must be explicitly allowed.
+	if err := h.b.TriggerSyntheticFailover(keyspace, shard, 1*time.Second); err == nil {
+		t.Fatal("synthetic failover without -buffer_allow_synthetic_failover should have failed")
+	}
+	h.b.cfg.AllowSyntheticFailover = true
+	// Buffering is not enabled for "shard2".
+	if err := h.b.TriggerSyntheticFailover(keyspace, shard2, 1*time.Second); err == nil {
+		t.Fatal("synthetic failover for a shard without buffering should have failed")
+	}
+
+	triggered := make(chan error)
+	go func() {
+		triggered <- h.b.TriggerSyntheticFailover(keyspace, shard, 2*time.Second)
+	}()
+	if err := waitForState(h.b, stateBuffering); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, reason := h.b.IsBuffering(keyspace, shard); reason != syntheticFailoverError.Error() {
+		t.Fatalf("wrong buffering reason: got = %v, want = %v", reason, syntheticFailoverError)
+	}
+	// A second synthetic failover cannot be started while buffering.
+	if err := h.b.TriggerSyntheticFailover(keyspace, shard, 1*time.Second); err == nil {
+		t.Fatal("synthetic failover during a failover should have failed")
+	}
+
+	// Requests without an error are buffered as well.
+	stopped := issueRequest(context.Background(), t, h.b, nil)
+	if err := waitForRequestsInFlight(h.b, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	h.clock.Advance(2 * time.Second)
+	if err := <-triggered; err != nil {
+		t.Fatalf("synthetic failover failed: %v", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("request should have been buffered and not returned an error: %v", err)
+	}
+	if err := waitForState(h.b, stateIdle); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := takeStatsSnapshot()
+	for _, c := range []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"BufferStarts", snapshot.starts[statsKeyJoined], 1},
+		{"BufferStops", snapshot.stops[statsKeyJoinedFailoverEndDetected], 1},
+		{"BufferFailoverDurationSumMs", snapshot.failoverDurationSumMs[statsKeyJoined], 2000},
+		{"BufferRequestsBuffered", snapshot.requestsBuffered[statsKeyJoined], 1},
+		{"BufferRequestsDrained", snapshot.requestsDrained[statsKeyJoined], 1},
+	} {
+		if c.got != c.want {
+			t.Errorf("wrong %v: got = %v, want = %v", c.name, c.got, c.want)
+		}
+	}
+}
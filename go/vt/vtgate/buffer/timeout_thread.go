@@ -18,7 +18,6 @@ package buffer
 
 import (
 	"sync"
-	"time"
 )
 
 // timeoutThread captures the state of the timeout thread.
@@ -28,8 +27,8 @@ import (
 type timeoutThread struct {
 	sb *shardBuffer
 	// maxDuration enforces that a failover stops after
-	// -buffer_max_failover_duration at most.
-	maxDuration *time.Timer
+	// -buffer_max_failover_duration (minus the jitter) at most.
+	maxDuration Timer
 	// stopChan will be closed when the thread should stop e.g. before the drain.
 	stopChan chan struct{}
 	wg       sync.WaitGroup
@@ -46,7 +45,7 @@ type timeoutThread struct {
 func newTimeoutThread(sb *shardBuffer) *timeoutThread {
 	return &timeoutThread{
 		sb:            sb,
-		maxDuration:   time.NewTimer(*maxFailoverDuration),
+		maxDuration:   sb.clock.NewTimer(sb.maxFailoverDuration),
 		stopChan:      make(chan struct{}),
 		queueNotEmpty: make(chan struct{}),
 	}
@@ -95,12 +94,12 @@ func (tt *timeoutThread) run() {
 // waitForEntry blocks until "e" exceeds its buffering window or buffering stops
 // in general. It returns true if the timeout thread should stop.
 func (tt *timeoutThread) waitForEntry(e *entry) bool {
-	windowExceeded := time.NewTimer(time.Until(e.deadline))
+	windowExceeded := tt.sb.clock.NewTimer(e.deadline.Sub(tt.sb.clock.Now()))
 	defer windowExceeded.Stop()
 
 	select {
 	// a) Always check these channels, regardless of the state.
-	case <-tt.maxDuration.C:
+	case <-tt.maxDuration.C():
 		// Max duration is up. Stop buffering. Do not error out entries explicitly.
 		tt.sb.stopBufferingDueToMaxDuration()
 		return true
@@ -116,7 +115,7 @@ func (tt *timeoutThread) waitForEntry(e *entry) bool {
 	// this thread would race with the request thread which runs
 	// shardBuffer.remove(). Instead, remove() will notify us here eventually by
 	// closing "e.done".
-	case <-windowExceeded.C:
+	case <-windowExceeded.C():
 		// Entry expired. Evict it and then get the next entry.
 		tt.sb.evictOldestEntry(e)
 		return false
@@ -133,7 +132,7 @@ func (tt *timeoutThread) waitForNonEmptyQueue() bool {
 
 	select {
 	// a) Always check these channels, regardless of the state.
-	case <-tt.maxDuration.C:
+	case <-tt.maxDuration.C():
 		// Max duration is up. Stop buffering. Do not error out entries explicitly.
 		tt.sb.stopBufferingDueToMaxDuration()
 		return true
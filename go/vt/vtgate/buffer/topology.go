@@ -0,0 +1,259 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/key"
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/topo"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+// This file contains the detection of deleted keyspaces and shards and of
+// resharding cutovers. A failover of such a shard never ends because there
+// will be no new master. The
+// healthcheck cannot tell us about it: A tablet is also removed from it when
+// it was replaced or changed its type. Instead, we watch the SrvKeyspace
+// objects of the local cell which list the shards serving MASTER traffic.
+
+// srvKeyspaceWatchRetryDelay is the time between two attempts to watch the
+// SrvKeyspace of a keyspace e.g. after the watch failed or the keyspace was
+// deleted.
+const srvKeyspaceWatchRetryDelay = 5 * time.Second
+
+// topologyWatcher watches the SrvKeyspace of each keyspace which has a
+// shardBuffer. It's shared by all shardBuffer instances and does nothing until
+// Buffer.SetTopoServer() was called.
+type topologyWatcher struct {
+	// onChange is called with the current SrvKeyspace of a keyspace. The value
+	// is nil if the keyspace was deleted from the topology.
+	onChange func(keyspace string, srvKeyspace *topodatapb.SrvKeyspace)
+	// ctx is canceled by stop() and ends all watches.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wg tracks the watch Go routines.
+	wg sync.WaitGroup
+
+	// mu guards all fields in this group.
+	mu sync.Mutex
+	// ts is nil until start() was called.
+	ts   *topo.Server
+	cell string
+	// keyspaces has the watched keyspaces. The value is true once their
+	// SrvKeyspace was seen. Only then a missing SrvKeyspace means that the
+	// keyspace was deleted.
+	keyspaces map[string]bool
+	stopped   bool
+}
+
+func newTopologyWatcher(onChange func(keyspace string, srvKeyspace *topodatapb.SrvKeyspace)) *topologyWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &topologyWatcher{
+		onChange:  onChange,
+		ctx:       ctx,
+		cancel:    cancel,
+		keyspaces: make(map[string]bool),
+	}
+}
+
+// start begins to watch the SrvKeyspace objects in "cell". Keyspaces which
+// were added before are watched as well.
+func (w *topologyWatcher) start(ts *topo.Server, cell string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped || w.ts != nil {
+		return
+	}
+	w.ts = ts
+	w.cell = cell
+	for keyspace := range w.keyspaces {
+		w.startWatchLocked(keyspace)
+	}
+}
+
+// watch adds "keyspace" to the watched keyspaces. It's a no-op if the
+// keyspace is already watched.
+func (w *topologyWatcher) watch(keyspace string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.keyspaces[keyspace]; ok {
+		return
+	}
+	w.keyspaces[keyspace] = false
+	if w.ts == nil || w.stopped {
+		return
+	}
+	w.startWatchLocked(keyspace)
+}
+
+func (w *topologyWatcher) startWatchLocked(keyspace string) {
+	ts := w.ts
+	cell := w.cell
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.watchKeyspace(ts, cell, keyspace)
+	}()
+}
+
+// watchKeyspace (re-)establishes the watch of "keyspace" until stop() is
+// called.
+func (w *topologyWatcher) watchKeyspace(ts *topo.Server, cell, keyspace string) {
+	for {
+		current, changes, cancel := ts.WatchSrvKeyspace(w.ctx, cell, keyspace)
+		w.handle(cell, keyspace, current)
+		if current.Err == nil {
+			for c := range changes {
+				w.handle(cell, keyspace, c)
+			}
+			cancel()
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(srvKeyspaceWatchRetryDelay):
+		}
+	}
+}
+
+func (w *topologyWatcher) handle(cell, keyspace string, data *topo.WatchSrvKeyspaceData) {
+	switch {
+	case data.Err == nil:
+		w.mu.Lock()
+		w.keyspaces[keyspace] = true
+		w.mu.Unlock()
+		w.onChange(keyspace, data.Value)
+	case topo.IsErrType(data.Err, topo.NoNode):
+		if !w.seen(keyspace) {
+			// The SrvKeyspace was not built yet e.g. in a new cell.
+			return
+		}
+		w.onChange(keyspace, nil)
+	case topo.IsErrType(data.Err, topo.Interrupted):
+		// The watch was canceled by stop().
+	default:
+		log.Warningf("Failed to watch the SrvKeyspace of keyspace %v in cell %v: %v", keyspace, cell, data.Err)
+	}
+}
+
+// seen returns true if the SrvKeyspace of "keyspace" was seen once.
+func (w *topologyWatcher) seen(keyspace string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.keyspaces[keyspace]
+}
+
+// stop ends all watches. It does not wait for them. See wait().
+func (w *topologyWatcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	w.cancel()
+}
+
+// wait blocks until all watch Go routines have returned.
+func (w *topologyWatcher) wait() {
+	w.wg.Wait()
+}
+
+// recordSrvKeyspace stops the pending bufferings of "keyspace" whose shard
+// no longer serves MASTER traffic. If the MASTER traffic was migrated to
+// shards with an overlapping key range, a resharding cutover took place.
+// Otherwise, the shard was removed. "srvKeyspace" is nil if the keyspace was
+// deleted from the topology.
+func (b *Buffer) recordSrvKeyspace(keyspace string, srvKeyspace *topodatapb.SrvKeyspace) {
+	var masterShards []*topodatapb.ShardReference
+	if srvKeyspace != nil {
+		partition := masterPartition(srvKeyspace)
+		if partition == nil {
+			// The keyspace does not serve MASTER traffic (yet).
+			return
+		}
+		masterShards = partition.ShardReferences
+	}
+
+	b.mu.RLock()
+	var buffers []*shardBuffer
+	for _, sb := range b.buffers {
+		if sb.keyspace == keyspace {
+			buffers = append(buffers, sb)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sb := range buffers {
+		if srvKeyspace == nil {
+			sb.recordShardRemoved(fmt.Sprintf("keyspace %v was deleted from the topology", keyspace))
+			continue
+		}
+		if servesShard(masterShards, sb.shard) {
+			continue
+		}
+		if destinationShards := overlappingShards(masterShards, sb.shard); len(destinationShards) > 0 {
+			// The shard was replaced by other shards during a resharding.
+			sb.recordReshardCutover(destinationShards)
+			continue
+		}
+		sb.recordShardRemoved("shard no longer serves MASTER traffic")
+	}
+}
+
+// masterPartition returns the MASTER partition of "srvKeyspace" or nil.
+func masterPartition(srvKeyspace *topodatapb.SrvKeyspace) *topodatapb.SrvKeyspace_KeyspacePartition {
+	for _, partition := range srvKeyspace.Partitions {
+		if partition.ServedType == topodatapb.TabletType_MASTER {
+			return partition
+		}
+	}
+	return nil
+}
+
+func servesShard(shards []*topodatapb.ShardReference, shard string) bool {
+	for _, ref := range shards {
+		if ref.Name == shard {
+			return true
+		}
+	}
+	return false
+}
+
+// overlappingShards returns the names of the shards whose key range
+// overlaps with the one of "shard".
+func overlappingShards(shards []*topodatapb.ShardReference, shard string) []string {
+	_, keyRange, err := topo.ValidateShardName(shard)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, ref := range shards {
+		if key.KeyRangesIntersect(keyRange, ref.KeyRange) {
+			names = append(names, ref.Name)
+		}
+	}
+	return names
+}
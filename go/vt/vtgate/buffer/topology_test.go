@@ -0,0 +1,231 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buffer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/discovery"
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/memorytopo"
+	"vitess.io/vitess/go/vt/vterrors"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
+)
+
+// localCell is the cell of the vtgate in the topology tests.
+const localCell = "cell1"
+
+// updateMasterShards writes the SrvKeyspace of "keyspace" with a MASTER
+// partition which consists of "shards".
+func updateMasterShards(t *testing.T, ts *topo.Server, shards ...string) {
+	partition := &topodatapb.SrvKeyspace_KeyspacePartition{
+		ServedType: topodatapb.TabletType_MASTER,
+	}
+	for _, s := range shards {
+		_, keyRange, err := topo.ValidateShardName(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partition.ShardReferences = append(partition.ShardReferences, &topodatapb.ShardReference{Name: s, KeyRange: keyRange})
+	}
+	srvKeyspace := &topodatapb.SrvKeyspace{
+		Partitions: []*topodatapb.SrvKeyspace_KeyspacePartition{partition},
+	}
+	if err := ts.UpdateSrvKeyspace(context.Background(), localCell, keyspace, srvKeyspace); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// watchTopology lets the buffer of "h" watch a topology where "shard" serves
+// the MASTER traffic of "keyspace". It returns after the buffer has seen the
+// SrvKeyspace.
+func watchTopology(h *failoverHarness) *topo.Server {
+	ts := memorytopo.NewServer(localCell)
+	updateMasterShards(h.t, ts, shard)
+	if err := h.b.SetTopoServer(context.Background(), ts, localCell); err != nil {
+		h.t.Fatal(err)
+	}
+	if err := waitForSrvKeyspaceSeen(h.b, keyspace); err != nil {
+		h.t.Fatal(err)
+	}
+	return ts
+}
+
+// waitForSrvKeyspaceSeen waits up to 10s until the buffer has seen the
+// SrvKeyspace of "keyspace".
+func waitForSrvKeyspaceSeen(b *Buffer, keyspace string) error {
+	start := time.Now()
+	for !b.watcher.seen(keyspace) {
+		if time.Since(start) > 10*time.Second {
+			return fmt.Errorf("SrvKeyspace of keyspace %v was not seen", keyspace)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	return nil
+}
+
+// waitForFailedPrecondition verifies that all pending requests fail with a
+// FAILED_PRECONDITION error which contains "details".
+func (h *failoverHarness) waitForFailedPrecondition(details string) {
+	for i, stopped := range h.pending {
+		err := <-stopped
+		if got, want := vterrors.Code(err), vtrpcpb.Code_FAILED_PRECONDITION; got != want {
+			h.t.Fatalf("request %v returned the wrong error code: got = %v, want = %v, err: %v", i, got, want, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), details) {
+			h.t.Fatalf("request %v returned an error which does not contain %q: %v", i, details, err)
+		}
+	}
+	h.pending = nil
+	if err := waitForState(h.b, stateIdle); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// TestKeyspaceRemoved tests that buffering stops when the keyspace is deleted
+// from the topology while buffering.
+func TestKeyspaceRemoved(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+	ts := watchTopology(h)
+
+	h.startBuffering()
+	h.enqueue(1)
+
+	if err := ts.DeleteSrvKeyspace(context.Background(), localCell, keyspace); err != nil {
+		t.Fatal(err)
+	}
+	h.waitForFailedPrecondition("keyspace ks1 was deleted")
+	snapshot := takeStatsSnapshot()
+
+	if got, want := snapshot.stops[statsKeyJoined+"."+string(stopKeyspaceRemoved)], int64(1); got != want {
+		t.Fatalf("wrong stop reason count: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsDrained[statsKeyJoined], int64(2); got != want {
+		t.Fatalf("wrong drained requests count: got = %v, want = %v", got, want)
+	}
+}
+
+// TestShardRemoved tests that buffering stops when the shard no longer serves
+// MASTER traffic and was not replaced by other shards.
+func TestShardRemoved(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+	ts := watchTopology(h)
+
+	h.startBuffering()
+	h.enqueue(1)
+
+	// An update which still lists the shard does not stop the buffering.
+	updateMasterShards(t, ts, shard)
+	// Remove the shard.
+	updateMasterShards(t, ts)
+	h.waitForFailedPrecondition("shard no longer serves MASTER traffic")
+	snapshot := takeStatsSnapshot()
+
+	if got, want := snapshot.stops[statsKeyJoined+"."+string(stopKeyspaceRemoved)], int64(1); got != want {
+		t.Fatalf("wrong stop reason count: got = %v, want = %v", got, want)
+	}
+}
+
+// TestReshardCutover tests that a resharding cutover stops the buffering and
+// the buffered requests see a FAILED_PRECONDITION error. vtgate re-resolves
+// such requests and retries them on the destination shards.
+func TestReshardCutover(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+	ts := watchTopology(h)
+
+	h.startBuffering()
+	h.enqueue(1)
+
+	// Migrate the MASTER traffic to the destination shards.
+	updateMasterShards(t, ts, "-80", "80-")
+	h.waitForFailedPrecondition("-80,80-")
+	snapshot := takeStatsSnapshot()
+
+	if got, want := snapshot.stops[statsKeyJoined+"."+string(stopReshardCutover)], int64(1); got != want {
+		t.Fatalf("wrong stop reason count: got = %v, want = %v", got, want)
+	}
+	if got, want := snapshot.requestsDrained[statsKeyJoined], int64(2); got != want {
+		t.Fatalf("wrong drained requests count: got = %v, want = %v", got, want)
+	}
+}
+
+func TestOverlappingShards(t *testing.T) {
+	var shards []*topodatapb.ShardReference
+	for _, s := range []string{"-40", "40-80", "80-"} {
+		_, keyRange, err := topo.ValidateShardName(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, &topodatapb.ShardReference{Name: s, KeyRange: keyRange})
+	}
+	for _, c := range []struct {
+		shard string
+		want  []string
+	}{
+		{"0", []string{"-40", "40-80", "80-"}},
+		{"-80", []string{"-40", "40-80"}},
+		{"80-c0", []string{"80-"}},
+		{"invalid-shard-name", nil},
+	} {
+		if got := overlappingShards(shards, c.shard); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("overlappingShards(%v) = %v, want = %v", c.shard, got, c.want)
+		}
+	}
+}
+
+// TestMasterRemovedFromHealthcheck tests that the buffering continues when the
+// current master is removed from the healthcheck. This also happens when the
+// tablet was replaced e.g. after its type changed. Only the topology tells us
+// if the shard is gone.
+func TestMasterRemovedFromHealthcheck(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+	watchTopology(h)
+
+	h.startBuffering()
+	h.enqueue(1)
+
+	h.b.StatsUpdate(&discovery.TabletStats{
+		Tablet:                              oldMaster,
+		Target:                              &querypb.Target{Keyspace: keyspace, Shard: shard, TabletType: topodatapb.TabletType_MASTER},
+		Up:                                  false,
+		TabletExternallyReparentedTimestamp: h.clock.Now().Unix(),
+		LastError:                           context.Canceled,
+	})
+	if err := waitForState(h.b, stateBuffering); err != nil {
+		t.Fatal(err)
+	}
+
+	h.injectNewMaster(2 * time.Second)
+	snapshot := h.drain()
+
+	if got, want := snapshot.stops[statsKeyJoined+"."+string(stopKeyspaceRemoved)], int64(0); got != want {
+		t.Fatalf("buffering must not be stopped due to a removed keyspace: got = %v, want = %v", got, want)
+	}
+}
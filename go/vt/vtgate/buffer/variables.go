@@ -17,7 +17,11 @@ limitations under the License.
 package buffer
 
 import (
+	"strconv"
+	"strings"
+
 	"vitess.io/vitess/go/stats"
+	"vitess.io/vitess/go/sync2"
 )
 
 // This file contains all status variables which can be used to monitor the
@@ -43,6 +47,23 @@ var (
 		"BufferFailoverDurationSumMs",
 		"Total buffering failover duration",
 		[]string{"Keyspace", "ShardName"})
+	// failoverDurationTotalMs, requestsInFlightMaxTotal and
+	// requestsDryRunMaxTotal are the monotonic companions of
+	// "failoverDurationSumMs" (which is reset when a failover starts) and of
+	// the "last*" gauges below. They are added to at the end of each failover
+	// and never reset. Monitoring systems can calculate rates from them.
+	failoverDurationTotalMs = stats.NewCountersWithMultiLabels(
+		"BufferFailoverDurationTotalMs",
+		"Total buffering failover duration across all failovers (never reset)",
+		[]string{"Keyspace", "ShardName"})
+	requestsInFlightMaxTotal = stats.NewCountersWithMultiLabels(
+		"BufferRequestsInFlightMaxTotal",
+		"Sum of the max value of buffered requests in flight of all failovers (never reset)",
+		[]string{"Keyspace", "ShardName"})
+	requestsDryRunMaxTotal = stats.NewCountersWithMultiLabels(
+		"BufferRequestsDryRunMaxTotal",
+		"Sum of the max # of requests which were seen during the dry-run bufferings of all failovers (never reset)",
+		[]string{"Keyspace", "ShardName"})
 
 	// utilizationSum is the cumulative sum of the maximum buffer utilization
 	// (in percentage) during each failover.
@@ -100,35 +121,168 @@ var (
 		"BufferRequestsSkipped",
 		"Skipped buffering requests (incl. dry-run)",
 		[]string{"Keyspace", "ShardName", "Reason"})
+	// stopsDryRun, requestsEvictedDryRun and requestsSkippedDryRun only count
+	// dry-run bufferings. The stops and skips are also included in "stops"
+	// and "requestsSkipped". Requests are never evicted during a dry-run.
+	// Instead, "requestsEvictedDryRun" counts the requests which would have
+	// evicted an older request because the buffer would have been full.
+	stopsDryRun = stats.NewCountersWithMultiLabels(
+		"BufferDryRunStops",
+		"Dry-run buffering operation stops",
+		[]string{"Keyspace", "ShardName", "Reason"})
+	requestsEvictedDryRun = stats.NewCountersWithMultiLabels(
+		"BufferDryRunRequestsEvicted",
+		"Requests which would have been evicted (dry-run)",
+		[]string{"Keyspace", "ShardName", "Reason"})
+	requestsSkippedDryRun = stats.NewCountersWithMultiLabels(
+		"BufferDryRunRequestsSkipped",
+		"Skipped buffering requests (dry-run)",
+		[]string{"Keyspace", "ShardName", "Reason"})
+	// requestsByPriority tracks how many requests were added to the buffer per
+	// priority. See the type "Priority" for all possible values of "Priority".
+	requestsByPriority = stats.NewCountersWithMultiLabels(
+		"BufferRequestsByPriority",
+		"Buffered requests by priority",
+		[]string{"Keyspace", "ShardName", "Priority"})
+	// requestsDuringDrain tracks how many requests saw a failover error while
+	// the buffer was draining. They were not buffered and retried immediately.
+	requestsDuringDrain = stats.NewCountersWithMultiLabels(
+		"BufferRequestsDuringDrain",
+		"Requests with a failover error which were not buffered because the buffer was draining",
+		[]string{"Keyspace", "ShardName"})
+	// drainBackpressureEvents tracks how many requests were not buffered while
+	// the buffer was draining. Each of them was delayed by up to
+	// -buffer_drain_backpressure_delay (if set).
+	drainBackpressureEvents = stats.NewCountersWithMultiLabels(
+		"BufferDrainBackpressureEvents",
+		"Requests which were passed through during a drain",
+		[]string{"Keyspace", "ShardName"})
+	// failoverDurationEWMA and utilizationEWMA are the exponentially weighted
+	// moving averages of the failover duration (in milliseconds) and of the
+	// maximum buffer utilization (in percentage) per failover.
+	// Unlike the sums above, they can be used directly by dashboards.
+	// The smoothing factor is set by -buffer_ewma_alpha.
+	failoverDurationEWMA = stats.NewGaugesWithMultiLabels(
+		"BufferFailoverDurationEWMA",
+		"Moving average of the failover duration (in ms)",
+		[]string{"Keyspace", "ShardName"})
+	utilizationEWMA = stats.NewGaugesWithMultiLabels(
+		"BufferUtilizationEWMA",
+		"Moving average of the buffer utilization (in %) during failover",
+		[]string{"Keyspace", "ShardName"})
+	// highUtilizationEvents counts how often the buffered requests of a shard
+	// used more than -buffer_high_util_threshold of the pool slots for longer
+	// than -buffer_high_util_duration. It's increased at most once per
+	// period of high utilization. Frequent events indicate that the buffer is
+	// too small.
+	highUtilizationEvents = stats.NewCountersWithMultiLabels(
+		"BufferHighUtilizationEvents",
+		"Periods in which the buffer utilization stayed above the threshold for longer than the configured duration",
+		[]string{"Keyspace", "ShardName"})
+	// recencyGraceBuffered counts the failovers which were buffered because
+	// they started within -buffer_recency_grace before the end of
+	// -buffer_min_time_between_failovers. Without the grace period, their
+	// requests would have been skipped as "LastFailoverTooRecent".
+	recencyGraceBuffered = stats.NewCountersWithMultiLabels(
+		"BufferRecencyGraceBuffered",
+		"Failovers which were buffered due to the recency grace period instead of being skipped",
+		[]string{"Keyspace", "ShardName"})
+	// requestsPerFailover is a histogram of the number of requests which were
+	// buffered during a failover. It's observed when the failover ends.
+	// Dry-run failovers are not observed. See
+	// "requestsPerFailoverCutoffs" below for the values of "Bucket".
+	requestsPerFailover = stats.NewCountersWithMultiLabels(
+		"BufferRequestsPerFailover",
+		"Histogram of the number of buffered requests per failover",
+		[]string{"Keyspace", "ShardName", "Bucket"})
+	// timeBetweenFailoversMs is the time between the starts of the last two
+	// failovers (including dry-run bufferings). It's set when a failover
+	// starts. Low values indicate a flapping shard.
+	timeBetweenFailoversMs = stats.NewGaugesWithMultiLabels(
+		"BufferTimeBetweenFailoversMs",
+		"Time between the starts of the last two failovers (in ms)",
+		[]string{"Keyspace", "ShardName"})
+	// enqueueLatency and dequeueLatency track the time which is spent in the
+	// critical sections which add a request to the queue or remove it. The time
+	// includes waiting for the lock and can be used to detect lock contention.
+	// Dequeue covers requests which were canceled or exceeded their window.
+	// The drain removes all requests at once and is not tracked.
+	enqueueLatency = stats.NewMultiTimings(
+		"BufferEnqueueLatencyNs",
+		"Time spent to add a request to the buffer (incl. waiting for the lock)",
+		[]string{"Keyspace", "ShardName"})
+	dequeueLatency = stats.NewMultiTimings(
+		"BufferDequeueLatencyNs",
+		"Time spent to remove a request from the buffer (incl. waiting for the lock)",
+		[]string{"Keyspace", "ShardName"})
 )
 
+// requestsPerFailoverCutoffs are the inclusive upper bounds of the buckets of
+// "requestsPerFailover". Like stats.Histogram, a value v falls into the first
+// bucket with v <= cutoff. Larger values fall into the bucket "inf".
+var requestsPerFailoverCutoffs = []int64{0, 1, 5, 10, 50, 100, 500, 1000}
+
+// requestsPerFailoverBuckets returns all values of the "Bucket" label of
+// "requestsPerFailover".
+func requestsPerFailoverBuckets() []string {
+	buckets := make([]string, 0, len(requestsPerFailoverCutoffs)+1)
+	for _, cutoff := range requestsPerFailoverCutoffs {
+		buckets = append(buckets, strconv.FormatInt(cutoff, 10))
+	}
+	return append(buckets, "inf")
+}
+
+// requestsPerFailoverBucket returns the "Bucket" label for "requests".
+func requestsPerFailoverBucket(requests int64) string {
+	for _, cutoff := range requestsPerFailoverCutoffs {
+		if requests <= cutoff {
+			return strconv.FormatInt(cutoff, 10)
+		}
+	}
+	return "inf"
+}
+
 // stopReason is used in "stopsByReason" as "Reason" label.
 type stopReason string
 
-var stopReasons = []stopReason{stopFailoverEndDetected, stopMaxFailoverDurationExceeded, stopShutdown}
+var stopReasons = []stopReason{stopFailoverEndDetected, stopMaxFailoverDurationExceeded, stopShutdown, stopKeyspaceRemoved, stopReshardCutover}
 
 const (
 	stopFailoverEndDetected         stopReason = "NewMasterSeen"
 	stopMaxFailoverDurationExceeded stopReason = "MaxDurationExceeded"
 	stopShutdown                    stopReason = "Shutdown"
+	// stopKeyspaceRemoved is used when the shard no longer serves MASTER
+	// traffic because it or its keyspace was deleted from the topology. It's
+	// also used when the shard moved to another keyspace.
+	stopKeyspaceRemoved stopReason = "KeyspaceRemoved"
+	// stopReshardCutover is used when the shard stopped serving MASTER traffic
+	// because it was replaced by its destination shards during a resharding.
+	// See Buffer.recordSrvKeyspace().
+	stopReshardCutover stopReason = "ReshardCutover"
 )
 
 // evictedReason is used in "requestsEvicted" as "Reason" label.
 type evictedReason string
 
-var evictReasons = []evictedReason{evictedContextDone, evictedBufferFull, evictedWindowExceeded}
+var evictReasons = []evictedReason{evictedContextDone, evictedBufferFull, evictedWindowExceeded, evictedMaxBytes, evictedTopologyChanged}
 
 const (
 	evictedContextDone evictedReason = "ContextDone"
 	//lint: ignore SA9004 ok not to use explicit type here because implicit type string is correct
 	evictedBufferFull     = "BufferFull"
 	evictedWindowExceeded = "WindowExceeded"
+	// evictedMaxBytes is used when a request was evicted because a newer
+	// request would have exceeded -buffer_max_bytes.
+	evictedMaxBytes evictedReason = "MaxBytesExceeded"
+	// evictedTopologyChanged is used when the shard moved to another keyspace
+	// while requests were buffered. See Buffer.recordMasterKeyspace().
+	evictedTopologyChanged evictedReason = "TopologyChanged"
 )
 
 // skippedReason is used in "requestsSkipped" as "Reason" label.
 type skippedReason string
 
-var skippedReasons = []skippedReason{skippedBufferFull, skippedDisabled, skippedShutdown, skippedLastReparentTooRecent, skippedLastFailoverTooRecent}
+var skippedReasons = []skippedReason{skippedBufferFull, skippedDisabled, skippedShutdown, skippedLastReparentTooRecent, skippedLastFailoverTooRecent, skippedSoftLimit, skippedInTransaction, skippedMaxBytes, skippedClientOptOut, skippedPerShardLimit}
 
 const (
 	// skippedBufferFull occurs when all slots in the buffer are occupied by one
@@ -141,35 +295,91 @@ const (
 	skippedShutdown              = "Shutdown"
 	skippedLastReparentTooRecent = "LastReparentTooRecent"
 	skippedLastFailoverTooRecent = "LastFailoverTooRecent"
+	// skippedSoftLimit is used for requests with a priority lower than
+	// "PriorityHigh" while the buffer is above -buffer_soft_limit.
+	skippedSoftLimit = "SoftLimit"
+	// skippedInTransaction is used for requests which are part of a
+	// multi-statement transaction (see NewContextInTransaction()) and failed
+	// due to the failover. The transaction cannot be continued on the new
+	// master.
+	skippedInTransaction skippedReason = "InTransaction"
+	// skippedMaxBytes is used when a request would exceed -buffer_max_bytes
+	// even after all buffered requests of its shard were evicted.
+	skippedMaxBytes skippedReason = "MaxBytesExceeded"
+	// skippedClientOptOut is used for requests whose client opted out of
+	// buffering (see NewContextNoBuffer()).
+	skippedClientOptOut skippedReason = "ClientOptOut"
+	// skippedPerShardLimit is used for requests which would exceed
+	// -buffer_max_per_shard.
+	skippedPerShardLimit skippedReason = "PerShardLimit"
 )
 
-// initVariablesForShard is used to initialize all shard variables to 0.
+// initForShard is used to initialize all shard variables to 0.
 // If we don't do this, monitoring frameworks may not correctly calculate rates
 // for the first failover of the shard because they see a transition from
 // "no value for this label set (NaN)" to "a value".
 // "statsKey" should have two members for keyspace and shard.
-func initVariablesForShard(statsKey []string) {
-	starts.Reset(statsKey)
+// The dry-run variables are only initialized if the shard is in dry-run mode.
+func (v *variables) initForShard(statsKey []string, mode bufferMode) {
+	v.starts.Reset(statsKey)
 	for _, reason := range stopReasons {
 		key := append(statsKey, string(reason))
-		stops.Reset(key)
+		v.stops.Reset(key)
 	}
 
-	failoverDurationSumMs.Reset(statsKey)
+	v.failoverDurationSumMs.Reset(statsKey)
+	v.failoverDurationTotalMs.Reset(statsKey)
+	v.requestsInFlightMaxTotal.Reset(statsKey)
+	v.requestsDryRunMaxTotal.Reset(statsKey)
 
-	utilizationSum.Set(statsKey, 0)
-	utilizationDryRunSum.Reset(statsKey)
+	v.utilizationSum.Set(statsKey, 0)
+	v.utilizationDryRunSum.Reset(statsKey)
+	v.failoverDurationEWMA.Set(statsKey, 0)
+	v.utilizationEWMA.Set(statsKey, 0)
+	v.timeBetweenFailoversMs.Set(statsKey, 0)
+	v.highUtilizationEvents.Reset(statsKey)
+	v.recencyGraceBuffered.Reset(statsKey)
+	for _, bucket := range requestsPerFailoverBuckets() {
+		v.requestsPerFailover.Reset(append(statsKey, bucket))
+	}
 
-	requestsBuffered.Reset(statsKey)
-	requestsBufferedDryRun.Reset(statsKey)
-	requestsDrained.Reset(statsKey)
+	v.requestsBuffered.Reset(statsKey)
+	v.requestsBufferedDryRun.Reset(statsKey)
+	v.requestsDrained.Reset(statsKey)
+	v.requestsDuringDrain.Reset(statsKey)
+	v.drainBackpressureEvents.Reset(statsKey)
 	for _, reason := range evictReasons {
 		key := append(statsKey, string(reason))
-		requestsEvicted.Reset(key)
+		v.requestsEvicted.Reset(key)
 	}
 	for _, reason := range skippedReasons {
 		key := append(statsKey, string(reason))
-		requestsSkipped.Reset(key)
+		v.requestsSkipped.Reset(key)
+	}
+	if mode == bufferDryRun {
+		for _, reason := range stopReasons {
+			v.stopsDryRun.Reset(append(statsKey, string(reason)))
+		}
+		for _, reason := range evictReasons {
+			v.requestsEvictedDryRun.Reset(append(statsKey, string(reason)))
+		}
+		for _, reason := range skippedReasons {
+			v.requestsSkippedDryRun.Reset(append(statsKey, string(reason)))
+		}
+	}
+	for _, p := range priorities {
+		key := append(statsKey, p.String())
+		v.requestsByPriority.Reset(key)
+	}
+
+	// The values of the last failover may have been restored from the
+	// topology already (see persist.go). Therefore, they are only initialized
+	// if they are not set yet.
+	statsKeyJoined := strings.Join(statsKey, ".")
+	for _, g := range v.lastFailoverGauges {
+		if _, ok := g.Counts()[statsKeyJoined]; !ok {
+			g.Set(statsKey, 0)
+		}
 	}
 }
 
@@ -194,4 +404,230 @@ var (
 		"BufferLastRequestsDryRunMax",
 		"Max # of requests which were seen during a dry-run buffering of the last failover",
 		[]string{"Keyspace", "ShardName"})
+	// lastMaxFailoverDurationMs is the effective max failover duration of the
+	// last failover i.e. -buffer_max_failover_duration minus the random jitter.
+	lastMaxFailoverDurationMs = stats.NewGaugesWithMultiLabels(
+		"BufferLastMaxFailoverDurationMs",
+		"Effective max failover duration (including the jitter) of the last failover",
+		[]string{"Keyspace", "ShardName"})
+
+	// lastFailoverGauges has all gauges above which describe the last
+	// failover of a shard.
+	lastFailoverGauges = []*stats.GaugesWithMultiLabels{lastFailoverDurationMs, lastRequestsInFlightMax, lastRequestsDryRunMax, lastMaxFailoverDurationMs}
 )
+
+var (
+	// slotsInUse is the number of buffer slots which are currently used across
+	// all pools (see -buffer_pools). A slot is in use from the time a request
+	// was buffered until its retry finished.
+	slotsInUse = sync2.NewAtomicInt64(0)
+	// slotsTotal is the number of buffer slots of all pools i.e. -buffer_size
+	// plus the sizes of the -buffer_pools.
+	slotsTotal = sync2.NewAtomicInt64(0)
+	// globalUtilizationPercent publishes the current buffer utilization across
+	// all shards and pools. Unlike "utilizationSum", it is not reset per
+	// failover.
+	globalUtilizationPercent = stats.NewGaugeFunc(
+		"BufferGlobalUtilizationPercent",
+		"Current buffer utilization (in %) across all shards",
+		func() int64 {
+			size := slotsTotal.Get()
+			if size == 0 {
+				return 0
+			}
+			return slotsInUse.Get() * 100 / size
+		})
+	// bytesInUse is the total size of all buffered requests (see
+	// NewContextWithRequestSize()). It's capped by -buffer_max_bytes.
+	bytesInUse = sync2.NewAtomicInt64(0)
+	// bytesInUseGauge publishes "bytesInUse".
+	bytesInUseGauge = stats.NewGaugeFunc(
+		"BufferBytesInUse",
+		"Total size (in bytes) of all currently buffered requests",
+		bytesInUse.Get)
+	// poolSize publishes the number of slots of each buffer pool.
+	poolSize = stats.NewGaugesWithSingleLabel(
+		"BufferPoolSize",
+		"The configured number of slots of each buffer pool",
+		"Pool")
+	// poolUtilizationPercent publishes the current utilization of each buffer
+	// pool.
+	poolUtilizationPercent = stats.NewGaugesWithSingleLabel(
+		"BufferPoolUtilizationPercent",
+		"Current buffer utilization (in %) of each buffer pool",
+		"Pool")
+	// subscriberEventsDropped counts the events which were not delivered to a
+	// subscriber (see Buffer.Subscribe()) because its channel was full.
+	subscriberEventsDropped = stats.NewCounter(
+		"BufferSubscriberEventsDropped",
+		"Buffer events which were dropped because a subscriber did not keep up")
+)
+
+// variables has the stats variables which are written by a Buffer.
+// The Buffer of vtgate uses "publishedVariables" i.e. the exported variables
+// above. Simulate() uses its own set which is not exported. This way, a
+// simulation does not show up in the stats of the process.
+type variables struct {
+	starts                   *stats.CountersWithMultiLabels
+	stops                    *stats.CountersWithMultiLabels
+	failoverDurationSumMs    *stats.CountersWithMultiLabels
+	failoverDurationTotalMs  *stats.CountersWithMultiLabels
+	requestsInFlightMaxTotal *stats.CountersWithMultiLabels
+	requestsDryRunMaxTotal   *stats.CountersWithMultiLabels
+	utilizationSum           *stats.GaugesWithMultiLabels
+	utilizationDryRunSum     *stats.CountersWithMultiLabels
+	requestsBuffered         *stats.CountersWithMultiLabels
+	requestsBufferedDryRun   *stats.CountersWithMultiLabels
+	requestsDrained          *stats.CountersWithMultiLabels
+	requestsEvicted          *stats.CountersWithMultiLabels
+	requestsSkipped          *stats.CountersWithMultiLabels
+	stopsDryRun              *stats.CountersWithMultiLabels
+	requestsEvictedDryRun    *stats.CountersWithMultiLabels
+	requestsSkippedDryRun    *stats.CountersWithMultiLabels
+	requestsByPriority       *stats.CountersWithMultiLabels
+	requestsDuringDrain      *stats.CountersWithMultiLabels
+	drainBackpressureEvents  *stats.CountersWithMultiLabels
+	failoverDurationEWMA     *stats.GaugesWithMultiLabels
+	utilizationEWMA          *stats.GaugesWithMultiLabels
+	highUtilizationEvents    *stats.CountersWithMultiLabels
+	recencyGraceBuffered     *stats.CountersWithMultiLabels
+	requestsPerFailover      *stats.CountersWithMultiLabels
+	timeBetweenFailoversMs   *stats.GaugesWithMultiLabels
+	enqueueLatency           *stats.MultiTimings
+	dequeueLatency           *stats.MultiTimings
+
+	bufferSize                *stats.Gauge
+	lastFailoverDurationMs    *stats.GaugesWithMultiLabels
+	lastRequestsInFlightMax   *stats.GaugesWithMultiLabels
+	lastRequestsDryRunMax     *stats.GaugesWithMultiLabels
+	lastMaxFailoverDurationMs *stats.GaugesWithMultiLabels
+	lastFailoverGauges        []*stats.GaugesWithMultiLabels
+
+	slotsInUse              *sync2.AtomicInt64
+	slotsTotal              *sync2.AtomicInt64
+	bytesInUse              *sync2.AtomicInt64
+	poolSize                *stats.GaugesWithSingleLabel
+	poolUtilizationPercent  *stats.GaugesWithSingleLabel
+	subscriberEventsDropped *stats.Counter
+}
+
+// publishedVariables are the exported stats variables of this package.
+var publishedVariables = &variables{
+	starts:                   starts,
+	stops:                    stops,
+	failoverDurationSumMs:    failoverDurationSumMs,
+	failoverDurationTotalMs:  failoverDurationTotalMs,
+	requestsInFlightMaxTotal: requestsInFlightMaxTotal,
+	requestsDryRunMaxTotal:   requestsDryRunMaxTotal,
+	utilizationSum:           utilizationSum,
+	utilizationDryRunSum:     utilizationDryRunSum,
+	requestsBuffered:         requestsBuffered,
+	requestsBufferedDryRun:   requestsBufferedDryRun,
+	requestsDrained:          requestsDrained,
+	requestsEvicted:          requestsEvicted,
+	requestsSkipped:          requestsSkipped,
+	stopsDryRun:              stopsDryRun,
+	requestsEvictedDryRun:    requestsEvictedDryRun,
+	requestsSkippedDryRun:    requestsSkippedDryRun,
+	requestsByPriority:       requestsByPriority,
+	requestsDuringDrain:      requestsDuringDrain,
+	drainBackpressureEvents:  drainBackpressureEvents,
+	failoverDurationEWMA:     failoverDurationEWMA,
+	utilizationEWMA:          utilizationEWMA,
+	highUtilizationEvents:    highUtilizationEvents,
+	recencyGraceBuffered:     recencyGraceBuffered,
+	requestsPerFailover:      requestsPerFailover,
+	timeBetweenFailoversMs:   timeBetweenFailoversMs,
+	enqueueLatency:           enqueueLatency,
+	dequeueLatency:           dequeueLatency,
+
+	bufferSize:                bufferSize,
+	lastFailoverDurationMs:    lastFailoverDurationMs,
+	lastRequestsInFlightMax:   lastRequestsInFlightMax,
+	lastRequestsDryRunMax:     lastRequestsDryRunMax,
+	lastMaxFailoverDurationMs: lastMaxFailoverDurationMs,
+	lastFailoverGauges:        lastFailoverGauges,
+
+	slotsInUse:              &slotsInUse,
+	slotsTotal:              &slotsTotal,
+	bytesInUse:              &bytesInUse,
+	poolSize:                poolSize,
+	poolUtilizationPercent:  poolUtilizationPercent,
+	subscriberEventsDropped: subscriberEventsDropped,
+}
+
+// newUnpublishedVariables returns a set of stats variables which are not
+// exported. Variables with an empty name are not published.
+func newUnpublishedVariables() *variables {
+	shardLabels := []string{"Keyspace", "ShardName"}
+	reasonLabels := []string{"Keyspace", "ShardName", "Reason"}
+	counters := func(labels []string) *stats.CountersWithMultiLabels {
+		return stats.NewCountersWithMultiLabels("", "", labels)
+	}
+	gauges := func() *stats.GaugesWithMultiLabels {
+		return stats.NewGaugesWithMultiLabels("", "", shardLabels)
+	}
+	v := &variables{
+		starts:                   counters(shardLabels),
+		stops:                    counters(reasonLabels),
+		failoverDurationSumMs:    counters(shardLabels),
+		failoverDurationTotalMs:  counters(shardLabels),
+		requestsInFlightMaxTotal: counters(shardLabels),
+		requestsDryRunMaxTotal:   counters(shardLabels),
+		utilizationSum:           gauges(),
+		utilizationDryRunSum:     counters(shardLabels),
+		requestsBuffered:         counters(shardLabels),
+		requestsBufferedDryRun:   counters(shardLabels),
+		requestsDrained:          counters(shardLabels),
+		requestsEvicted:          counters(reasonLabels),
+		requestsSkipped:          counters(reasonLabels),
+		stopsDryRun:              counters(reasonLabels),
+		requestsEvictedDryRun:    counters(reasonLabels),
+		requestsSkippedDryRun:    counters(reasonLabels),
+		requestsByPriority:       counters([]string{"Keyspace", "ShardName", "Priority"}),
+		requestsDuringDrain:      counters(shardLabels),
+		drainBackpressureEvents:  counters(shardLabels),
+		failoverDurationEWMA:     gauges(),
+		utilizationEWMA:          gauges(),
+		highUtilizationEvents:    counters(shardLabels),
+		recencyGraceBuffered:     counters(shardLabels),
+		requestsPerFailover:      counters([]string{"Keyspace", "ShardName", "Bucket"}),
+		timeBetweenFailoversMs:   gauges(),
+		enqueueLatency:           stats.NewMultiTimings("", "", shardLabels),
+		dequeueLatency:           stats.NewMultiTimings("", "", shardLabels),
+
+		bufferSize:                stats.NewGauge("", ""),
+		lastFailoverDurationMs:    gauges(),
+		lastRequestsInFlightMax:   gauges(),
+		lastRequestsDryRunMax:     gauges(),
+		lastMaxFailoverDurationMs: gauges(),
+
+		slotsInUse:              new(sync2.AtomicInt64),
+		slotsTotal:              new(sync2.AtomicInt64),
+		bytesInUse:              new(sync2.AtomicInt64),
+		poolSize:                stats.NewGaugesWithSingleLabel("", "", "Pool"),
+		poolUtilizationPercent:  stats.NewGaugesWithSingleLabel("", "", "Pool"),
+		subscriberEventsDropped: stats.NewCounter("", ""),
+	}
+	v.lastFailoverGauges = []*stats.GaugesWithMultiLabels{v.lastFailoverDurationMs, v.lastRequestsInFlightMax, v.lastRequestsDryRunMax, v.lastMaxFailoverDurationMs}
+	return v
+}
+
+// movingAverage is an exponentially weighted moving average.
+type movingAverage struct {
+	value float64
+	// initialized is false until the first value was added. The first value
+	// is taken as is.
+	initialized bool
+}
+
+// add adds "v" with the smoothing factor "alpha" and returns the new average.
+func (a *movingAverage) add(v, alpha float64) float64 {
+	if !a.initialized {
+		a.value = v
+		a.initialized = true
+		return a.value
+	}
+	a.value = alpha*v + (1-alpha)*a.value
+	return a.value
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -29,7 +30,7 @@ import (
 
 func TestVariables(t *testing.T) {
 	flag.Set("buffer_size", "23")
-	defer resetFlagsForTesting()
+	defer ResetFlagsForTesting()
 
 	// Create new buffer which will the flags.
 	New()
@@ -57,11 +58,25 @@ func TestVariablesAreInitialized(t *testing.T) {
 	testCases := []testCase{
 		{"starts", starts, statsKey},
 		{"failoverDurationSumMs", failoverDurationSumMs, statsKey},
+		{"failoverDurationTotalMs", failoverDurationTotalMs, statsKey},
+		{"requestsInFlightMaxTotal", requestsInFlightMaxTotal, statsKey},
+		{"requestsDryRunMaxTotal", requestsDryRunMaxTotal, statsKey},
 		{"utilizationSum", &utilizationSum.CountersWithMultiLabels, statsKey},
 		{"utilizationDryRunSum", utilizationDryRunSum, statsKey},
+		{"failoverDurationEWMA", &failoverDurationEWMA.CountersWithMultiLabels, statsKey},
+		{"utilizationEWMA", &utilizationEWMA.CountersWithMultiLabels, statsKey},
+		{"timeBetweenFailoversMs", &timeBetweenFailoversMs.CountersWithMultiLabels, statsKey},
+		{"highUtilizationEvents", highUtilizationEvents, statsKey},
+		{"recencyGraceBuffered", recencyGraceBuffered, statsKey},
 		{"requestsBuffered", requestsBuffered, statsKey},
 		{"requestsBufferedDryRun", requestsBufferedDryRun, statsKey},
 		{"requestsDrained", requestsDrained, statsKey},
+		{"requestsDuringDrain", requestsDuringDrain, statsKey},
+		{"drainBackpressureEvents", drainBackpressureEvents, statsKey},
+		{"lastFailoverDurationMs", &lastFailoverDurationMs.CountersWithMultiLabels, statsKey},
+		{"lastRequestsInFlightMax", &lastRequestsInFlightMax.CountersWithMultiLabels, statsKey},
+		{"lastRequestsDryRunMax", &lastRequestsDryRunMax.CountersWithMultiLabels, statsKey},
+		{"lastMaxFailoverDurationMs", &lastMaxFailoverDurationMs.CountersWithMultiLabels, statsKey},
 	}
 	for _, r := range stopReasons {
 		testCases = append(testCases, testCase{"stops", stops, append(statsKey, string(r))})
@@ -72,6 +87,12 @@ func TestVariablesAreInitialized(t *testing.T) {
 	for _, r := range skippedReasons {
 		testCases = append(testCases, testCase{"skipped", requestsSkipped, append(statsKey, string(r))})
 	}
+	for _, b := range requestsPerFailoverBuckets() {
+		testCases = append(testCases, testCase{"requestsPerFailover", requestsPerFailover, append(statsKey, b)})
+	}
+	for _, p := range priorities {
+		testCases = append(testCases, testCase{"requestsByPriority", requestsByPriority, append(statsKey, p.String())})
+	}
 
 	for _, tc := range testCases {
 		wantValue := 0
@@ -85,6 +106,51 @@ func TestVariablesAreInitialized(t *testing.T) {
 	}
 }
 
+func TestDryRunVariablesAreInitialized(t *testing.T) {
+	resetVariables()
+	flag.Set("enable_buffer", "true")
+	flag.Set("enable_buffer_dry_run", "true")
+	flag.Set("buffer_keyspace_shards", "init_real_test")
+	defer ResetFlagsForTesting()
+	b := New()
+	for _, ks := range []string{"init_dry_run_test", "init_real_test"} {
+		if _, err := b.WaitForFailoverEnd(context.Background(), ks, "0", nil /* err */); err != nil {
+			t.Fatalf("buffer should just passthrough and not return an error: %v", err)
+		}
+	}
+
+	dryRunKey := []string{"init_dry_run_test", "0"}
+	type testCase struct {
+		desc     string
+		counter  *stats.CountersWithMultiLabels
+		statsKey []string
+	}
+	var testCases []testCase
+	for _, r := range stopReasons {
+		testCases = append(testCases, testCase{"stopsDryRun", stopsDryRun, append(dryRunKey, string(r))})
+	}
+	for _, r := range evictReasons {
+		testCases = append(testCases, testCase{"requestsEvictedDryRun", requestsEvictedDryRun, append(dryRunKey, string(r))})
+	}
+	for _, r := range skippedReasons {
+		testCases = append(testCases, testCase{"requestsSkippedDryRun", requestsSkippedDryRun, append(dryRunKey, string(r))})
+	}
+	for _, tc := range testCases {
+		if err := checkEntry(tc.counter, tc.statsKey, 0); err != nil {
+			t.Fatalf("variable: %v not correctly initialized: %v", tc.desc, err)
+		}
+	}
+
+	// The shard with actual buffering has no dry-run variables.
+	for _, counter := range []*stats.CountersWithMultiLabels{stopsDryRun, requestsEvictedDryRun, requestsSkippedDryRun} {
+		for name := range counter.Counts() {
+			if strings.HasPrefix(name, "init_real_test.") {
+				t.Errorf("dry-run variable must not be initialized for a shard with actual buffering: %v", name)
+			}
+		}
+	}
+}
+
 func checkEntry(counters *stats.CountersWithMultiLabels, statsKey []string, want int) error {
 	name := strings.Join(statsKey, ".")
 	got, ok := counters.Counts()[name]
@@ -97,3 +163,95 @@ func checkEntry(counters *stats.CountersWithMultiLabels, statsKey []string, want
 
 	return nil
 }
+
+func TestMovingAverage(t *testing.T) {
+	a := &movingAverage{}
+	if got, want := a.add(100, 0.5), 100.0; got != want {
+		t.Fatalf("first value must be taken as is: got = %v, want = %v", got, want)
+	}
+	if got, want := a.add(200, 0.5), 150.0; got != want {
+		t.Fatalf("wrong average: got = %v, want = %v", got, want)
+	}
+	// The average converges towards a constant input.
+	prevDiff := 50.0
+	for i := 0; i < 20; i++ {
+		diff := 200 - a.add(200, 0.5)
+		if diff >= prevDiff {
+			t.Fatalf("average does not converge: diff = %v after %v values, previous diff = %v", diff, i, prevDiff)
+		}
+		prevDiff = diff
+	}
+	if prevDiff > 0.001 {
+		t.Fatalf("average did not converge: got = %v, want = 200", a.value)
+	}
+}
+
+func TestEWMAVariables(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// 3 of 10 slots are used for 2 seconds.
+	h.runFailover(3, 2*time.Second)
+
+	// The first failover is taken as is.
+	if got, want := failoverDurationEWMA.Counts()[statsKeyJoined], int64(2000); got != want {
+		t.Fatalf("wrong failover duration EWMA: got = %v, want = %v", got, want)
+	}
+	if got, want := utilizationEWMA.Counts()[statsKeyJoined], int64(30); got != want {
+		t.Fatalf("wrong utilization EWMA: got = %v, want = %v", got, want)
+	}
+}
+
+func TestLastFailoverGauges(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// 3 requests are buffered for 4 seconds.
+	h.runFailover(3, 4*time.Second)
+	if got, want := lastFailoverDurationMs.Counts()[statsKeyJoined], int64(4000); got != want {
+		t.Fatalf("wrong last failover duration after the first failover: got = %v, want = %v", got, want)
+	}
+	if got, want := lastRequestsInFlightMax.Counts()[statsKeyJoined], int64(3); got != want {
+		t.Fatalf("wrong last requests in flight max after the first failover: got = %v, want = %v", got, want)
+	}
+
+	// The second failover is shorter and buffers fewer requests. The gauges
+	// must decrease to the values of the second failover.
+	h.clock.Advance(*minTimeBetweenFailovers)
+	h.runFailover(1, 1*time.Second)
+	if got, want := lastFailoverDurationMs.Counts()[statsKeyJoined], int64(1000); got != want {
+		t.Fatalf("wrong last failover duration after the second failover: got = %v, want = %v", got, want)
+	}
+	if got, want := lastRequestsInFlightMax.Counts()[statsKeyJoined], int64(1); got != want {
+		t.Fatalf("wrong last requests in flight max after the second failover: got = %v, want = %v", got, want)
+	}
+}
+
+func TestCumulativeCounters(t *testing.T) {
+	h := newFailoverHarness(t)
+	defer h.close()
+
+	// 3 requests are buffered for 4 seconds.
+	h.runFailover(3, 4*time.Second)
+	if got, want := failoverDurationTotalMs.Counts()[statsKeyJoined], int64(4000); got != want {
+		t.Fatalf("wrong total failover duration after the first failover: got = %v, want = %v", got, want)
+	}
+	if got, want := requestsInFlightMaxTotal.Counts()[statsKeyJoined], int64(3); got != want {
+		t.Fatalf("wrong total requests in flight max after the first failover: got = %v, want = %v", got, want)
+	}
+
+	// The second failover is shorter and buffers fewer requests. Unlike the
+	// "last" gauges and the sum, which is reset when a failover starts, the
+	// cumulative counters must only increase.
+	h.clock.Advance(*minTimeBetweenFailovers)
+	h.runFailover(1, 1*time.Second)
+	if got, want := failoverDurationSumMs.Counts()[statsKeyJoined], int64(1000); got != want {
+		t.Fatalf("the sum of the failover durations should have been reset: got = %v, want = %v", got, want)
+	}
+	if got, want := failoverDurationTotalMs.Counts()[statsKeyJoined], int64(5000); got != want {
+		t.Fatalf("wrong total failover duration after the second failover: got = %v, want = %v", got, want)
+	}
+	if got, want := requestsInFlightMaxTotal.Counts()[statsKeyJoined], int64(4); got != want {
+		t.Fatalf("wrong total requests in flight max after the second failover: got = %v, want = %v", got, want)
+	}
+}
@@ -44,6 +44,7 @@ import (
 	"vitess.io/vitess/go/vt/topo/topoproto"
 	"vitess.io/vitess/go/vt/topotools"
 	"vitess.io/vitess/go/vt/vterrors"
+	"vitess.io/vitess/go/vt/vtgate/buffer"
 	"vitess.io/vitess/go/vt/vtgate/engine"
 	"vitess.io/vitess/go/vt/vtgate/planbuilder"
 	"vitess.io/vitess/go/vt/vtgate/vindexes"
@@ -147,6 +148,10 @@ func (e *Executor) Execute(ctx context.Context, method string, safeSession *Safe
 }
 
 func (e *Executor) execute(ctx context.Context, safeSession *SafeSession, sql string, bindVars map[string]*querypb.BindVariable, logStats *LogStats) (*sqltypes.Result, error) {
+	if noBufferDirective(sql) {
+		ctx = buffer.NewContextNoBuffer(ctx)
+	}
+
 	// Start an implicit transaction if necessary.
 	if !safeSession.Autocommit && !safeSession.InTransaction() {
 		if err := e.txConn.Begin(ctx, safeSession); err != nil {
@@ -1056,6 +1061,9 @@ func (e *Executor) StreamExecute(ctx context.Context, method string, safeSession
 	logStats.StmtType = sqlparser.StmtType(sqlparser.Preview(sql))
 	defer logStats.Send()
 
+	if noBufferDirective(sql) {
+		ctx = buffer.NewContextNoBuffer(ctx)
+	}
 	if bindVars == nil {
 		bindVars = make(map[string]*querypb.BindVariable)
 	}
@@ -1328,6 +1336,31 @@ func (e *Executor) getPlan(vcursor *vcursorImpl, sql string, comments sqlparser.
 	return plan, nil
 }
 
+// noBufferDirective returns true if a comment of "sql" has the directive
+// NO_BUFFER e.g. "select /*vt+ NO_BUFFER */ 1". Such queries fail fast during
+// a failover instead of being buffered. The comments are only extracted if
+// the query mentions the directive at all.
+func noBufferDirective(sql string) bool {
+	if !strings.Contains(sql, sqlparser.DirectiveNoBuffer) {
+		return false
+	}
+	var comments sqlparser.Comments
+	for rest := sql; ; {
+		start := strings.Index(rest, "/*vt+")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(rest[start:], "*/")
+		if end == -1 {
+			break
+		}
+		end += start + len("*/")
+		comments = append(comments, []byte(rest[start:end]))
+		rest = rest[end:]
+	}
+	return sqlparser.ExtractCommentDirectives(comments).IsSet(sqlparser.DirectiveNoBuffer)
+}
+
 // skipQueryPlanCache extracts SkipQueryPlanCache from session
 func skipQueryPlanCache(safeSession *SafeSession) bool {
 	if safeSession == nil || safeSession.Options == nil {
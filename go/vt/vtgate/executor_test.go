@@ -2402,6 +2402,27 @@ func TestDebugVSchema(t *testing.T) {
 	}
 }
 
+func TestNoBufferDirective(t *testing.T) {
+	testCases := []struct {
+		sql  string
+		want bool
+	}{
+		{"select 1 from user", false},
+		{"select /*vt+ NO_BUFFER */ 1 from user", true},
+		{"/*vt+ NO_BUFFER=1 */ select 1 from user", true},
+		{"insert /*vt+ SKIP_QUERY_PLAN_CACHE=1 NO_BUFFER */ into user(id) values (1)", true},
+		{"select /*vt+ NO_BUFFER=0 */ 1 from user", false},
+		{"select /* NO_BUFFER */ 1 from user", false},
+		{"select /*vt+ SKIP_QUERY_PLAN_CACHE=1 */ 1 from user /*vt+ NO_BUFFER */", true},
+		{"select /*vt+ NO_BUFFER 1 from user", false},
+	}
+	for _, tc := range testCases {
+		if got := noBufferDirective(tc.sql); got != tc.want {
+			t.Errorf("noBufferDirective(%v) = %v, want = %v", tc.sql, got, tc.want)
+		}
+	}
+}
+
 func makeComments(text string) sqlparser.MarginComments {
 	return sqlparser.MarginComments{Trailing: text}
 }
@@ -105,6 +105,12 @@ func createDiscoveryGateway(ctx context.Context, hc discovery.HealthCheck, serv
 		buffer:            buffer.New(),
 	}
 
+	if topoServer != nil {
+		if err := dg.buffer.SetTopoServer(ctx, topoServer, cell); err != nil {
+			log.Warningf("Unable to restore the last failover stats of the buffer: %v", err)
+		}
+	}
+
 	// Set listener which will update TabletStatsCache and MasterBuffer.
 	// We set sendDownEvents=true because it's required by TabletStatsCache.
 	hc.SetListener(dg, true /* sendDownEvents */)
@@ -135,8 +141,10 @@ func createDiscoveryGateway(ctx context.Context, hc discovery.HealthCheck, serv
 }
 
 // RegisterStats registers the stats to export the lag since the last refresh
-// and the checksum of the topology
+// and the checksum of the topology. It also registers the /bufferz page.
 func (dg *discoveryGateway) RegisterStats() {
+	dg.buffer.RegisterBufferzHandler()
+
 	stats.NewGaugeDurationFunc(
 		"TopologyWatcherMaxRefreshLag",
 		"maximum time since the topology watcher refreshed a cell",
@@ -238,7 +246,7 @@ func (dg *discoveryGateway) CacheStatus() TabletCacheStatusList {
 // the middle of a transaction. While returning the error check if it maybe a result of
 // a resharding event, and set the re-resolve bit and let the upper layers
 // re-resolve and retry.
-func (dg *discoveryGateway) withRetry(ctx context.Context, target *querypb.Target, unused queryservice.QueryService, name string, inTransaction bool, inner func(ctx context.Context, target *querypb.Target, conn queryservice.QueryService) (bool, error)) error {
+func (dg *discoveryGateway) withRetry(ctx context.Context, target *querypb.Target, unused queryservice.QueryService, name string, inTransaction bool, request queryservice.Request, inner func(ctx context.Context, target *querypb.Target, conn queryservice.QueryService) (bool, error)) error {
 	var tabletLastUsed *topodatapb.Tablet
 	var err error
 	invalidTablets := make(map[string]bool)
@@ -263,15 +271,37 @@ func (dg *discoveryGateway) withRetry(ctx context.Context, target *querypb.Targe
 		// Note: We only buffer once and only "!inTransaction" queries i.e.
 		// a) no transaction is necessary (e.g. critical reads) or
 		// b) no transaction was created yet.
-		if !bufferedOnce && !inTransaction && target.TabletType == topodatapb.TabletType_MASTER {
+		// Queries within a transaction are passed to the buffer as well once
+		// they failed. It does not buffer them but accounts for them as
+		// skipped if the error was caused by a failover.
+		if !bufferedOnce && target.TabletType == topodatapb.TabletType_MASTER && (!inTransaction || err != nil) {
+			bufferCtx := ctx
+			if inTransaction {
+				bufferCtx = buffer.NewContextInTransaction(ctx)
+			} else if query := request.Query(); query != "" && dg.mayBuffer(target, err) {
+				// Show the query on /bufferz and count its size against
+				// -buffer_max_bytes if the request gets buffered.
+				// Both are only attached if the request may be buffered to
+				// avoid allocating a context for every request.
+				bufferCtx = buffer.NewContextWithRequestSize(buffer.NewContextWithQuery(ctx, query), requestSize(request))
+			}
 			// The next call blocks if we should buffer during a failover.
-			retryDone, bufferErr := dg.buffer.WaitForFailoverEnd(ctx, target.Keyspace, target.Shard, err)
+			retryDone, bufferErr := dg.buffer.WaitForFailoverEnd(bufferCtx, target.Keyspace, target.Shard, err)
 			if bufferErr != nil {
 				// Buffering failed e.g. buffer is already full. Do not retry.
-				err = vterrors.Errorf(
-					vterrors.Code(bufferErr),
-					"failed to automatically buffer and retry failed request during failover: %v original err (type=%T): %v",
-					bufferErr, err, err)
+				// (If the shard was resharded, the error has the code
+				// FAILED_PRECONDITION and the caller retries the request on
+				// the destination shards.)
+				if retryDone != nil {
+					// Return the buffer slot. We do not retry here.
+					retryDone()
+				}
+				// Wrap bufferErr to keep it as the root cause for
+				// buffer.IsErrBufferFull().
+				err = vterrors.Wrapf(
+					bufferErr,
+					"failed to automatically buffer and retry failed request during failover (original err (type=%T): %v)",
+					err, err)
 				break
 			}
 
@@ -330,6 +360,38 @@ func (dg *discoveryGateway) withRetry(ctx context.Context, target *querypb.Targe
 	return NewShardError(err, target, tabletLastUsed)
 }
 
+// mayBuffer returns true if the buffer may buffer a request for "target"
+// which failed with "err". Only failed requests can start a buffering. Other
+// requests are only buffered while the shard is buffering already.
+func (dg *discoveryGateway) mayBuffer(target *querypb.Target, err error) bool {
+	if err != nil {
+		return true
+	}
+	buffering, _, _ := dg.buffer.IsBuffering(target.Keyspace, target.Shard)
+	return buffering
+}
+
+// requestSize returns the approximate size in bytes of the queries and bind
+// variables of "request".
+func requestSize(request queryservice.Request) int64 {
+	size := boundQuerySize(request.Sql, request.BindVariables)
+	for _, q := range request.Queries {
+		size += boundQuerySize(q.Sql, q.BindVariables)
+	}
+	return size
+}
+
+func boundQuerySize(sql string, bindVars map[string]*querypb.BindVariable) int64 {
+	size := int64(len(sql))
+	for name, bv := range bindVars {
+		size += int64(len(name) + len(bv.Value))
+		for _, v := range bv.Values {
+			size += int64(len(v.Value))
+		}
+	}
+	return size
+}
+
 func shuffleTablets(cell string, tablets []discovery.TabletStats) {
 	sameCell, diffCell, sameCellMax := 0, 0, -1
 	length := len(tablets)
@@ -30,6 +30,7 @@ import (
 	"vitess.io/vitess/go/vt/topo/memorytopo"
 	"vitess.io/vitess/go/vt/topotools"
 	"vitess.io/vitess/go/vt/vterrors"
+	"vitess.io/vitess/go/vt/vttablet/queryservice"
 
 	querypb "vitess.io/vitess/go/vt/proto/query"
 	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
@@ -130,6 +131,28 @@ func TestDiscoveryGatewayGetTablets(t *testing.T) {
 	}
 }
 
+func TestRequestSize(t *testing.T) {
+	bindVars := map[string]*querypb.BindVariable{
+		"id":  sqltypes.Int64BindVariable(12345),
+		"ids": sqltypes.TestBindVariable([]interface{}{1, 22}),
+	}
+	for _, tc := range []struct {
+		name    string
+		request queryservice.Request
+		want    int64
+	}{
+		{"empty", queryservice.Request{}, 0},
+		{"query", queryservice.Request{Sql: "select 1"}, 8},
+		// 28 bytes of SQL, 2+5 bytes for :id and 3+1+2 bytes for :ids.
+		{"bind variables", queryservice.Request{Sql: "select * from t where id=:id", BindVariables: bindVars}, 41},
+		{"batch", queryservice.Request{Queries: []*querypb.BoundQuery{{Sql: "select 1"}, {Sql: "select 22"}}}, 17},
+	} {
+		if got := requestSize(tc.request); got != tc.want {
+			t.Errorf("%v: requestSize() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
 func TestShuffleTablets(t *testing.T) {
 	ts1 := discovery.TabletStats{
 		Key:     "t1",
@@ -28,7 +28,7 @@ import (
 // ErrorQueryService is an object that returns an error for all methods.
 var ErrorQueryService = queryservice.Wrap(
 	nil,
-	func(ctx context.Context, target *querypb.Target, conn queryservice.QueryService, name string, inTransaction bool, inner func(context.Context, *querypb.Target, queryservice.QueryService) (bool, error)) error {
+	func(ctx context.Context, target *querypb.Target, conn queryservice.QueryService, name string, inTransaction bool, request queryservice.Request, inner func(context.Context, *querypb.Target, queryservice.QueryService) (bool, error)) error {
 		return fmt.Errorf("ErrorQueryService does not implement any method")
 	},
 )
@@ -21,6 +21,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"strings"
 	"time"
@@ -194,6 +195,46 @@ func (f *v3KeyRangeFilter) Recv() (*sqltypes.Result, error) {
 	return r, nil
 }
 
+// samplingFilter is a sqltypes.ResultStream implementation that only keeps a
+// sample of the underlying results. The rows must have the primary key
+// columns in front (see orderedColumns()).
+// Because the sample only depends on the primary key, the same rows are kept
+// on the source and the destination and the samples can be diffed.
+type samplingFilter struct {
+	input             sqltypes.ResultStream
+	primaryKeyColumns int
+	percent           int
+}
+
+// Recv is part of sqltypes.ResultStream interface.
+func (f *samplingFilter) Recv() (*sqltypes.Result, error) {
+	r, err := f.input.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]sqltypes.Value, 0, len(r.Rows))
+	for _, row := range r.Rows {
+		if inSample(row[:f.primaryKeyColumns], f.percent) {
+			rows = append(rows, row)
+		}
+	}
+	r.Rows = rows
+	return r, nil
+}
+
+// inSample returns true if the row with the primary key "primaryKey" is part
+// of a sample of "percent" percent of all rows.
+func inSample(primaryKey []sqltypes.Value, percent int) bool {
+	h := fnv.New32a()
+	for _, v := range primaryKey {
+		h.Write(v.Raw())
+		// Separate the values such that e.g. ("1", "23") and ("12", "3") differ.
+		h.Write([]byte{0})
+	}
+	return int(h.Sum32()%100) < percent
+}
+
 // reorderColumnsPrimaryKeyFirst returns a copy of "td" with the only difference
 // that the Columns field is reordered such that the primary key columns come
 // first. See orderedColumns() for details on the ordering.
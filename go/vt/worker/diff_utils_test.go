@@ -137,3 +137,23 @@ func TestCompareRows(t *testing.T) {
 		}
 	}
 }
+
+func TestInSample(t *testing.T) {
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		pk := []sqltypes.Value{sqltypes.NewInt64(int64(i))}
+		if !inSample(pk, 100) {
+			t.Fatalf("a sample of 100%% must include all rows: %v is missing", pk)
+		}
+		got := inSample(pk, 30)
+		if inSample(pk, 30) != got {
+			t.Fatalf("the sample must only depend on the primary key: %v", pk)
+		}
+		if got {
+			sampled++
+		}
+	}
+	if sampled < 200 || sampled > 400 {
+		t.Errorf("a sample of 30%% should have about 300 of 1000 rows: got = %v", sampled)
+	}
+}
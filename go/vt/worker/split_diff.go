@@ -55,7 +55,10 @@ type SplitDiffWorker struct {
 	minHealthyRdonlyTablets int
 	destinationTabletType   topodatapb.TabletType
 	parallelDiffsCount      int
-	cleaner                 *wrangler.Cleaner
+	// samplePercent is the percentage of the rows which are diffed. Tables
+	// without a primary key are always diffed completely.
+	samplePercent int
+	cleaner       *wrangler.Cleaner
 
 	// populated during WorkerStateInit, read-only after that
 	keyspaceInfo *topo.KeyspaceInfo
@@ -71,7 +74,7 @@ type SplitDiffWorker struct {
 }
 
 // NewSplitDiffWorker returns a new SplitDiffWorker object.
-func NewSplitDiffWorker(wr *wrangler.Wrangler, cell, keyspace, shard string, sourceUID uint32, excludeTables []string, minHealthyRdonlyTablets, parallelDiffsCount int, tabletType topodatapb.TabletType) Worker {
+func NewSplitDiffWorker(wr *wrangler.Wrangler, cell, keyspace, shard string, sourceUID uint32, excludeTables []string, minHealthyRdonlyTablets, parallelDiffsCount, samplePercent int, tabletType topodatapb.TabletType) Worker {
 	return &SplitDiffWorker{
 		StatusWorker:            NewStatusWorker(),
 		wr:                      wr,
@@ -83,6 +86,7 @@ func NewSplitDiffWorker(wr *wrangler.Wrangler, cell, keyspace, shard string, sou
 		minHealthyRdonlyTablets: minHealthyRdonlyTablets,
 		destinationTabletType:   tabletType,
 		parallelDiffsCount:      parallelDiffsCount,
+		samplePercent:           samplePercent,
 		cleaner:                 &wrangler.Cleaner{},
 	}
 }
@@ -508,6 +512,7 @@ func (sdw *SplitDiffWorker) diff(ctx context.Context) error {
 				return
 			}
 			defer sourceQueryResultReader.Close(ctx)
+			sdw.sample(sourceQueryResultReader, tableDefinition)
 
 			// On the destination, see if we need a full scan
 			// or a filtered scan.
@@ -524,6 +529,7 @@ func (sdw *SplitDiffWorker) diff(ctx context.Context) error {
 				return
 			}
 			defer destinationQueryResultReader.Close(ctx)
+			sdw.sample(destinationQueryResultReader, tableDefinition)
 
 			// Create the row differ.
 			differ, err := NewRowDiffer(sourceQueryResultReader, destinationQueryResultReader, tableDefinition)
@@ -547,6 +553,9 @@ func (sdw *SplitDiffWorker) diff(ctx context.Context) error {
 					sdw.wr.Logger().Warningf(err.Error())
 				} else {
 					sdw.wr.Logger().Infof("Table %v checks out (%v rows processed, %v qps)", tableDefinition.Name, report.processedRows, report.processingQPS)
+					if sdw.samplePercent < 100 && len(tableDefinition.PrimaryKeyColumns) > 0 {
+						sdw.wr.Logger().Warningf("WARNING: Only a sample of %v%% of the rows of table %v was diffed (-sample_percent). The other rows were NOT verified.", sdw.samplePercent, tableDefinition.Name)
+					}
 				}
 			}
 		}()
@@ -558,6 +567,18 @@ func (sdw *SplitDiffWorker) diff(ctx context.Context) error {
 	return rec.Error()
 }
 
+// sample restricts the diff of the table to -sample_percent of its rows.
+func (sdw *SplitDiffWorker) sample(qrr *QueryResultReader, td *tabletmanagerdatapb.TableDefinition) {
+	if sdw.samplePercent >= 100 || len(td.PrimaryKeyColumns) == 0 {
+		return
+	}
+	qrr.output = &samplingFilter{
+		input:             qrr.output,
+		primaryKeyColumns: len(td.PrimaryKeyColumns),
+		percent:           sdw.samplePercent,
+	}
+}
+
 // markAsWillFail records the error and changes the state of the worker to reflect this
 func (sdw *SplitDiffWorker) markAsWillFail(er concurrency.ErrorRecorder, err error) {
 	er.RecordError(err)
@@ -87,6 +87,8 @@ func commandSplitDiff(wi *Instance, wr *wrangler.Wrangler, subFlags *flag.FlagSe
 	minHealthyRdonlyTablets := subFlags.Int("min_healthy_rdonly_tablets", defaultMinHealthyTablets, "minimum number of healthy RDONLY tablets before taking out one")
 	destTabletTypeStr := subFlags.String("dest_tablet_type", defaultDestTabletType, "destination tablet type (RDONLY or REPLICA) that will be used to compare the shards")
 	parallelDiffsCount := subFlags.Int("parallel_diffs_count", defaultParallelDiffsCount, "number of tables to diff in parallel")
+	cell := subFlags.String("cell", "", "cell in which the tablets for the diff are picked (defaults to the cell of the vtworker)")
+	samplePercent := subFlags.Int("sample_percent", 100, "percentage of the rows (selected by a hash of the primary key) which are diffed. The other rows are NOT verified")
 	if err := subFlags.Parse(args); err != nil {
 		return nil, err
 	}
@@ -108,7 +110,15 @@ func commandSplitDiff(wi *Instance, wr *wrangler.Wrangler, subFlags *flag.FlagSe
 		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "command SplitDiff invalid dest_tablet_type: %v", destTabletType)
 	}
 
-	return NewSplitDiffWorker(wr, wi.cell, keyspace, shard, uint32(*sourceUID), excludeTableArray, *minHealthyRdonlyTablets, *parallelDiffsCount, topodatapb.TabletType(destTabletType)), nil
+	if *samplePercent < 1 || *samplePercent > 100 {
+		return nil, vterrors.Errorf(vtrpc.Code_INVALID_ARGUMENT, "command SplitDiff invalid sample_percent: %v (must be between 1 and 100)", *samplePercent)
+	}
+
+	diffCell := wi.cell
+	if *cell != "" {
+		diffCell = *cell
+	}
+	return NewSplitDiffWorker(wr, diffCell, keyspace, shard, uint32(*sourceUID), excludeTableArray, *minHealthyRdonlyTablets, *parallelDiffsCount, *samplePercent, topodatapb.TabletType(destTabletType)), nil
 }
 
 // shardsWithSources returns all the shards that have SourceShards set
@@ -226,13 +236,13 @@ func interactiveSplitDiff(ctx context.Context, wi *Instance, wr *wrangler.Wrangl
 
 	// start the diff job
 	// TODO: @rafael - Add option to set destination tablet type in UI form.
-	wrk := NewSplitDiffWorker(wr, wi.cell, keyspace, shard, uint32(sourceUID), excludeTableArray, int(minHealthyRdonlyTablets), int(parallelDiffsCount), topodatapb.TabletType_RDONLY)
+	wrk := NewSplitDiffWorker(wr, wi.cell, keyspace, shard, uint32(sourceUID), excludeTableArray, int(minHealthyRdonlyTablets), int(parallelDiffsCount), 100 /* samplePercent */, topodatapb.TabletType_RDONLY)
 	return wrk, nil, nil, nil
 }
 
 func init() {
 	AddCommand("Diffs", Command{"SplitDiff",
 		commandSplitDiff, interactiveSplitDiff,
-		"[--exclude_tables=''] <keyspace/shard>",
+		"[--exclude_tables=''] [--sample_percent=100] <keyspace/shard>",
 		"Diffs a rdonly destination shard against its SourceShards"})
 }
@@ -184,7 +184,7 @@ func handleAPI(pattern string, handlerFunc func(w http.ResponseWriter, r *http.R
 
 // HandleHTTPLongPolling registers the streaming-over-HTTP APIs.
 func (m *Manager) HandleHTTPLongPolling(pattern string) {
-	log.Infof("workflow Manager listening to web traffic at %v/{create,poll,delete}", pattern)
+	log.Infof("workflow Manager listening to web traffic at %v/{create,poll,delete,tree}", pattern)
 	lpm := newLongPollingManager(m)
 
 	handleAPI(pattern+"/create", func(w http.ResponseWriter, r *http.Request) error {
@@ -231,6 +231,21 @@ func (m *Manager) HandleHTTPLongPolling(pattern string) {
 		return nil
 	})
 
+	handleAPI(pattern+"/tree/", func(w http.ResponseWriter, r *http.Request) error {
+		// The rest of the URL is the path of the node, e.g.
+		// <uuid> or <uuid>/child1.
+		nodePath := r.URL.Path[len(pattern+"/tree"):]
+		result, err := m.NodeManager().GetNodeTree(nodePath)
+		if err != nil {
+			return fmt.Errorf("NodeManager.GetNodeTree failed: %v", err)
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		w.Header().Set("Content-Length", fmt.Sprintf("%v", len(result)))
+		w.Write(result)
+		return nil
+	})
+
 	handleAPI(pattern+"/action/", func(w http.ResponseWriter, r *http.Request) error {
 		_, err := getID(r.URL.Path, pattern+"/action/")
 		if err != nil {
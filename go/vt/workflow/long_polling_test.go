@@ -132,3 +132,72 @@ func TestLongPolling(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestLongPollingTree(t *testing.T) {
+	ts := memorytopo.NewServer("cell1")
+	m := NewManager(ts)
+
+	// Register the manager to a web handler, start a web server.
+	// Use a different pattern than TestLongPolling: both register on
+	// the default mux.
+	m.HandleHTTPLongPolling("/workflowtree")
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+	go http.Serve(listener, nil)
+
+	n := &Node{
+		Listener: &testWorkflow{},
+
+		Name:        "name",
+		PathName:    "uuid1",
+		LastChanged: 143,
+	}
+	n.Children = []*Node{{
+		Name:     "child",
+		PathName: "child1",
+		Path:     "/uuid1/child1",
+		Children: []*Node{},
+	}}
+	if err := m.NodeManager().AddRootNode(n); err != nil {
+		t.Fatalf("adding root node failed: %v", err)
+	}
+
+	// Get the tree of a child node.
+	u := url.URL{Scheme: "http", Host: listener.Addr().String(), Path: "/workflowtree/tree/uuid1/child1"}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		t.Fatalf("/tree/uuid1/child1 failed: %v", err)
+	}
+	tree, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("/tree/uuid1/child1 reading failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/tree/uuid1/child1 returned wrong status: %v %v", resp.StatusCode, string(tree))
+	}
+	if got, want := resp.Header.Get("Content-Type"), jsonContentType; got != want {
+		t.Errorf("wrong content type: got = %v, want = %v", got, want)
+	}
+	if !strings.Contains(string(tree), `"name":"child"`) ||
+		!strings.Contains(string(tree), `"path":"/uuid1/child1"`) {
+		t.Errorf("unexpected tree: %v", string(tree))
+	}
+
+	// An unknown node returns an error.
+	u.Path = "/workflowtree/tree/uuid2"
+	resp, err = http.Get(u.String())
+	if err != nil {
+		t.Fatalf("/tree/uuid2 failed: %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("/tree/uuid2 reading failed: %v", err)
+	}
+	if resp.StatusCode == http.StatusOK || !strings.Contains(string(body), "no root node with path /uuid2") {
+		t.Errorf("/tree/uuid2 should fail: %v %v", resp.StatusCode, string(body))
+	}
+}
@@ -28,7 +28,9 @@ import (
 
 	"vitess.io/vitess/go/vt/log"
 	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/vterrors"
 
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
 	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
 )
 
@@ -282,6 +284,28 @@ func (m *Manager) Create(ctx context.Context, factoryName string, args []string)
 	return w.Uuid, nil
 }
 
+// IsRetryableCreateError returns true if "err", as returned by Create(), is
+// transient and creating the workflow again may succeed. This is the case
+// for timeouts and contention in the topology server. All other errors,
+// e.g. invalid parameters, are fatal.
+// It's shared by all workflows which create other workflows.
+func IsRetryableCreateError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch {
+	case topo.IsErrType(err, topo.Timeout),
+		topo.IsErrType(err, topo.Interrupted),
+		topo.IsErrType(err, topo.BadVersion):
+		return true
+	}
+	switch vterrors.Code(err) {
+	case vtrpcpb.Code_UNAVAILABLE, vtrpcpb.Code_DEADLINE_EXCEEDED, vtrpcpb.Code_ABORTED:
+		return true
+	}
+	return false
+}
+
 func (m *Manager) instantiateWorkflow(w *workflowpb.Workflow) (*runningWorkflow, error) {
 	rw := &runningWorkflow{
 		wi: &topo.WorkflowInfo{
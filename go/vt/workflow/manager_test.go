@@ -17,14 +17,18 @@ limitations under the License.
 package workflow
 
 import (
+	"errors"
 	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
 
+	"vitess.io/vitess/go/vt/topo"
 	"vitess.io/vitess/go/vt/topo/memorytopo"
+	"vitess.io/vitess/go/vt/vterrors"
 
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
 	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
 )
 
@@ -155,3 +159,25 @@ func TestManagerRestart(t *testing.T) {
 		t.Errorf("invalid workflow error: %v", wi.Error)
 	}
 }
+
+func TestIsRetryableCreateError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{topo.NewError(topo.Timeout, "/workflows/uuid"), true},
+		{topo.NewError(topo.Interrupted, "/workflows/uuid"), true},
+		{topo.NewError(topo.BadVersion, "/workflows/uuid"), true},
+		{vterrors.New(vtrpcpb.Code_UNAVAILABLE, "topo server unavailable"), true},
+		{context.DeadlineExceeded, true},
+		{topo.NewError(topo.NodeExists, "/workflows/uuid"), false},
+		{errors.New("flag provided but not defined: -foo"), false},
+		{vterrors.New(vtrpcpb.Code_INVALID_ARGUMENT, "keyspace name must be provided"), false},
+	}
+	for _, tc := range testCases {
+		if got := IsRetryableCreateError(tc.err); got != tc.want {
+			t.Errorf("IsRetryableCreateError(%v) = %v, want = %v", tc.err, got, tc.want)
+		}
+	}
+}
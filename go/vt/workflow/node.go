@@ -348,6 +348,18 @@ func (m *NodeManager) GetFullTree() ([]byte, error) {
 	return m.toJSON(0)
 }
 
+// GetNodeTree returns the JSON representation of the Node at the
+// provided path, including all its children.
+func (m *NodeManager) GetNodeTree(nodePath string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.getNodeByPathLocked(nodePath)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
 // GetAndWatchFullTree returns the JSON representation of the entire Node tree,
 // and registers a watcher to monitor changes to the tree.
 func (m *NodeManager) GetAndWatchFullTree(notifications chan []byte) ([]byte, int, error) {
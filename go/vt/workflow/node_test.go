@@ -111,3 +111,50 @@ func TestNodeManagerWithRoot(t *testing.T) {
 		t.Errorf("unexpected notification: %v %v", ok, string(result))
 	}
 }
+
+// TestNodeManagerGetNodeTree tests that GetNodeTree returns the
+// subtree at the given path.
+func TestNodeManagerGetNodeTree(t *testing.T) {
+	nodeManager := NewNodeManager()
+	n := &Node{
+		Listener: &testWorkflow{},
+
+		Name:        "name",
+		PathName:    "uuid1",
+		LastChanged: time.Now().Unix(),
+	}
+	n.Children = []*Node{{
+		Name:     "child",
+		PathName: "child1",
+		Path:     "/uuid1/child1",
+		Children: []*Node{},
+	}}
+	if err := nodeManager.AddRootNode(n); err != nil {
+		t.Fatalf("adding root node failed: %v", err)
+	}
+
+	result, err := nodeManager.GetNodeTree("/uuid1")
+	if err != nil {
+		t.Fatalf("GetNodeTree(/uuid1) failed: %v", err)
+	}
+	if !strings.Contains(string(result), `"path":"/uuid1"`) ||
+		!strings.Contains(string(result), `"path":"/uuid1/child1"`) {
+		t.Errorf("unexpected tree: %v", string(result))
+	}
+
+	result, err = nodeManager.GetNodeTree("/uuid1/child1")
+	if err != nil {
+		t.Fatalf("GetNodeTree(/uuid1/child1) failed: %v", err)
+	}
+	if !strings.HasPrefix(string(result), `{"name":"child",`) ||
+		strings.Contains(string(result), `"path":"/uuid1",`) {
+		t.Errorf("unexpected subtree: %v", string(result))
+	}
+
+	if _, err := nodeManager.GetNodeTree("/uuid2"); err == nil || !strings.Contains(err.Error(), "no root node with path /uuid2") {
+		t.Errorf("GetNodeTree(/uuid2) returned wrong error: %v", err)
+	}
+	if _, err := nodeManager.GetNodeTree("/uuid1/child2"); err == nil || !strings.Contains(err.Error(), "has no children named child2") {
+		t.Errorf("GetNodeTree(/uuid1/child2) returned wrong error: %v", err)
+	}
+}
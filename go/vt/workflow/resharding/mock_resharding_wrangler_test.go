@@ -71,3 +71,15 @@ func (m *MockReshardingWrangler) MigrateServedTypes(ctx context.Context, keyspac
 func (mr *MockReshardingWranglerMockRecorder) MigrateServedTypes(ctx, keyspace, shard, cells, servedType, reverse, skipReFreshState, filteredReplicationWaitTime, reverseReplication interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MigrateServedTypes", reflect.TypeOf((*MockReshardingWrangler)(nil).MigrateServedTypes), ctx, keyspace, shard, cells, servedType, reverse, skipReFreshState, filteredReplicationWaitTime, reverseReplication)
 }
+
+// MigrateServedFrom mocks base method
+func (m *MockReshardingWrangler) MigrateServedFrom(ctx context.Context, keyspace, shard string, servedType topodata.TabletType, cells []string, reverse bool, filteredReplicationWaitTime time.Duration) error {
+	ret := m.ctrl.Call(m, "MigrateServedFrom", ctx, keyspace, shard, servedType, cells, reverse, filteredReplicationWaitTime)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MigrateServedFrom indicates an expected call of MigrateServedFrom
+func (mr *MockReshardingWranglerMockRecorder) MigrateServedFrom(ctx, keyspace, shard, servedType, cells, reverse, filteredReplicationWaitTime interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MigrateServedFrom", reflect.TypeOf((*MockReshardingWrangler)(nil).MigrateServedFrom), ctx, keyspace, shard, servedType, cells, reverse, filteredReplicationWaitTime)
+}
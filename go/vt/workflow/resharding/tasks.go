@@ -72,6 +72,7 @@ func (hw *horizontalReshardingWorkflow) runSplitClone(ctx context.Context, t *wo
 	minHealthyRdonlyTablets := t.Attributes["min_healthy_rdonly_tablets"]
 	splitCmd := t.Attributes["split_cmd"]
 	useConsistentSnapshot := t.Attributes["use_consistent_snapshot"]
+	destinationWriterCount := t.Attributes["destination_writer_count"]
 
 	sourceKeyspaceShard := topoproto.KeyspaceShardString(keyspace, sourceShard)
 	// Reset the vtworker to avoid error if vtworker command has been called elsewhere.
@@ -80,7 +81,11 @@ func (hw *horizontalReshardingWorkflow) runSplitClone(ctx context.Context, t *wo
 		return err
 	}
 
-	args := []string{splitCmd, "--min_healthy_rdonly_tablets=" + minHealthyRdonlyTablets, sourceKeyspaceShard}
+	args := []string{splitCmd, "--min_healthy_rdonly_tablets=" + minHealthyRdonlyTablets}
+	if destinationWriterCount != "" {
+		args = append(args, "--destination_writer_count="+destinationWriterCount)
+	}
+	args = append(args, sourceKeyspaceShard)
 	if useConsistentSnapshot != "" {
 		args = append(args, "--use_consistent_snapshot")
 	}
@@ -100,11 +105,20 @@ func (hw *horizontalReshardingWorkflow) runSplitDiff(ctx context.Context, t *wor
 	destinationTabletType := t.Attributes["dest_tablet_type"]
 	worker := t.Attributes["vtworker"]
 	useConsistentSnapshot := t.Attributes["use_consistent_snapshot"]
+	cell := t.Attributes["cell"]
+	samplePercent := t.Attributes["sample_percent"]
 
 	if _, err := automation.ExecuteVtworker(hw.ctx, worker, []string{"Reset"}); err != nil {
 		return err
 	}
-	args := []string{"SplitDiff", "--min_healthy_rdonly_tablets=1", "--dest_tablet_type=" + destinationTabletType, topoproto.KeyspaceShardString(keyspace, destShard)}
+	args := []string{"SplitDiff", "--min_healthy_rdonly_tablets=1", "--dest_tablet_type=" + destinationTabletType}
+	if cell != "" {
+		args = append(args, "--cell="+cell)
+	}
+	if samplePercent != "" {
+		args = append(args, "--sample_percent="+samplePercent)
+	}
+	args = append(args, topoproto.KeyspaceShardString(keyspace, destShard))
 	if useConsistentSnapshot != "" {
 		args = append(args, "--use_consistent_snapshot")
 	}
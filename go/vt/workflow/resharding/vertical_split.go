@@ -0,0 +1,356 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resharding
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/automation"
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/logutil"
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+	"vitess.io/vitess/go/vt/vttablet/tmclient"
+	"vitess.io/vitess/go/vt/workflow"
+	"vitess.io/vitess/go/vt/wrangler"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+const (
+	verticalSplitFactoryName = "vertical_split"
+)
+
+// VerticalSplitFactory is the factory to create a vertical split workflow.
+// It moves tables from a shard of the source keyspace to the shard with the
+// same name of the destination keyspace, which is served from the source
+// keyspace.
+// The phases are the same as for the horizontal resharding, but each phase
+// has only one task for the destination shard.
+type VerticalSplitFactory struct{}
+
+// Init is part of the workflow.Factory interface.
+func (*VerticalSplitFactory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string) error {
+	subFlags := flag.NewFlagSet(verticalSplitFactoryName, flag.ContinueOnError)
+	sourceKeyspace := subFlags.String("source_keyspace", "", "Name of the keyspace the tables are moved from")
+	destinationKeyspace := subFlags.String("destination_keyspace", "", "Name of the keyspace the tables are moved to. It must be served from the source keyspace")
+	sourceShard := subFlags.String("source_shard", "", "Name of the shard in the source keyspace")
+	destinationShard := subFlags.String("destination_shard", "", "Name of the shard in the destination keyspace")
+	tables := subFlags.String("tables", "", "A comma-separated list of tables to move")
+	vtworkersStr := subFlags.String("vtworkers", "", "Address of the vtworker")
+	minHealthyRdonlyTablets := subFlags.String("min_healthy_rdonly_tablets", "1", "Minimum number of healthy RDONLY tablets required in the source shard")
+	phaseEnaableApprovalsDesc := fmt.Sprintf("Comma separated phases that require explicit approval in the UI to execute. Phase names are: %v", strings.Join(WorkflowPhases(), ","))
+	phaseEnableApprovalsStr := subFlags.String("phase_enable_approvals", strings.Join(WorkflowPhases(), ","), phaseEnaableApprovalsDesc)
+	parentWorkflow := subFlags.String("parent_workflow", "", "UUID of the workflow which created this workflow (e.g. a keyspace resharding). It's recorded in the checkpoint and shown in the UI")
+
+	if err := subFlags.Parse(args); err != nil {
+		return err
+	}
+	if *sourceKeyspace == "" || *destinationKeyspace == "" || *sourceShard == "" || *destinationShard == "" || *tables == "" || *vtworkersStr == "" || *minHealthyRdonlyTablets == "" {
+		return fmt.Errorf("source and destination keyspace and shard, tables, min healthy rdonly tablets and vtworkers information must be provided for a vertical split")
+	}
+	vtworkers := strings.Split(*vtworkersStr, ",")
+	if len(vtworkers) != 1 {
+		return fmt.Errorf("there are %v vtworkers, 1 destination shard: the number should be same", len(vtworkers))
+	}
+	phaseEnableApprovals := parsePhaseEnableApprovals(*phaseEnableApprovalsStr)
+	for _, phase := range phaseEnableApprovals {
+		validPhase := false
+		for _, registeredPhase := range WorkflowPhases() {
+			if phase == registeredPhase {
+				validPhase = true
+			}
+		}
+		if !validPhase {
+			return fmt.Errorf("invalid phase in phase_enable_approvals: %v", phase)
+		}
+	}
+
+	if err := validateVerticalSplit(m.TopoServer(), *sourceKeyspace, *destinationKeyspace, *sourceShard, *destinationShard); err != nil {
+		return err
+	}
+
+	w.Name = fmt.Sprintf("Vertical split of tables %v from %v/%v to %v/%v.", *tables, *sourceKeyspace, *sourceShard, *destinationKeyspace, *destinationShard)
+	checkpoint := initVerticalSplitCheckpoint(*sourceKeyspace, *destinationKeyspace, *sourceShard, *destinationShard, *tables, vtworkers[0], *minHealthyRdonlyTablets)
+	checkpoint.Settings["phase_enable_approvals"] = *phaseEnableApprovalsStr
+	if *parentWorkflow != "" {
+		checkpoint.Settings["parent_workflow"] = *parentWorkflow
+	}
+
+	var err error
+	w.Data, err = proto.Marshal(checkpoint)
+	return err
+}
+
+// Instantiate is part the workflow.Factory interface.
+func (*VerticalSplitFactory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNode *workflow.Node) (workflow.Workflow, error) {
+	rootNode.Message = "This is a workflow to execute a vertical split automatically."
+
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(w.Data, checkpoint); err != nil {
+		return nil, err
+	}
+	if parent := checkpoint.Settings["parent_workflow"]; parent != "" {
+		rootNode.Message += fmt.Sprintf(" It was created by workflow %v.", parent)
+	}
+
+	phaseEnableApprovals := make(map[string]bool)
+	for _, phase := range parsePhaseEnableApprovals(checkpoint.Settings["phase_enable_approvals"]) {
+		phaseEnableApprovals[phase] = true
+	}
+
+	vw := &verticalSplitWorkflow{
+		checkpoint:           checkpoint,
+		rootUINode:           rootNode,
+		logger:               logutil.NewMemoryLogger(),
+		wr:                   wrangler.New(logutil.NewConsoleLogger(), m.TopoServer(), tmclient.NewTabletManagerClient()),
+		topoServer:           m.TopoServer(),
+		manager:              m,
+		phaseEnableApprovals: phaseEnableApprovals,
+	}
+
+	destinationShard := checkpoint.Settings["destination_shard"]
+	for _, phase := range []struct {
+		name      string
+		phaseName workflow.PhaseType
+	}{
+		{"CopySchemaShard", phaseCopySchema},
+		{"VerticalSplitClone", phaseClone},
+		{"WaitForFilteredReplication", phaseWaitForFilteredReplication},
+		{"VerticalSplitDiff", phaseDiff},
+		{"MigrateServedFromRDONLY", phaseMigrateRdonly},
+		{"MigrateServedFromREPLICA", phaseMigrateReplica},
+		{"MigrateServedFromMASTER", phaseMigrateMaster},
+	} {
+		vw.rootUINode.Children = append(vw.rootUINode.Children, &workflow.Node{
+			Name:     phase.name,
+			PathName: string(phase.phaseName),
+		})
+		if err := createUINodes(vw.rootUINode, phase.phaseName, []string{destinationShard}); err != nil {
+			return vw, err
+		}
+	}
+	return vw, nil
+}
+
+// validateVerticalSplit checks that "destinationKeyspace" is served from
+// "sourceKeyspace" and that both shards exist.
+func validateVerticalSplit(ts *topo.Server, sourceKeyspace, destinationKeyspace, sourceShard, destinationShard string) error {
+	ctx := context.Background()
+	ki, err := ts.GetKeyspace(ctx, destinationKeyspace)
+	if err != nil {
+		return fmt.Errorf("cannot read destination keyspace %v: %v", destinationKeyspace, err)
+	}
+	servedFrom := false
+	for _, sf := range ki.ServedFroms {
+		if sf.Keyspace == sourceKeyspace {
+			servedFrom = true
+		}
+	}
+	if !servedFrom {
+		return fmt.Errorf("destination keyspace %v is not served from keyspace %v", destinationKeyspace, sourceKeyspace)
+	}
+	if _, err := ts.GetShard(ctx, sourceKeyspace, sourceShard); err != nil {
+		return fmt.Errorf("cannot read source shard %v/%v: %v", sourceKeyspace, sourceShard, err)
+	}
+	if _, err := ts.GetShard(ctx, destinationKeyspace, destinationShard); err != nil {
+		return fmt.Errorf("cannot read destination shard %v/%v: %v", destinationKeyspace, destinationShard, err)
+	}
+	return nil
+}
+
+// initVerticalSplitCheckpoint initializes the checkpoint for the vertical
+// split workflow. Each phase has one task for the destination shard.
+func initVerticalSplitCheckpoint(sourceKeyspace, destinationKeyspace, sourceShard, destinationShard, tables, vtworker, minHealthyRdonlyTablets string) *workflowpb.WorkflowCheckpoint {
+	tasks := make(map[string]*workflowpb.Task)
+	shards := []string{destinationShard}
+	initTasks(tasks, phaseCopySchema, shards, func(i int, shard string) map[string]string {
+		return map[string]string{
+			"source_keyspace":      sourceKeyspace,
+			"source_shard":         sourceShard,
+			"destination_keyspace": destinationKeyspace,
+			"destination_shard":    shard,
+			"tables":               tables,
+		}
+	})
+	initTasks(tasks, phaseClone, shards, func(i int, shard string) map[string]string {
+		return map[string]string{
+			"destination_keyspace":       destinationKeyspace,
+			"destination_shard":          shard,
+			"tables":                     tables,
+			"min_healthy_rdonly_tablets": minHealthyRdonlyTablets,
+			"vtworker":                   vtworker,
+		}
+	})
+	initTasks(tasks, phaseWaitForFilteredReplication, shards, func(i int, shard string) map[string]string {
+		return map[string]string{
+			"destination_keyspace": destinationKeyspace,
+			"destination_shard":    shard,
+		}
+	})
+	initTasks(tasks, phaseDiff, shards, func(i int, shard string) map[string]string {
+		return map[string]string{
+			"destination_keyspace": destinationKeyspace,
+			"destination_shard":    shard,
+			"vtworker":             vtworker,
+		}
+	})
+	for phase, servedType := range map[workflow.PhaseType]topodatapb.TabletType{
+		phaseMigrateRdonly:  topodatapb.TabletType_RDONLY,
+		phaseMigrateReplica: topodatapb.TabletType_REPLICA,
+		phaseMigrateMaster:  topodatapb.TabletType_MASTER,
+	} {
+		servedType := servedType
+		initTasks(tasks, phase, shards, func(i int, shard string) map[string]string {
+			return map[string]string{
+				"destination_keyspace": destinationKeyspace,
+				"destination_shard":    shard,
+				"served_type":          servedType.String(),
+			}
+		})
+	}
+
+	return &workflowpb.WorkflowCheckpoint{
+		CodeVersion: codeVersion,
+		Tasks:       tasks,
+		Settings: map[string]string{
+			"source_keyspace":      sourceKeyspace,
+			"destination_keyspace": destinationKeyspace,
+			"source_shard":         sourceShard,
+			"destination_shard":    destinationShard,
+			"tables":               tables,
+		},
+	}
+}
+
+// verticalSplitWorkflow contains meta-information and methods to control
+// the vertical split workflow.
+type verticalSplitWorkflow struct {
+	ctx        context.Context
+	wr         ReshardingWrangler
+	manager    *workflow.Manager
+	topoServer *topo.Server
+	wi         *topo.WorkflowInfo
+	// logger is the logger we export UI logs from.
+	logger *logutil.MemoryLogger
+
+	// rootUINode is the root node representing the workflow in the UI.
+	rootUINode *workflow.Node
+
+	checkpoint       *workflowpb.WorkflowCheckpoint
+	checkpointWriter *workflow.CheckpointWriter
+
+	phaseEnableApprovals map[string]bool
+}
+
+// Run executes the vertical split process.
+// It implements the workflow.Workflow interface.
+func (vw *verticalSplitWorkflow) Run(ctx context.Context, manager *workflow.Manager, wi *topo.WorkflowInfo) error {
+	vw.ctx = ctx
+	vw.wi = wi
+	vw.checkpointWriter = workflow.NewCheckpointWriter(vw.topoServer, vw.checkpoint, vw.wi)
+	vw.rootUINode.Display = workflow.NodeDisplayDeterminate
+	vw.rootUINode.BroadcastChanges(true /* updateChildren */)
+
+	if err := vw.runWorkflow(); err != nil {
+		return err
+	}
+	vw.setUIMessage("Vertical split is finished successfully.")
+	return nil
+}
+
+func (vw *verticalSplitWorkflow) runWorkflow() error {
+	for _, phase := range []struct {
+		phaseName   workflow.PhaseType
+		executeFunc func(context.Context, *workflowpb.Task) error
+	}{
+		{phaseCopySchema, vw.runCopySchema},
+		{phaseClone, vw.runVerticalSplitClone},
+		{phaseWaitForFilteredReplication, vw.runWaitForFilteredReplication},
+		{phaseDiff, vw.runVerticalSplitDiff},
+		{phaseMigrateRdonly, vw.runMigrate},
+		{phaseMigrateReplica, vw.runMigrate},
+		{phaseMigrateMaster, vw.runMigrate},
+	} {
+		tasks := []*workflowpb.Task{vw.checkpoint.Tasks[createTaskID(phase.phaseName, vw.checkpoint.Settings["destination_shard"])]}
+		runner := workflow.NewParallelRunner(vw.ctx, vw.rootUINode, vw.checkpointWriter, tasks, phase.executeFunc, workflow.Sequential, vw.phaseEnableApprovals[string(phase.phaseName)])
+		if err := runner.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vw *verticalSplitWorkflow) setUIMessage(message string) {
+	log.Infof("Vertical split : %v.", message)
+	vw.logger.Infof(message)
+	vw.rootUINode.Log = vw.logger.String()
+	vw.rootUINode.Message = message
+	vw.rootUINode.BroadcastChanges(false /* updateChildren */)
+}
+
+func (vw *verticalSplitWorkflow) runCopySchema(ctx context.Context, t *workflowpb.Task) error {
+	tables := strings.Split(t.Attributes["tables"], ",")
+	return vw.wr.CopySchemaShardFromShard(ctx, tables, nil /* excludeTableArray */, true, /*includeViews*/
+		t.Attributes["source_keyspace"], t.Attributes["source_shard"], t.Attributes["destination_keyspace"], t.Attributes["destination_shard"], wrangler.DefaultWaitSlaveTimeout)
+}
+
+func (vw *verticalSplitWorkflow) runVerticalSplitClone(ctx context.Context, t *workflowpb.Task) error {
+	worker := t.Attributes["vtworker"]
+	// Reset the vtworker to avoid error if vtworker command has been called elsewhere.
+	// This is because vtworker class doesn't cleanup the environment after execution.
+	if _, err := automation.ExecuteVtworker(ctx, worker, []string{"Reset"}); err != nil {
+		return err
+	}
+	args := []string{"VerticalSplitClone", "--tables=" + t.Attributes["tables"], "--min_healthy_tablets=" + t.Attributes["min_healthy_rdonly_tablets"], topoproto.KeyspaceShardString(t.Attributes["destination_keyspace"], t.Attributes["destination_shard"])}
+	_, err := automation.ExecuteVtworker(ctx, worker, args)
+	return err
+}
+
+func (vw *verticalSplitWorkflow) runWaitForFilteredReplication(ctx context.Context, t *workflowpb.Task) error {
+	return vw.wr.WaitForFilteredReplication(ctx, t.Attributes["destination_keyspace"], t.Attributes["destination_shard"], wrangler.DefaultWaitForFilteredReplicationMaxDelay)
+}
+
+func (vw *verticalSplitWorkflow) runVerticalSplitDiff(ctx context.Context, t *workflowpb.Task) error {
+	worker := t.Attributes["vtworker"]
+	if _, err := automation.ExecuteVtworker(ctx, worker, []string{"Reset"}); err != nil {
+		return err
+	}
+	args := []string{"VerticalSplitDiff", "--min_healthy_rdonly_tablets=1", topoproto.KeyspaceShardString(t.Attributes["destination_keyspace"], t.Attributes["destination_shard"])}
+	_, err := automation.ExecuteVtworker(ctx, worker, args)
+	return err
+}
+
+func (vw *verticalSplitWorkflow) runMigrate(ctx context.Context, t *workflowpb.Task) error {
+	servedTypeStr := t.Attributes["served_type"]
+	servedType, err := topoproto.ParseTabletType(servedTypeStr)
+	if err != nil {
+		return fmt.Errorf("unknown tablet type: %v", servedTypeStr)
+	}
+	if servedType != topodatapb.TabletType_RDONLY &&
+		servedType != topodatapb.TabletType_REPLICA &&
+		servedType != topodatapb.TabletType_MASTER {
+		return fmt.Errorf("wrong served type to be migrated: %v", servedTypeStr)
+	}
+	return vw.wr.MigrateServedFrom(ctx, t.Attributes["destination_keyspace"], t.Attributes["destination_shard"], servedType, nil /* cells */, false /* reverse */, wrangler.DefaultFilteredReplicationWaitTime)
+}
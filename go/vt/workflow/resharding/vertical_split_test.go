@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resharding
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/memorytopo"
+	"vitess.io/vitess/go/vt/worker/fakevtworkerclient"
+	"vitess.io/vitess/go/vt/worker/vtworkerclient"
+	"vitess.io/vitess/go/vt/workflow"
+	"vitess.io/vitess/go/vt/wrangler"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+const (
+	testSourceKeyspace = "source_keyspace"
+	testTables         = "moving1,moving2"
+)
+
+// TestVerticalSplit runs the happy path of the vertical split workflow.
+func TestVerticalSplit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockWranglerInterface := NewMockReshardingWrangler(ctrl)
+	mockWranglerInterface.EXPECT().CopySchemaShardFromShard(gomock.Any(), []string{"moving1", "moving2"}, nil /* excludeTableArray */, true /*includeViews*/, testSourceKeyspace, "0", testKeyspace, "0", wrangler.DefaultWaitSlaveTimeout).Return(nil)
+	mockWranglerInterface.EXPECT().WaitForFilteredReplication(gomock.Any(), testKeyspace, "0", wrangler.DefaultWaitForFilteredReplicationMaxDelay).Return(nil)
+	for _, servedType := range []topodatapb.TabletType{topodatapb.TabletType_RDONLY, topodatapb.TabletType_REPLICA, topodatapb.TabletType_MASTER} {
+		mockWranglerInterface.EXPECT().MigrateServedFrom(gomock.Any(), testKeyspace, "0", servedType, nil /* cells */, false /* reverse */, wrangler.DefaultFilteredReplicationWaitTime).Return(nil)
+	}
+
+	flag.Set("vtworker_client_protocol", "fake")
+	fakeVtworkerClient := fakevtworkerclient.NewFakeVtworkerClient()
+	fakeVtworkerClient.RegisterResultForAddr(testVtworkers, resetCommand(), "", nil)
+	fakeVtworkerClient.RegisterResultForAddr(testVtworkers, []string{"VerticalSplitClone", "--tables=" + testTables, "--min_healthy_tablets=2", testKeyspace + "/0"}, "", nil)
+	fakeVtworkerClient.RegisterResultForAddr(testVtworkers, resetCommand(), "", nil)
+	fakeVtworkerClient.RegisterResultForAddr(testVtworkers, []string{"VerticalSplitDiff", "--min_healthy_rdonly_tablets=1", testKeyspace + "/0"}, "", nil)
+	vtworkerclient.RegisterFactory("fake", fakeVtworkerClient.FakeVtworkerClientFactory)
+	defer vtworkerclient.UnregisterFactoryForTest("fake")
+
+	ts := setupVerticalSplitTopology(ctx, t)
+	m := workflow.NewManager(ts)
+	wg, _, cancel := workflow.StartManager(m)
+	uuid, err := m.Create(ctx, verticalSplitFactoryName, []string{"-source_keyspace=" + testSourceKeyspace, "-destination_keyspace=" + testKeyspace, "-source_shard=0", "-destination_shard=0", "-tables=" + testTables, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=2", "-phase_enable_approvals="})
+	if err != nil {
+		t.Fatalf("cannot create vertical split workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	w.(*verticalSplitWorkflow).wr = mockWranglerInterface
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start vertical split workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	if err := workflow.VerifyAllTasksDone(ctx, ts, uuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(ctx, uuid); err != nil {
+		t.Fatalf("cannot stop vertical split workflow: %v", err)
+	}
+	cancel()
+	wg.Wait()
+}
+
+// TestVerticalSplitInit tests that Init rejects invalid parameters.
+func TestVerticalSplitInit(t *testing.T) {
+	ctx := context.Background()
+	ts := setupVerticalSplitTopology(ctx, t)
+	m := workflow.NewManager(ts)
+
+	args := []string{"-destination_keyspace=" + testKeyspace, "-source_shard=0", "-destination_shard=0", "-tables=" + testTables, "-min_healthy_rdonly_tablets=2"}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{append(args, "-source_keyspace="+testSourceKeyspace), "must be provided"},
+		{append(args, "-source_keyspace="+testSourceKeyspace, "-vtworkers=a,b"), "there are 2 vtworkers"},
+		{append(args, "-source_keyspace=other_keyspace", "-vtworkers="+testVtworkers), "is not served from keyspace other_keyspace"},
+		{append(args, "-source_keyspace="+testSourceKeyspace, "-vtworkers="+testVtworkers, "-phase_enable_approvals=unknown"), "invalid phase"},
+	} {
+		if _, err := m.Create(ctx, verticalSplitFactoryName, tc.args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Create(%v) returned wrong error: got = %v, want = %v", tc.args, err, tc.want)
+		}
+	}
+}
+
+func setupVerticalSplitTopology(ctx context.Context, t *testing.T) *topo.Server {
+	ts := memorytopo.NewServer("cell")
+	if err := ts.CreateKeyspace(ctx, testSourceKeyspace, &topodatapb.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.CreateShard(ctx, testSourceKeyspace, "0"); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+	if err := ts.CreateKeyspace(ctx, testKeyspace, &topodatapb.Keyspace{
+		ServedFroms: []*topodatapb.Keyspace_ServedFrom{
+			{TabletType: topodatapb.TabletType_MASTER, Keyspace: testSourceKeyspace},
+			{TabletType: topodatapb.TabletType_REPLICA, Keyspace: testSourceKeyspace},
+			{TabletType: topodatapb.TabletType_RDONLY, Keyspace: testSourceKeyspace},
+		},
+	}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.CreateShard(ctx, testKeyspace, "0"); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+	return ts
+}
@@ -53,10 +53,11 @@ const (
 	phaseMigrateMaster              workflow.PhaseType = "migrate_master"
 )
 
-// Register registers the HorizontalReshardingWorkflowFactory as a factory
-// in the workflow framework.
+// Register registers the HorizontalReshardingWorkflowFactory and the
+// VerticalSplitFactory as factories in the workflow framework.
 func Register() {
 	workflow.Register(horizontalReshardingFactoryName, &Factory{})
+	workflow.Register(verticalSplitFactoryName, &VerticalSplitFactory{})
 }
 
 // Factory is the factory to create
@@ -76,6 +77,11 @@ func (*Factory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string)
 	phaseEnaableApprovalsDesc := fmt.Sprintf("Comma separated phases that require explicit approval in the UI to execute. Phase names are: %v", strings.Join(WorkflowPhases(), ","))
 	phaseEnableApprovalsStr := subFlags.String("phase_enable_approvals", strings.Join(WorkflowPhases(), ","), phaseEnaableApprovalsDesc)
 	useConsistentSnapshot := subFlags.Bool("use_consistent_snapshot", false, "Instead of pausing replication on the source, uses transactions with consistent snapshot to have a stable view of the data.")
+	skipSplitDiff := subFlags.Bool("skip_split_diff", false, "If true, the SplitDiff phase is skipped and the copied data is NOT verified. Only use this if the data is verified externally")
+	splitParallelism := subFlags.Int("split_parallelism", 0, "Number of concurrent writers per destination shard during the SplitClone phase (passed as --destination_writer_count to vtworker). 0 uses the vtworker default")
+	diffCellsStr := subFlags.String("diff_cells", "", "A comma-separated list of cells. If set, the SplitDiff tasks of the destination shards are distributed across these cells (round-robin) instead of using the cell of the vtworker")
+	diffSamplePercent := subFlags.Int("diff_sample_percent", 100, "Percentage of the rows which SplitDiff verifies (passed as --sample_percent to vtworker). Values below 100 are faster, but the other rows are NOT verified")
+	parentWorkflow := subFlags.String("parent_workflow", "", "UUID of the workflow which created this workflow (e.g. a keyspace resharding). It's recorded in the checkpoint and shown in the UI")
 
 	if err := subFlags.Parse(args); err != nil {
 		return err
@@ -83,6 +89,18 @@ func (*Factory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string)
 	if *keyspace == "" || *vtworkersStr == "" || *minHealthyRdonlyTablets == "" || *splitCmd == "" {
 		return fmt.Errorf("keyspace name, min healthy rdonly tablets, split command, and vtworkers information must be provided for horizontal resharding")
 	}
+	if *splitParallelism < 0 {
+		return fmt.Errorf("split_parallelism must not be negative: %v", *splitParallelism)
+	}
+	if *diffCellsStr != "" && *skipSplitDiff {
+		return fmt.Errorf("diff_cells cannot be used with skip_split_diff")
+	}
+	if *diffSamplePercent < 1 || *diffSamplePercent > 100 {
+		return fmt.Errorf("diff_sample_percent must be between 1 and 100: %v", *diffSamplePercent)
+	}
+	if *diffSamplePercent < 100 && *skipSplitDiff {
+		return fmt.Errorf("diff_sample_percent cannot be used with skip_split_diff")
+	}
 
 	vtworkers := strings.Split(*vtworkersStr, ",")
 	sourceShards := strings.Split(*sourceShardsStr, ",")
@@ -116,6 +134,34 @@ func (*Factory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string)
 	}
 
 	checkpoint.Settings["phase_enable_approvals"] = *phaseEnableApprovalsStr
+	checkpoint.Settings["skip_split_diff"] = fmt.Sprintf("%v", *skipSplitDiff)
+	checkpoint.Settings["diff_sample_percent"] = strconv.Itoa(*diffSamplePercent)
+	if *parentWorkflow != "" {
+		checkpoint.Settings["parent_workflow"] = *parentWorkflow
+	}
+	if *skipSplitDiff {
+		log.Warningf("Horizontal resharding of keyspace %v: SplitDiff is skipped. The copied data will not be verified.", *keyspace)
+		for _, shard := range destinationShards {
+			delete(checkpoint.Tasks, createTaskID(phaseDiff, shard))
+		}
+	}
+	if *splitParallelism > 0 {
+		for _, shard := range sourceShards {
+			checkpoint.Tasks[createTaskID(phaseClone, shard)].Attributes["destination_writer_count"] = strconv.Itoa(*splitParallelism)
+		}
+	}
+	if *diffCellsStr != "" {
+		diffCells := strings.Split(*diffCellsStr, ",")
+		for i, shard := range destinationShards {
+			checkpoint.Tasks[createTaskID(phaseDiff, shard)].Attributes["cell"] = diffCells[i%len(diffCells)]
+		}
+	}
+	if *diffSamplePercent < 100 {
+		log.Warningf("Horizontal resharding of keyspace %v: SplitDiff only verifies a sample of %v%% of the rows. The other rows will not be verified.", *keyspace, *diffSamplePercent)
+		for _, shard := range destinationShards {
+			checkpoint.Tasks[createTaskID(phaseDiff, shard)].Attributes["sample_percent"] = strconv.Itoa(*diffSamplePercent)
+		}
+	}
 
 	w.Data, err = proto.Marshal(checkpoint)
 	if err != nil {
@@ -132,6 +178,9 @@ func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNod
 	if err := proto.Unmarshal(w.Data, checkpoint); err != nil {
 		return nil, err
 	}
+	if parent := checkpoint.Settings["parent_workflow"]; parent != "" {
+		rootNode.Message += fmt.Sprintf(" It was created by workflow %v.", parent)
+	}
 
 	phaseEnableApprovals := make(map[string]bool)
 	for _, phase := range parsePhaseEnableApprovals(checkpoint.Settings["phase_enable_approvals"]) {
@@ -146,6 +195,7 @@ func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNod
 		topoServer:           m.TopoServer(),
 		manager:              m,
 		phaseEnableApprovals: phaseEnableApprovals,
+		skipSplitDiff:        checkpoint.Settings["skip_split_diff"] == "true",
 	}
 	copySchemaUINode := &workflow.Node{
 		Name:     "CopySchemaShard",
@@ -198,9 +248,14 @@ func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNod
 	if err := createUINodes(hw.rootUINode, phaseWaitForFilteredReplication, destinationShards); err != nil {
 		return hw, err
 	}
-	if err := createUINodes(hw.rootUINode, phaseDiff, destinationShards); err != nil {
+	if hw.skipSplitDiff {
+		diffUINode.Message = "SplitDiff is skipped (-skip_split_diff). The copied data will not be verified."
+	} else if err := createUINodes(hw.rootUINode, phaseDiff, destinationShards); err != nil {
 		return hw, err
 	}
+	if percent := checkpoint.Settings["diff_sample_percent"]; percent != "" && percent != "100" {
+		diffUINode.Message = fmt.Sprintf("WARNING: SplitDiff only verifies a sample of %v%% of the rows (-diff_sample_percent). The other rows will not be verified.", percent)
+	}
 	if err := createUINodes(hw.rootUINode, phaseMigrateRdonly, sourceShards); err != nil {
 		return hw, err
 	}
@@ -214,6 +269,17 @@ func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNod
 	return hw, nil
 }
 
+// ParentWorkflow returns the UUID of the workflow which created the
+// horizontal resharding workflow "w" (see -parent_workflow). It returns an
+// empty string if it was not created by another workflow.
+func ParentWorkflow(w *workflowpb.Workflow) (string, error) {
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(w.Data, checkpoint); err != nil {
+		return "", err
+	}
+	return checkpoint.Settings["parent_workflow"], nil
+}
+
 func createUINodes(rootNode *workflow.Node, phaseName workflow.PhaseType, shards []string) error {
 	phaseNode, err := rootNode.GetChildByPath(string(phaseName))
 	if err != nil {
@@ -364,6 +430,8 @@ type horizontalReshardingWorkflow struct {
 	checkpointWriter *workflow.CheckpointWriter
 
 	phaseEnableApprovals map[string]bool
+	// skipSplitDiff is true if the SplitDiff phase has no tasks and is not run.
+	skipSplitDiff bool
 }
 
 // Run executes the horizontal resharding process.
@@ -401,10 +469,12 @@ func (hw *horizontalReshardingWorkflow) runWorkflow() error {
 		return err
 	}
 
-	diffTasks := hw.GetTasks(phaseDiff)
-	diffRunner := workflow.NewParallelRunner(hw.ctx, hw.rootUINode, hw.checkpointWriter, diffTasks, hw.runSplitDiff, workflow.Parallel, hw.phaseEnableApprovals[string(phaseWaitForFilteredReplication)])
-	if err := diffRunner.Run(); err != nil {
-		return err
+	if !hw.skipSplitDiff {
+		diffTasks := hw.GetTasks(phaseDiff)
+		diffRunner := workflow.NewParallelRunner(hw.ctx, hw.rootUINode, hw.checkpointWriter, diffTasks, hw.runSplitDiff, workflow.Parallel, hw.phaseEnableApprovals[string(phaseWaitForFilteredReplication)])
+		if err := diffRunner.Run(); err != nil {
+			return err
+		}
 	}
 
 	migrateRdonlyTasks := hw.GetTasks(phaseMigrateRdonly)
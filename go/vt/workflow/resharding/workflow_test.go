@@ -18,9 +18,11 @@ package resharding
 
 import (
 	"flag"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
 
 	"vitess.io/vitess/go/vt/topo"
@@ -34,6 +36,7 @@ import (
 	_ "vitess.io/vitess/go/vt/vttablet/grpctmclient"
 
 	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
 )
 
 var (
@@ -148,6 +151,185 @@ func testHorizontalReshardingWorkflow(t *testing.T, useConsistentSnapshot bool)
 	wg.Wait()
 }
 
+// TestSkipSplitDiff tests that no SplitDiff tasks are created with
+// -skip_split_diff.
+func TestSkipSplitDiff(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, horizontalReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-phase_enable_approvals=", "-min_healthy_rdonly_tablets=2", "-source_shards=0", "-destination_shards=-80,80-", "-skip_split_diff"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := checkpoint.Settings["skip_split_diff"], "true"; got != want {
+		t.Fatalf("wrong skip_split_diff setting: got = %v, want = %v", got, want)
+	}
+	for _, shard := range []string{"-80", "80-"} {
+		if _, ok := checkpoint.Tasks[createTaskID(phaseDiff, shard)]; ok {
+			t.Fatalf("SplitDiff task for shard %v should not have been created", shard)
+		}
+		if _, ok := checkpoint.Tasks[createTaskID(phaseWaitForFilteredReplication, shard)]; !ok {
+			t.Fatalf("other tasks for shard %v must still be created", shard)
+		}
+	}
+
+	rootNode := workflow.NewNode()
+	if _, err := (&Factory{}).Instantiate(m, wi.Workflow, rootNode); err != nil {
+		t.Fatalf("cannot instantiate workflow: %v", err)
+	}
+	diffNode, err := rootNode.GetChildByPath(string(phaseDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffNode.Children) != 0 || !strings.Contains(diffNode.Message, "skipped") {
+		t.Fatalf("SplitDiff phase should be shown as skipped: %+v", diffNode)
+	}
+}
+
+// TestSplitParallelism tests that -split_parallelism is passed to the
+// SplitClone tasks.
+func TestSplitParallelism(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-phase_enable_approvals=", "-min_healthy_rdonly_tablets=2", "-source_shards=0", "-destination_shards=-80,80-"}
+	if _, err := m.Create(ctx, horizontalReshardingFactoryName, append(args, "-split_parallelism=-1")); err == nil {
+		t.Fatal("negative -split_parallelism should have been rejected")
+	}
+	uuid, err := m.Create(ctx, horizontalReshardingFactoryName, append(args, "-split_parallelism=4"))
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := checkpoint.Tasks[createTaskID(phaseClone, "0")].Attributes["destination_writer_count"], "4"; got != want {
+		t.Fatalf("wrong destination_writer_count of the SplitClone task: got = %v, want = %v", got, want)
+	}
+}
+
+// TestDiffCells tests that the SplitDiff tasks are distributed across the
+// -diff_cells.
+func TestDiffCells(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-phase_enable_approvals=", "-min_healthy_rdonly_tablets=2", "-source_shards=0", "-destination_shards=-80,80-"}
+	if _, err := m.Create(ctx, horizontalReshardingFactoryName, append(args, "-diff_cells=cell1", "-skip_split_diff")); err == nil {
+		t.Fatal("-diff_cells with -skip_split_diff should have been rejected")
+	}
+	uuid, err := m.Create(ctx, horizontalReshardingFactoryName, append(args, "-diff_cells=cell1,cell2"))
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	for shard, want := range map[string]string{"-80": "cell1", "80-": "cell2"} {
+		if got := checkpoint.Tasks[createTaskID(phaseDiff, shard)].Attributes["cell"]; got != want {
+			t.Errorf("wrong cell of the SplitDiff task for shard %v: got = %v, want = %v", shard, got, want)
+		}
+	}
+}
+
+// TestDiffSamplePercent tests that -diff_sample_percent is recorded in the
+// SplitDiff tasks.
+func TestDiffSamplePercent(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-phase_enable_approvals=", "-min_healthy_rdonly_tablets=2", "-source_shards=0", "-destination_shards=-80,80-"}
+	for _, invalid := range [][]string{
+		{"-diff_sample_percent=0"},
+		{"-diff_sample_percent=101"},
+		{"-diff_sample_percent=10", "-skip_split_diff"},
+	} {
+		if _, err := m.Create(ctx, horizontalReshardingFactoryName, append(args, invalid...)); err == nil {
+			t.Errorf("%v should have been rejected", invalid)
+		}
+	}
+	uuid, err := m.Create(ctx, horizontalReshardingFactoryName, append(args, "-diff_sample_percent=10"))
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := checkpoint.Settings["diff_sample_percent"], "10"; got != want {
+		t.Errorf("wrong diff_sample_percent setting: got = %v, want = %v", got, want)
+	}
+	for _, shard := range []string{"-80", "80-"} {
+		if got, want := checkpoint.Tasks[createTaskID(phaseDiff, shard)].Attributes["sample_percent"], "10"; got != want {
+			t.Errorf("wrong sample percent of the SplitDiff task for shard %v: got = %v, want = %v", shard, got, want)
+		}
+	}
+}
+
+// TestParentWorkflow tests that -parent_workflow is recorded in the
+// checkpoint and can be looked up.
+func TestParentWorkflow(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-phase_enable_approvals=", "-min_healthy_rdonly_tablets=2", "-source_shards=0", "-destination_shards=-80,80-"}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{args, ""},
+		{append(args, "-parent_workflow=parent-uuid"), "parent-uuid"},
+	} {
+		uuid, err := m.Create(ctx, horizontalReshardingFactoryName, tc.args)
+		if err != nil {
+			t.Fatalf("cannot create resharding workflow: %v", err)
+		}
+		wi, err := ts.GetWorkflow(ctx, uuid)
+		if err != nil {
+			t.Fatalf("cannot read workflow: %v", err)
+		}
+		got, err := ParentWorkflow(wi.Workflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("wrong parent workflow: got = %v, want = %v", got, tc.want)
+		}
+	}
+}
+
 func setupFakeVtworker(keyspace, vtworkers string, useConsistentSnapshot bool) *fakevtworkerclient.FakeVtworkerClient {
 	flag.Set("vtworker_client_protocol", "fake")
 	fakeVtworkerClient := fakevtworkerclient.NewFakeVtworkerClient()
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/workflow"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the "Abort" action of the root UI node which stops all
+// child workflows and fails this workflow.
+
+// abortAction is the name of the action on the root UI node.
+const abortAction = "Abort"
+
+// enableAbortAction adds the "Abort" action to the root UI node. It returns
+// the context for the creation and tracking of the child workflows. The
+// context is canceled by Abort().
+func (hw *reshardingWorkflowGen) enableAbortAction(ctx context.Context) context.Context {
+	runCtx, cancel := context.WithCancel(ctx)
+	hw.mu.Lock()
+	hw.cancelRun = cancel
+	hw.mu.Unlock()
+
+	hw.rootUINode.Listener = hw
+	hw.rootUINode.Actions = []*workflow.Action{
+		{
+			Name:    abortAction,
+			State:   workflow.ActionStateEnabled,
+			Style:   workflow.ActionStyleWarning,
+			Message: "Stops all running child workflows and fails this workflow. Child workflows which were not started yet are not touched.",
+		},
+	}
+	hw.rootUINode.BroadcastChanges(false /* updateChildren */)
+	return runCtx
+}
+
+// Action implements the workflow.ActionListener interface for the root UI
+// node.
+func (hw *reshardingWorkflowGen) Action(ctx context.Context, path, name string) error {
+	if name != abortAction {
+		return fmt.Errorf("unknown action %v on node %v", name, path)
+	}
+	return hw.Abort(ctx)
+}
+
+// Abort stops the creation of further child workflows and stops all recorded
+// child workflows which are running. Afterwards, the root UI node shows that
+// the workflow was aborted and Run() fails with ErrAborted.
+func (hw *reshardingWorkflowGen) Abort(ctx context.Context) error {
+	hw.mu.Lock()
+	hw.aborted = true
+	cancel := hw.cancelRun
+	var uuids []string
+	for _, task := range hw.checkpoint.Tasks {
+		if uuid := task.Attributes[childUUIDAttribute]; uuid != "" {
+			uuids = append(uuids, uuid)
+		}
+	}
+	hw.mu.Unlock()
+	sort.Strings(uuids)
+
+	if cancel != nil {
+		cancel()
+	}
+
+	var stopped, failed []string
+	for _, uuid := range uuids {
+		if w, err := hw.childWorkflowReader(ctx, uuid); err == nil && w.State != workflowpb.WorkflowState_Running {
+			// Only running workflows can be stopped. If the state cannot be
+			// read, we try to stop it anyway.
+			continue
+		}
+		if err := hw.childStopper(ctx, uuid); err != nil {
+			log.Errorf("Keyspace resharding: cannot stop child workflow %v: %v", uuid, err)
+			failed = append(failed, fmt.Sprintf("%v (%v)", uuid, err))
+			continue
+		}
+		stopped = append(stopped, uuid)
+	}
+
+	for _, action := range hw.rootUINode.Actions {
+		if action.Name == abortAction {
+			action.State = workflow.ActionStateDisabled
+		}
+	}
+	message := fmt.Sprintf("ABORTED: Stopped %v child workflow(s): %v.", len(stopped), strings.Join(stopped, ", "))
+	if len(failed) > 0 {
+		message += fmt.Sprintf(" Failed to stop %v child workflow(s): %v", len(failed), strings.Join(failed, ", "))
+	}
+	hw.setUIMessage(hw.rootUINode, message)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to stop child workflows: %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// abortedError returns an ErrAborted error if Abort() was called and nil
+// otherwise.
+func (hw *reshardingWorkflowGen) abortedError() error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if !hw.aborted {
+		return nil
+	}
+	return newError(ErrAborted, "keyspace resharding was aborted")
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/workflow"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+func TestAbort(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2", "-skip_start_workflows=false", "-track_children"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	hw.trackChildrenInterval = 1 * time.Millisecond
+	// The child workflows keep running until they are stopped.
+	var mu sync.Mutex
+	var stopped []string
+	hw.childStarter = func(ctx context.Context, childUUID string) error {
+		return nil
+	}
+	hw.childWorkflowReader = func(ctx context.Context, childUUID string) (*workflowpb.Workflow, error) {
+		return &workflowpb.Workflow{State: workflowpb.WorkflowState_Running}, nil
+	}
+	hw.childStopper = func(ctx context.Context, childUUID string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = append(stopped, childUUID)
+		return nil
+	}
+
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	defer m.Stop(ctx, uuid)
+	// Wait until both child workflows were created.
+	var childUUIDs []string
+	for start := time.Now(); ; {
+		hw.mu.Lock()
+		childUUIDs = append([]string(nil), hw.childUUIDs...)
+		hw.mu.Unlock()
+		if len(childUUIDs) == 2 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("two child workflows should have been created: %v", childUUIDs)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	// Trigger the action like the UI does.
+	if err := m.NodeManager().Action(ctx, &workflow.ActionParameters{Path: hw.rootUINode.Path, Name: abortAction}); err != nil {
+		t.Fatalf("abort action failed: %v", err)
+	}
+	m.Wait(ctx, uuid)
+
+	mu.Lock()
+	sort.Strings(stopped)
+	sort.Strings(childUUIDs)
+	if strings.Join(stopped, ",") != strings.Join(childUUIDs, ",") {
+		t.Fatalf("all child workflows should have been stopped: got = %v, want = %v", stopped, childUUIDs)
+	}
+	mu.Unlock()
+
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	if !strings.Contains(wi.Error, "aborted") {
+		t.Fatalf("the workflow should have failed because it was aborted: %v", wi.Error)
+	}
+	if !strings.Contains(hw.rootUINode.Message, "ABORTED: Stopped 2 child workflow(s)") {
+		t.Fatalf("the root node should show that the workflow was aborted: %v", hw.rootUINode.Message)
+	}
+	if len(hw.rootUINode.Actions) != 1 || hw.rootUINode.Actions[0].State != workflow.ActionStateDisabled {
+		t.Fatalf("the abort action should have been disabled: %v", hw.rootUINode.Actions)
+	}
+
+	if err := hw.Action(ctx, hw.rootUINode.Path, "Unknown"); err == nil {
+		t.Fatal("an unknown action should have failed")
+	}
+}
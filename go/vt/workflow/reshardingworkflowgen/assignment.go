@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the export of the task assignment which is enabled by
+// -show_assignment.
+
+const (
+	// assignmentFormatTable and assignmentFormatCSV are the values for the
+	// -show_assignment flag.
+	assignmentFormatTable = "table"
+	assignmentFormatCSV   = "csv"
+)
+
+var assignmentHeader = []string{"Task", "Source Shards", "Destination Shards", "Vtworkers"}
+
+// assignmentRows returns one row per task, ordered by the task number.
+// Each row has the columns of "assignmentHeader".
+func assignmentRows(tasks map[string]*workflowpb.Task) [][]string {
+	ids := make([]string, 0, len(tasks))
+	for id := range tasks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return taskNumber(ids[i]) < taskNumber(ids[j])
+	})
+
+	rows := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		task := tasks[id]
+		rows = append(rows, []string{
+			id,
+			task.Attributes["source_shards"],
+			task.Attributes["destination_shards"],
+			task.Attributes["vtworkers"],
+		})
+	}
+	return rows
+}
+
+// taskNumber returns N for the task ID "<phaseName>/N" or -1 if the ID has a
+// different format.
+func taskNumber(id string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(id, phaseName+"/"))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// formatAssignmentTable returns the task assignment as text table with
+// aligned columns.
+func formatAssignmentTable(tasks map[string]*workflowpb.Task) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0 /* minwidth */, 8 /* tabwidth */, 2 /* padding */, ' ', 0 /* flags */)
+	fmt.Fprintln(w, strings.Join(assignmentHeader, "\t"))
+	for _, row := range assignmentRows(tasks) {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// formatAssignmentCSV returns the task assignment as CSV including a header.
+func formatAssignmentCSV(tasks map[string]*workflowpb.Task) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(assignmentHeader); err != nil {
+		return "", err
+	}
+	if err := w.WriteAll(assignmentRows(tasks)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// formatAssignment returns the task assignment in the format of
+// -show_assignment.
+func formatAssignment(format string, tasks map[string]*workflowpb.Task) (string, error) {
+	switch format {
+	case assignmentFormatTable:
+		return formatAssignmentTable(tasks), nil
+	case assignmentFormatCSV:
+		return formatAssignmentCSV(tasks)
+	}
+	return "", newError(ErrInvalidArguments, "invalid show_assignment: %v (must be %v or %v)", format, assignmentFormatTable, assignmentFormatCSV)
+}
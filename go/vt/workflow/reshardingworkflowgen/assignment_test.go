@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/workflow"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+func TestAssignment(t *testing.T) {
+	shardsToSplit := [][][]string{
+		{{"-80"}, {"-40", "40-80"}},
+		{{"80-"}, {"80-c0", "c0-"}},
+	}
+	vtworkers := []string{"vtworker1:15033", "vtworker2:15033", "vtworker3:15033", "vtworker4:15033"}
+	checkpoint, err := initCheckpoint(testKeyspace, vtworkers, shardsToSplit, "2", "SplitClone", "RDONLY", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRows := [][]string{
+		{phaseName + "/0", "-80", "-40,40-80", "vtworker1:15033,vtworker2:15033"},
+		{phaseName + "/1", "80-", "80-c0,c0-", "vtworker3:15033,vtworker4:15033"},
+	}
+	if got := assignmentRows(checkpoint.Tasks); !reflect.DeepEqual(got, wantRows) {
+		t.Fatalf("wrong assignment rows: got = %v, want = %v", got, wantRows)
+	}
+
+	wantTable := "" +
+		"Task                Source Shards  Destination Shards  Vtworkers\n" +
+		"create_workflows/0  -80            -40,40-80           vtworker1:15033,vtworker2:15033\n" +
+		"create_workflows/1  80-            80-c0,c0-           vtworker3:15033,vtworker4:15033\n"
+	if got := formatAssignmentTable(checkpoint.Tasks); got != wantTable {
+		t.Fatalf("wrong assignment table: got =\n%v\nwant =\n%v", got, wantTable)
+	}
+
+	wantCSV := "" +
+		"Task,Source Shards,Destination Shards,Vtworkers\n" +
+		"create_workflows/0,-80,\"-40,40-80\",\"vtworker1:15033,vtworker2:15033\"\n" +
+		"create_workflows/1,80-,\"80-c0,c0-\",\"vtworker3:15033,vtworker4:15033\"\n"
+	got, err := formatAssignmentCSV(checkpoint.Tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != wantCSV {
+		t.Fatalf("wrong assignment CSV: got =\n%v\nwant =\n%v", got, wantCSV)
+	}
+}
+
+func TestShowAssignment(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-show_assignment=xml"}); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("an invalid format should have failed with ErrInvalidArguments: %v", err)
+	}
+
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-show_assignment=table"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	rootNode := workflow.NewNode()
+	if _, err := (&Factory{}).Instantiate(m, wi.Workflow, rootNode); err != nil {
+		t.Fatalf("cannot instantiate workflow: %v", err)
+	}
+	if want := formatAssignmentTable(checkpoint.Tasks); !strings.Contains(rootNode.Message, want) {
+		t.Fatalf("root node message does not contain the assignment:\n%v\nmessage:\n%v", want, rootNode.Message)
+	}
+}
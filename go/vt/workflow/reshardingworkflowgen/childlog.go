@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"encoding/json"
+	"strings"
+
+	"vitess.io/vitess/go/vt/log"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the structured log line which is written for each
+// created child workflow. Log aggregators can index its JSON fields.
+
+// childCreatedLogPrefix precedes the JSON object in the log line.
+const childCreatedLogPrefix = "Keyspace resharding child workflow created: "
+
+// childCreatedEvent is logged as JSON after a child workflow was created.
+type childCreatedEvent struct {
+	Keyspace string `json:"keyspace"`
+	// SourceKeyspace is only set for vertical splits.
+	SourceKeyspace    string   `json:"source_keyspace,omitempty"`
+	ParentUUID        string   `json:"parent_uuid,omitempty"`
+	Task              string   `json:"task"`
+	SourceShards      []string `json:"source_shards"`
+	DestinationShards []string `json:"destination_shards"`
+	Vtworkers         []string `json:"vtworkers"`
+	Factory           string   `json:"factory"`
+	ChildUUID         string   `json:"child_uuid"`
+}
+
+// logChildCreated writes the structured log line for the child workflow
+// "childUUID" which was created by "factoryName" for "task".
+func (hw *reshardingWorkflowGen) logChildCreated(task *workflowpb.Task, factoryName, childUUID string) {
+	event := &childCreatedEvent{
+		Keyspace:          hw.keyspaceParam,
+		Task:              task.Id,
+		SourceShards:      strings.Split(task.Attributes["source_shards"], ","),
+		DestinationShards: strings.Split(task.Attributes["destination_shards"], ","),
+		Vtworkers:         strings.Split(task.Attributes["vtworkers"], ","),
+		Factory:           factoryName,
+		ChildUUID:         childUUID,
+	}
+	if hw.splitTypeParam == splitTypeVertical {
+		event.SourceKeyspace = hw.sourceKeyspaceParam
+	}
+	if hw.wi != nil {
+		event.ParentUUID = hw.wi.Uuid
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Keyspace resharding: cannot encode the log line of child workflow %v: %v", childUUID, err)
+		return
+	}
+	hw.structuredLogf("%v%s", childCreatedLogPrefix, data)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/workflow"
+)
+
+func TestLogChildCreated(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	var mu sync.Mutex
+	var lines []string
+	hw.structuredLogf = func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	defer m.Stop(ctx, uuid)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lines) != 1 || len(hw.childUUIDs) != 1 {
+		t.Fatalf("one line should have been logged for the one child workflow: lines: %v child UUIDs: %v", lines, hw.childUUIDs)
+	}
+	if !strings.HasPrefix(lines[0], childCreatedLogPrefix) {
+		t.Fatalf("wrong prefix of the log line: %v", lines[0])
+	}
+	var got childCreatedEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[0], childCreatedLogPrefix)), &got); err != nil {
+		t.Fatalf("the log line must have a JSON object: %v line: %v", err, lines[0])
+	}
+	want := childCreatedEvent{
+		Keyspace:          testKeyspace,
+		ParentUUID:        uuid,
+		Task:              phaseName + "/0",
+		SourceShards:      []string{"0"},
+		DestinationShards: []string{"-80", "80-"},
+		Vtworkers:         []string{testVtworkers, testVtworkers},
+		Factory:           horizontalReshardingFactoryName,
+		ChildUUID:         hw.childUUIDs[0],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong structured fields: got = %+v, want = %+v", got, want)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/key"
+	"vitess.io/vitess/go/vt/topo"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+// This file contains the check that the destination shards of a horizontal
+// resharding exactly cover the key range of their source shards. Without it,
+// shards with a gap or an overlap are silently ignored by
+// topotools.FindOverlappingShards() or fail with a generic error.
+
+// checkDestinationCoverage verifies for all source shards of the keyspace
+// which are split or merged that the union of the key ranges of their
+// destination shards is exactly their key range.
+// Source shards are the shards which are serving. Adjacent source shards are
+// checked together because they may be merged.
+func checkDestinationCoverage(ctx context.Context, ts *topo.Server, keyspace string) error {
+	shards, err := ts.FindAllShardsInKeyspace(ctx, keyspace)
+	if err != nil {
+		return wrapError(ErrTopo, err)
+	}
+	var sources, destinations []*topo.ShardInfo
+	for _, si := range shards {
+		if si.IsMasterServing {
+			sources = append(sources, si)
+		} else {
+			destinations = append(destinations, si)
+		}
+	}
+	return checkShardCoverage(sources, destinations)
+}
+
+// checkShardCoverage groups the source shards which overlap with destination
+// shards into runs of adjacent shards and checks that the overlapping
+// destination shards exactly cover each run.
+func checkShardCoverage(sources, destinations []*topo.ShardInfo) error {
+	var split []*topo.ShardInfo
+	for _, s := range sources {
+		if len(intersectingShards(s.KeyRange, destinations)) > 0 {
+			split = append(split, s)
+		}
+	}
+	sortShards(split)
+
+	var problems []string
+	for i := 0; i < len(split); {
+		// Extend the run as long as the next source shard is adjacent.
+		run := &topodatapb.KeyRange{Start: split[i].KeyRange.GetStart(), End: split[i].KeyRange.GetEnd()}
+		j := i + 1
+		for ; j < len(split) && len(run.End) != 0 && bytes.Equal(run.End, split[j].KeyRange.GetStart()); j++ {
+			run.End = split[j].KeyRange.GetEnd()
+		}
+		problems = append(problems, keyRangeCoverProblems(run, intersectingShards(run, destinations))...)
+		i = j
+	}
+	if len(problems) > 0 {
+		return newError(ErrKeyRangeNotCovered, "destination shards do not exactly cover the key range of their source shards: %v", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// keyRangeCoverProblems returns the gaps and overlaps of "shards" within the
+// key range "want". It returns nothing if the key ranges of the shards are
+// adjacent and their union is "want".
+func keyRangeCoverProblems(want *topodatapb.KeyRange, shards []*topo.ShardInfo) []string {
+	sortShards(shards)
+	var problems []string
+	first, last := shards[0], shards[len(shards)-1]
+	if !key.KeyRangeStartEqual(want, first.KeyRange) {
+		if bytes.Compare(first.KeyRange.GetStart(), want.GetStart()) > 0 {
+			problems = append(problems, fmt.Sprintf("gap %v", keyRangeString(want.GetStart(), first.KeyRange.GetStart())))
+		} else {
+			problems = append(problems, fmt.Sprintf("shard %v starts before the source key range %v", first.ShardName(), key.KeyRangeString(want)))
+		}
+	}
+	for i := 0; i+1 < len(shards); i++ {
+		cur, next := shards[i], shards[i+1]
+		end, nextStart := cur.KeyRange.GetEnd(), next.KeyRange.GetStart()
+		switch {
+		case len(end) == 0 || bytes.Compare(end, nextStart) > 0:
+			overlapEnd := end
+			if len(end) == 0 || (len(next.KeyRange.GetEnd()) != 0 && bytes.Compare(next.KeyRange.GetEnd(), end) < 0) {
+				overlapEnd = next.KeyRange.GetEnd()
+			}
+			problems = append(problems, fmt.Sprintf("overlap %v of shards %v and %v", keyRangeString(nextStart, overlapEnd), cur.ShardName(), next.ShardName()))
+		case bytes.Compare(end, nextStart) < 0:
+			problems = append(problems, fmt.Sprintf("gap %v between shards %v and %v", keyRangeString(end, nextStart), cur.ShardName(), next.ShardName()))
+		}
+	}
+	if !key.KeyRangeEndEqual(want, last.KeyRange) {
+		if len(last.KeyRange.GetEnd()) != 0 && (len(want.GetEnd()) == 0 || bytes.Compare(last.KeyRange.GetEnd(), want.GetEnd()) < 0) {
+			problems = append(problems, fmt.Sprintf("gap %v", keyRangeString(last.KeyRange.GetEnd(), want.GetEnd())))
+		} else {
+			problems = append(problems, fmt.Sprintf("shard %v ends after the source key range %v", last.ShardName(), key.KeyRangeString(want)))
+		}
+	}
+	return problems
+}
+
+// intersectingShards returns the shards which intersect with "kr".
+func intersectingShards(kr *topodatapb.KeyRange, shards []*topo.ShardInfo) []*topo.ShardInfo {
+	var result []*topo.ShardInfo
+	for _, si := range shards {
+		if key.KeyRangesIntersect(kr, si.KeyRange) {
+			result = append(result, si)
+		}
+	}
+	return result
+}
+
+// sortShards sorts the shards by the start of their key range. Shards with
+// the same start are sorted by name to get a deterministic order.
+func sortShards(shards []*topo.ShardInfo) {
+	sort.Slice(shards, func(i, j int) bool {
+		if c := bytes.Compare(shards[i].KeyRange.GetStart(), shards[j].KeyRange.GetStart()); c != 0 {
+			return c < 0
+		}
+		return shards[i].ShardName() < shards[j].ShardName()
+	})
+}
+
+// keyRangeString formats the key range from "start" to "end" like a shard
+// name.
+func keyRangeString(start, end []byte) string {
+	return key.KeyRangeString(&topodatapb.KeyRange{Start: start, End: end})
+}
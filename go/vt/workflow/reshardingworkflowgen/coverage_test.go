@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/memorytopo"
+	"vitess.io/vitess/go/vt/workflow"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+func newTestShardInfos(t *testing.T, names ...string) []*topo.ShardInfo {
+	var result []*topo.ShardInfo
+	for _, name := range names {
+		_, keyRange, err := topo.ValidateShardName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, topo.NewShardInfo(testKeyspace, name, &topodatapb.Shard{KeyRange: keyRange}, nil))
+	}
+	return result
+}
+
+func TestCheckShardCoverage(t *testing.T) {
+	testCases := []struct {
+		name         string
+		sources      []string
+		destinations []string
+		wantError    string
+	}{
+		{
+			name:         "split",
+			sources:      []string{"-80", "80-"},
+			destinations: []string{"80-c0", "c0-"},
+		},
+		{
+			name:         "split of the unsharded range",
+			sources:      []string{"0"},
+			destinations: []string{"-80", "80-"},
+		},
+		{
+			name:         "merge",
+			sources:      []string{"-40", "40-80", "80-"},
+			destinations: []string{"-80"},
+		},
+		{
+			name:         "two independent splits",
+			sources:      []string{"-40", "40-80", "80-"},
+			destinations: []string{"-20", "20-40", "80-c0", "c0-"},
+		},
+		{
+			name:         "gap",
+			sources:      []string{"-80", "80-"},
+			destinations: []string{"80-c0", "d0-"},
+			wantError:    "gap c0-d0 between shards 80-c0 and d0-",
+		},
+		{
+			name:         "gap at the end",
+			sources:      []string{"-80", "80-"},
+			destinations: []string{"-40", "40-70"},
+			wantError:    "gap 70-80",
+		},
+		{
+			name:         "overlap",
+			sources:      []string{"-80", "80-"},
+			destinations: []string{"80-c0", "b0-"},
+			wantError:    "overlap b0-c0 of shards 80-c0 and b0-",
+		},
+	}
+	for _, tc := range testCases {
+		err := checkShardCoverage(newTestShardInfos(t, tc.sources...), newTestShardInfos(t, tc.destinations...))
+		if tc.wantError == "" {
+			if err != nil {
+				t.Errorf("%v: destination shards should cover the source shards: %v", tc.name, err)
+			}
+			continue
+		}
+		if !IsErrType(err, ErrKeyRangeNotCovered) || !strings.Contains(err.Error(), tc.wantError) {
+			t.Errorf("%v: wrong error: got = %v, want = %v", tc.name, err, tc.wantError)
+		}
+	}
+}
+
+func TestInitDestinationShardsGap(t *testing.T) {
+	ctx := context.Background()
+	ts := memorytopo.NewServer("cell")
+	if err := ts.CreateKeyspace(ctx, testKeyspace, &topodatapb.Keyspace{
+		ShardingColumnName: "keyspace_id",
+		ShardingColumnType: topodatapb.KeyspaceIdType_UINT64,
+	}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	// The source shards are created first and are serving.
+	for _, shard := range []string{"-80", "80-", "80-c0", "d0-"} {
+		if err := ts.CreateShard(ctx, testKeyspace, shard); err != nil {
+			t.Fatalf("CreateShard: %v", err)
+		}
+	}
+	m := workflow.NewManager(ts)
+
+	_, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=2"})
+	if err == nil || !strings.Contains(err.Error(), "gap c0-d0") {
+		t.Fatalf("workflow creation should have failed because of the gap: %v", err)
+	}
+}
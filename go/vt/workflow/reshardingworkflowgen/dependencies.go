@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the dependencies between tasks which are set by
+// -dependencies. They define the order in which the child workflows are
+// created and started. They do NOT wait for the completion of a child
+// workflow: The child workflow of a "before" task is usually still running
+// when the child workflow of its "after" task is started.
+
+// dependency requires that the task of shard "before" creates and starts its
+// child workflow before the task of shard "after".
+type dependency struct {
+	before string
+	after  string
+}
+
+// parseDependencies parses the -dependencies list of "shard>shard" pairs.
+func parseDependencies(list string) ([]dependency, error) {
+	var dependencies []dependency
+	if list == "" {
+		return dependencies, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		parts := strings.Split(pair, ">")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, newError(ErrInvalidArguments, "invalid dependency: %v (must be shard>shard)", pair)
+		}
+		dependencies = append(dependencies, dependency{before: parts[0], after: parts[1]})
+	}
+	return dependencies, nil
+}
+
+// setTaskOrder computes an order of the tasks which satisfies
+// "dependencies" and stores it in the setting "task_order". A dependency
+// applies to the tasks which have the shards as source or destination shard.
+// Tasks which do not depend on each other keep their index order.
+// It returns an error if a shard does not match any task, if both shards
+// belong to the same task or if the dependencies have a cycle.
+func setTaskOrder(checkpoint *workflowpb.WorkflowCheckpoint, dependencies []dependency) error {
+	if len(dependencies) == 0 {
+		return nil
+	}
+	count := len(checkpoint.Tasks)
+	taskOfShard := make(map[string]int)
+	for i := 0; i < count; i++ {
+		task := checkpoint.Tasks[fmt.Sprintf("%s/%v", phaseName, i)]
+		shards := append(strings.Split(task.Attributes["source_shards"], ","), strings.Split(task.Attributes["destination_shards"], ",")...)
+		for _, shard := range shards {
+			taskOfShard[shard] = i
+		}
+	}
+
+	// successors[i] lists the tasks which must wait for task i. pending[i]
+	// is the number of tasks which task i still waits for.
+	successors := make([][]int, count)
+	pending := make([]int, count)
+	for _, d := range dependencies {
+		before, ok := taskOfShard[d.before]
+		if !ok {
+			return newError(ErrInvalidArguments, "dependency %v>%v: shard %v does not match any task", d.before, d.after, d.before)
+		}
+		after, ok := taskOfShard[d.after]
+		if !ok {
+			return newError(ErrInvalidArguments, "dependency %v>%v: shard %v does not match any task", d.before, d.after, d.after)
+		}
+		if before == after {
+			return newError(ErrInvalidArguments, "dependency %v>%v: both shards belong to the task of the source shards %v", d.before, d.after, checkpoint.Tasks[fmt.Sprintf("%s/%v", phaseName, before)].Attributes["source_shards"])
+		}
+		successors[before] = append(successors[before], after)
+		pending[after]++
+	}
+
+	// Always pick the ready task with the lowest index next.
+	done := make([]bool, count)
+	var order []string
+	for len(order) < count {
+		next := -1
+		for i := 0; i < count; i++ {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var cycle []string
+			for i := 0; i < count; i++ {
+				if !done[i] {
+					cycle = append(cycle, checkpoint.Tasks[fmt.Sprintf("%s/%v", phaseName, i)].Attributes["source_shards"])
+				}
+			}
+			return newError(ErrDependencyCycle, "dependencies have a cycle between the tasks of the source shards: %v", strings.Join(cycle, " "))
+		}
+		done[next] = true
+		order = append(order, strconv.Itoa(next))
+		for _, successor := range successors[next] {
+			pending[successor]--
+		}
+	}
+	checkpoint.Settings["task_order"] = strings.Join(order, ",")
+	return nil
+}
+
+// parseTaskOrder returns the task IDs in the order of the setting
+// "task_order". Without the setting, the tasks are in index order.
+func parseTaskOrder(setting string, workflowsCount int) ([]string, error) {
+	var taskIDs []string
+	if setting == "" {
+		for i := 0; i < workflowsCount; i++ {
+			taskIDs = append(taskIDs, fmt.Sprintf("%s/%v", phaseName, i))
+		}
+		return taskIDs, nil
+	}
+	seen := make(map[int]bool)
+	for _, v := range strings.Split(setting, ",") {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= workflowsCount || seen[i] {
+			return nil, newError(ErrInvalidCheckpoint, "invalid task_order: %v", setting)
+		}
+		seen[i] = true
+		taskIDs = append(taskIDs, fmt.Sprintf("%s/%v", phaseName, i))
+	}
+	if len(taskIDs) != workflowsCount {
+		return nil, newError(ErrInvalidCheckpoint, "invalid task_order: %v (must list all %v tasks)", setting, workflowsCount)
+	}
+	return taskIDs, nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/workflow"
+)
+
+func TestDependencies(t *testing.T) {
+	const orderingFactoryName = "ordering_resharding"
+	factory := &recordingFactory{}
+	workflow.Register(orderingFactoryName, factory)
+	defer workflow.Unregister(orderingFactoryName)
+
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-40", "40-80", "80-"}, []string{"-20", "20-40", "40-60", "60-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 5) + testVtworkers
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2", "-child_factory=" + orderingFactoryName}
+
+	// A cycle is rejected. 60-80 belongs to the same task as 40-80.
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-dependencies=80->40-80,40-80>-40,-20>60-80")); !IsErrType(err, ErrDependencyCycle) {
+		t.Fatalf("the cycle should have been rejected: %v", err)
+	}
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-dependencies=80->ff-")); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("the unknown shard should have been rejected: %v", err)
+	}
+	// Both shards belong to the task of 40-80. This is not reported as cycle.
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-dependencies=40-60>40-80")); !IsErrType(err, ErrInvalidArguments) || !strings.Contains(err.Error(), "both shards belong to the task") {
+		t.Fatalf("the dependency within one task should have been rejected: %v", err)
+	}
+
+	// The chain 80- => 40-80 => -40 reverses the discovered order. The
+	// dependencies may refer to source or destination shards.
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-dependencies=40-60>-20,c0->40-80"))
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	defer m.Stop(ctx, uuid)
+
+	var got []string
+	for _, childArgs := range factory.args {
+		for _, arg := range childArgs {
+			if strings.HasPrefix(arg, "-source_shards=") {
+				got = append(got, strings.TrimPrefix(arg, "-source_shards="))
+			}
+		}
+	}
+	if want := []string{"80-", "40-80", "-40"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("the child workflows were created in the wrong order: got = %v, want = %v", got, want)
+	}
+}
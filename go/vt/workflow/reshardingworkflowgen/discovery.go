@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/topo"
+)
+
+// This file contains the strategies which find the source and destination
+// shards of a horizontal resharding (-discovery_strategy).
+
+// defaultDiscoveryStrategy finds the overlapping shards of the keyspace.
+const defaultDiscoveryStrategy = "overlapping_shards"
+
+// ShardPairDiscoverer finds the source and destination shards which should be
+// split or merged in a keyspace.
+// Custom topologies can register their own implementation with
+// RegisterShardPairDiscoverer().
+type ShardPairDiscoverer interface {
+	// DiscoverShardPairs returns one entry per task. Each entry has the
+	// source shards at index 0 and the destination shards at index 1.
+	DiscoverShardPairs(ctx context.Context, ts *topo.Server, keyspace string) ([][][]string, error)
+}
+
+// discoverers has the registered strategies by their name.
+var discoverers = map[string]ShardPairDiscoverer{
+	defaultDiscoveryStrategy: overlappingShardsDiscoverer{},
+}
+
+// RegisterShardPairDiscoverer registers a strategy which can be selected with
+// -discovery_strategy=<name>.
+// If a strategy with that name already exists, it log.Fatals out.
+// Call this in the 'init' function of your module.
+func RegisterShardPairDiscoverer(name string, discoverer ShardPairDiscoverer) {
+	if discoverers[name] != nil {
+		log.Fatalf("Duplicate ShardPairDiscoverer registration for %v", name)
+	}
+	discoverers[name] = discoverer
+}
+
+// shardPairDiscoverer returns the strategy registered as "name".
+func shardPairDiscoverer(name string) (ShardPairDiscoverer, error) {
+	discoverer, ok := discoverers[name]
+	if !ok {
+		var names []string
+		for n := range discoverers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, newError(ErrInvalidArguments, "invalid discovery_strategy: %v (registered strategies: %v)", name, strings.Join(names, ","))
+	}
+	return discoverer, nil
+}
+
+// overlappingShardsDiscoverer is the default strategy. It pairs the shards
+// whose key ranges overlap. The side which is serving is the source.
+type overlappingShardsDiscoverer struct{}
+
+// DiscoverShardPairs is part of the ShardPairDiscoverer interface.
+func (overlappingShardsDiscoverer) DiscoverShardPairs(ctx context.Context, ts *topo.Server, keyspace string) ([][][]string, error) {
+	return findSourceAndDestinationShards(ts, keyspace)
+}
+
+// skipMigratedOverlaps removes the pairs whose destination shards already
+// serve at least one tablet type. These overlaps were (partially) migrated
+// by a previous resharding and a re-run must not split them again.
+// The skipped pairs are logged.
+func skipMigratedOverlaps(ctx context.Context, ts *topo.Server, keyspace string, shardsToSplit [][][]string) ([][][]string, error) {
+	var result [][][]string
+	for _, shardToSplit := range shardsToSplit {
+		migrated := false
+		for _, shard := range shardToSplit[1] {
+			si, err := ts.GetShard(ctx, keyspace, shard)
+			if err != nil {
+				return nil, wrapError(ErrTopo, err)
+			}
+			servingTypes, err := ts.GetShardServingTypes(ctx, si)
+			if err != nil {
+				return nil, wrapError(ErrTopo, err)
+			}
+			if len(servingTypes) > 0 {
+				log.Infof("Keyspace resharding of keyspace %v: skipping source shards %v (destination shards: %v) because destination shard %v already serves %v", keyspace, strings.Join(shardToSplit[0], ","), strings.Join(shardToSplit[1], ","), shard, servingTypes)
+				migrated = true
+				break
+			}
+		}
+		if !migrated {
+			result = append(result, shardToSplit)
+		}
+	}
+	return result, nil
+}
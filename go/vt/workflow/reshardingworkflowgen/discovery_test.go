@@ -0,0 +1,254 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/workflow"
+)
+
+const fakeDiscoveryStrategy = "fake_test"
+
+var fakeDiscoverer = &explicitPairsDiscoverer{
+	pairs: [][][]string{{{"80-"}, {"80-c0", "c0-"}}},
+}
+
+func init() {
+	RegisterShardPairDiscoverer(fakeDiscoveryStrategy, fakeDiscoverer)
+}
+
+// explicitPairsDiscoverer returns a fixed list of pairs and records for which
+// keyspaces it was called.
+type explicitPairsDiscoverer struct {
+	pairs [][][]string
+
+	mu        sync.Mutex
+	keyspaces []string
+}
+
+// DiscoverShardPairs is part of the ShardPairDiscoverer interface.
+func (d *explicitPairsDiscoverer) DiscoverShardPairs(ctx context.Context, ts *topo.Server, keyspace string) ([][][]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keyspaces = append(d.keyspaces, keyspace)
+	return d.pairs, nil
+}
+
+func TestDiscoveryStrategy(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	for _, args := range [][]string{
+		{"-discovery_strategy=unknown"},
+		{"-discovery_strategy=" + fakeDiscoveryStrategy, "-split_type=vertical", "-tables=t1"},
+	} {
+		args = append(args, "-keyspace="+testKeyspace, "-vtworkers="+vtworkersParameter, "-min_healthy_rdonly_tablets=2")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+
+	// The default strategy finds both pairs of overlapping shards. The fake
+	// strategy only returns the second one.
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-discovery_strategy=" + fakeDiscoveryStrategy})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	fakeDiscoverer.mu.Lock()
+	keyspaces := fakeDiscoverer.keyspaces
+	fakeDiscoverer.mu.Unlock()
+	if len(keyspaces) != 1 || keyspaces[0] != testKeyspace {
+		t.Fatalf("the fake strategy should have been called once for keyspace %v: got = %v", testKeyspace, keyspaces)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if len(hw.checkpoint.Tasks) != 1 {
+		t.Fatalf("only the pair of the fake strategy must be split: got = %v", hw.checkpoint.Tasks)
+	}
+	task := hw.checkpoint.Tasks[phaseName+"/0"]
+	if got, want := task.Attributes["source_shards"], "80-"; got != want {
+		t.Errorf("wrong source shards: got = %v, want = %v", got, want)
+	}
+	if got, want := task.Attributes["destination_shards"], "80-c0,c0-"; got != want {
+		t.Errorf("wrong destination shards: got = %v, want = %v", got, want)
+	}
+}
+
+func TestExplainDiscovery(t *testing.T) {
+	ctx := context.Background()
+	ts := setupServingTopology(ctx, t, testKeyspace, 0)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	for _, args := range [][]string{
+		{"-explain_discovery", "-discovery_strategy=" + fakeDiscoveryStrategy},
+		{"-explain_discovery", "-split_type=vertical", "-tables=t1"},
+	} {
+		args = append(args, "-keyspace="+testKeyspace, "-vtworkers="+vtworkersParameter, "-min_healthy_rdonly_tablets=2")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-explain_discovery"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	explanation := hw.checkpoint.Settings["discovery_explanation"]
+	for _, want := range []string{
+		"overlap 0:",
+		"0 serves [MASTER REPLICA RDONLY]",
+		"-80 serves []",
+		"80- serves []",
+	} {
+		if !strings.Contains(explanation, want) {
+			t.Errorf("explanation does not contain %q:\n%v", want, explanation)
+		}
+	}
+	// The order of the sides is not deterministic. Either way, shard 0 must
+	// have been chosen as source.
+	if !strings.Contains(explanation, "left side is the source because left shard 0 serves [MASTER REPLICA RDONLY]") &&
+		!strings.Contains(explanation, "right side is the source because left shard -80 serves no types") {
+		t.Errorf("explanation does not contain the decision:\n%v", explanation)
+	}
+	if got, want := hw.checkpoint.Tasks[phaseName+"/0"].Attributes["source_shards"], "0"; got != want {
+		t.Errorf("wrong source shards: got = %v, want = %v", got, want)
+	}
+	if !strings.Contains(hw.rootUINode.Message, "Shard discovery:\n"+explanation) {
+		t.Errorf("root node message does not contain the explanation:\n%v", hw.rootUINode.Message)
+	}
+}
+
+func TestSkipMigratedOverlaps(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	// The REPLICA and RDONLY types of -80 were already migrated to -40 and
+	// 40-80 by a previous resharding.
+	partitions := []*topodatapb.SrvKeyspace_KeyspacePartition{
+		{
+			ServedType:      topodatapb.TabletType_MASTER,
+			ShardReferences: []*topodatapb.ShardReference{{Name: "-80"}, {Name: "80-"}},
+		},
+	}
+	for _, tabletType := range []topodatapb.TabletType{topodatapb.TabletType_REPLICA, topodatapb.TabletType_RDONLY} {
+		partitions = append(partitions, &topodatapb.SrvKeyspace_KeyspacePartition{
+			ServedType:      tabletType,
+			ShardReferences: []*topodatapb.ShardReference{{Name: "-40"}, {Name: "40-80"}, {Name: "80-"}},
+		})
+	}
+	if err := ts.UpdateSrvKeyspace(ctx, "cell", testKeyspace, &topodatapb.SrvKeyspace{Partitions: partitions}); err != nil {
+		t.Fatalf("UpdateSrvKeyspace: %v", err)
+	}
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if len(hw.checkpoint.Tasks) != 1 {
+		t.Fatalf("the already migrated overlap must be skipped: got = %v", hw.checkpoint.Tasks)
+	}
+	task := hw.checkpoint.Tasks[phaseName+"/0"]
+	if got, want := task.Attributes["source_shards"], "80-"; got != want {
+		t.Errorf("wrong source shards: got = %v, want = %v", got, want)
+	}
+	if got, want := task.Attributes["destination_shards"], "80-c0,c0-"; got != want {
+		t.Errorf("wrong destination shards: got = %v, want = %v", got, want)
+	}
+
+	// -validate_only skips the migrated overlap as well and runs the other
+	// checks of Init().
+	p := &preflightParams{
+		ts:                      ts,
+		discoverer:              overlappingShardsDiscoverer{},
+		keyspace:                testKeyspace,
+		vtworkers:               []string{testVtworkers, testVtworkers},
+		minHealthyRdonlyTablets: "2",
+		splitCmd:                splitCmdSplitClone,
+		cells:                   []string{"unknown_cell"},
+		maxOverlaps:             defaultMaxOverlaps,
+	}
+	report, _ := preflightReport(runPreflightChecks(ctx, p))
+	if len(p.shardsToSplit) != 1 {
+		t.Fatalf("the already migrated overlap must be skipped by the pre-flight checks: got = %v", p.shardsToSplit)
+	}
+	for _, want := range []string{
+		"PASS VtworkerCount\n",
+		"PASS DestinationShardsNotServing\n",
+		"PASS SplitCmd\n",
+		"PASS DestinationCoverage\n",
+		"FAIL CellsExist: cell unknown_cell not found",
+		"PASS MaxOverlaps\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("report does not contain: %v report:\n%v", want, report)
+		}
+	}
+}
+
+func TestMaxOverlaps(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2"}
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-max_overlaps=-1")); !IsErrType(err, ErrInvalidArguments) {
+		t.Errorf("Create() with a negative -max_overlaps should have failed with ErrInvalidArguments: %v", err)
+	}
+
+	// The keyspace has two overlaps. The guard must fire for a limit of one.
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-max_overlaps=1")); !IsErrType(err, ErrTooManyOverlaps) {
+		t.Fatalf("Create() should have failed with ErrTooManyOverlaps: %v", err)
+	}
+
+	// -force overrides the guard.
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-max_overlaps=1", "-force"))
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow with -force: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	if got, want := len(w.(*reshardingWorkflowGen).checkpoint.Tasks), 2; got != want {
+		t.Fatalf("wrong number of tasks: got = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+)
+
+// ErrorCode is the error code for keyspace resharding errors.
+type ErrorCode int
+
+// The following is the list of error codes.
+const (
+	// ErrInvalidArguments is returned for missing or invalid flags.
+	ErrInvalidArguments = ErrorCode(iota)
+	// ErrNoOverlappingShards is returned if no source or destination shards
+	// were found in the keyspace.
+	ErrNoOverlappingShards
+	// ErrVtworkerCountMismatch is returned if the number of vtworkers does not
+	// match the number of destination shards.
+	ErrVtworkerCountMismatch
+	// ErrNotEnoughRdonlyTablets is returned if there are fewer (healthy)
+	// rdonly tablets than required.
+	ErrNotEnoughRdonlyTablets
+	// ErrNotServedFrom is returned if a vertical split was requested for a
+	// keyspace which is not served from any other keyspace.
+	ErrNotServedFrom
+	// ErrDestinationShardServing is returned if a destination shard is
+	// already serving.
+	ErrDestinationShardServing
+	// ErrVtworkerUnreachable is returned if a vtworker did not respond.
+	ErrVtworkerUnreachable
+	// ErrValidationFailed is returned if at least one pre-flight check failed.
+	ErrValidationFailed
+	// ErrTopo is returned if reading from the topology failed.
+	// The original error is available with Cause().
+	ErrTopo
+	// ErrEstimationFailed is returned if the data volume of a source shard
+	// could not be estimated.
+	ErrEstimationFailed
+	// ErrInvalidCheckpoint is returned if a stored checkpoint is inconsistent
+	// e.g. because it was corrupted.
+	ErrInvalidCheckpoint
+	// ErrChildWorkflowFailed is returned if -track_children is set and at
+	// least one child workflow failed.
+	ErrChildWorkflowFailed
+	// ErrPostHookFailed is returned if the -post_hook did not succeed and
+	// -post_hook_fatal is set.
+	ErrPostHookFailed
+	// ErrKeyspaceNotFound is returned if the keyspace does not exist.
+	ErrKeyspaceNotFound
+	// ErrKeyRangeNotCovered is returned if the destination shards of a
+	// horizontal resharding have gaps or overlaps within the key range of
+	// their source shards.
+	ErrKeyRangeNotCovered
+	// ErrTooManyOverlaps is returned if more pairs of source and destination
+	// shards were found than -max_overlaps allows and -force is not set.
+	ErrTooManyOverlaps
+	// ErrTaskTimedOut is returned if creating or starting the child workflow
+	// of a task took longer than -per_task_timeout.
+	ErrTaskTimedOut
+	// ErrInvalidPlan is returned if the -plan_out file could not be written
+	// or the -plan_in file could not be read or is inconsistent.
+	ErrInvalidPlan
+	// ErrAborted is returned if the workflow was aborted with the "Abort"
+	// action of the root UI node.
+	ErrAborted
+	// ErrNotEnoughReplicaTablets is returned if a destination shard has fewer
+	// replica tablets than -min_destination_replicas.
+	ErrNotEnoughReplicaTablets
+	// ErrDependencyCycle is returned if the -dependencies between the tasks
+	// have a cycle i.e. no order of the tasks satisfies all of them.
+	ErrDependencyCycle
+	// ErrKeyspaceNotSharded is returned for a horizontal resharding of a
+	// keyspace which is not configured for sharding i.e. it has neither a
+	// sharding column nor a sharded VSchema. The -split_cmd cannot compute
+	// the keyspace IDs of such a keyspace.
+	ErrKeyspaceNotSharded
+)
+
+// Error represents a keyspace resharding error.
+type Error struct {
+	code    ErrorCode
+	message string
+	cause   error
+}
+
+// newError creates a new keyspace resharding error.
+func newError(code ErrorCode, format string, args ...interface{}) error {
+	return &Error{
+		code:    code,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+// wrapError wraps "err" and keeps its message as is.
+func wrapError(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{
+		code:    code,
+		message: err.Error(),
+		cause:   err,
+	}
+}
+
+// Error satisfies error.
+func (e *Error) Error() string {
+	return e.message
+}
+
+// Cause returns the wrapped error, if any.
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+// IsErrType returns true if the error has the specified ErrorCode.
+func IsErrType(err error, code ErrorCode) bool {
+	if e, ok := err.(*Error); ok {
+		return e.code == code
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+	"vitess.io/vitess/go/vt/vttablet/tmclient"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the estimation of the data volume which is run by
+// -estimate.
+
+// estimateTasks estimates how much data each task has to copy and stores the
+// result in the task attributes "estimated_bytes" and "estimated_rows".
+// The estimate is the sum of the table sizes of all source shards of the
+// task, as reported by one tablet of each source shard.
+// If "tables" is not empty, only these tables are taken into account.
+func estimateTasks(ctx context.Context, ts *topo.Server, sourceKeyspace string, tables []string, checkpoint *workflowpb.WorkflowCheckpoint) error {
+	tmc := tmclient.NewTabletManagerClient()
+	defer tmc.Close()
+
+	for _, task := range checkpoint.Tasks {
+		bytes, rows, err := estimateShards(ctx, tmc, ts, sourceKeyspace, strings.Split(task.Attributes["source_shards"], ","), tables)
+		if err != nil {
+			return err
+		}
+		task.Attributes["estimated_bytes"] = strconv.FormatUint(bytes, 10)
+		task.Attributes["estimated_rows"] = strconv.FormatUint(rows, 10)
+	}
+	return nil
+}
+
+// estimateShards returns the sum of the table sizes of the shards.
+func estimateShards(ctx context.Context, tmc tmclient.TabletManagerClient, ts *topo.Server, keyspace string, shards, tables []string) (bytes, rows uint64, err error) {
+	for _, shard := range shards {
+		tablet, err := estimationTablet(ctx, ts, keyspace, shard)
+		if err != nil {
+			return 0, 0, err
+		}
+		sd, err := tmc.GetSchema(ctx, tablet, tables, nil /* excludeTables */, false /* includeViews */)
+		if err != nil {
+			return 0, 0, &Error{
+				code:    ErrEstimationFailed,
+				message: fmt.Sprintf("failed to get the schema of tablet %v: %v", topoproto.TabletAliasString(tablet.Alias), err),
+				cause:   err,
+			}
+		}
+		for _, td := range sd.TableDefinitions {
+			bytes += td.DataLength
+			rows += td.RowCount
+		}
+	}
+	return bytes, rows, nil
+}
+
+// filterSmallOverlaps removes the pairs of source and destination shards
+// whose source shards have less than "minBytes" of data
+// (-min_overlap_bytes). The removed pairs are logged. They can be resharded
+// later by another workflow.
+func filterSmallOverlaps(ctx context.Context, ts *topo.Server, keyspace string, shardsToSplit [][][]string, minBytes uint64) ([][][]string, error) {
+	tmc := tmclient.NewTabletManagerClient()
+	defer tmc.Close()
+
+	var result [][][]string
+	for _, shardToSplit := range shardsToSplit {
+		bytes, _, err := estimateShards(ctx, tmc, ts, keyspace, shardToSplit[0], nil /* tables */)
+		if err != nil {
+			return nil, err
+		}
+		if bytes < minBytes {
+			log.Infof("Keyspace resharding of keyspace %v: excluding source shards %v (destination shards: %v) because their estimated size of %v bytes is below -min_overlap_bytes=%v", keyspace, strings.Join(shardToSplit[0], ","), strings.Join(shardToSplit[1], ","), bytes, minBytes)
+			continue
+		}
+		result = append(result, shardToSplit)
+	}
+	return result, nil
+}
+
+// estimationTablet returns the tablet which is asked for the size of the
+// shard. RDONLY tablets are preferred to avoid load on the MASTER.
+func estimationTablet(ctx context.Context, ts *topo.Server, keyspace, shard string) (*topodatapb.Tablet, error) {
+	tablets, err := ts.GetTabletMapForShard(ctx, keyspace, shard)
+	if err != nil {
+		return nil, wrapError(ErrTopo, err)
+	}
+	if len(tablets) == 0 {
+		return nil, newError(ErrEstimationFailed, "source shard %v has no tablets", topoproto.KeyspaceShardString(keyspace, shard))
+	}
+
+	// Sort the tablets to make the choice deterministic.
+	aliases := make([]string, 0, len(tablets))
+	for alias := range tablets {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if tablets[alias].Type == topodatapb.TabletType_RDONLY {
+			return tablets[alias].Tablet, nil
+		}
+	}
+	return tablets[aliases[0]].Tablet, nil
+}
+
+// estimateMessage returns the estimate of a task for the UI. It's empty if
+// no estimate was made.
+func estimateMessage(task *workflowpb.Task) string {
+	bytes, ok := task.Attributes["estimated_bytes"]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("Estimated data to copy: %v bytes, %v rows.", bytes, task.Attributes["estimated_rows"])
+}
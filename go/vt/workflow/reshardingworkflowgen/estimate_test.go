@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo/topoproto"
+	"vitess.io/vitess/go/vt/vttablet/tmclient"
+	"vitess.io/vitess/go/vt/workflow"
+
+	tabletmanagerdatapb "vitess.io/vitess/go/vt/proto/tabletmanagerdata"
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+const estimateTestProtocol = "estimate_test"
+
+func init() {
+	tmclient.RegisterTabletManagerClientFactory(estimateTestProtocol, func() tmclient.TabletManagerClient {
+		return &estimateFakeTMC{}
+	})
+}
+
+// estimateFakeTMC returns a fixed schema with two tables.
+// All other methods are not implemented and will panic.
+type estimateFakeTMC struct {
+	tmclient.TabletManagerClient
+}
+
+// GetSchema is part of the tmclient.TabletManagerClient interface.
+func (*estimateFakeTMC) GetSchema(ctx context.Context, tablet *topodatapb.Tablet, tables, excludeTables []string, includeViews bool) (*tabletmanagerdatapb.SchemaDefinition, error) {
+	if tablet.Type != topodatapb.TabletType_RDONLY {
+		return nil, fmt.Errorf("estimation must use an RDONLY tablet and not: %v", topoproto.TabletAliasString(tablet.Alias))
+	}
+	return &tabletmanagerdatapb.SchemaDefinition{
+		TableDefinitions: []*tabletmanagerdatapb.TableDefinition{
+			{Name: "table1", DataLength: 1000, RowCount: 10},
+			{Name: "table2", DataLength: 2000, RowCount: 20},
+		},
+	}, nil
+}
+
+// Close is part of the tmclient.TabletManagerClient interface.
+func (*estimateFakeTMC) Close() {}
+
+func TestEstimate(t *testing.T) {
+	protocol := *tmclient.TabletManagerProtocol
+	flag.Set("tablet_manager_protocol", estimateTestProtocol)
+	defer flag.Set("tablet_manager_protocol", protocol)
+
+	ctx := context.Background()
+	ts := setupServingTopology(ctx, t, testKeyspace, 2 /* rdonlyTablets */)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-estimate"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	task := checkpoint.Tasks[phaseName+"/0"]
+	if got, want := task.Attributes["estimated_bytes"], "3000"; got != want {
+		t.Fatalf("wrong estimated bytes: got = %v, want = %v", got, want)
+	}
+	if got, want := task.Attributes["estimated_rows"], "30"; got != want {
+		t.Fatalf("wrong estimated rows: got = %v, want = %v", got, want)
+	}
+
+	rootNode := workflow.NewNode()
+	if _, err := (&Factory{}).Instantiate(m, wi.Workflow, rootNode); err != nil {
+		t.Fatalf("cannot instantiate workflow: %v", err)
+	}
+	taskNode, err := rootNode.GetChildByPath(phaseName + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := taskNode.Message, "Estimated data to copy: 3000 bytes, 30 rows."; got != want {
+		t.Fatalf("wrong task node message: got = %v, want = %v", got, want)
+	}
+}
+
+const minOverlapBytesTestProtocol = "min_overlap_bytes_test"
+
+func init() {
+	tmclient.RegisterTabletManagerClientFactory(minOverlapBytesTestProtocol, func() tmclient.TabletManagerClient {
+		return &shardSizeFakeTMC{
+			bytes: map[string]uint64{
+				"-80": 100,
+				"80-": 5000,
+			},
+		}
+	})
+}
+
+// shardSizeFakeTMC returns a schema with one table whose size depends on
+// the shard of the tablet.
+// All other methods are not implemented and will panic.
+type shardSizeFakeTMC struct {
+	tmclient.TabletManagerClient
+	bytes map[string]uint64
+}
+
+// GetSchema is part of the tmclient.TabletManagerClient interface.
+func (f *shardSizeFakeTMC) GetSchema(ctx context.Context, tablet *topodatapb.Tablet, tables, excludeTables []string, includeViews bool) (*tabletmanagerdatapb.SchemaDefinition, error) {
+	return &tabletmanagerdatapb.SchemaDefinition{
+		TableDefinitions: []*tabletmanagerdatapb.TableDefinition{
+			{Name: "table1", DataLength: f.bytes[tablet.Shard], RowCount: 1},
+		},
+	}, nil
+}
+
+// Close is part of the tmclient.TabletManagerClient interface.
+func (*shardSizeFakeTMC) Close() {}
+
+func TestMinOverlapBytes(t *testing.T) {
+	protocol := *tmclient.TabletManagerProtocol
+	flag.Set("tablet_manager_protocol", minOverlapBytesTestProtocol)
+	defer flag.Set("tablet_manager_protocol", protocol)
+
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	for i, shard := range []string{"-80", "80-"} {
+		if err := ts.CreateTablet(ctx, &topodatapb.Tablet{
+			Alias:    &topodatapb.TabletAlias{Cell: "cell", Uid: uint32(100 + i)},
+			Keyspace: testKeyspace,
+			Shard:    shard,
+			Type:     topodatapb.TabletType_RDONLY,
+		}); err != nil {
+			t.Fatalf("CreateTablet: %v", err)
+		}
+	}
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-min_overlap_bytes=-1"}); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("negative -min_overlap_bytes should have failed with ErrInvalidArguments: %v", err)
+	}
+
+	// Only the source shard 80- has more than 1000 bytes.
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-min_overlap_bytes=1000"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if len(hw.checkpoint.Tasks) != 1 {
+		t.Fatalf("only the large overlap must become a task: got = %v", hw.checkpoint.Tasks)
+	}
+	task := hw.checkpoint.Tasks[phaseName+"/0"]
+	if got, want := task.Attributes["source_shards"], "80-"; got != want {
+		t.Errorf("wrong source shards: got = %v, want = %v", got, want)
+	}
+	if got, want := task.Attributes["destination_shards"], "80-c0,c0-"; got != want {
+		t.Errorf("wrong destination shards: got = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// This file contains the estimated completion time (ETA) which is shown
+// while the child workflows are tracked (-track_children).
+
+// taskWeight returns how much work a task represents. It's the estimated
+// data volume of the task if -estimate was set. Otherwise, all tasks have
+// the same weight.
+func taskWeight(attributes map[string]string) float64 {
+	if bytes, err := strconv.ParseUint(attributes["estimated_bytes"], 10, 64); err == nil && bytes > 0 {
+		return float64(bytes)
+	}
+	return 1
+}
+
+// estimateRemaining extrapolates the time which is needed for the remaining
+// work from the time it took to complete the done work so far. It returns
+// false if no work is done yet.
+func estimateRemaining(elapsed time.Duration, doneWeight, totalWeight float64) (time.Duration, bool) {
+	if doneWeight <= 0 || totalWeight <= 0 {
+		return 0, false
+	}
+	if doneWeight >= totalWeight {
+		return 0, true
+	}
+	return time.Duration(float64(elapsed) * (totalWeight - doneWeight) / doneWeight), true
+}
+
+// etaMessage returns the progress and the ETA of all child workflows.
+// "states" has the last seen child state of each task (see
+// updateChildStates()) and "start" is when the tracking started.
+func (hw *reshardingWorkflowGen) etaMessage(start time.Time, states map[string]string) string {
+	var doneWeight, totalWeight float64
+	hw.mu.Lock()
+	for i := 0; i < hw.workflowsCount; i++ {
+		taskID := fmt.Sprintf("%s/%v", phaseName, i)
+		weight := taskWeight(hw.checkpoint.Tasks[taskID].Attributes)
+		totalWeight += weight
+		switch states[taskID] {
+		case childStateSucceeded, childStateFailed:
+			doneWeight += weight
+		}
+	}
+	hw.mu.Unlock()
+
+	now := hw.now()
+	remaining, ok := estimateRemaining(now.Sub(start), doneWeight, totalWeight)
+	if !ok {
+		return "Estimated completion: unknown until the first child workflow finished."
+	}
+	remaining = remaining.Round(time.Second)
+	return fmt.Sprintf("Progress: %.0f%%. Estimated completion in %v (at %v).", doneWeight/totalWeight*100, remaining, now.Add(remaining).Format(time.RFC3339))
+}
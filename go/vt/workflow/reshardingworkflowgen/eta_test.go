@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+func TestEstimateRemaining(t *testing.T) {
+	testCases := []struct {
+		elapsed     time.Duration
+		doneWeight  float64
+		totalWeight float64
+		want        time.Duration
+		wantOK      bool
+	}{
+		{10 * time.Minute, 0, 4, 0, false},
+		{10 * time.Minute, 1, 4, 30 * time.Minute, true},
+		{10 * time.Minute, 3, 4, 10 * time.Minute / 3, true},
+		{10 * time.Minute, 4, 4, 0, true},
+	}
+	for _, tc := range testCases {
+		got, ok := estimateRemaining(tc.elapsed, tc.doneWeight, tc.totalWeight)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("estimateRemaining(%v, %v, %v) = (%v, %v), want = (%v, %v)", tc.elapsed, tc.doneWeight, tc.totalWeight, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestETAMessage(t *testing.T) {
+	// Four tasks. The first one has twice the estimated data volume of the
+	// others.
+	tasks := make(map[string]*workflowpb.Task)
+	for i, bytes := range []string{"200", "100", "100", "100"} {
+		taskID := fmt.Sprintf("%s/%v", phaseName, i)
+		tasks[taskID] = &workflowpb.Task{
+			Id:         taskID,
+			Attributes: map[string]string{"estimated_bytes": bytes},
+		}
+	}
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	hw := &reshardingWorkflowGen{
+		checkpoint:     &workflowpb.WorkflowCheckpoint{Tasks: tasks},
+		workflowsCount: len(tasks),
+		now:            func() time.Time { return now },
+	}
+	states := make(map[string]string)
+
+	if got := hw.etaMessage(start, states); !strings.Contains(got, "unknown") {
+		t.Fatalf("ETA must be unknown before the first child workflow finished: %v", got)
+	}
+
+	// The synthetic progress has a constant rate: 100 bytes per 10 minutes.
+	// The remaining time must decrease with each finished task while the
+	// estimated completion stays the same.
+	end := start.Add(50 * time.Minute).Format(time.RFC3339)
+	for _, step := range []struct {
+		elapsed   time.Duration
+		finished  int
+		remaining time.Duration
+		progress  string
+	}{
+		{10 * time.Minute, 1, 40 * time.Minute, "20%"},
+		{20 * time.Minute, 2, 30 * time.Minute, "40%"},
+		{30 * time.Minute, 3, 20 * time.Minute, "60%"},
+		{50 * time.Minute, 0, 0, "100%"},
+	} {
+		now = start.Add(step.elapsed)
+		// Tasks finish in the reverse order. The first task is the largest one.
+		states[fmt.Sprintf("%s/%v", phaseName, step.finished)] = childStateSucceeded
+		got := hw.etaMessage(start, states)
+		want := fmt.Sprintf("Progress: %v. Estimated completion in %v (at %v).", step.progress, step.remaining, end)
+		if got != want {
+			t.Fatalf("wrong ETA after %v: got = %v, want = %v", step.elapsed, got, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+
+	"vitess.io/vitess/go/stats"
+)
+
+// fanoutDistribution counts the tasks of all keyspace resharding workflows by
+// their fan-out e.g. "1:2" for a split of one source shard into two
+// destination shards or "2:1" for a merge of two source shards. Unusual
+// reshards stand out as rare fan-outs.
+var fanoutDistribution = stats.NewCountersWithSingleLabel(
+	"KeyspaceReshardFanoutDistribution",
+	"Number of keyspace resharding tasks by fan-out (source shards:destination shards)",
+	"FanOut")
+
+// fanout returns the label of a task with the given source and destination
+// shards in "fanoutDistribution".
+func fanout(sourceShards, destinationShards []string) string {
+	return fmt.Sprintf("%v:%v", len(sourceShards), len(destinationShards))
+}
+
+// recordFanout adds the fan-out of each pair of source and destination shards
+// to "fanoutDistribution".
+func recordFanout(shardsToSplit [][][]string) {
+	for _, shardToSplit := range shardsToSplit {
+		fanoutDistribution.Add(fanout(shardToSplit[0], shardToSplit[1]), 1)
+	}
+}
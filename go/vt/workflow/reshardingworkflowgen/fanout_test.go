@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFanoutDistribution(t *testing.T) {
+	fanoutDistribution.ResetAll()
+
+	shardsToSplit := [][][]string{
+		{{"-40"}, {"-20", "20-40"}},
+		{{"40-80"}, {"40-50", "50-60", "60-70", "70-80"}},
+		{{"80-c0", "c0-"}, {"80-"}},
+		{{"0"}, {"-80", "80-"}},
+	}
+	var vtworkers []string
+	for i := 0; i < 9; i++ {
+		vtworkers = append(vtworkers, "vtworker")
+	}
+	if _, err := initCheckpoint(testKeyspace, vtworkers, shardsToSplit, "4", "SplitClone", "RDONLY", "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{
+		"1:2": 2,
+		"1:4": 1,
+		"2:1": 1,
+	}
+	if got := fanoutDistribution.Counts(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong fan-out distribution: got = %v, want = %v", got, want)
+	}
+
+	// A checkpoint which cannot be created is not recorded.
+	if _, err := initCheckpoint(testKeyspace, vtworkers[:1], shardsToSplit, "4", "SplitClone", "RDONLY", "", false); err == nil {
+		t.Fatal("initCheckpoint should have failed because of too few vtworkers")
+	}
+	if got := fanoutDistribution.Counts(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("failed initCheckpoint must not change the fan-out distribution: got = %v, want = %v", got, want)
+	}
+}
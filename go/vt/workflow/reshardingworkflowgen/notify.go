@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/log"
+)
+
+// This file contains the notifications which are sent to -notify_webhook.
+
+const (
+	notifyStateStarted   = "started"
+	notifyStateCompleted = "completed"
+	notifyStateFailed    = "failed"
+
+	// notifyTimeout bounds how long we wait for the webhook.
+	notifyTimeout = 10 * time.Second
+)
+
+// notifyEvent is the JSON payload which is POSTed to the webhook.
+type notifyEvent struct {
+	Keyspace string `json:"keyspace"`
+	UUID     string `json:"uuid"`
+	State    string `json:"state"`
+	// Error is only set for the state "failed".
+	Error      string    `json:"error,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+	Time       time.Time `json:"time"`
+	ChildUUIDs []string  `json:"child_uuids"`
+}
+
+// notify sends a notification for the lifecycle transition to "state" if
+// -notify_webhook was set. Failures are logged but do not fail the workflow.
+func (hw *reshardingWorkflowGen) notify(ctx context.Context, state string, err error) {
+	if hw.notifyWebhookParam == "" {
+		return
+	}
+
+	hw.mu.Lock()
+	childUUIDs := append([]string{}, hw.childUUIDs...)
+	hw.mu.Unlock()
+	sort.Strings(childUUIDs)
+
+	event := &notifyEvent{
+		Keyspace:   hw.keyspaceParam,
+		UUID:       hw.wi.Uuid,
+		State:      state,
+		StartTime:  hw.startTime,
+		Time:       time.Now(),
+		ChildUUIDs: childUUIDs,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if err := postNotification(ctx, hw.notifyWebhookParam, event); err != nil {
+		log.Warningf("Keyspace resharding: failed to send notification for state %v of workflow %v to %v: %v", state, hw.wi.Uuid, hw.notifyWebhookParam, err)
+	}
+}
+
+func postNotification(ctx context.Context, url string, event *notifyEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected HTTP status: %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/workflow"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// fakeWebhook records all received notifications.
+type fakeWebhook struct {
+	mu     sync.Mutex
+	events []*notifyEvent
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := &notifyEvent{}
+	if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.events = append(f.events, event)
+	f.mu.Unlock()
+}
+
+func (f *fakeWebhook) states() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var states []string
+	for _, e := range f.events {
+		states = append(states, e.State)
+	}
+	return states
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	webhook := &fakeWebhook{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-notify_webhook=" + server.URL})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	defer m.Stop(ctx, uuid)
+
+	if got, want := webhook.states(), []string{notifyStateStarted, notifyStateCompleted}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("wrong notifications: got = %v, want = %v", got, want)
+	}
+	started, completed := webhook.events[0], webhook.events[1]
+	if started.Keyspace != testKeyspace || started.UUID != uuid {
+		t.Fatalf("wrong keyspace or UUID in notification: %+v", started)
+	}
+	if len(started.ChildUUIDs) != 0 {
+		t.Fatalf("no child workflows should have been created at the start: %+v", started)
+	}
+	if len(completed.ChildUUIDs) != 1 {
+		t.Fatalf("one child workflow should have been created: %+v", completed)
+	}
+	if !completed.StartTime.Equal(started.StartTime) {
+		t.Fatalf("start time must be the same in all notifications: %v != %v", completed.StartTime, started.StartTime)
+	}
+}
+
+func TestNotifyFailure(t *testing.T) {
+	webhook := &fakeWebhook{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	hw := &reshardingWorkflowGen{
+		checkpoint:         &workflowpb.WorkflowCheckpoint{},
+		wi:                 &topo.WorkflowInfo{Workflow: &workflowpb.Workflow{Uuid: "uuid"}},
+		keyspaceParam:      testKeyspace,
+		notifyWebhookParam: server.URL,
+	}
+	hw.notify(context.Background(), notifyStateFailed, errors.New("creation failed"))
+	if got := webhook.states(); len(got) != 1 || got[0] != notifyStateFailed {
+		t.Fatalf("wrong notifications: got = %v, want = [%v]", got, notifyStateFailed)
+	}
+	if got, want := webhook.events[0].Error, "creation failed"; got != want {
+		t.Fatalf("wrong error in notification: got = %v, want = %v", got, want)
+	}
+
+	// An unreachable webhook is not fatal.
+	hw.notifyWebhookParam = "http://localhost:0/unreachable"
+	hw.notify(context.Background(), notifyStateFailed, errors.New("creation failed"))
+	if err := postNotification(context.Background(), hw.notifyWebhookParam, &notifyEvent{}); err == nil {
+		t.Fatalf("postNotification to an unreachable webhook should have failed")
+	}
+}
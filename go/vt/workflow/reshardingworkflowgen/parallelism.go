@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"strconv"
+	"strings"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the split parallelism which is set by
+// -default_split_parallelism and -split_parallelism.
+
+// parseSplitParallelism parses the -split_parallelism list of "shard=N"
+// overrides.
+func parseSplitParallelism(list string) (map[string]int, error) {
+	overrides := make(map[string]int)
+	if list == "" {
+		return overrides, nil
+	}
+	for _, override := range strings.Split(list, ",") {
+		parts := strings.Split(override, "=")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, newError(ErrInvalidArguments, "invalid split_parallelism override: %v (must be shard=N)", override)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, newError(ErrInvalidArguments, "invalid split_parallelism of shard %v: %v (must be >= 1)", parts[0], parts[1])
+		}
+		if _, ok := overrides[parts[0]]; ok {
+			return nil, newError(ErrInvalidArguments, "duplicate split_parallelism override for shard %v", parts[0])
+		}
+		overrides[parts[0]] = n
+	}
+	return overrides, nil
+}
+
+// setSplitParallelism stores the parallelism of each task in the task
+// attribute "split_parallelism". An override applies to the task which has
+// the shard as source or destination shard. If several overrides apply to a
+// task, the lowest one wins. Tasks without an override get
+// "defaultParallelism". If it is 0, the attribute is not set and the child
+// workflow uses the vtworker default.
+func setSplitParallelism(checkpoint *workflowpb.WorkflowCheckpoint, defaultParallelism int, overrides map[string]int) error {
+	used := make(map[string]bool)
+	for _, task := range checkpoint.Tasks {
+		parallelism := 0
+		shards := append(strings.Split(task.Attributes["source_shards"], ","), strings.Split(task.Attributes["destination_shards"], ",")...)
+		for _, shard := range shards {
+			if n, ok := overrides[shard]; ok {
+				used[shard] = true
+				if parallelism == 0 || n < parallelism {
+					parallelism = n
+				}
+			}
+		}
+		if parallelism == 0 {
+			parallelism = defaultParallelism
+		}
+		if parallelism > 0 {
+			task.Attributes["split_parallelism"] = strconv.Itoa(parallelism)
+		}
+	}
+	for shard := range overrides {
+		if !used[shard] {
+			return newError(ErrInvalidArguments, "split_parallelism override for shard %v does not match any task", shard)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/workflow"
+)
+
+func TestSplitParallelism(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		// want maps the source shards of each task to the expected
+		// -split_parallelism parameter of its child workflow.
+		want map[string]string
+	}{
+		{
+			name: "no parallelism",
+			want: map[string]string{"-80": "", "80-": ""},
+		},
+		{
+			name: "default only",
+			args: []string{"-default_split_parallelism=4"},
+			want: map[string]string{"-80": "-split_parallelism=4", "80-": "-split_parallelism=4"},
+		},
+		{
+			name: "override of a destination shard",
+			args: []string{"-default_split_parallelism=4", "-split_parallelism=c0-=2"},
+			want: map[string]string{"-80": "-split_parallelism=4", "80-": "-split_parallelism=2"},
+		},
+		{
+			name: "lowest override wins",
+			args: []string{"-split_parallelism=-80=8,-40=3,40-80=5"},
+			want: map[string]string{"-80": "-split_parallelism=3", "80-": ""},
+		},
+	}
+	for _, tc := range testCases {
+		ctx := context.Background()
+		ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+		m := workflow.NewManager(ts)
+
+		vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+		args := append([]string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2"}, tc.args...)
+		uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, args)
+		if err != nil {
+			t.Fatalf("%v: cannot create resharding workflow: %v", tc.name, err)
+		}
+		w, err := m.WorkflowForTesting(uuid)
+		if err != nil {
+			t.Fatalf("%v: fail to get workflow from manager: %v", tc.name, err)
+		}
+		hw := w.(*reshardingWorkflowGen)
+		if len(hw.checkpoint.Tasks) != len(tc.want) {
+			t.Fatalf("%v: wrong number of tasks: got = %v, want = %v", tc.name, len(hw.checkpoint.Tasks), len(tc.want))
+		}
+		for _, task := range hw.checkpoint.Tasks {
+			_, params := hw.childWorkflowParams(task)
+			got := ""
+			for _, param := range params {
+				if strings.HasPrefix(param, "-split_parallelism=") {
+					got = param
+				}
+			}
+			if want := tc.want[task.Attributes["source_shards"]]; got != want {
+				t.Errorf("%v: wrong parallelism of the child workflow for source shards %v: got = %q, want = %q", tc.name, task.Attributes["source_shards"], got, want)
+			}
+		}
+	}
+}
+
+func TestSplitParallelismInvalidArguments(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+	for _, args := range [][]string{
+		{"-default_split_parallelism=-1"},
+		{"-split_parallelism=-80"},
+		{"-split_parallelism=-80=0"},
+		{"-split_parallelism=-80=x"},
+		{"-split_parallelism=-80=2,-80=3"},
+		// The shard is not part of any task.
+		{"-split_parallelism=c0-=2"},
+		{"-split_type=vertical", "-tables=t1", "-default_split_parallelism=2"},
+	} {
+		args = append(args, "-keyspace="+testKeyspace, "-vtworkers="+testVtworkers+","+testVtworkers, "-min_healthy_rdonly_tablets=2")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/json2"
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/topo"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the reading and writing of plans (-plan_out and
+// -plan_in). A plan is the checkpoint of the workflow in JSON. It has the
+// tasks and the settings. Operators can review it before they create the
+// workflow from it.
+// The workflow is created through the vtctld API. Therefore, -plan_out and
+// -plan_in only accept file names in -keyspace_resharding_plan_dir and not
+// arbitrary paths.
+
+var planDir = flag.String("keyspace_resharding_plan_dir", "", "Directory of the plans of the keyspace resharding workflow. Its -plan_out and -plan_in parameters are file names in this directory. If empty, plans are disabled")
+
+// planPath returns the path of the plan "name" in -keyspace_resharding_plan_dir.
+func planPath(name string) (string, error) {
+	if *planDir == "" {
+		return "", newError(ErrInvalidArguments, "plan_out and plan_in require that -keyspace_resharding_plan_dir is set")
+	}
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return "", newError(ErrInvalidArguments, "invalid plan: %v (must be a file name in -keyspace_resharding_plan_dir and not a path)", name)
+	}
+	return path.Join(*planDir, name), nil
+}
+
+// writePlanOut writes the checkpoint without the -plan_out setting as plan
+// "name".
+func writePlanOut(name string, checkpoint *workflowpb.WorkflowCheckpoint) error {
+	planFile, err := planPath(name)
+	if err != nil {
+		return err
+	}
+	plan := proto.Clone(checkpoint).(*workflowpb.WorkflowCheckpoint)
+	delete(plan.Settings, "plan_out")
+	return writePlan(planFile, plan)
+}
+
+// writePlan writes the checkpoint as plan to "path".
+func writePlan(path string, checkpoint *workflowpb.WorkflowCheckpoint) error {
+	data, err := json2.MarshalIndentPB(checkpoint, "  ")
+	if err != nil {
+		return wrapError(ErrInvalidPlan, err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return wrapError(ErrInvalidPlan, err)
+	}
+	log.Infof("Keyspace resharding plan for keyspace %v with %v tasks written to: %v", checkpoint.Settings["keyspace"], len(checkpoint.Tasks), path)
+	return nil
+}
+
+// readPlan reads the plan at "path" and verifies that the workflow can be
+// instantiated from it.
+func readPlan(path string) (*workflowpb.WorkflowCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, wrapError(ErrInvalidPlan, err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := json2.Unmarshal(data, checkpoint); err != nil {
+		return nil, newError(ErrInvalidPlan, "cannot parse plan %v: %v", path, err)
+	}
+	if checkpoint.CodeVersion != codeVersion {
+		return nil, newError(ErrInvalidPlan, "plan %v has code version %v, but this workflow has code version %v", path, checkpoint.CodeVersion, codeVersion)
+	}
+	if checkpoint.Settings["keyspace"] == "" {
+		return nil, newError(ErrInvalidPlan, "plan %v has no keyspace", path)
+	}
+	workflowsCount, err := strconv.Atoi(checkpoint.Settings["workflows_count"])
+	if err != nil {
+		return nil, newError(ErrInvalidPlan, "plan %v has an invalid workflows_count: %v", path, err)
+	}
+	if err := checkTasks(checkpoint, workflowsCount); err != nil {
+		return nil, newError(ErrInvalidPlan, "plan %v is inconsistent: %v", path, err)
+	}
+	return checkpoint, nil
+}
+
+// checkPlanTopology reruns the checks of Init against the current topology
+// for the tasks of a loaded plan. The topology may have changed since the
+// plan was written e.g. a destination shard may be serving already.
+func checkPlanTopology(ctx context.Context, ts *topo.Server, checkpoint *workflowpb.WorkflowCheckpoint) error {
+	keyspace := checkpoint.Settings["keyspace"]
+	if checkpoint.Settings["split_type"] == splitTypeVertical {
+		return nil
+	}
+	if err := checkSplitCmd(checkpoint.Settings["split_cmd"]); err != nil {
+		return err
+	}
+	if err := checkDestinationCoverage(ctx, ts, keyspace); err != nil {
+		return err
+	}
+	return checkDestinationShardsNotServing(ctx, &preflightParams{
+		ts:            ts,
+		keyspace:      keyspace,
+		shardsToSplit: planShardsToSplit(checkpoint),
+	})
+}
+
+// planShardsToSplit returns the source and destination shards of the tasks
+// of "checkpoint" in the format of the shard discovery.
+func planShardsToSplit(checkpoint *workflowpb.WorkflowCheckpoint) [][][]string {
+	workflowsCount, _ := strconv.Atoi(checkpoint.Settings["workflows_count"])
+	shardsToSplit := [][][]string{}
+	for i := 0; i < workflowsCount; i++ {
+		task := checkpoint.Tasks[fmt.Sprintf("%s/%v", phaseName, i)]
+		shardsToSplit = append(shardsToSplit, [][]string{
+			strings.Split(task.Attributes["source_shards"], ","),
+			strings.Split(task.Attributes["destination_shards"], ","),
+		})
+	}
+	return shardsToSplit
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/workflow"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	dir, err := ioutil.TempDir("", "plan_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const planName = "plan.json"
+	planFile := path.Join(dir, planName)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2", "-skip_start_workflows=false"}
+	// Plans are disabled without -keyspace_resharding_plan_dir.
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-plan_out="+planName)); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("Create() with -plan_out and without -keyspace_resharding_plan_dir should have failed with ErrInvalidArguments: %v", err)
+	}
+	flag.Set("keyspace_resharding_plan_dir", dir)
+	defer flag.Set("keyspace_resharding_plan_dir", "")
+
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-plan_out="+planName, "-plan_in="+planName)); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("Create() with -plan_in and -plan_out should have failed with ErrInvalidArguments: %v", err)
+	}
+	for _, name := range []string{planFile, "../" + planName, ".."} {
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-plan_out="+name)); !IsErrType(err, ErrInvalidArguments) {
+			t.Fatalf("Create() with -plan_out=%v should have failed with ErrInvalidArguments: %v", name, err)
+		}
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-plan_in=" + name}); !IsErrType(err, ErrInvalidArguments) {
+			t.Fatalf("Create() with -plan_in=%v should have failed with ErrInvalidArguments: %v", name, err)
+		}
+	}
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-plan_in=missing.json"}); !IsErrType(err, ErrInvalidPlan) {
+		t.Fatalf("Create() with a missing -plan_in should have failed with ErrInvalidPlan: %v", err)
+	}
+
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-plan_out="+planName))
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	// Creating the workflow does not write the plan yet.
+	if _, err := os.Stat(planFile); !os.IsNotExist(err) {
+		t.Fatalf("plan must not be written before the workflow runs: %v", err)
+	}
+
+	// Running the workflow writes the plan. It does not create any child
+	// workflows.
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	m.Stop(ctx, uuid)
+	if len(hw.childUUIDs) != 0 {
+		t.Fatalf("no child workflows must be created with -plan_out: got = %v", hw.childUUIDs)
+	}
+
+	// The written plan is the checkpoint without the -plan_out setting.
+	plan, err := readPlan(planFile)
+	if err != nil {
+		t.Fatalf("cannot read plan: %v", err)
+	}
+	want := proto.Clone(hw.checkpoint).(*workflowpb.WorkflowCheckpoint)
+	delete(want.Settings, "plan_out")
+	if !proto.Equal(plan, want) {
+		t.Fatalf("wrong plan:\ngot =\n%v\nwant =\n%v", proto.MarshalTextString(plan), proto.MarshalTextString(want))
+	}
+
+	// The plan can be reloaded. All other flags are taken from the plan.
+	uuid, err = m.Create(ctx, keyspaceReshardingFactoryName, []string{"-plan_in=" + planName})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow from plan: %v", err)
+	}
+	w, err = m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	if got := w.(*reshardingWorkflowGen).checkpoint; !proto.Equal(got, want) {
+		t.Fatalf("wrong checkpoint loaded from plan:\ngot =\n%v\nwant =\n%v", proto.MarshalTextString(got), proto.MarshalTextString(want))
+	}
+
+	// A plan with an unsupported -split_cmd is rejected like the flag.
+	invalid := proto.Clone(want).(*workflowpb.WorkflowCheckpoint)
+	invalid.Settings["split_cmd"] = "SplitDiff"
+	if err := writePlan(path.Join(dir, "invalid.json"), invalid); err != nil {
+		t.Fatalf("cannot write plan: %v", err)
+	}
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-plan_in=invalid.json"}); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("Create() with -plan_in and an invalid split_cmd should have failed with ErrInvalidArguments: %v", err)
+	}
+
+	// The plan is checked against the current topology: A destination shard
+	// which started serving since the plan was written (here: RDONLY was
+	// migrated) fails the creation.
+	srvKeyspace, err := ts.GetSrvKeyspace(ctx, "cell", testKeyspace)
+	if err != nil {
+		t.Fatalf("GetSrvKeyspace: %v", err)
+	}
+	for _, partition := range srvKeyspace.Partitions {
+		if partition.ServedType == topodatapb.TabletType_RDONLY {
+			partition.ShardReferences = []*topodatapb.ShardReference{{Name: "-40"}, {Name: "40-80"}, {Name: "80-"}}
+		}
+	}
+	if err := ts.UpdateSrvKeyspace(ctx, "cell", testKeyspace, srvKeyspace); err != nil {
+		t.Fatalf("UpdateSrvKeyspace: %v", err)
+	}
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-plan_in=" + planName}); !IsErrType(err, ErrDestinationShardServing) {
+		t.Fatalf("Create() with -plan_in and a serving destination shard should have failed with ErrDestinationShardServing: %v", err)
+	}
+}
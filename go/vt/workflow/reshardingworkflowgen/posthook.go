@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"vitess.io/vitess/go/vt/hook"
+)
+
+// This file contains the execution of the -post_hook.
+
+// postHookParams returns the parameters which are passed to the post hook.
+func (hw *reshardingWorkflowGen) postHookParams() []string {
+	hw.mu.Lock()
+	childUUIDs := append([]string{}, hw.childUUIDs...)
+	hw.mu.Unlock()
+	sort.Strings(childUUIDs)
+
+	return []string{
+		"-keyspace=" + hw.keyspaceParam,
+		"-uuid=" + hw.wi.Uuid,
+		"-child_uuids=" + strings.Join(childUUIDs, ","),
+	}
+}
+
+// runPostHook executes the -post_hook, if set, and shows its output on the
+// root UI node. It returns an error if the hook did not succeed. Whether the
+// error fails the workflow is up to the caller (-post_hook_fatal).
+func (hw *reshardingWorkflowGen) runPostHook() error {
+	if hw.postHookParam == "" {
+		return nil
+	}
+
+	h := hook.NewHook(hw.postHookParam, hw.postHookParams())
+	hr := hw.hookRunner(h)
+	hw.setUIMessage(hw.rootUINode, fmt.Sprintf("Post hook %v %v", h.Name, hr.String()))
+	if hr.ExitStatus != hook.HOOK_SUCCESS {
+		return newError(ErrPostHookFailed, "post hook %v failed: %v", h.Name, hr.String())
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/hook"
+	"vitess.io/vitess/go/vt/workflow"
+)
+
+func TestPostHook(t *testing.T) {
+	testCases := []struct {
+		name        string
+		fatal       bool
+		exitStatus  int
+		wantMessage string
+		wantError   string
+	}{
+		{
+			name:        "succeeded",
+			exitStatus:  hook.HOOK_SUCCESS,
+			wantMessage: "Keyspace resharding is finished successfully.",
+		},
+		{
+			name:        "failed with warning",
+			exitStatus:  1,
+			wantMessage: "Keyspace resharding is finished successfully. WARNING: post hook notify_done failed",
+		},
+		{
+			name:        "failed fatal",
+			fatal:       true,
+			exitStatus:  1,
+			wantMessage: "Keyspace resharding failed because of the post hook",
+			wantError:   "post hook notify_done failed",
+		},
+	}
+	for _, tc := range testCases {
+		ctx := context.Background()
+		ts := setupTopology(ctx, t, testKeyspace)
+		m := workflow.NewManager(ts)
+		workflow.StartManager(m)
+
+		vtworkersParameter := testVtworkers + "," + testVtworkers
+		args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-post_hook=notify_done"}
+		if tc.fatal {
+			args = append(args, "-post_hook_fatal")
+		}
+		uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, args)
+		if err != nil {
+			t.Fatalf("%v: cannot create resharding workflow: %v", tc.name, err)
+		}
+		w, err := m.WorkflowForTesting(uuid)
+		if err != nil {
+			t.Fatalf("%v: fail to get workflow from manager: %v", tc.name, err)
+		}
+		hw := w.(*reshardingWorkflowGen)
+		var executed []*hook.Hook
+		hw.hookRunner = func(h *hook.Hook) *hook.HookResult {
+			executed = append(executed, h)
+			return &hook.HookResult{ExitStatus: tc.exitStatus, Stdout: "hook output"}
+		}
+
+		if err := m.Start(ctx, uuid); err != nil {
+			t.Fatalf("%v: cannot start resharding workflow: %v", tc.name, err)
+		}
+		m.Wait(ctx, uuid)
+
+		if len(executed) != 1 {
+			t.Fatalf("%v: post hook should have been executed once: %v", tc.name, executed)
+		}
+		hw.mu.Lock()
+		childUUIDs := hw.childUUIDs
+		hw.mu.Unlock()
+		if len(childUUIDs) != 1 {
+			t.Fatalf("%v: one child workflow should have been created: %v", tc.name, childUUIDs)
+		}
+		wantParams := []string{"-keyspace=" + testKeyspace, "-uuid=" + uuid, "-child_uuids=" + childUUIDs[0]}
+		if got := executed[0]; got.Name != "notify_done" || !reflect.DeepEqual(got.Parameters, wantParams) {
+			t.Fatalf("%v: wrong post hook: got = %v %v, want = notify_done %v", tc.name, got.Name, got.Parameters, wantParams)
+		}
+		if !strings.HasPrefix(hw.rootUINode.Message, tc.wantMessage) {
+			t.Fatalf("%v: wrong root message: got = %v, want prefix = %v", tc.name, hw.rootUINode.Message, tc.wantMessage)
+		}
+		if !strings.Contains(hw.rootUINode.Log, "hook output") {
+			t.Fatalf("%v: hook output is not shown on the root node: %v", tc.name, hw.rootUINode.Log)
+		}
+
+		wi, err := ts.GetWorkflow(ctx, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.wantError == "" && wi.Error != "" {
+			t.Fatalf("%v: workflow should not have failed: %v", tc.name, wi.Error)
+		}
+		if !strings.Contains(wi.Error, tc.wantError) {
+			t.Fatalf("%v: wrong workflow error: got = %v, want substring = %v", tc.name, wi.Error, tc.wantError)
+		}
+		m.Stop(ctx, uuid)
+	}
+}
+
+func TestPostHookInvalidName(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	_, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=2", "-post_hook=../notify_done"})
+	if !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("a path must not be accepted as post hook: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"strings"
+
+	"vitess.io/vitess/go/vt/topo/topoproto"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+// This file contains the rollback plan which is generated by
+// -generate_rollback_plan.
+
+// rollbackServedTypes are the served types whose migration can be reverted,
+// in the order in which they must be reverted. The child workflows migrate
+// them in the opposite order.
+var rollbackServedTypes = []topodatapb.TabletType{topodatapb.TabletType_REPLICA, topodatapb.TabletType_RDONLY}
+
+// rollbackMasterWarning is the first line of each rollback plan.
+const rollbackMasterWarning = "# The migration of the MASTER cannot be reverted. The plan only applies as long as the MASTER was not migrated."
+
+// rollbackPlan returns the vtctl commands which revert the served type
+// migrations of the child workflows, in the order in which they must be run.
+// "shardsToSplit" has the pairs of source and destination shards as returned
+// by ShardPairDiscoverer.DiscoverShardPairs() or findVerticalSplitShards().
+// The commands are not executed by the workflow.
+func rollbackPlan(keyspace, splitType string, shardsToSplit [][][]string) []string {
+	plan := []string{rollbackMasterWarning}
+	for i, shardToSplit := range shardsToSplit {
+		plan = append(plan, fmt.Sprintf("# Task %v/%v: source shards %v, destination shards %v", phaseName, i, strings.Join(shardToSplit[0], ","), strings.Join(shardToSplit[1], ",")))
+		for _, servedType := range rollbackServedTypes {
+			tabletType := strings.ToLower(servedType.String())
+			if splitType == splitTypeVertical {
+				// MigrateServedFrom is run against the destination shard.
+				for _, shard := range shardToSplit[1] {
+					plan = append(plan, fmt.Sprintf("MigrateServedFrom -reverse %v %v", topoproto.KeyspaceShardString(keyspace, shard), tabletType))
+				}
+				continue
+			}
+			for _, shard := range shardToSplit[0] {
+				plan = append(plan, fmt.Sprintf("MigrateServedTypes -reverse %v %v", topoproto.KeyspaceShardString(keyspace, shard), tabletType))
+			}
+		}
+	}
+	return plan
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/workflow"
+)
+
+func TestRollbackPlan(t *testing.T) {
+	testCases := []struct {
+		name          string
+		splitType     string
+		shardsToSplit [][][]string
+		want          []string
+	}{
+		{
+			name:      "split and merge",
+			splitType: splitTypeHorizontal,
+			shardsToSplit: [][][]string{
+				{{"-80"}, {"-40", "40-80"}},
+				{{"80-c0", "c0-"}, {"80-"}},
+			},
+			want: []string{
+				rollbackMasterWarning,
+				"# Task create_workflows/0: source shards -80, destination shards -40,40-80",
+				"MigrateServedTypes -reverse test_keyspace/-80 replica",
+				"MigrateServedTypes -reverse test_keyspace/-80 rdonly",
+				"# Task create_workflows/1: source shards 80-c0,c0-, destination shards 80-",
+				"MigrateServedTypes -reverse test_keyspace/80-c0 replica",
+				"MigrateServedTypes -reverse test_keyspace/c0- replica",
+				"MigrateServedTypes -reverse test_keyspace/80-c0 rdonly",
+				"MigrateServedTypes -reverse test_keyspace/c0- rdonly",
+			},
+		},
+		{
+			name:      "vertical split",
+			splitType: splitTypeVertical,
+			shardsToSplit: [][][]string{
+				{{"0"}, {"0"}},
+			},
+			want: []string{
+				rollbackMasterWarning,
+				"# Task create_workflows/0: source shards 0, destination shards 0",
+				"MigrateServedFrom -reverse test_keyspace/0 replica",
+				"MigrateServedFrom -reverse test_keyspace/0 rdonly",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		if got := rollbackPlan(testKeyspace, tc.splitType, tc.shardsToSplit); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: wrong rollback plan:\ngot =\n%v\nwant =\n%v", tc.name, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestGenerateRollbackPlan(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-generate_rollback_plan"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+
+	rootNode := workflow.NewNode()
+	if _, err := (&Factory{}).Instantiate(m, wi.Workflow, rootNode); err != nil {
+		t.Fatalf("cannot instantiate workflow: %v", err)
+	}
+	want := strings.Join(rollbackPlan(testKeyspace, splitTypeHorizontal, [][][]string{{{"0"}, {"-80", "80-"}}}), "\n")
+	if !strings.Contains(rootNode.Message, want) {
+		t.Fatalf("root node message does not contain the rollback plan:\n%v\nmessage:\n%v", want, rootNode.Message)
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+const (
+	// splitCmdSplitClone and splitCmdLegacySplitClone are the supported
+	// values for -split_cmd.
+	splitCmdSplitClone       = "SplitClone"
+	splitCmdLegacySplitClone = "LegacySplitClone"
+)
+
+// checkSplitCmd verifies that "splitCmd" is a supported -split_cmd.
+// SplitClone and LegacySplitClone have the same requirements on the keyspace
+// and its shards: Both compute the keyspace IDs with the sharding column or
+// the sharded VSchema (see checkKeyspaceSharded()), both require that the
+// source shards serve all tablet types and that the destination shards
+// neither serve nor have filtered replication set up. Therefore, the check
+// does not depend on the keyspace.
+func checkSplitCmd(splitCmd string) error {
+	switch splitCmd {
+	case splitCmdSplitClone, splitCmdLegacySplitClone:
+	default:
+		return newError(ErrInvalidArguments, "invalid split_cmd: %v (must be %v or %v)", splitCmd, splitCmdSplitClone, splitCmdLegacySplitClone)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/workflow"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the tracking of the child workflows (-track_children)
+// and the limit of running child workflows (-max_running_children).
+
+const (
+	// trackChildrenInterval is how often the state of the child workflows is
+	// polled.
+	trackChildrenInterval = 30 * time.Second
+
+	// childUUIDAttribute is the task attribute which stores the UUID of the
+	// child workflow which was created for the task.
+	childUUIDAttribute = "child_uuid"
+
+	childStateNotStarted = "not started"
+	childStateRunning    = "running"
+	childStateSucceeded  = "succeeded"
+	childStateFailed     = "failed"
+	// childStateUnknown is used for tasks which were created before their
+	// child UUID was recorded in the checkpoint.
+	childStateUnknown = "unknown"
+)
+
+// trackChildren polls the state of all child workflows and shows it on the
+// task UI nodes. The ETA of all child workflows is shown on the root node and
+// updated after each poll. It returns when all child workflows are done. It
+// fails if at least one child workflow failed.
+func (hw *reshardingWorkflowGen) trackChildren(ctx context.Context) error {
+	const waitingMessage = "All workflows were created. Waiting for the child workflows to finish."
+	hw.setUIMessage(hw.rootUINode, waitingMessage)
+	start := hw.now()
+	lastStates := make(map[string]string)
+	for {
+		done, failed := hw.updateChildStates(ctx, lastStates)
+		if done {
+			if len(failed) > 0 {
+				return newError(ErrChildWorkflowFailed, "%v child workflow(s) failed: %v", len(failed), strings.Join(failed, ", "))
+			}
+			return nil
+		}
+		if message := waitingMessage + " " + hw.etaMessage(start, lastStates); message != hw.rootUINode.Message {
+			hw.setUIMessage(hw.rootUINode, message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(hw.trackChildrenInterval):
+		}
+	}
+}
+
+// updateChildStates reads the state of each child workflow and updates the
+// task UI node if the state changed since the last call. It returns true if
+// all child workflows are done and the UUIDs of the failed ones.
+func (hw *reshardingWorkflowGen) updateChildStates(ctx context.Context, lastStates map[string]string) (bool, []string) {
+	done := true
+	var failed []string
+	for i := 0; i < hw.workflowsCount; i++ {
+		taskID := fmt.Sprintf("%s/%v", phaseName, i)
+		taskUINode, err := hw.rootUINode.GetChildByPath(taskID)
+		if err != nil {
+			log.Errorf("Keyspace resharding: cannot find UI node of task %v: %v", taskID, err)
+			continue
+		}
+		hw.mu.Lock()
+		uuid := hw.checkpoint.Tasks[taskID].Attributes[childUUIDAttribute]
+		hw.mu.Unlock()
+
+		state := childStateUnknown
+		message := "Child workflow state is unknown because its UUID was not recorded."
+		if uuid != "" {
+			w, err := hw.childWorkflowReader(ctx, uuid)
+			if err != nil {
+				// Retry in the next round.
+				log.Warningf("Keyspace resharding: cannot read the state of child workflow %v: %v", uuid, err)
+				done = false
+				continue
+			}
+			state = childState(w)
+			message = fmt.Sprintf("Child workflow %v is %v.", uuid, state)
+			switch state {
+			case childStateNotStarted, childStateRunning:
+				done = false
+			case childStateFailed:
+				failed = append(failed, uuid)
+				message = fmt.Sprintf("Child workflow %v failed: %v", uuid, w.Error)
+			}
+		}
+		if lastStates[taskID] != state {
+			lastStates[taskID] = state
+			hw.setUIMessage(taskUINode, message)
+		}
+	}
+	return done, failed
+}
+
+// waitForRunningChildren blocks until fewer than -max_running_children child
+// workflows are running. It returns immediately if the flag is not set.
+func (hw *reshardingWorkflowGen) waitForRunningChildren(ctx context.Context, phaseUINode *workflow.Node) error {
+	if hw.maxRunningChildrenParam == 0 {
+		return nil
+	}
+	for {
+		running := hw.runningChildren(ctx)
+		if running < hw.maxRunningChildrenParam {
+			return nil
+		}
+		hw.setUIMessage(phaseUINode, fmt.Sprintf("%v child workflows are running (max_running_children: %v). Waiting for one of them to finish before starting the next one.", running, hw.maxRunningChildrenParam))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(hw.trackChildrenInterval):
+		}
+	}
+}
+
+// runningChildren returns the number of created child workflows which are
+// running. Child workflows whose state cannot be read are counted as running.
+func (hw *reshardingWorkflowGen) runningChildren(ctx context.Context) int {
+	var uuids []string
+	hw.mu.Lock()
+	for _, task := range hw.checkpoint.Tasks {
+		if uuid := task.Attributes[childUUIDAttribute]; uuid != "" {
+			uuids = append(uuids, uuid)
+		}
+	}
+	hw.mu.Unlock()
+
+	running := 0
+	for _, uuid := range uuids {
+		w, err := hw.childWorkflowReader(ctx, uuid)
+		if err != nil {
+			log.Warningf("Keyspace resharding: cannot read the state of child workflow %v: %v", uuid, err)
+			running++
+			continue
+		}
+		if w.State == workflowpb.WorkflowState_Running {
+			running++
+		}
+	}
+	return running
+}
+
+// readChildWorkflow is the default childWorkflowReader. It reads the child
+// workflow from the topology.
+func (hw *reshardingWorkflowGen) readChildWorkflow(ctx context.Context, uuid string) (*workflowpb.Workflow, error) {
+	wi, err := hw.manager.TopoServer().GetWorkflow(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return wi.Workflow, nil
+}
+
+// childState maps the state of a child workflow to the value shown in the UI.
+func childState(w *workflowpb.Workflow) string {
+	switch w.State {
+	case workflowpb.WorkflowState_NotStarted:
+		return childStateNotStarted
+	case workflowpb.WorkflowState_Running:
+		return childStateRunning
+	}
+	if w.Error != "" {
+		return childStateFailed
+	}
+	return childStateSucceeded
+}
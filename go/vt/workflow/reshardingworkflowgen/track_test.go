@@ -0,0 +1,417 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/memorytopo"
+	"vitess.io/vitess/go/vt/workflow"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+func TestTrackChildren(t *testing.T) {
+	testCases := []struct {
+		name        string
+		childError  string
+		wantMessage string
+		wantError   string
+	}{
+		{
+			name:        "succeeded",
+			wantMessage: "is succeeded.",
+		},
+		{
+			name:        "failed",
+			childError:  "SplitClone failed",
+			wantMessage: "failed: SplitClone failed",
+			wantError:   "1 child workflow(s) failed",
+		},
+	}
+	for _, tc := range testCases {
+		ctx := context.Background()
+		ts := setupTopology(ctx, t, testKeyspace)
+		m := workflow.NewManager(ts)
+		workflow.StartManager(m)
+
+		vtworkersParameter := testVtworkers + "," + testVtworkers
+		uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-skip_start_workflows=false", "-track_children"})
+		if err != nil {
+			t.Fatalf("%v: cannot create resharding workflow: %v", tc.name, err)
+		}
+		w, err := m.WorkflowForTesting(uuid)
+		if err != nil {
+			t.Fatalf("%v: fail to get workflow from manager: %v", tc.name, err)
+		}
+		hw := w.(*reshardingWorkflowGen)
+		hw.trackChildrenInterval = 1 * time.Millisecond
+		// The mock child goes through all states, one per poll.
+		states := []*workflowpb.Workflow{
+			{State: workflowpb.WorkflowState_NotStarted},
+			{State: workflowpb.WorkflowState_Running},
+			{State: workflowpb.WorkflowState_Done, Error: tc.childError},
+		}
+		hw.childStarter = func(ctx context.Context, childUUID string) error {
+			return nil
+		}
+		var readUUIDs []string
+		hw.childWorkflowReader = func(ctx context.Context, childUUID string) (*workflowpb.Workflow, error) {
+			readUUIDs = append(readUUIDs, childUUID)
+			state := states[0]
+			if len(states) > 1 {
+				states = states[1:]
+			}
+			return state, nil
+		}
+
+		if err := m.Start(ctx, uuid); err != nil {
+			t.Fatalf("%v: cannot start resharding workflow: %v", tc.name, err)
+		}
+		m.Wait(ctx, uuid)
+
+		if len(readUUIDs) != 3 {
+			t.Fatalf("%v: child workflow should have been polled 3 times: %v", tc.name, readUUIDs)
+		}
+		if got, want := readUUIDs[0], hw.checkpoint.Tasks[phaseName+"/0"].Attributes[childUUIDAttribute]; got != want || got == "" {
+			t.Fatalf("%v: wrong child workflow polled: got = %v, want = %v", tc.name, got, want)
+		}
+		taskUINode, err := hw.rootUINode.GetChildByPath(phaseName + "/0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(taskUINode.Message, tc.wantMessage) {
+			t.Fatalf("%v: wrong task message: got = %v, want substring = %v", tc.name, taskUINode.Message, tc.wantMessage)
+		}
+		for _, state := range []string{childStateNotStarted, childStateRunning} {
+			if !strings.Contains(taskUINode.Log, "is "+state+".") {
+				t.Fatalf("%v: task log does not show the child state %v: %v", tc.name, state, taskUINode.Log)
+			}
+		}
+
+		wi, err := ts.GetWorkflow(ctx, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.wantError == "" && wi.Error != "" {
+			t.Fatalf("%v: workflow should not have failed: %v", tc.name, wi.Error)
+		}
+		if !strings.Contains(wi.Error, tc.wantError) {
+			t.Fatalf("%v: wrong workflow error: got = %v, want substring = %v", tc.name, wi.Error, tc.wantError)
+		}
+		m.Stop(ctx, uuid)
+	}
+}
+
+// setupTasksTopology creates a keyspace with the shards "sources" and
+// "destinations". Only the source shards are serving. Each group of
+// overlapping shards results in one task.
+func setupTasksTopology(ctx context.Context, t *testing.T, sources, destinations []string) *topo.Server {
+	ts := memorytopo.NewServer("cell")
+	if err := ts.CreateKeyspace(ctx, testKeyspace, &topodatapb.Keyspace{
+		ShardingColumnName: "keyspace_id",
+		ShardingColumnType: topodatapb.KeyspaceIdType_UINT64,
+	}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	for _, shard := range append(append([]string{}, sources...), destinations...) {
+		if err := ts.CreateShard(ctx, testKeyspace, shard); err != nil {
+			t.Fatalf("CreateShard: %v", err)
+		}
+	}
+	var shardReferences []*topodatapb.ShardReference
+	for _, shard := range sources {
+		shardReferences = append(shardReferences, &topodatapb.ShardReference{Name: shard})
+	}
+	var partitions []*topodatapb.SrvKeyspace_KeyspacePartition
+	for _, tabletType := range []topodatapb.TabletType{topodatapb.TabletType_MASTER, topodatapb.TabletType_REPLICA, topodatapb.TabletType_RDONLY} {
+		partitions = append(partitions, &topodatapb.SrvKeyspace_KeyspacePartition{
+			ServedType:      tabletType,
+			ShardReferences: shardReferences,
+		})
+	}
+	if err := ts.UpdateSrvKeyspace(ctx, "cell", testKeyspace, &topodatapb.SrvKeyspace{Partitions: partitions}); err != nil {
+		t.Fatalf("UpdateSrvKeyspace: %v", err)
+	}
+	return ts
+}
+
+func TestMaxRunningChildren(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2", "-skip_start_workflows=false", "-max_running_children=1"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if hw.workflowsCount != 2 {
+		t.Fatalf("two child workflows must be created: got = %v", hw.workflowsCount)
+	}
+	hw.trackChildrenInterval = 1 * time.Millisecond
+
+	// Started children are running until they were polled twice.
+	var mu sync.Mutex
+	states := make(map[string]workflowpb.WorkflowState)
+	polls := make(map[string]int)
+	var started []string
+	maxRunning := 0
+	hw.childWorkflowReader = func(ctx context.Context, childUUID string) (*workflowpb.Workflow, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if states[childUUID] == workflowpb.WorkflowState_Running {
+			polls[childUUID]++
+			if polls[childUUID] == 2 {
+				states[childUUID] = workflowpb.WorkflowState_Done
+			}
+		}
+		return &workflowpb.Workflow{Uuid: childUUID, State: states[childUUID]}, nil
+	}
+	hw.childStarter = func(ctx context.Context, childUUID string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		states[childUUID] = workflowpb.WorkflowState_Running
+		started = append(started, childUUID)
+		running := 0
+		for _, state := range states {
+			if state == workflowpb.WorkflowState_Running {
+				running++
+			}
+		}
+		if running > maxRunning {
+			maxRunning = running
+		}
+		return nil
+	}
+
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	defer m.Stop(ctx, uuid)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(started) != 2 {
+		t.Fatalf("both child workflows should have been started: %v", started)
+	}
+	if maxRunning != 1 {
+		t.Fatalf("at most one child workflow should have been running: got = %v", maxRunning)
+	}
+	if got := polls[started[0]]; got != 2 {
+		t.Fatalf("the first child workflow should have been polled until it finished: got = %v polls", got)
+	}
+}
+
+func TestMaxRunningChildrenInvalidArguments(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+	for _, args := range [][]string{
+		{"-max_running_children=-1", "-skip_start_workflows=false"},
+		// Child workflows which are not started cannot be limited.
+		{"-max_running_children=1"},
+		// Child workflows which are not started would never finish.
+		{"-track_children"},
+	} {
+		args = append(args, "-keyspace="+testKeyspace, "-vtworkers="+testVtworkers+","+testVtworkers, "-min_healthy_rdonly_tablets=2")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+}
+
+func TestPerTaskTimeout(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2", "-per_task_timeout=-1s"}); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("Create() with a negative -per_task_timeout should have failed with ErrInvalidArguments: %v", err)
+	}
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2", "-skip_start_workflows=false", "-per_task_timeout=100ms"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+
+	// Starting the first child hangs. All other starts succeed.
+	hang := make(chan struct{})
+	defer close(hang)
+	var mu sync.Mutex
+	var started []string
+	calls := 0
+	hw.childStarter = func(ctx context.Context, childUUID string) error {
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+		if call == 1 {
+			<-hang
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		started = append(started, childUUID)
+		return nil
+	}
+	// The hanging start did not change the state of the child.
+	hw.childWorkflowReader = func(ctx context.Context, childUUID string) (*workflowpb.Workflow, error) {
+		return &workflowpb.Workflow{State: workflowpb.WorkflowState_NotStarted}, nil
+	}
+
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	defer m.Stop(ctx, uuid)
+
+	// Wait until the first task failed because of the timeout.
+	var timedOut *workflowpb.Task
+	for start := time.Now(); ; {
+		wi, err := ts.GetWorkflow(ctx, uuid)
+		if err != nil {
+			t.Fatalf("cannot read workflow: %v", err)
+		}
+		checkpoint := &workflowpb.WorkflowCheckpoint{}
+		if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+			t.Fatal(err)
+		}
+		timedOut = checkpoint.Tasks[phaseName+"/0"]
+		if strings.Contains(timedOut.Error, "per_task_timeout") {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("the first task should have failed because of the timeout: %v", timedOut)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	if timedOut.Attributes[timedOutAttribute] != "true" || timedOut.Attributes[childUUIDAttribute] == "" {
+		t.Fatalf("the first task should have been marked as timed out and have recorded its child workflow: %v", timedOut)
+	}
+	mu.Lock()
+	if len(started) != 0 {
+		t.Fatalf("the next task must not proceed before the timed out task was retried: started = %v", started)
+	}
+	mu.Unlock()
+
+	// The retry reuses the child workflow of the first attempt.
+	taskUINode, err := hw.rootUINode.GetChildByPath(phaseName + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NodeManager().Action(ctx, &workflow.ActionParameters{Path: taskUINode.Path, Name: "Retry"}); err != nil {
+		t.Fatalf("retry action failed: %v", err)
+	}
+	m.Wait(ctx, uuid)
+
+	hw.mu.Lock()
+	childUUIDs := append([]string(nil), hw.childUUIDs...)
+	hw.mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(childUUIDs) != 2 || len(started) != 2 || started[0] != timedOut.Attributes[childUUIDAttribute] {
+		t.Fatalf("the retry should have started the child workflow of the first attempt: child UUIDs = %v, started = %v, first child = %v", childUUIDs, started, timedOut.Attributes[childUUIDAttribute])
+	}
+}
+
+// TestPerTaskTimeoutExcludesSlotWait tests that the wait for a free slot
+// (-max_running_children) does not count against -per_task_timeout.
+func TestPerTaskTimeoutExcludesSlotWait(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2", "-skip_start_workflows=false", "-max_running_children=1", "-per_task_timeout=100ms"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	hw.trackChildrenInterval = 1 * time.Millisecond
+
+	// Started children are running for 3 times the timeout.
+	var mu sync.Mutex
+	startedAt := make(map[string]time.Time)
+	hw.childWorkflowReader = func(ctx context.Context, childUUID string) (*workflowpb.Workflow, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		state := workflowpb.WorkflowState_NotStarted
+		if at, ok := startedAt[childUUID]; ok {
+			state = workflowpb.WorkflowState_Running
+			if time.Since(at) > 300*time.Millisecond {
+				state = workflowpb.WorkflowState_Done
+			}
+		}
+		return &workflowpb.Workflow{Uuid: childUUID, State: state}, nil
+	}
+	hw.childStarter = func(ctx context.Context, childUUID string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		startedAt[childUUID] = time.Now()
+		return nil
+	}
+
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	defer m.Stop(ctx, uuid)
+	// A task which failed would wait for a retry and block Wait().
+	done := make(chan struct{})
+	go func() {
+		m.Wait(ctx, uuid)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		hw.mu.Lock()
+		defer hw.mu.Unlock()
+		t.Fatalf("the workflow should have finished without a timed out task: %v", hw.checkpoint.Tasks)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(startedAt) != 2 {
+		t.Fatalf("both child workflows should have been started: %v", startedAt)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/automation"
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/topoproto"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+)
+
+// This file contains the pre-flight checks which are run by -validate_only.
+// They run the same checks as Init() does before it creates the tasks.
+
+// errShardsNotDiscovered is returned by all checks which depend on the
+// discovered shards if the discovery failed.
+var errShardsNotDiscovered = errors.New("skipped because the source and destination shards could not be discovered")
+
+// preflightCheck is a single named check. All checks are run even if a
+// previous check failed.
+type preflightCheck struct {
+	name string
+	run  func(ctx context.Context, p *preflightParams) error
+}
+
+// preflightParams is the input for all pre-flight checks.
+type preflightParams struct {
+	ts                      *topo.Server
+	discoverer              ShardPairDiscoverer
+	keyspace                string
+	vtworkers               []string
+	minHealthyRdonlyTablets string
+	minDestinationReplicas  int
+	splitCmd                string
+	// cells are the -diff_cells and -discovery_cells.
+	cells       []string
+	maxOverlaps int
+	force       bool
+
+	// shardsToSplit is set by the first check. It has the same format as the
+	// return value of ShardPairDiscoverer.DiscoverShardPairs(). Overlaps
+	// whose destination shards are serving already are skipped like Init()
+	// does.
+	shardsToSplit [][][]string
+}
+
+// preflightResult is the outcome of a single check.
+type preflightResult struct {
+	name string
+	err  error
+}
+
+// preflightChecks is the list of all checks in the order they are run.
+var preflightChecks = []preflightCheck{
+	{"DiscoverShards", checkDiscoverShards},
+	{"VtworkerCount", checkVtworkerCount},
+	{"MinHealthyRdonlyTablets", checkMinHealthyRdonlyTablets},
+	{"SourceRdonlyTablets", checkSourceRdonlyTablets},
+	{"DestinationShardsNotServing", checkDestinationShardsNotServing},
+	{"VtworkersReachable", checkVtworkersReachable},
+	{"DestinationReplicaTablets", checkDestinationReplicaTablets},
+	{"SplitCmd", checkSplitCmdSupported},
+	{"DestinationCoverage", checkDestinationShardsCoverage},
+	{"CellsExist", checkCellsKnown},
+	{"MaxOverlaps", checkOverlapCount},
+}
+
+// runPreflightChecks runs all pre-flight checks and returns their results.
+func runPreflightChecks(ctx context.Context, p *preflightParams) []preflightResult {
+	results := make([]preflightResult, 0, len(preflightChecks))
+	for _, c := range preflightChecks {
+		results = append(results, preflightResult{c.name, c.run(ctx, p)})
+	}
+	return results
+}
+
+// preflightReport formats the results as one line per check.
+// It returns true if all checks passed.
+func preflightReport(results []preflightResult) (string, bool) {
+	var b bytes.Buffer
+	passed := true
+	for _, r := range results {
+		if r.err != nil {
+			passed = false
+			fmt.Fprintf(&b, "FAIL %v: %v\n", r.name, r.err)
+		} else {
+			fmt.Fprintf(&b, "PASS %v\n", r.name)
+		}
+	}
+	return b.String(), passed
+}
+
+func checkDiscoverShards(ctx context.Context, p *preflightParams) error {
+	shardsToSplit, err := p.discoverer.DiscoverShardPairs(ctx, p.ts, p.keyspace)
+	if err != nil {
+		return err
+	}
+	shardsToSplit, err = skipMigratedOverlaps(ctx, p.ts, p.keyspace, shardsToSplit)
+	if err != nil {
+		return err
+	}
+	if len(shardsToSplit) == 0 {
+		return newError(ErrNoOverlappingShards, "no overlapping shards found in keyspace %v", p.keyspace)
+	}
+	p.shardsToSplit = shardsToSplit
+	return nil
+}
+
+func checkVtworkerCount(ctx context.Context, p *preflightParams) error {
+	if p.shardsToSplit == nil {
+		return errShardsNotDiscovered
+	}
+	destShards := 0
+	for _, shardToSplit := range p.shardsToSplit {
+		destShards = destShards + len(shardToSplit[1])
+	}
+	if len(p.vtworkers) != destShards {
+		return newError(ErrVtworkerCountMismatch, "there are %v vtworkers, %v destination shards: the number should be same", len(p.vtworkers), destShards)
+	}
+	return nil
+}
+
+func checkMinHealthyRdonlyTablets(ctx context.Context, p *preflightParams) error {
+	if p.shardsToSplit == nil {
+		return errShardsNotDiscovered
+	}
+	minHealthyRdonlyTablets, err := strconv.Atoi(p.minHealthyRdonlyTablets)
+	if err != nil {
+		return newError(ErrInvalidArguments, "invalid min_healthy_rdonly_tablets: %v", p.minHealthyRdonlyTablets)
+	}
+	for _, shardToSplit := range p.shardsToSplit {
+		splitRatio := len(shardToSplit[1]) / len(shardToSplit[0])
+		if minHealthyRdonlyTablets < splitRatio {
+			return newError(ErrNotEnoughRdonlyTablets, "there are not enough rdonly tablets in source shards. You need at least %v, it got: %v", splitRatio, minHealthyRdonlyTablets)
+		}
+	}
+	return nil
+}
+
+func checkSourceRdonlyTablets(ctx context.Context, p *preflightParams) error {
+	if p.shardsToSplit == nil {
+		return errShardsNotDiscovered
+	}
+	minHealthyRdonlyTablets, err := strconv.Atoi(p.minHealthyRdonlyTablets)
+	if err != nil {
+		return newError(ErrInvalidArguments, "invalid min_healthy_rdonly_tablets: %v", p.minHealthyRdonlyTablets)
+	}
+	for _, shardToSplit := range p.shardsToSplit {
+		for _, shard := range shardToSplit[0] {
+			tablets, err := p.ts.GetTabletMapForShard(ctx, p.keyspace, shard)
+			if err != nil {
+				return wrapError(ErrTopo, err)
+			}
+			rdonlyTablets := 0
+			for _, ti := range tablets {
+				if ti.Type == topodatapb.TabletType_RDONLY {
+					rdonlyTablets++
+				}
+			}
+			if rdonlyTablets < minHealthyRdonlyTablets {
+				return newError(ErrNotEnoughRdonlyTablets, "source shard %v has %v rdonly tablets, but at least %v are required", topoproto.KeyspaceShardString(p.keyspace, shard), rdonlyTablets, minHealthyRdonlyTablets)
+			}
+		}
+	}
+	return nil
+}
+
+func checkDestinationShardsNotServing(ctx context.Context, p *preflightParams) error {
+	if p.shardsToSplit == nil {
+		return errShardsNotDiscovered
+	}
+	for _, shardToSplit := range p.shardsToSplit {
+		for _, shard := range shardToSplit[1] {
+			si, err := p.ts.GetShard(ctx, p.keyspace, shard)
+			if err != nil {
+				return wrapError(ErrTopo, err)
+			}
+			servingTypes, err := p.ts.GetShardServingTypes(ctx, si)
+			if err != nil {
+				return wrapError(ErrTopo, err)
+			}
+			if len(servingTypes) > 0 {
+				return newError(ErrDestinationShardServing, "destination shard %v is already serving: %v", topoproto.KeyspaceShardString(p.keyspace, shard), servingTypes)
+			}
+		}
+	}
+	return nil
+}
+
+func checkVtworkersReachable(ctx context.Context, p *preflightParams) error {
+	pinged := make(map[string]bool)
+	for _, vtworker := range p.vtworkers {
+		if pinged[vtworker] {
+			continue
+		}
+		pinged[vtworker] = true
+		if _, err := automation.ExecuteVtworker(ctx, vtworker, []string{"Ping", "keyspace resharding pre-flight check"}); err != nil {
+			return &Error{
+				code:    ErrVtworkerUnreachable,
+				message: fmt.Sprintf("vtworker %v is not reachable: %v", vtworker, err),
+				cause:   err,
+			}
+		}
+	}
+	return nil
+}
+
+func checkDestinationReplicaTablets(ctx context.Context, p *preflightParams) error {
+	if p.shardsToSplit == nil {
+		return errShardsNotDiscovered
+	}
+	return checkMinDestinationReplicas(ctx, p.ts, p.keyspace, p.shardsToSplit, p.minDestinationReplicas)
+}
+
+// checkMinDestinationReplicas returns an error if a destination shard in
+// "shardsToSplit" has fewer than "minReplicas" replica tablets in the
+// topology (-min_destination_replicas). Without replicas, the shard cannot
+// serve reliably after the cutover. 0 disables the check.
+func checkMinDestinationReplicas(ctx context.Context, ts *topo.Server, keyspace string, shardsToSplit [][][]string, minReplicas int) error {
+	if minReplicas == 0 {
+		return nil
+	}
+	for _, shardToSplit := range shardsToSplit {
+		for _, shard := range shardToSplit[1] {
+			tablets, err := ts.GetTabletMapForShard(ctx, keyspace, shard)
+			if err != nil {
+				return wrapError(ErrTopo, err)
+			}
+			replicaTablets := 0
+			for _, ti := range tablets {
+				if ti.Type == topodatapb.TabletType_REPLICA {
+					replicaTablets++
+				}
+			}
+			if replicaTablets < minReplicas {
+				return newError(ErrNotEnoughReplicaTablets, "destination shard %v has %v replica tablets, but at least %v are required (min_destination_replicas)", topoproto.KeyspaceShardString(keyspace, shard), replicaTablets, minReplicas)
+			}
+		}
+	}
+	return nil
+}
+
+func checkSplitCmdSupported(ctx context.Context, p *preflightParams) error {
+	return checkSplitCmd(p.splitCmd)
+}
+
+func checkDestinationShardsCoverage(ctx context.Context, p *preflightParams) error {
+	return checkDestinationCoverage(ctx, p.ts, p.keyspace)
+}
+
+func checkCellsKnown(ctx context.Context, p *preflightParams) error {
+	if len(p.cells) == 0 {
+		return nil
+	}
+	return checkCellsExist(ctx, p.ts, p.cells)
+}
+
+func checkOverlapCount(ctx context.Context, p *preflightParams) error {
+	if p.shardsToSplit == nil {
+		return errShardsNotDiscovered
+	}
+	return checkMaxOverlaps(p.keyspace, p.shardsToSplit, p.maxOverlaps, p.force)
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/log"
+	"vitess.io/vitess/go/vt/topo"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+// This file contains the replacement of vtworker addresses for a workflow
+// which is resumed e.g. after a vtworker died and the alternative
+// assignment of the vtworkers to the tasks (-vtworker_assignment).
+
+const (
+	// vtworkerAssignmentContiguous and vtworkerAssignmentRoundRobin are the
+	// values of the -vtworker_assignment flag.
+	// Contiguous gives each task the next vtworkers of the list: The first
+	// task gets as many vtworkers as it has destination shards, then the
+	// second task and so on.
+	vtworkerAssignmentContiguous = "contiguous"
+	// Round-robin gives each task one vtworker per round until it has one
+	// per destination shard. Tasks with many destination shards do not get
+	// all the vtworkers at the beginning of the list.
+	vtworkerAssignmentRoundRobin = "round_robin"
+)
+
+// UpdateVtworkers overrides the vtworker addresses of all tasks of the
+// keyspace resharding workflow "uuid" which did not create their child
+// workflow yet. Completed tasks are not changed.
+// "vtworkers" must have one address per destination shard of the remaining
+// tasks, in the order of the tasks.
+// The override is stored in the checkpoint setting "vtworkers_override".
+// Instantiate() applies it when the workflow is loaded the next time e.g.
+// when it's restarted (see applyVtworkersOverride()). It fails if the
+// workflow is running: Stop it first. If a Manager starts the workflow
+// concurrently, the save fails because the version in the topo changed.
+func UpdateVtworkers(ctx context.Context, ts *topo.Server, uuid string, vtworkers []string) error {
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		return wrapError(ErrTopo, err)
+	}
+	if wi.FactoryName != keyspaceReshardingFactoryName {
+		return newError(ErrInvalidArguments, "workflow %v is not a keyspace resharding workflow: %v", uuid, wi.FactoryName)
+	}
+	if wi.State == workflowpb.WorkflowState_Running {
+		return newError(ErrInvalidArguments, "workflow %v is running: stop it before updating its vtworkers", uuid)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		return err
+	}
+	if _, err := pendingTasks(checkpoint, vtworkers); err != nil {
+		return err
+	}
+	checkpoint.Settings["vtworkers_override"] = strings.Join(vtworkers, ",")
+	wi.Data, err = proto.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := ts.SaveWorkflow(ctx, wi); err != nil {
+		return wrapError(ErrTopo, err)
+	}
+	log.Infof("Keyspace resharding workflow %v: the vtworkers of the remaining tasks will be updated to: %v", uuid, strings.Join(vtworkers, ","))
+	return nil
+}
+
+// applyVtworkersOverride applies the setting "vtworkers_override" which was
+// stored by UpdateVtworkers() and removes it. It's a no-op if the setting
+// is not set.
+func applyVtworkersOverride(checkpoint *workflowpb.WorkflowCheckpoint) error {
+	override := checkpoint.Settings["vtworkers_override"]
+	if override == "" {
+		return nil
+	}
+	if err := updateVtworkers(checkpoint, strings.Split(override, ",")); err != nil {
+		return err
+	}
+	delete(checkpoint.Settings, "vtworkers_override")
+	log.Infof("Keyspace resharding of keyspace %v: updated the vtworkers of the remaining tasks to: %v", checkpoint.Settings["keyspace"], override)
+	return nil
+}
+
+// pendingTasks returns the tasks which did not create their child workflow
+// yet, in the order of the tasks. It returns an error if there are none or
+// if "vtworkers" does not have one address per destination shard of them.
+// A task which failed after it created its child workflow (e.g. because the
+// start failed) is not pending. Its child workflow uses the old vtworkers.
+func pendingTasks(checkpoint *workflowpb.WorkflowCheckpoint, vtworkers []string) ([]*workflowpb.Task, error) {
+	var pending []*workflowpb.Task
+	destShards := 0
+	for _, task := range checkpoint.Tasks {
+		if task.State == workflowpb.TaskState_TaskDone && task.Error == "" {
+			continue
+		}
+		if task.Attributes[childUUIDAttribute] != "" {
+			continue
+		}
+		pending = append(pending, task)
+		destShards += len(strings.Split(task.Attributes["destination_shards"], ","))
+	}
+	if len(pending) == 0 {
+		return nil, newError(ErrInvalidArguments, "all tasks created their child workflows: there are no vtworkers to update")
+	}
+	if len(vtworkers) != destShards {
+		return nil, newError(ErrVtworkerCountMismatch, "there are %v vtworkers, %v destination shards in the remaining tasks: the number should be same", len(vtworkers), destShards)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return taskNumber(pending[i].Id) < taskNumber(pending[j].Id)
+	})
+	return pending, nil
+}
+
+// updateVtworkers assigns "vtworkers" to the tasks which did not create
+// their child workflow yet. Each task gets as many vtworkers as it has
+// destination shards.
+func updateVtworkers(checkpoint *workflowpb.WorkflowCheckpoint, vtworkers []string) error {
+	pending, err := pendingTasks(checkpoint, vtworkers)
+	if err != nil {
+		return err
+	}
+
+	usedVtworkersIdx := 0
+	for _, task := range pending {
+		count := len(strings.Split(task.Attributes["destination_shards"], ","))
+		task.Attributes["vtworkers"] = strings.Join(vtworkers[usedVtworkersIdx:usedVtworkersIdx+count], ",")
+		usedVtworkersIdx += count
+	}
+
+	syncVtworkersSetting(checkpoint)
+	return nil
+}
+
+// assignVtworkersRoundRobin reassigns the vtworkers of a new checkpoint
+// (see initCheckpoint()) with -vtworker_assignment=round_robin.
+// For example, the vtworkers 1,2,3,4,5 are assigned as 1,3,4,5 and 2 to
+// two tasks with four respectively one destination shard.
+func assignVtworkersRoundRobin(checkpoint *workflowpb.WorkflowCheckpoint) {
+	vtworkers := strings.Split(checkpoint.Settings["vtworkers"], ",")
+	tasks := make([]*workflowpb.Task, 0, len(checkpoint.Tasks))
+	for _, task := range checkpoint.Tasks {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return taskNumber(tasks[i].Id) < taskNumber(tasks[j].Id)
+	})
+
+	assigned := make([][]string, len(tasks))
+	usedVtworkersIdx := 0
+	for round := 0; usedVtworkersIdx < len(vtworkers); round++ {
+		for i, task := range tasks {
+			if round < len(strings.Split(task.Attributes["destination_shards"], ",")) {
+				assigned[i] = append(assigned[i], vtworkers[usedVtworkersIdx])
+				usedVtworkersIdx++
+			}
+		}
+	}
+	for i, task := range tasks {
+		task.Attributes["vtworkers"] = strings.Join(assigned[i], ",")
+	}
+
+	syncVtworkersSetting(checkpoint)
+}
+
+// syncVtworkersSetting keeps the list of all vtworkers in sync with the
+// vtworkers of the tasks.
+func syncVtworkersSetting(checkpoint *workflowpb.WorkflowCheckpoint) {
+	var all []string
+	for _, row := range assignmentRows(checkpoint.Tasks) {
+		// The last column has the vtworkers of the task.
+		all = append(all, row[len(row)-1])
+	}
+	checkpoint.Settings["vtworkers"] = strings.Join(all, ",")
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo/memorytopo"
+	"vitess.io/vitess/go/vt/workflow"
+
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+func TestUpdateVtworkers(t *testing.T) {
+	shardsToSplit := [][][]string{
+		{{"-40"}, {"-20", "20-40"}},
+		{{"40-80"}, {"40-60", "60-80"}},
+		{{"80-"}, {"80-c0", "c0-"}},
+	}
+	checkpoint, err := initCheckpoint(testKeyspace, []string{"vtworker1", "vtworker2", "vtworker3", "vtworker4", "vtworker5", "vtworker6"}, shardsToSplit, "2", "SplitClone", "RDONLY", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first task created its child workflow already. The second one
+	// created its child workflow, but failed to start it.
+	checkpoint.Tasks[phaseName+"/0"].State = workflowpb.TaskState_TaskDone
+	checkpoint.Tasks[phaseName+"/1"].State = workflowpb.TaskState_TaskDone
+	checkpoint.Tasks[phaseName+"/1"].Error = "cannot start child workflow"
+	checkpoint.Tasks[phaseName+"/1"].Attributes[childUUIDAttribute] = "child"
+
+	ctx := context.Background()
+	ts := memorytopo.NewServer("cell")
+	data, err := proto.Marshal(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wi, err := ts.CreateWorkflow(ctx, &workflowpb.Workflow{
+		Uuid:        "uuid",
+		FactoryName: keyspaceReshardingFactoryName,
+		Data:        data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A running workflow is not updated.
+	wi.State = workflowpb.WorkflowState_Running
+	if err := ts.SaveWorkflow(ctx, wi); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateVtworkers(ctx, ts, wi.Uuid, []string{"vtworker7", "vtworker8"}); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("updating a running workflow should have failed with ErrInvalidArguments: %v", err)
+	}
+	wi.State = workflowpb.WorkflowState_Done
+	if err := ts.SaveWorkflow(ctx, wi); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateVtworkers(ctx, ts, wi.Uuid, []string{"vtworker7"}); !IsErrType(err, ErrVtworkerCountMismatch) {
+		t.Fatalf("a wrong number of vtworkers should have failed with ErrVtworkerCountMismatch: %v", err)
+	}
+	if err := UpdateVtworkers(ctx, ts, wi.Uuid, []string{"vtworker7", "vtworker8"}); err != nil {
+		t.Fatalf("UpdateVtworkers failed: %v", err)
+	}
+
+	// The override is applied when the workflow is loaded.
+	wi, err = ts.GetWorkflow(ctx, wi.Uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := (&Factory{}).Instantiate(workflow.NewManager(ts), wi.Workflow, workflow.NewNode())
+	if err != nil {
+		t.Fatalf("cannot instantiate workflow: %v", err)
+	}
+	got := w.(*reshardingWorkflowGen).checkpoint
+	for _, tc := range []struct {
+		taskID string
+		want   string
+	}{
+		// The tasks which created their child workflow are untouched.
+		{phaseName + "/0", "vtworker1,vtworker2"},
+		{phaseName + "/1", "vtworker3,vtworker4"},
+		{phaseName + "/2", "vtworker7,vtworker8"},
+	} {
+		if got := got.Tasks[tc.taskID].Attributes["vtworkers"]; got != tc.want {
+			t.Errorf("wrong vtworkers for task %v: got = %v, want = %v", tc.taskID, got, tc.want)
+		}
+	}
+	if got, want := got.Settings["vtworkers"], "vtworker1,vtworker2,vtworker3,vtworker4,vtworker7,vtworker8"; got != want {
+		t.Errorf("wrong vtworkers setting: got = %v, want = %v", got, want)
+	}
+	if override, ok := got.Settings["vtworkers_override"]; ok {
+		t.Errorf("the override should have been removed after it was applied: %v", override)
+	}
+}
+
+func TestAssignVtworkersRoundRobin(t *testing.T) {
+	shardsToSplit := [][][]string{
+		{{"-40"}, {"-10", "10-20", "20-30", "30-40"}},
+		{{"40-80"}, {"40-60", "60-80"}},
+		{{"80-"}, {"80-"}},
+	}
+	vtworkers := []string{"vtworker1", "vtworker2", "vtworker3", "vtworker4", "vtworker5", "vtworker6", "vtworker7"}
+	checkpoint, err := initCheckpoint(testKeyspace, vtworkers, shardsToSplit, "4", "SplitClone", "RDONLY", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default assignment gives the first task the first four vtworkers.
+	if got, want := checkpoint.Tasks[phaseName+"/0"].Attributes["vtworkers"], "vtworker1,vtworker2,vtworker3,vtworker4"; got != want {
+		t.Fatalf("wrong contiguous assignment: got = %v, want = %v", got, want)
+	}
+
+	assignVtworkersRoundRobin(checkpoint)
+	for _, tc := range []struct {
+		taskID string
+		want   string
+	}{
+		{phaseName + "/0", "vtworker1,vtworker4,vtworker6,vtworker7"},
+		{phaseName + "/1", "vtworker2,vtworker5"},
+		{phaseName + "/2", "vtworker3"},
+	} {
+		if got := checkpoint.Tasks[tc.taskID].Attributes["vtworkers"]; got != tc.want {
+			t.Errorf("wrong vtworkers of task %v: got = %v, want = %v", tc.taskID, got, tc.want)
+		}
+	}
+	// Each vtworker is used exactly once.
+	if got, want := checkpoint.Settings["vtworkers"], "vtworker1,vtworker4,vtworker6,vtworker7,vtworker2,vtworker5,vtworker3"; got != want {
+		t.Errorf("wrong list of all vtworkers: got = %v, want = %v", got, want)
+	}
+}
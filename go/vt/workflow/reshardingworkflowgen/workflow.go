@@ -15,17 +15,23 @@ package reshardingworkflowgen
 
 // This package contains a workflow to generate horizontal resharding workflows
 // that automatically discovers available overlapping shards to split/merge.
+// It can also generate vertical split workflows for a keyspace which is
+// (partially) served from another keyspace.
 
 import (
 	"flag"
 	"fmt"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
 
+	"vitess.io/vitess/go/vt/hook"
 	"vitess.io/vitess/go/vt/log"
 	"vitess.io/vitess/go/vt/logutil"
 	"vitess.io/vitess/go/vt/topo"
@@ -33,6 +39,7 @@ import (
 	"vitess.io/vitess/go/vt/workflow"
 	"vitess.io/vitess/go/vt/workflow/resharding"
 
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
 	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
 )
 
@@ -41,6 +48,36 @@ const (
 
 	keyspaceReshardingFactoryName = "hr_workflow_gen"
 	phaseName                     = "create_workflows"
+
+	// horizontalReshardingFactoryName and verticalSplitFactoryName are the
+	// factories used to create the child workflows. Both are registered by
+	// resharding.Register().
+	horizontalReshardingFactoryName = "horizontal_resharding"
+	verticalSplitFactoryName        = "vertical_split"
+
+	// splitTypeHorizontal and splitTypeVertical are the values for the
+	// -split_type flag.
+	splitTypeHorizontal = "horizontal"
+	splitTypeVertical   = "vertical"
+
+	// createWorkflowAttempts is how often the creation of a child workflow is
+	// tried if it fails with a transient error.
+	createWorkflowAttempts   = 3
+	createWorkflowRetryDelay = 5 * time.Second
+
+	// timedOutAttribute is the task attribute which is "true" if the task
+	// exceeded -per_task_timeout.
+	timedOutAttribute = "timed_out"
+
+	// defaultMaxOverlaps is the default of -max_overlaps. It protects against
+	// creating an overwhelming number of child workflows by accident.
+	defaultMaxOverlaps = 64
+
+	// skipSplitDiffWarning is shown in the UI if -skip_split_diff is set.
+	skipSplitDiffWarning = "WARNING: SplitDiff is skipped (-skip_split_diff). The copied data will not be verified."
+	// diffSampleWarning is shown in the UI if -diff_sample_percent is below
+	// 100. It's formatted with the percentage.
+	diffSampleWarning = "WARNING: SplitDiff only verifies a SAMPLE of %v%% of the rows (-diff_sample_percent). The other rows will not be verified."
 )
 
 // Register registers the KeyspaceResharding as a factory
@@ -59,26 +96,309 @@ func (*Factory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string)
 	keyspace := subFlags.String("keyspace", "", "Name of keyspace to perform horizontal resharding")
 	vtworkersStr := subFlags.String("vtworkers", "", "A comma-separated list of vtworker addresses")
 	minHealthyRdonlyTablets := subFlags.String("min_healthy_rdonly_tablets", "1", "Minimum number of healthy RDONLY tablets required in source shards")
-	splitCmd := subFlags.String("split_cmd", "SplitClone", "Split command to use to perform horizontal resharding (either SplitClone or LegacySplitClone)")
+	splitCmd := subFlags.String("split_cmd", splitCmdSplitClone, "Split command to use to perform horizontal resharding (either SplitClone or LegacySplitClone)")
 	splitDiffDestTabletType := subFlags.String("split_diff_dest_tablet_type", "RDONLY", "Specifies tablet type to use in destination shards while performing SplitDiff operation")
 	skipStartWorkflows := subFlags.Bool("skip_start_workflows", true, "If true, newly created workflows will have skip_start set")
 	phaseEnableApprovalsDesc := fmt.Sprintf("Comma separated phases that require explicit approval in the UI to execute. Phase names are: %v", strings.Join(resharding.WorkflowPhases(), ","))
 	phaseEnableApprovalsStr := subFlags.String("phase_enable_approvals", strings.Join(resharding.WorkflowPhases(), ","), phaseEnableApprovalsDesc)
+	splitType := subFlags.String("split_type", splitTypeHorizontal, "Type of the workflows to create: horizontal (split/merge overlapping shards of the keyspace) or vertical (move tables from the keyspace this keyspace is served from)")
+	tables := subFlags.String("tables", "", "A comma-separated list of tables to move. Required for -split_type=vertical")
+	validateOnly := subFlags.Bool("validate_only", false, "If true, only run all pre-flight checks and report the results. No workflows will be created")
+	owner := subFlags.String("owner", "", "Who launched the resharding. It's shown in the UI")
+	oncall := subFlags.String("oncall", "", "Oncall contact for the resharding. It's shown in the UI")
+	estimate := subFlags.Bool("estimate", false, "If true, estimate the data volume of each task by querying the size of the source shards. The estimate is shown in the UI")
+	skipSplitDiff := subFlags.Bool("skip_split_diff", false, "If true, the horizontal resharding workflows skip the SplitDiff phase and the copied data is NOT verified. Only use this if the data is verified externally")
+	showAssignment := subFlags.String("show_assignment", "", "If set to table or csv, the assignment of source shards, destination shards and vtworkers of each task is shown in the UI in this format")
+	notifyWebhook := subFlags.String("notify_webhook", "", "If not empty, a JSON payload is POSTed to this URL when the workflow starts, completes or fails")
+	trackChildren := subFlags.Bool("track_children", false, "If true, the workflow does not finish after creating the child workflows. Instead, it polls their state, shows it in the UI and finishes when all child workflows are done. Requires -skip_start_workflows=false")
+	maxRunningChildren := subFlags.Int("max_running_children", 0, "If > 0, at most this many child workflows are running at the same time. Further child workflows are started when earlier ones finished. Requires -skip_start_workflows=false")
+	postHook := subFlags.String("post_hook", "", "If not empty, the name of a hook (in $VTROOT/vthook) which is executed after the workflow finished successfully. It's called with -keyspace, -uuid and -child_uuids")
+	postHookFatal := subFlags.Bool("post_hook_fatal", false, "If true, the workflow fails if the -post_hook fails. Otherwise, a failure is only shown as a warning")
+	generateRollbackPlan := subFlags.Bool("generate_rollback_plan", false, "If true, the commands which revert the served type migrations of the child workflows are shown in the UI and logged. They are not executed")
+	defaultSplitParallelism := subFlags.Int("default_split_parallelism", 0, "Number of concurrent writers per destination shard which the horizontal resharding workflows use during SplitClone. 0 uses the vtworker default")
+	splitParallelismStr := subFlags.String("split_parallelism", "", "A comma-separated list of shard=N overrides of -default_split_parallelism. An override applies to the task which has the shard as source or destination shard")
+	diffSamplePercent := subFlags.Int("diff_sample_percent", 100, "Percentage of the rows which the SplitDiff of the horizontal resharding workflows verifies. Values below 100 are faster, but the other rows are NOT verified")
+	diffCellsStr := subFlags.String("diff_cells", "", "A comma-separated list of cells. If set, the horizontal resharding workflows distribute their SplitDiff tasks across these cells")
+	minOverlapBytes := subFlags.Int64("min_overlap_bytes", 0, "If > 0, source shards with less data than this (as estimated by querying the size of the source shards) are excluded from the horizontal resharding. The excluded shards are logged")
+	vtworkerAssignment := subFlags.String("vtworker_assignment", vtworkerAssignmentContiguous, "How the -vtworkers are assigned to the tasks: contiguous (each task gets the next vtworkers of the list) or round_robin (the tasks get one vtworker per round until each has one per destination shard)")
+	discoveryCellsStr := subFlags.String("discovery_cells", "", "A comma-separated list of cells. If set, only source shards which are serving in at least one of these cells are split or merged")
+	discoveryStrategy := subFlags.String("discovery_strategy", defaultDiscoveryStrategy, "Name of the strategy which finds the source and destination shards of the horizontal resharding. The default pairs the overlapping shards. Other strategies can be registered with RegisterShardPairDiscoverer()")
+	explainDiscovery := subFlags.Bool("explain_discovery", false, "If true, the served types of the shards of each overlap and which side was chosen as source are shown in the UI and logged")
+	maxOverlaps := subFlags.Int("max_overlaps", defaultMaxOverlaps, "Maximum number of pairs of source and destination shards (i.e. child workflows). If more are found, the workflow is not created unless -force is set. 0 disables the limit")
+	perTaskTimeout := subFlags.Duration("per_task_timeout", 0, "If > 0, creating the child workflow of a task and starting it may each take at most this long. The wait for a free slot (-max_running_children) does not count. A task which times out fails and can be retried. The retry reuses the child workflow if it was created already. 0 disables the timeout")
+	planOut := subFlags.String("plan_out", "", "If set, the computed tasks and settings are written to this file in -keyspace_resharding_plan_dir in JSON when the workflow runs. The workflow does not create any child workflows. The plan can be reviewed and then used with -plan_in")
+	planIn := subFlags.String("plan_in", "", "If set, the tasks and settings are loaded from this file in -keyspace_resharding_plan_dir (written by -plan_out) instead of being computed. All other flags are ignored")
+	minDestinationReplicas := subFlags.Int("min_destination_replicas", 0, "If > 0, each destination shard must have at least this many replica tablets in the topology. Otherwise, the workflow is not created. 0 disables the check")
+	childFactory := subFlags.String("child_factory", horizontalReshardingFactoryName, "Name of the registered workflow factory which is used to create the horizontal resharding workflows. It must accept the same parameters as horizontal_resharding. Use this to plug in a customized child workflow")
+	dependenciesStr := subFlags.String("dependencies", "", "A comma-separated list of shard>shard pairs. The task which has the first shard as source or destination shard creates and starts its child workflow before the task of the second shard. The first child workflow is not waited for and usually still running when the second one starts. Tasks without dependencies keep the order in which they were discovered")
+	force := subFlags.Bool("force", false, "If true, the workflow is created even if more than -max_overlaps pairs of source and destination shards were found")
 
 	if err := subFlags.Parse(args); err != nil {
 		return err
 	}
+	if *planIn != "" {
+		if *planOut != "" {
+			return newError(ErrInvalidArguments, "plan_in and plan_out cannot be used together")
+		}
+		return initFromPlan(m, w, *planIn)
+	}
+	if *planOut != "" {
+		if _, err := planPath(*planOut); err != nil {
+			return err
+		}
+	}
 	if *keyspace == "" || *vtworkersStr == "" || *minHealthyRdonlyTablets == "" || *splitCmd == "" {
-		return fmt.Errorf("keyspace name, min healthy rdonly tablets, split command, and vtworkers information must be provided for horizontal resharding")
+		return newError(ErrInvalidArguments, "keyspace name, min healthy rdonly tablets, split command, and vtworkers information must be provided for horizontal resharding")
+	}
+	switch *splitType {
+	case splitTypeHorizontal:
+	case splitTypeVertical:
+		if *tables == "" {
+			return newError(ErrInvalidArguments, "tables must be provided for a vertical split")
+		}
+		if *skipSplitDiff {
+			return newError(ErrInvalidArguments, "skip_split_diff is only supported for horizontal resharding")
+		}
+		if *defaultSplitParallelism != 0 || *splitParallelismStr != "" {
+			return newError(ErrInvalidArguments, "default_split_parallelism and split_parallelism are only supported for horizontal resharding")
+		}
+		if *diffCellsStr != "" {
+			return newError(ErrInvalidArguments, "diff_cells is only supported for horizontal resharding")
+		}
+		if *diffSamplePercent != 100 {
+			return newError(ErrInvalidArguments, "diff_sample_percent is only supported for horizontal resharding")
+		}
+		if *discoveryCellsStr != "" {
+			return newError(ErrInvalidArguments, "discovery_cells is only supported for horizontal resharding")
+		}
+		if *discoveryStrategy != defaultDiscoveryStrategy {
+			return newError(ErrInvalidArguments, "discovery_strategy is only supported for horizontal resharding")
+		}
+		if *explainDiscovery {
+			return newError(ErrInvalidArguments, "explain_discovery is only supported for horizontal resharding")
+		}
+		if *minOverlapBytes != 0 {
+			return newError(ErrInvalidArguments, "min_overlap_bytes is only supported for horizontal resharding")
+		}
+		if *childFactory != horizontalReshardingFactoryName {
+			return newError(ErrInvalidArguments, "child_factory is only supported for horizontal resharding")
+		}
+		if *dependenciesStr != "" {
+			return newError(ErrInvalidArguments, "dependencies is only supported for horizontal resharding")
+		}
+	default:
+		return newError(ErrInvalidArguments, "invalid split_type: %v (must be %v or %v)", *splitType, splitTypeHorizontal, splitTypeVertical)
+	}
+
+	if *maxRunningChildren < 0 {
+		return newError(ErrInvalidArguments, "invalid max_running_children: %v (must be >= 0)", *maxRunningChildren)
+	}
+	if *maxRunningChildren > 0 && *skipStartWorkflows {
+		return newError(ErrInvalidArguments, "max_running_children requires that skip_start_workflows is false")
+	}
+	if *trackChildren && *skipStartWorkflows {
+		// The child workflows would never finish because nobody starts them.
+		return newError(ErrInvalidArguments, "track_children requires that skip_start_workflows is false")
+	}
+	if *minDestinationReplicas < 0 {
+		return newError(ErrInvalidArguments, "invalid min_destination_replicas: %v (must be >= 0)", *minDestinationReplicas)
+	}
+	if *childFactory == "" {
+		return newError(ErrInvalidArguments, "child_factory must not be empty")
+	}
+	if *perTaskTimeout < 0 {
+		return newError(ErrInvalidArguments, "invalid per_task_timeout: %v (must be >= 0)", *perTaskTimeout)
+	}
+	if *maxOverlaps < 0 {
+		return newError(ErrInvalidArguments, "invalid max_overlaps: %v (must be >= 0)", *maxOverlaps)
+	}
+	if *minOverlapBytes < 0 {
+		return newError(ErrInvalidArguments, "invalid min_overlap_bytes: %v (must be >= 0)", *minOverlapBytes)
+	}
+	if *defaultSplitParallelism < 0 {
+		return newError(ErrInvalidArguments, "invalid default_split_parallelism: %v (must be >= 0)", *defaultSplitParallelism)
+	}
+	splitParallelism, err := parseSplitParallelism(*splitParallelismStr)
+	if err != nil {
+		return err
+	}
+	dependencies, err := parseDependencies(*dependenciesStr)
+	if err != nil {
+		return err
+	}
+	if *diffCellsStr != "" && *skipSplitDiff {
+		return newError(ErrInvalidArguments, "diff_cells cannot be used with skip_split_diff")
+	}
+	if *diffSamplePercent < 1 || *diffSamplePercent > 100 {
+		return newError(ErrInvalidArguments, "invalid diff_sample_percent: %v (must be between 1 and 100)", *diffSamplePercent)
+	}
+	if *diffSamplePercent < 100 && *skipSplitDiff {
+		return newError(ErrInvalidArguments, "diff_sample_percent cannot be used with skip_split_diff")
+	}
+	if *explainDiscovery && *discoveryStrategy != defaultDiscoveryStrategy {
+		return newError(ErrInvalidArguments, "explain_discovery is only supported with discovery_strategy=%v", defaultDiscoveryStrategy)
+	}
+	if strings.Contains(*postHook, "/") {
+		return newError(ErrInvalidArguments, "invalid post_hook: %v (must be the name of a hook and not a path)", *postHook)
+	}
+
+	switch *vtworkerAssignment {
+	case vtworkerAssignmentContiguous, vtworkerAssignmentRoundRobin:
+	default:
+		return newError(ErrInvalidArguments, "invalid vtworker_assignment: %v (must be %v or %v)", *vtworkerAssignment, vtworkerAssignmentContiguous, vtworkerAssignmentRoundRobin)
+	}
+
+	switch *showAssignment {
+	case "", assignmentFormatTable, assignmentFormatCSV:
+	default:
+		return newError(ErrInvalidArguments, "invalid show_assignment: %v (must be %v or %v)", *showAssignment, assignmentFormatTable, assignmentFormatCSV)
+	}
+
+	discoverer, err := shardPairDiscoverer(*discoveryStrategy)
+	if err != nil {
+		return err
 	}
 
 	vtworkers := strings.Split(*vtworkersStr, ",")
 
+	if err := checkKeyspaceExists(context.Background(), m.TopoServer(), *keyspace); err != nil {
+		return err
+	}
+	if *splitType == splitTypeHorizontal {
+		if err := checkKeyspaceSharded(context.Background(), m.TopoServer(), *keyspace); err != nil {
+			return err
+		}
+	}
+
+	if *validateOnly {
+		if *splitType != splitTypeHorizontal {
+			return newError(ErrInvalidArguments, "validate_only is only supported for horizontal resharding")
+		}
+		if *planOut != "" {
+			return newError(ErrInvalidArguments, "plan_out cannot be used with validate_only")
+		}
+		w.Name = fmt.Sprintf("Keyspace reshard validation on %s", *keyspace)
+		var cells []string
+		if *diffCellsStr != "" {
+			cells = append(cells, strings.Split(*diffCellsStr, ",")...)
+		}
+		if *discoveryCellsStr != "" {
+			cells = append(cells, strings.Split(*discoveryCellsStr, ",")...)
+		}
+		checkpoint := initValidateOnlyCheckpoint(&preflightParams{
+			ts:                      m.TopoServer(),
+			discoverer:              discoverer,
+			keyspace:                *keyspace,
+			vtworkers:               vtworkers,
+			minHealthyRdonlyTablets: *minHealthyRdonlyTablets,
+			minDestinationReplicas:  *minDestinationReplicas,
+			splitCmd:                *splitCmd,
+			cells:                   cells,
+			maxOverlaps:             *maxOverlaps,
+			force:                   *force,
+		})
+		setCommonSettings(checkpoint, *owner, *oncall, *notifyWebhook)
+		w.Data, err = proto.Marshal(checkpoint)
+		return err
+	}
+
+	if *splitType == splitTypeVertical {
+		w.Name = fmt.Sprintf("Keyspace vertical split on %s", *keyspace)
+		sourceKeyspace, shardsToSplit, err := findVerticalSplitShards(m.TopoServer(), *keyspace)
+		if err != nil {
+			return err
+		}
+		if err := checkMaxOverlaps(*keyspace, shardsToSplit, *maxOverlaps, *force); err != nil {
+			return err
+		}
+		if err := checkMinDestinationReplicas(context.Background(), m.TopoServer(), *keyspace, shardsToSplit, *minDestinationReplicas); err != nil {
+			return err
+		}
+		checkpoint, err := initVerticalSplitCheckpoint(
+			sourceKeyspace,
+			*keyspace,
+			vtworkers,
+			shardsToSplit,
+			strings.Split(*tables, ","),
+			*minHealthyRdonlyTablets,
+			*phaseEnableApprovalsStr,
+			*skipStartWorkflows,
+		)
+		if err != nil {
+			return err
+		}
+		if *vtworkerAssignment == vtworkerAssignmentRoundRobin {
+			assignVtworkersRoundRobin(checkpoint)
+		}
+		if *estimate {
+			if err := estimateTasks(context.Background(), m.TopoServer(), sourceKeyspace, strings.Split(*tables, ","), checkpoint); err != nil {
+				return err
+			}
+		}
+		setCommonSettings(checkpoint, *owner, *oncall, *notifyWebhook)
+		checkpoint.Settings["show_assignment"] = *showAssignment
+		checkpoint.Settings["track_children"] = fmt.Sprintf("%v", *trackChildren)
+		checkpoint.Settings["max_running_children"] = strconv.Itoa(*maxRunningChildren)
+		checkpoint.Settings["per_task_timeout"] = perTaskTimeout.String()
+		setPostHookSettings(checkpoint, *postHook, *postHookFatal)
+		if *generateRollbackPlan {
+			setRollbackPlan(checkpoint, rollbackPlan(*keyspace, splitTypeVertical, shardsToSplit))
+		}
+		if *planOut != "" {
+			checkpoint.Settings["plan_out"] = *planOut
+		}
+		w.Data, err = proto.Marshal(checkpoint)
+		return err
+	}
+
 	w.Name = fmt.Sprintf("Keyspace reshard on %s", *keyspace)
-	shardsToSplit, err := findSourceAndDestinationShards(m.TopoServer(), *keyspace)
+	if err := checkSplitCmd(*splitCmd); err != nil {
+		return err
+	}
+	if *diffCellsStr != "" {
+		if err := checkCellsExist(context.Background(), m.TopoServer(), strings.Split(*diffCellsStr, ",")); err != nil {
+			return err
+		}
+	}
+	if err := checkDestinationCoverage(context.Background(), m.TopoServer(), *keyspace); err != nil {
+		return err
+	}
+	var shardsToSplit [][][]string
+	var discoveryExplanation []string
+	if *explainDiscovery {
+		shardsToSplit, discoveryExplanation, err = explainSourceAndDestinationShards(m.TopoServer(), *keyspace)
+	} else {
+		shardsToSplit, err = discoverer.DiscoverShardPairs(context.Background(), m.TopoServer(), *keyspace)
+	}
 	if err != nil {
 		return err
 	}
+	shardsToSplit, err = skipMigratedOverlaps(context.Background(), m.TopoServer(), *keyspace, shardsToSplit)
+	if err != nil {
+		return err
+	}
+	if *discoveryCellsStr != "" {
+		discoveryCells := strings.Split(*discoveryCellsStr, ",")
+		if err := checkCellsExist(context.Background(), m.TopoServer(), discoveryCells); err != nil {
+			return err
+		}
+		shardsToSplit, err = filterShardsByServingCells(context.Background(), m.TopoServer(), *keyspace, shardsToSplit, discoveryCells)
+		if err != nil {
+			return err
+		}
+	}
+	if *minOverlapBytes > 0 {
+		shardsToSplit, err = filterSmallOverlaps(context.Background(), m.TopoServer(), *keyspace, shardsToSplit, uint64(*minOverlapBytes))
+		if err != nil {
+			return err
+		}
+	}
+	if err := checkMaxOverlaps(*keyspace, shardsToSplit, *maxOverlaps, *force); err != nil {
+		return err
+	}
+	if err := checkMinDestinationReplicas(context.Background(), m.TopoServer(), *keyspace, shardsToSplit, *minDestinationReplicas); err != nil {
+		return err
+	}
 
 	checkpoint, err := initCheckpoint(
 		*keyspace,
@@ -93,6 +413,45 @@ func (*Factory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string)
 	if err != nil {
 		return err
 	}
+	if *vtworkerAssignment == vtworkerAssignmentRoundRobin {
+		assignVtworkersRoundRobin(checkpoint)
+	}
+	if *estimate {
+		if err := estimateTasks(context.Background(), m.TopoServer(), *keyspace, nil /* tables */, checkpoint); err != nil {
+			return err
+		}
+	}
+	if err := setSplitParallelism(checkpoint, *defaultSplitParallelism, splitParallelism); err != nil {
+		return err
+	}
+	if err := setTaskOrder(checkpoint, dependencies); err != nil {
+		return err
+	}
+	setCommonSettings(checkpoint, *owner, *oncall, *notifyWebhook)
+	checkpoint.Settings["show_assignment"] = *showAssignment
+	checkpoint.Settings["skip_split_diff"] = fmt.Sprintf("%v", *skipSplitDiff)
+	checkpoint.Settings["diff_cells"] = *diffCellsStr
+	checkpoint.Settings["diff_sample_percent"] = strconv.Itoa(*diffSamplePercent)
+	checkpoint.Settings["track_children"] = fmt.Sprintf("%v", *trackChildren)
+	checkpoint.Settings["max_running_children"] = strconv.Itoa(*maxRunningChildren)
+	checkpoint.Settings["per_task_timeout"] = perTaskTimeout.String()
+	checkpoint.Settings["child_factory"] = *childFactory
+	setPostHookSettings(checkpoint, *postHook, *postHookFatal)
+	if *generateRollbackPlan {
+		setRollbackPlan(checkpoint, rollbackPlan(*keyspace, splitTypeHorizontal, shardsToSplit))
+	}
+	if *explainDiscovery {
+		setDiscoveryExplanation(checkpoint, discoveryExplanation)
+	}
+	if *skipSplitDiff {
+		log.Warningf("Keyspace resharding of keyspace %v: SplitDiff is skipped. The copied data will not be verified.", *keyspace)
+	}
+	if *diffSamplePercent < 100 {
+		log.Warningf("Keyspace resharding of keyspace %v: SplitDiff only verifies a SAMPLE of %v%% of the rows. The other rows will not be verified.", *keyspace, *diffSamplePercent)
+	}
+	if *planOut != "" {
+		checkpoint.Settings["plan_out"] = *planOut
+	}
 
 	w.Data, err = proto.Marshal(checkpoint)
 	if err != nil {
@@ -101,6 +460,77 @@ func (*Factory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string)
 	return nil
 }
 
+// initFromPlan initializes the workflow from the plan at "planIn" (-plan_in).
+// The tasks are checked against the current topology before anything is
+// created.
+func initFromPlan(m *workflow.Manager, w *workflowpb.Workflow, planIn string) error {
+	planFile, err := planPath(planIn)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := readPlan(planFile)
+	if err != nil {
+		return err
+	}
+	keyspace := checkpoint.Settings["keyspace"]
+	if err := checkKeyspaceExists(context.Background(), m.TopoServer(), keyspace); err != nil {
+		return err
+	}
+	if checkpoint.Settings["split_type"] != splitTypeVertical {
+		if err := checkKeyspaceSharded(context.Background(), m.TopoServer(), keyspace); err != nil {
+			return err
+		}
+	}
+	if err := checkPlanTopology(context.Background(), m.TopoServer(), checkpoint); err != nil {
+		return err
+	}
+	if checkpoint.Settings["split_type"] == splitTypeVertical {
+		w.Name = fmt.Sprintf("Keyspace vertical split on %s", keyspace)
+	} else {
+		w.Name = fmt.Sprintf("Keyspace reshard on %s", keyspace)
+	}
+	log.Infof("Keyspace resharding of keyspace %v: loaded %v tasks from plan: %v", keyspace, len(checkpoint.Tasks), planIn)
+	w.Data, err = proto.Marshal(checkpoint)
+	return err
+}
+
+// setCommonSettings records the settings which apply to all kinds of keyspace
+// resharding workflows: who launched the workflow, whom to contact and where
+// to send notifications to.
+func setCommonSettings(checkpoint *workflowpb.WorkflowCheckpoint, owner, oncall, notifyWebhook string) {
+	checkpoint.Settings["owner"] = owner
+	checkpoint.Settings["oncall"] = oncall
+	checkpoint.Settings["notify_webhook"] = notifyWebhook
+	if owner != "" || oncall != "" {
+		log.Infof("Keyspace resharding workflow for keyspace %v launched by owner: %v oncall: %v", checkpoint.Settings["keyspace"], owner, oncall)
+	}
+}
+
+// setPostHookSettings records the -post_hook and whether its failure is fatal.
+func setPostHookSettings(checkpoint *workflowpb.WorkflowCheckpoint, postHook string, postHookFatal bool) {
+	checkpoint.Settings["post_hook"] = postHook
+	checkpoint.Settings["post_hook_fatal"] = fmt.Sprintf("%v", postHookFatal)
+}
+
+// setRollbackPlan records the -generate_rollback_plan output and logs it.
+func setRollbackPlan(checkpoint *workflowpb.WorkflowCheckpoint, plan []string) {
+	checkpoint.Settings["rollback_plan"] = strings.Join(plan, "\n")
+	log.Infof("Keyspace resharding rollback plan for keyspace %v (NOT executed):\n%v", checkpoint.Settings["keyspace"], checkpoint.Settings["rollback_plan"])
+}
+
+// ownerMessage returns the owner and oncall contact for the UI. It's empty
+// if neither was specified.
+func ownerMessage(owner, oncall string) string {
+	var parts []string
+	if owner != "" {
+		parts = append(parts, "Owner: "+owner)
+	}
+	if oncall != "" {
+		parts = append(parts, "Oncall: "+oncall)
+	}
+	return strings.Join(parts, ", ")
+}
+
 // Instantiate is part the workflow.Factory interface.
 func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNode *workflow.Node) (workflow.Workflow, error) {
 	rootNode.Message = "This is a workflow to execute a keyspace resharding automatically."
@@ -114,11 +544,40 @@ func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNod
 	if err != nil {
 		return nil, err
 	}
+	if err := checkTasks(checkpoint, workflowsCount); err != nil {
+		return nil, err
+	}
+	if err := applyVtworkersOverride(checkpoint); err != nil {
+		return nil, err
+	}
+	// The setting is missing in checkpoints which were created before
+	// -dependencies was supported. They use the index order.
+	taskOrder, err := parseTaskOrder(checkpoint.Settings["task_order"], workflowsCount)
+	if err != nil {
+		return nil, err
+	}
+	// The setting is missing in checkpoints which were created before
+	// -max_running_children was supported.
+	maxRunningChildren := 0
+	if v := checkpoint.Settings["max_running_children"]; v != "" {
+		if maxRunningChildren, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	// The setting is missing in checkpoints which were created before
+	// -per_task_timeout was supported.
+	var perTaskTimeout time.Duration
+	if v := checkpoint.Settings["per_task_timeout"]; v != "" {
+		if perTaskTimeout, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
 
 	hw := &reshardingWorkflowGen{
 		checkpoint:                   checkpoint,
 		rootUINode:                   rootNode,
 		logger:                       logutil.NewMemoryLogger(),
+		taskLoggers:                  make(map[*workflow.Node]*logutil.MemoryLogger),
 		topoServer:                   m.TopoServer(),
 		manager:                      m,
 		phaseEnableApprovalsParam:    checkpoint.Settings["phase_enable_approvals"],
@@ -127,7 +586,56 @@ func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNod
 		keyspaceParam:                checkpoint.Settings["keyspace"],
 		splitDiffDestTabletTypeParam: checkpoint.Settings["split_diff_dest_tablet_type"],
 		splitCmdParam:                checkpoint.Settings["split_cmd"],
+		splitTypeParam:               checkpoint.Settings["split_type"],
+		sourceKeyspaceParam:          checkpoint.Settings["source_keyspace"],
+		validationReportParam:        checkpoint.Settings["validation_report"],
+		notifyWebhookParam:           checkpoint.Settings["notify_webhook"],
+		skipSplitDiffParam:           checkpoint.Settings["skip_split_diff"] == "true",
+		diffCellsParam:               checkpoint.Settings["diff_cells"],
+		diffSamplePercentParam:       checkpoint.Settings["diff_sample_percent"],
+		trackChildrenParam:           checkpoint.Settings["track_children"] == "true",
+		trackChildrenInterval:        trackChildrenInterval,
+		now:                          time.Now,
+		maxRunningChildrenParam:      maxRunningChildren,
+		perTaskTimeoutParam:          perTaskTimeout,
+		childFactoryParam:            checkpoint.Settings["child_factory"],
+		postHookParam:                checkpoint.Settings["post_hook"],
+		postHookFatalParam:           checkpoint.Settings["post_hook_fatal"] == "true",
+		hookRunner:                   (*hook.Hook).Execute,
+		structuredLogf:               log.Infof,
 		workflowsCount:               workflowsCount,
+		taskOrder:                    taskOrder,
+	}
+	hw.childWorkflowReader = hw.readChildWorkflow
+	hw.childStarter = m.Start
+	hw.childStopper = m.Stop
+	hw.childDeleter = m.Delete
+	if msg := ownerMessage(checkpoint.Settings["owner"], checkpoint.Settings["oncall"]); msg != "" {
+		rootNode.Message += " " + msg
+	}
+	if hw.skipSplitDiffParam {
+		rootNode.Message += "\n" + skipSplitDiffWarning
+	}
+	if hw.diffSamplePercentParam != "" && hw.diffSamplePercentParam != "100" {
+		rootNode.Message += "\n" + fmt.Sprintf(diffSampleWarning, hw.diffSamplePercentParam)
+	}
+	if format := checkpoint.Settings["show_assignment"]; format != "" {
+		assignment, err := formatAssignment(format, checkpoint.Tasks)
+		if err != nil {
+			return nil, err
+		}
+		rootNode.Message += "\nTask assignment:\n" + assignment
+	}
+	if plan := checkpoint.Settings["rollback_plan"]; plan != "" {
+		rootNode.Message += "\nRollback plan (NOT executed):\n" + plan
+	}
+	if explanation := checkpoint.Settings["discovery_explanation"]; explanation != "" {
+		rootNode.Message += "\nShard discovery:\n" + explanation
+	}
+	hw.planOutParam = checkpoint.Settings["plan_out"]
+	if checkpoint.Settings["validate_only"] == "true" {
+		hw.validateOnly = true
+		hw.validationPassed = checkpoint.Settings["validation_passed"] == "true"
 	}
 	createWorkflowsUINode := &workflow.Node{
 		Name:     "CreateWorkflows",
@@ -145,46 +653,286 @@ func (*Factory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNod
 	for i := 0; i < workflowsCount; i++ {
 		taskID := fmt.Sprintf("%s/%v", phaseName, i)
 		task := hw.checkpoint.Tasks[taskID]
+		name := fmt.Sprintf("Split shards %v to %v workflow creation", task.Attributes["source_shards"], task.Attributes["destination_shards"])
+		if hw.splitTypeParam == splitTypeVertical {
+			name = fmt.Sprintf("Vertical split of tables %v from %v/%v to %v/%v workflow creation", task.Attributes["tables"], hw.sourceKeyspaceParam, task.Attributes["source_shards"], hw.keyspaceParam, task.Attributes["destination_shards"])
+		}
 		taskUINode := &workflow.Node{
-			Name:     fmt.Sprintf("Split shards %v to %v workflow creation", task.Attributes["source_shards"], task.Attributes["destination_shards"]),
+			Name:     name,
 			PathName: fmt.Sprintf("%v", i),
+			Message:  estimateMessage(task),
 		}
 		phaseNode.Children = append(phaseNode.Children, taskUINode)
+		hw.taskLoggers[taskUINode] = logutil.NewMemoryLogger()
 	}
 	return hw, nil
 }
 
+// checkTasks verifies that the checkpoint has a task for each of the
+// "workflowsCount" workflows and no other tasks in the phase.
+func checkTasks(checkpoint *workflowpb.WorkflowCheckpoint, workflowsCount int) error {
+	tasks := 0
+	for taskID := range checkpoint.Tasks {
+		if strings.HasPrefix(taskID, phaseName+"/") {
+			tasks++
+		}
+	}
+	if tasks != workflowsCount {
+		return newError(ErrInvalidCheckpoint, "checkpoint has %v tasks in phase %v, but workflows_count is %v", tasks, phaseName, workflowsCount)
+	}
+	for i := 0; i < workflowsCount; i++ {
+		taskID := fmt.Sprintf("%s/%v", phaseName, i)
+		if _, ok := checkpoint.Tasks[taskID]; !ok {
+			return newError(ErrInvalidCheckpoint, "checkpoint has no task %v (workflows_count is %v)", taskID, workflowsCount)
+		}
+	}
+	return nil
+}
+
+// checkKeyspaceExists returns ErrKeyspaceNotFound if the keyspace does not
+// exist. Otherwise, the shard discovery would only report that there are no
+// overlapping shards.
+func checkKeyspaceExists(ctx context.Context, ts *topo.Server, keyspace string) error {
+	if _, err := ts.GetKeyspace(ctx, keyspace); err != nil {
+		if topo.IsErrType(err, topo.NoNode) {
+			return newError(ErrKeyspaceNotFound, "keyspace %v not found", keyspace)
+		}
+		return wrapError(ErrTopo, err)
+	}
+	return nil
+}
+
+// checkKeyspaceSharded returns ErrKeyspaceNotSharded if "keyspace" cannot be
+// resharded horizontally.
+// Both split commands must compute the keyspace ID of each copied row. The
+// vtworker uses either the sharding column of the keyspace (v2) or the
+// primary vindex of a sharded VSchema (v3, -use_v3_resharding_mode). If the
+// keyspace has neither, the child workflows would only fail during the copy.
+func checkKeyspaceSharded(ctx context.Context, ts *topo.Server, keyspace string) error {
+	ki, err := ts.GetKeyspace(ctx, keyspace)
+	if err != nil {
+		return wrapError(ErrTopo, err)
+	}
+	hasShardingColumn := ki.ShardingColumnName != "" && ki.ShardingColumnType != topodatapb.KeyspaceIdType_UNSET
+
+	hasShardedVSchema := false
+	vschema, err := ts.GetVSchema(ctx, keyspace)
+	switch {
+	case err == nil:
+		hasShardedVSchema = vschema.Sharded
+	case topo.IsErrType(err, topo.NoNode):
+	default:
+		return wrapError(ErrTopo, err)
+	}
+
+	if !hasShardingColumn && !hasShardedVSchema {
+		return newError(ErrKeyspaceNotSharded, "keyspace %v is not configured for sharding: it has neither a sharding column nor a sharded VSchema. The split commands cannot compute its keyspace IDs", keyspace)
+	}
+	if !hasShardingColumn {
+		log.Warningf("Keyspace resharding of keyspace %v: the vtworkers must run with -use_v3_resharding_mode because the keyspace has no sharding column.", keyspace)
+	}
+	return nil
+}
+
+// checkCellsExist returns ErrInvalidArguments if one of the cells does not
+// exist.
+func checkCellsExist(ctx context.Context, ts *topo.Server, cells []string) error {
+	knownCells, err := ts.GetCellInfoNames(ctx)
+	if err != nil {
+		return wrapError(ErrTopo, err)
+	}
+	known := make(map[string]bool)
+	for _, cell := range knownCells {
+		known[cell] = true
+	}
+	for _, cell := range cells {
+		if !known[cell] {
+			return newError(ErrInvalidArguments, "cell %v not found (known cells: %v)", cell, strings.Join(knownCells, ","))
+		}
+	}
+	return nil
+}
+
+// findSourceAndDestinationShards pairs the overlapping shards of the keyspace.
+// It's used by the default -discovery_strategy.
 func findSourceAndDestinationShards(ts *topo.Server, keyspace string) ([][][]string, error) {
+	shardsToSplit, _, err := explainSourceAndDestinationShards(ts, keyspace)
+	return shardsToSplit, err
+}
+
+// explainSourceAndDestinationShards is findSourceAndDestinationShards, but it
+// also returns one line per overlap which explains the decision
+// (-explain_discovery): the served types of each side and which side was
+// chosen as source.
+func explainSourceAndDestinationShards(ts *topo.Server, keyspace string) ([][][]string, []string, error) {
 	overlappingShards, err := topotools.FindOverlappingShards(context.Background(), ts, keyspace)
 	if err != nil {
-		return nil, err
+		return nil, nil, wrapError(ErrTopo, err)
 	}
 
 	var shardsToSplit [][][]string
+	var explanation []string
 
-	for _, os := range overlappingShards {
+	for i, os := range overlappingShards {
 		var sourceShards, destinationShards []string
 		var sourceShardInfo *topo.ShardInfo
 		var destinationShardInfos []*topo.ShardInfo
 		// Judge which side is source shard by checking the number of servedTypes.
 		leftServingTypes, err := ts.GetShardServingTypes(context.Background(), os.Left[0])
 		if err != nil {
-			return nil, err
+			return nil, nil, wrapError(ErrTopo, err)
+		}
+		leftSide, err := describeServedTypes(ts, os.Left)
+		if err != nil {
+			return nil, nil, err
+		}
+		rightSide, err := describeServedTypes(ts, os.Right)
+		if err != nil {
+			return nil, nil, err
 		}
+		var decision string
 		if len(leftServingTypes) > 0 {
 			sourceShardInfo = os.Left[0]
 			destinationShardInfos = os.Right
+			decision = fmt.Sprintf("left side is the source because left shard %v serves %v", os.Left[0].ShardName(), leftServingTypes)
 		} else {
 			sourceShardInfo = os.Right[0]
 			destinationShardInfos = os.Left
+			decision = fmt.Sprintf("right side is the source because left shard %v serves no types", os.Left[0].ShardName())
 		}
 		sourceShards = append(sourceShards, sourceShardInfo.ShardName())
 		for _, d := range destinationShardInfos {
 			destinationShards = append(destinationShards, d.ShardName())
 		}
 		shardsToSplit = append(shardsToSplit, [][]string{sourceShards, destinationShards})
+		explanation = append(explanation, fmt.Sprintf("overlap %v: left %v, right %v: %v", i, leftSide, rightSide, decision))
+	}
+	return shardsToSplit, explanation, nil
+}
+
+// describeServedTypes returns the served types of each shard for the
+// -explain_discovery output, e.g. "[-80 serves [MASTER REPLICA]]".
+func describeServedTypes(ts *topo.Server, shards []*topo.ShardInfo) (string, error) {
+	var parts []string
+	for _, si := range shards {
+		servingTypes, err := ts.GetShardServingTypes(context.Background(), si)
+		if err != nil {
+			return "", wrapError(ErrTopo, err)
+		}
+		parts = append(parts, fmt.Sprintf("%v serves %v", si.ShardName(), servingTypes))
+	}
+	return "[" + strings.Join(parts, ", ") + "]", nil
+}
+
+// setDiscoveryExplanation records the -explain_discovery output and logs it.
+func setDiscoveryExplanation(checkpoint *workflowpb.WorkflowCheckpoint, explanation []string) {
+	checkpoint.Settings["discovery_explanation"] = strings.Join(explanation, "\n")
+	log.Infof("Keyspace resharding shard discovery for keyspace %v:\n%v", checkpoint.Settings["keyspace"], checkpoint.Settings["discovery_explanation"])
+}
+
+// checkMaxOverlaps returns an error if there are more than "maxOverlaps"
+// pairs of source and destination shards. Each pair results in a child
+// workflow. With "force", the limit is only logged. A "maxOverlaps" of 0
+// disables the check.
+func checkMaxOverlaps(keyspace string, shardsToSplit [][][]string, maxOverlaps int, force bool) error {
+	if maxOverlaps == 0 || len(shardsToSplit) <= maxOverlaps {
+		return nil
+	}
+	if force {
+		log.Warningf("Keyspace resharding of keyspace %v: found %v pairs of source and destination shards which is more than -max_overlaps=%v. Continuing because -force is set.", keyspace, len(shardsToSplit), maxOverlaps)
+		return nil
+	}
+	return newError(ErrTooManyOverlaps, "found %v pairs of source and destination shards in keyspace %v which is more than -max_overlaps=%v. Set -force to create that many child workflows anyway", len(shardsToSplit), keyspace, maxOverlaps)
+}
+
+// filterShardsByServingCells returns the pairs of source and destination
+// shards whose source shards are serving in at least one of "cells".
+func filterShardsByServingCells(ctx context.Context, ts *topo.Server, keyspace string, shardsToSplit [][][]string, cells []string) ([][][]string, error) {
+	wanted := make(map[string]bool)
+	for _, cell := range cells {
+		wanted[cell] = true
+	}
+	var result [][][]string
+	for _, shardToSplit := range shardsToSplit {
+		serving := false
+		for _, shard := range shardToSplit[0] {
+			si, err := ts.GetShard(ctx, keyspace, shard)
+			if err != nil {
+				return nil, wrapError(ErrTopo, err)
+			}
+			servingCells, err := ts.GetShardServingCells(ctx, si)
+			if err != nil {
+				return nil, wrapError(ErrTopo, err)
+			}
+			for _, cell := range servingCells {
+				if wanted[cell] {
+					serving = true
+				}
+			}
+		}
+		if serving {
+			result = append(result, shardToSplit)
+		}
+	}
+	return result, nil
+}
+
+// findVerticalSplitShards returns the keyspace from which "keyspace" is
+// (partially) served and the pairs of source and destination shards.
+// For each destination shard, the source keyspace must have a shard with the
+// same name.
+func findVerticalSplitShards(ts *topo.Server, keyspace string) (string, [][][]string, error) {
+	ki, err := ts.GetKeyspace(context.Background(), keyspace)
+	if err != nil {
+		return "", nil, wrapError(ErrTopo, err)
+	}
+	if len(ki.ServedFroms) == 0 {
+		return "", nil, newError(ErrNotServedFrom, "keyspace %v is not served from any other keyspace", keyspace)
+	}
+	sourceKeyspace := ki.ServedFroms[0].Keyspace
+
+	destinationShards, err := ts.GetShardNames(context.Background(), keyspace)
+	if err != nil {
+		return "", nil, wrapError(ErrTopo, err)
+	}
+	sourceShards, err := ts.GetShardNames(context.Background(), sourceKeyspace)
+	if err != nil {
+		return "", nil, wrapError(ErrTopo, err)
+	}
+	sourceShardSet := make(map[string]bool)
+	for _, s := range sourceShards {
+		sourceShardSet[s] = true
+	}
+
+	sort.Strings(destinationShards)
+	var shardsToSplit [][][]string
+	for _, d := range destinationShards {
+		if !sourceShardSet[d] {
+			return "", nil, newError(ErrNoOverlappingShards, "source keyspace %v has no shard %v which matches the destination shard of keyspace %v", sourceKeyspace, d, keyspace)
+		}
+		shardsToSplit = append(shardsToSplit, [][]string{{d}, {d}})
+	}
+	return sourceKeyspace, shardsToSplit, nil
+}
+
+// initValidateOnlyCheckpoint runs all pre-flight checks and returns a
+// checkpoint without any tasks which only records the results.
+func initValidateOnlyCheckpoint(p *preflightParams) *workflowpb.WorkflowCheckpoint {
+	results := runPreflightChecks(context.Background(), p)
+	report, passed := preflightReport(results)
+	log.Infof("Keyspace resharding pre-flight checks for keyspace %v (passed: %v):\n%v", p.keyspace, passed, report)
+
+	return &workflowpb.WorkflowCheckpoint{
+		CodeVersion: codeVersion,
+		Tasks:       make(map[string]*workflowpb.Task),
+		Settings: map[string]string{
+			"keyspace":          p.keyspace,
+			"workflows_count":   "0",
+			"validate_only":     "true",
+			"validation_passed": fmt.Sprintf("%v", passed),
+			"validation_report": report,
+		},
 	}
-	return shardsToSplit, nil
 }
 
 // initCheckpoint initialize the checkpoint for keyspace reshard
@@ -196,15 +944,15 @@ func initCheckpoint(keyspace string, vtworkers []string, shardsToSplit [][][]str
 		destShards = destShards + len(shardToSplit[1])
 	}
 	if sourceShards == 0 || destShards == 0 {
-		return nil, fmt.Errorf("invalid source or destination shards")
+		return nil, newError(ErrNoOverlappingShards, "invalid source or destination shards")
 	}
 	if len(vtworkers) != destShards {
-		return nil, fmt.Errorf("there are %v vtworkers, %v destination shards: the number should be same", len(vtworkers), destShards)
+		return nil, newError(ErrVtworkerCountMismatch, "there are %v vtworkers, %v destination shards: the number should be same", len(vtworkers), destShards)
 	}
 
 	splitRatio := destShards / sourceShards
 	if minHealthyRdonlyTabletsVal, err := strconv.Atoi(minHealthyRdonlyTablets); err != nil || minHealthyRdonlyTabletsVal < splitRatio {
-		return nil, fmt.Errorf("there are not enough rdonly tablets in source shards. You need at least %v, it got: %v", splitRatio, minHealthyRdonlyTablets)
+		return nil, newError(ErrNotEnoughRdonlyTablets, "there are not enough rdonly tablets in source shards. You need at least %v, it got: %v", splitRatio, minHealthyRdonlyTablets)
 	}
 
 	tasks := make(map[string]*workflowpb.Task)
@@ -222,6 +970,7 @@ func initCheckpoint(keyspace string, vtworkers []string, shardsToSplit [][][]str
 		}
 		usedVtworkersIdx = usedVtworkersIdx + len(shardToSplit[1])
 	}
+	recordFanout(shardsToSplit)
 	return &workflowpb.WorkflowCheckpoint{
 		CodeVersion: codeVersion,
 		Tasks:       tasks,
@@ -234,10 +983,27 @@ func initCheckpoint(keyspace string, vtworkers []string, shardsToSplit [][][]str
 			"skip_start_workflows":        fmt.Sprintf("%v", skipStartWorkflows),
 			"workflows_count":             fmt.Sprintf("%v", len(shardsToSplit)),
 			"keyspace":                    keyspace,
+			"split_type":                  splitTypeHorizontal,
 		},
 	}, nil
 }
 
+// initVerticalSplitCheckpoint initializes the checkpoint for a keyspace
+// vertical split. It reuses the horizontal checkpoint and adds the tables to
+// move to each task.
+func initVerticalSplitCheckpoint(sourceKeyspace, keyspace string, vtworkers []string, shardsToSplit [][][]string, tables []string, minHealthyRdonlyTablets, phaseEnableApprovals string, skipStartWorkflows bool) (*workflowpb.WorkflowCheckpoint, error) {
+	checkpoint, err := initCheckpoint(keyspace, vtworkers, shardsToSplit, minHealthyRdonlyTablets, "" /* splitCmd */, "" /* splitDiffDestTabletType */, phaseEnableApprovals, skipStartWorkflows)
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range checkpoint.Tasks {
+		task.Attributes["tables"] = strings.Join(tables, ",")
+	}
+	checkpoint.Settings["split_type"] = splitTypeVertical
+	checkpoint.Settings["source_keyspace"] = sourceKeyspace
+	return checkpoint, nil
+}
+
 // reshardingWorkflowGen contains meta-information and methods to
 // control workflow.
 type reshardingWorkflowGen struct {
@@ -245,8 +1011,12 @@ type reshardingWorkflowGen struct {
 	manager    *workflow.Manager
 	topoServer *topo.Server
 	wi         *topo.WorkflowInfo
-	// logger is the logger we export UI logs from.
+	// logger is the logger we export UI logs from. It has the messages of all
+	// nodes and its log is shown on the root node.
 	logger *logutil.MemoryLogger
+	// taskLoggers has a separate logger per task UI node. This way, a task
+	// node only shows its own messages.
+	taskLoggers map[*workflow.Node]*logutil.MemoryLogger
 
 	// rootUINode is the root node representing the workflow in the UI.
 	rootUINode *workflow.Node
@@ -263,6 +1033,86 @@ type reshardingWorkflowGen struct {
 	splitDiffDestTabletTypeParam string
 	splitCmdParam                string
 	skipStartWorkflowParam       string
+	// skipSplitDiffParam is passed as -skip_split_diff to the horizontal
+	// resharding workflows.
+	skipSplitDiffParam bool
+	// diffCellsParam is passed as -diff_cells to the horizontal resharding
+	// workflows, if set.
+	diffCellsParam string
+	// diffSamplePercentParam is passed as -diff_sample_percent to the
+	// horizontal resharding workflows if it's below 100. It's empty for
+	// checkpoints which were created before sampling was supported.
+	diffSamplePercentParam string
+	// splitTypeParam is empty for checkpoints which were created before
+	// vertical splits were supported. They are treated as horizontal.
+	splitTypeParam      string
+	sourceKeyspaceParam string
+	// childFactoryParam is the factory of the horizontal resharding
+	// workflows (-child_factory). It's empty for checkpoints which were
+	// created before the factory was configurable.
+	childFactoryParam string
+	// taskOrder lists the task IDs in the order in which their child
+	// workflows are created and started (see -dependencies).
+	taskOrder []string
+
+	// validateOnly is true if the workflow only reports the results of the
+	// pre-flight checks which were run by -validate_only.
+	validateOnly          bool
+	validationPassed      bool
+	validationReportParam string
+	// planOutParam is the file to which the plan is written instead of
+	// running the tasks (-plan_out).
+	// If set, the workflow does not create any child workflows.
+	planOutParam string
+
+	// notifyWebhookParam is the URL for the lifecycle notifications.
+	notifyWebhookParam string
+	// startTime is set when Run() starts. It's sent with all notifications.
+	startTime time.Time
+
+	// trackChildrenParam is true if the workflow waits for the child
+	// workflows to finish (-track_children).
+	trackChildrenParam    bool
+	trackChildrenInterval time.Duration
+	// childWorkflowReader returns the current state of a child workflow.
+	// It's replaced in tests.
+	childWorkflowReader func(ctx context.Context, uuid string) (*workflowpb.Workflow, error)
+	// now returns the current time for the ETA. It's replaced in tests.
+	now func() time.Time
+	// maxRunningChildrenParam limits how many child workflows are running at
+	// the same time. 0 means no limit.
+	maxRunningChildrenParam int
+	// childStarter starts a child workflow. It's replaced in tests.
+	childStarter func(ctx context.Context, uuid string) error
+	// childStopper stops a running child workflow on Abort(). It's replaced
+	// in tests.
+	childStopper func(ctx context.Context, uuid string) error
+	// childDeleter deletes a child workflow which was created after its task
+	// timed out. It's replaced in tests.
+	childDeleter func(ctx context.Context, uuid string) error
+	// structuredLogf writes the structured log lines (see logChildCreated()).
+	// It's replaced in tests.
+	structuredLogf func(format string, args ...interface{})
+	// perTaskTimeoutParam bounds the creation and start of the child workflow
+	// of each task. 0 means no limit.
+	perTaskTimeoutParam time.Duration
+
+	// postHookParam is the name of the hook which is run after the workflow
+	// finished successfully. postHookFatalParam is true if a failure of the
+	// hook fails the workflow.
+	postHookParam      string
+	postHookFatalParam bool
+	// hookRunner executes the post hook. It's replaced in tests.
+	hookRunner func(*hook.Hook) *hook.HookResult
+
+	// mu guards childUUIDs, cancelRun, aborted and the task attributes which
+	// are updated by the parallel task runners.
+	mu         sync.Mutex
+	childUUIDs []string
+	// cancelRun cancels the creation and tracking of the child workflows.
+	// aborted is true after Abort() was called.
+	cancelRun context.CancelFunc
+	aborted   bool
 }
 
 // Run implements workflow.Workflow interface. It creates one horizontal resharding workflow per shard to split
@@ -273,18 +1123,67 @@ func (hw *reshardingWorkflowGen) Run(ctx context.Context, manager *workflow.Mana
 	hw.rootUINode.Display = workflow.NodeDisplayDeterminate
 	hw.rootUINode.BroadcastChanges(true /* updateChildren */)
 
+	if hw.validateOnly {
+		hw.setUIMessage(hw.rootUINode, fmt.Sprintf("Keyspace resharding pre-flight checks (passed: %v):\n%v", hw.validationPassed, hw.validationReportParam))
+		if !hw.validationPassed {
+			return newError(ErrValidationFailed, "keyspace resharding pre-flight checks failed:\n%v", hw.validationReportParam)
+		}
+		return nil
+	}
+
+	if hw.planOutParam != "" {
+		if err := writePlanOut(hw.planOutParam, hw.checkpoint); err != nil {
+			return err
+		}
+		hw.setUIMessage(hw.rootUINode, fmt.Sprintf("Keyspace resharding plan was written to: %v. No workflows were created. Use -plan_in to create them from the plan.", hw.planOutParam))
+		return nil
+	}
+
+	runCtx := hw.enableAbortAction(ctx)
+	hw.ctx = runCtx
+	hw.startTime = time.Now()
+	hw.notify(ctx, notifyStateStarted, nil)
 	if err := hw.runWorkflow(); err != nil {
+		if abortErr := hw.abortedError(); abortErr != nil {
+			hw.notify(ctx, notifyStateFailed, abortErr)
+			return abortErr
+		}
 		hw.setUIMessage(hw.rootUINode, fmt.Sprintf("Keyspace resharding failed to create workflows"))
+		hw.notify(ctx, notifyStateFailed, err)
 		return err
 	}
-	hw.setUIMessage(hw.rootUINode, fmt.Sprintf("Keyspace resharding is finished successfully."))
+	if hw.trackChildrenParam {
+		if err := hw.trackChildren(runCtx); err != nil {
+			if abortErr := hw.abortedError(); abortErr != nil {
+				hw.notify(ctx, notifyStateFailed, abortErr)
+				return abortErr
+			}
+			hw.setUIMessage(hw.rootUINode, fmt.Sprintf("Keyspace resharding failed because of the child workflows: %v", err))
+			hw.notify(ctx, notifyStateFailed, err)
+			return err
+		}
+	}
+	if err := hw.abortedError(); err != nil {
+		hw.notify(ctx, notifyStateFailed, err)
+		return err
+	}
+	message := "Keyspace resharding is finished successfully."
+	if err := hw.runPostHook(); err != nil {
+		if hw.postHookFatalParam {
+			hw.setUIMessage(hw.rootUINode, fmt.Sprintf("Keyspace resharding failed because of the post hook: %v", err))
+			hw.notify(ctx, notifyStateFailed, err)
+			return err
+		}
+		message += fmt.Sprintf(" WARNING: %v", err)
+	}
+	hw.setUIMessage(hw.rootUINode, message)
+	hw.notify(ctx, notifyStateCompleted, nil)
 	return nil
 }
 
 func (hw *reshardingWorkflowGen) runWorkflow() error {
 	var tasks []*workflowpb.Task
-	for i := 0; i < hw.workflowsCount; i++ {
-		taskID := fmt.Sprintf("%s/%v", phaseName, i)
+	for _, taskID := range hw.taskOrder {
 		tasks = append(tasks, hw.checkpoint.Tasks[taskID])
 	}
 
@@ -292,8 +1191,26 @@ func (hw *reshardingWorkflowGen) runWorkflow() error {
 	return workflowsCreator.Run()
 }
 
-func (hw *reshardingWorkflowGen) workflowCreator(ctx context.Context, task *workflowpb.Task) error {
-	horizontalReshardingParams := []string{
+// childWorkflowParams returns the factory name and the parameters of the
+// child workflow which must be created for "task".
+func (hw *reshardingWorkflowGen) childWorkflowParams(task *workflowpb.Task) (string, []string) {
+	if hw.splitTypeParam == splitTypeVertical {
+		args := []string{
+			"-source_keyspace=" + hw.sourceKeyspaceParam,
+			"-destination_keyspace=" + hw.keyspaceParam,
+			"-source_shard=" + task.Attributes["source_shards"],
+			"-destination_shard=" + task.Attributes["destination_shards"],
+			"-tables=" + task.Attributes["tables"],
+			"-vtworkers=" + task.Attributes["vtworkers"],
+			"-min_healthy_rdonly_tablets=" + hw.minHealthyRdonlyTabletsParam,
+			"-phase_enable_approvals=" + hw.phaseEnableApprovalsParam,
+		}
+		if hw.wi != nil {
+			args = append(args, "-parent_workflow="+hw.wi.Uuid)
+		}
+		return verticalSplitFactoryName, args
+	}
+	args := []string{
 		"-keyspace=" + hw.keyspaceParam,
 		"-vtworkers=" + task.Attributes["vtworkers"],
 		"-split_cmd=" + hw.splitCmdParam,
@@ -303,6 +1220,81 @@ func (hw *reshardingWorkflowGen) workflowCreator(ctx context.Context, task *work
 		"-destination_shards=" + task.Attributes["destination_shards"],
 		"-phase_enable_approvals=" + hw.phaseEnableApprovalsParam,
 	}
+	if hw.wi != nil {
+		// Record this workflow in the child for the lookup from child to
+		// parent (see resharding.ParentWorkflow()).
+		args = append(args, "-parent_workflow="+hw.wi.Uuid)
+	}
+	if hw.skipSplitDiffParam {
+		args = append(args, "-skip_split_diff")
+	}
+	if parallelism := task.Attributes["split_parallelism"]; parallelism != "" {
+		args = append(args, "-split_parallelism="+parallelism)
+	}
+	if hw.diffCellsParam != "" {
+		args = append(args, "-diff_cells="+hw.diffCellsParam)
+	}
+	if hw.diffSamplePercentParam != "" && hw.diffSamplePercentParam != "100" {
+		args = append(args, "-diff_sample_percent="+hw.diffSamplePercentParam)
+	}
+	if hw.childFactoryParam != "" {
+		return hw.childFactoryParam, args
+	}
+	return horizontalReshardingFactoryName, args
+}
+
+// workflowCreator creates and starts the child workflow of "task". With
+// -per_task_timeout, a task whose creation or start takes too long fails with
+// ErrTaskTimedOut and can be retried. A child workflow which was created
+// before the timeout is recorded in the task and reused by the retry (see
+// createAndStartChild()).
+func (hw *reshardingWorkflowGen) workflowCreator(ctx context.Context, task *workflowpb.Task) error {
+	hw.mu.Lock()
+	delete(task.Attributes, timedOutAttribute)
+	hw.mu.Unlock()
+	return hw.createAndStartChild(ctx, task)
+}
+
+// runWithTaskTimeout runs "f" for "task" with -per_task_timeout. "action" is
+// what "f" does e.g. "creating" and is shown if it times out.
+func (hw *reshardingWorkflowGen) runWithTaskTimeout(ctx context.Context, task *workflowpb.Task, action string, f func(ctx context.Context) error) error {
+	if hw.perTaskTimeoutParam == 0 {
+		return f(ctx)
+	}
+
+	taskCtx, cancel := context.WithTimeout(ctx, hw.perTaskTimeoutParam)
+	defer cancel()
+	// "f" is run in a separate Go routine because a hanging call may not
+	// return when its context is done.
+	done := make(chan error, 1)
+	go func() {
+		done <- f(taskCtx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-taskCtx.Done():
+	}
+	if ctx.Err() != nil {
+		// The workflow was stopped. This is not a timeout of the task.
+		return ctx.Err()
+	}
+
+	hw.mu.Lock()
+	task.Attributes[timedOutAttribute] = "true"
+	hw.mu.Unlock()
+	if taskUINode, err := hw.rootUINode.GetChildByPath(task.Id); err == nil {
+		hw.setUIMessage(taskUINode, fmt.Sprintf("TIMED OUT: %v the shard split workflow for source shards: %v took longer than -per_task_timeout=%v. The task failed and can be retried.", strings.Title(action), task.Attributes["source_shards"], hw.perTaskTimeoutParam))
+	}
+	return newError(ErrTaskTimedOut, "%v the child workflow of task %v took longer than -per_task_timeout=%v", action, task.Id, hw.perTaskTimeoutParam)
+}
+
+// createAndStartChild creates the child workflow of "task" and starts it
+// unless -skip_start_workflows is set. If a previous attempt of the task
+// created the child workflow already, it's reused instead of creating a
+// second one for the same shards.
+func (hw *reshardingWorkflowGen) createAndStartChild(ctx context.Context, task *workflowpb.Task) error {
+	factoryName, params := hw.childWorkflowParams(task)
 
 	skipStart, err := strconv.ParseBool(hw.skipStartWorkflowParam)
 	if err != nil {
@@ -314,29 +1306,130 @@ func (hw *reshardingWorkflowGen) workflowCreator(ctx context.Context, task *work
 	if err != nil {
 		return err
 	}
+	taskUINode, err := hw.rootUINode.GetChildByPath(task.Id)
+	if err != nil {
+		return err
+	}
 
-	uuid, err := hw.manager.Create(ctx, "horizontal_resharding", horizontalReshardingParams)
+	hw.mu.Lock()
+	uuid := task.Attributes[childUUIDAttribute]
+	hw.mu.Unlock()
+	if uuid != "" {
+		return hw.reuseChild(ctx, task, uuid, skipStart, phaseUINode, taskUINode)
+	}
+	err = hw.runWithTaskTimeout(ctx, task, "creating", func(ctx context.Context) error {
+		return hw.createChild(ctx, task, factoryName, params, taskUINode)
+	})
 	if err != nil {
-		hw.setUIMessage(phaseUINode, fmt.Sprintf("Couldn't create shard split workflow for source shards: %v. Got error: %v", task.Attributes["source_shards"], err))
 		return err
 	}
-	hw.setUIMessage(phaseUINode, fmt.Sprintf("Created shard split workflow: %v for source shards: %v.", uuid, task.Attributes["source_shards"]))
-	workflowCmd := "WorkflowCreate horizontal_resharding" + strings.Join(horizontalReshardingParams, " ")
-	hw.setUIMessage(phaseUINode, fmt.Sprintf("Created workflow with the following params: %v", workflowCmd))
+	hw.mu.Lock()
+	uuid = task.Attributes[childUUIDAttribute]
+	hw.mu.Unlock()
 	if !skipStart {
-		err = hw.manager.Start(ctx, uuid)
-		if err != nil {
-			hw.setUIMessage(phaseUINode, fmt.Sprintf("Couldn't start shard split workflow: %v for source shards: %v. Got error: %v", uuid, task.Attributes["source_shards"], err))
+		return hw.startChild(ctx, task, uuid, phaseUINode, taskUINode)
+	}
+	return nil
+}
+
+// createChild creates the child workflow of "task" and records it in the
+// task.
+func (hw *reshardingWorkflowGen) createChild(ctx context.Context, task *workflowpb.Task, factoryName string, params []string, taskUINode *workflow.Node) error {
+	var uuid string
+	var err error
+	for attempt := 1; ; attempt++ {
+		uuid, err = hw.manager.Create(ctx, factoryName, params)
+		if err == nil {
+			break
+		}
+		if !workflow.IsRetryableCreateError(err) || attempt == createWorkflowAttempts {
+			hw.setUIMessage(taskUINode, fmt.Sprintf("Couldn't create shard split workflow for source shards: %v. Got error: %v", task.Attributes["source_shards"], err))
 			return err
 		}
+		hw.setUIMessage(taskUINode, fmt.Sprintf("Couldn't create shard split workflow for source shards: %v (attempt %v/%v). Retrying in %v. Got error: %v", task.Attributes["source_shards"], attempt, createWorkflowAttempts, createWorkflowRetryDelay, err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(createWorkflowRetryDelay):
+		}
+	}
+	if ctx.Err() != nil {
+		// The task timed out or was stopped while the child workflow was
+		// created. Delete it such that the retry does not leave an orphan
+		// behind.
+		if err := hw.childDeleter(context.Background(), uuid); err != nil {
+			log.Errorf("Keyspace resharding: cannot delete child workflow %v which was created after the task %v was canceled: %v", uuid, task.Id, err)
+		}
+		return ctx.Err()
 	}
+	hw.mu.Lock()
+	hw.childUUIDs = append(hw.childUUIDs, uuid)
+	task.Attributes[childUUIDAttribute] = uuid
+	hw.mu.Unlock()
+	hw.logChildCreated(task, factoryName, uuid)
+	hw.setUIMessage(taskUINode, fmt.Sprintf("Created shard split workflow: %v for source shards: %v.", uuid, task.Attributes["source_shards"]))
+	workflowCmd := "WorkflowCreate " + factoryName + " " + strings.Join(params, " ")
+	hw.setUIMessage(taskUINode, fmt.Sprintf("Created workflow with the following params: %v", workflowCmd))
 	return nil
 }
 
+// reuseChild starts the child workflow "uuid" which a previous attempt of
+// "task" created, unless it was started already.
+func (hw *reshardingWorkflowGen) reuseChild(ctx context.Context, task *workflowpb.Task, uuid string, skipStart bool, phaseUINode, taskUINode *workflow.Node) error {
+	hw.mu.Lock()
+	recorded := false
+	for _, childUUID := range hw.childUUIDs {
+		if childUUID == uuid {
+			recorded = true
+			break
+		}
+	}
+	if !recorded {
+		hw.childUUIDs = append(hw.childUUIDs, uuid)
+	}
+	hw.mu.Unlock()
+	hw.setUIMessage(taskUINode, fmt.Sprintf("Reusing shard split workflow: %v for source shards: %v which was created by a previous attempt.", uuid, task.Attributes["source_shards"]))
+	if skipStart {
+		return nil
+	}
+	w, err := hw.childWorkflowReader(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	if w.State != workflowpb.WorkflowState_NotStarted {
+		return nil
+	}
+	return hw.startChild(ctx, task, uuid, phaseUINode, taskUINode)
+}
+
+// startChild starts the child workflow "uuid" of "task". With
+// -max_running_children, it waits until a slot is free. Only the start itself
+// is bounded by -per_task_timeout.
+func (hw *reshardingWorkflowGen) startChild(ctx context.Context, task *workflowpb.Task, uuid string, phaseUINode, taskUINode *workflow.Node) error {
+	if err := hw.waitForRunningChildren(ctx, phaseUINode); err != nil {
+		return err
+	}
+	return hw.runWithTaskTimeout(ctx, task, "starting", func(ctx context.Context) error {
+		if err := hw.childStarter(ctx, uuid); err != nil {
+			hw.setUIMessage(taskUINode, fmt.Sprintf("Couldn't start shard split workflow: %v for source shards: %v. Got error: %v", uuid, task.Attributes["source_shards"], err))
+			return err
+		}
+		return nil
+	})
+}
+
 func (hw *reshardingWorkflowGen) setUIMessage(node *workflow.Node, message string) {
 	log.Infof("Keyspace resharding : %v.", message)
 	hw.logger.Infof(message)
-	node.Log = hw.logger.String()
+	if taskLogger, ok := hw.taskLoggers[node]; ok {
+		taskLogger.Infof(message)
+		node.Log = taskLogger.String()
+		// Keep the combined log on the root node up to date.
+		hw.rootUINode.Log = hw.logger.String()
+		hw.rootUINode.BroadcastChanges(false /* updateChildren */)
+	} else {
+		node.Log = hw.logger.String()
+	}
 	node.Message = message
 	node.BroadcastChanges(false /* updateChildren */)
 }
@@ -76,7 +76,10 @@ func TestWorfklowGenerator(t *testing.T) {
 
 func setupTopology(ctx context.Context, t *testing.T, keyspace string) *topo.Server {
 	ts := memorytopo.NewServer("cell")
-	if err := ts.CreateKeyspace(ctx, keyspace, &topodatapb.Keyspace{}); err != nil {
+	if err := ts.CreateKeyspace(ctx, keyspace, &topodatapb.Keyspace{
+		ShardingColumnName: "keyspace_id",
+		ShardingColumnType: topodatapb.KeyspaceIdType_UINT64,
+	}); err != nil {
 		t.Fatalf("CreateKeyspace: %v", err)
 	}
 	ts.CreateShard(ctx, keyspace, "0")
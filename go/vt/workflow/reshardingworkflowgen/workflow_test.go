@@ -0,0 +1,877 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reshardingworkflowgen
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	"vitess.io/vitess/go/vt/topo"
+	"vitess.io/vitess/go/vt/topo/memorytopo"
+	"vitess.io/vitess/go/vt/worker/fakevtworkerclient"
+	"vitess.io/vitess/go/vt/worker/vtworkerclient"
+	"vitess.io/vitess/go/vt/workflow"
+	"vitess.io/vitess/go/vt/workflow/resharding"
+
+	topodatapb "vitess.io/vitess/go/vt/proto/topodata"
+	vschemapb "vitess.io/vitess/go/vt/proto/vschema"
+	workflowpb "vitess.io/vitess/go/vt/proto/workflow"
+)
+
+const (
+	testSourceKeyspace = "source_keyspace"
+	testTables         = "moving1,moving2"
+)
+
+func TestVerticalSplitTasks(t *testing.T) {
+	ctx := context.Background()
+	ts := setupVerticalSplitTopology(ctx, t)
+
+	sourceKeyspace, shardsToSplit, err := findVerticalSplitShards(ts, testKeyspace)
+	if err != nil {
+		t.Fatalf("findVerticalSplitShards failed: %v", err)
+	}
+	if sourceKeyspace != testSourceKeyspace {
+		t.Fatalf("wrong source keyspace: got = %v, want = %v", sourceKeyspace, testSourceKeyspace)
+	}
+	if want := [][][]string{{{"0"}, {"0"}}}; !reflect.DeepEqual(shardsToSplit, want) {
+		t.Fatalf("wrong shards to split: got = %v, want = %v", shardsToSplit, want)
+	}
+
+	checkpoint, err := initVerticalSplitCheckpoint(sourceKeyspace, testKeyspace, []string{testVtworkers}, shardsToSplit, []string{"moving1", "moving2"}, "1", "", true /* skipStartWorkflows */)
+	if err != nil {
+		t.Fatalf("initVerticalSplitCheckpoint failed: %v", err)
+	}
+	if got, want := checkpoint.Settings["split_type"], splitTypeVertical; got != want {
+		t.Fatalf("wrong split type: got = %v, want = %v", got, want)
+	}
+	if got, want := checkpoint.Settings["source_keyspace"], testSourceKeyspace; got != want {
+		t.Fatalf("wrong source keyspace in settings: got = %v, want = %v", got, want)
+	}
+	task := checkpoint.Tasks[phaseName+"/0"]
+	wantAttributes := map[string]string{
+		"source_shards":      "0",
+		"destination_shards": "0",
+		"vtworkers":          testVtworkers,
+		"tables":             testTables,
+	}
+	if !reflect.DeepEqual(task.Attributes, wantAttributes) {
+		t.Fatalf("wrong task attributes: got = %v, want = %v", task.Attributes, wantAttributes)
+	}
+
+	hw := &reshardingWorkflowGen{
+		checkpoint:                   checkpoint,
+		keyspaceParam:                testKeyspace,
+		minHealthyRdonlyTabletsParam: "1",
+		splitTypeParam:               checkpoint.Settings["split_type"],
+		sourceKeyspaceParam:          checkpoint.Settings["source_keyspace"],
+	}
+	factoryName, params := hw.childWorkflowParams(task)
+	if factoryName != verticalSplitFactoryName {
+		t.Fatalf("wrong child workflow factory: got = %v, want = %v", factoryName, verticalSplitFactoryName)
+	}
+	wantParams := []string{
+		"-source_keyspace=" + testSourceKeyspace,
+		"-destination_keyspace=" + testKeyspace,
+		"-source_shard=0",
+		"-destination_shard=0",
+		"-tables=" + testTables,
+		"-vtworkers=" + testVtworkers,
+		"-min_healthy_rdonly_tablets=1",
+		"-phase_enable_approvals=",
+	}
+	if !reflect.DeepEqual(params, wantParams) {
+		t.Fatalf("wrong child workflow params: got = %v, want = %v", params, wantParams)
+	}
+
+	// The child workflow must be accepted by the registered factory.
+	m := workflow.NewManager(ts)
+	uuid, err := m.Create(ctx, factoryName, params)
+	if err != nil {
+		t.Fatalf("cannot create vertical split child workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read child workflow: %v", err)
+	}
+	if got, want := wi.FactoryName, verticalSplitFactoryName; got != want {
+		t.Fatalf("wrong factory of the child workflow: got = %v, want = %v", got, want)
+	}
+}
+
+func TestVerticalSplitNotServedFrom(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+
+	if _, _, err := findVerticalSplitShards(ts, testKeyspace); !IsErrType(err, ErrNotServedFrom) {
+		t.Fatalf("findVerticalSplitShards should have failed with ErrNotServedFrom for a keyspace which is not served from another keyspace: %v", err)
+	}
+}
+
+func TestInitCheckpointErrors(t *testing.T) {
+	shardsToSplit := [][][]string{{{"0"}, {"-80", "80-"}}}
+	testCases := []struct {
+		name                    string
+		vtworkers               []string
+		shardsToSplit           [][][]string
+		minHealthyRdonlyTablets string
+		want                    ErrorCode
+		wantMessage             string
+	}{
+		{
+			name:                    "no shards",
+			vtworkers:               []string{"vtworker1"},
+			minHealthyRdonlyTablets: "1",
+			want:                    ErrNoOverlappingShards,
+			wantMessage:             "invalid source or destination shards",
+		},
+		{
+			name:                    "vtworker count",
+			vtworkers:               []string{"vtworker1"},
+			shardsToSplit:           shardsToSplit,
+			minHealthyRdonlyTablets: "2",
+			want:                    ErrVtworkerCountMismatch,
+			wantMessage:             "there are 1 vtworkers, 2 destination shards: the number should be same",
+		},
+		{
+			name:                    "rdonly tablets",
+			vtworkers:               []string{"vtworker1", "vtworker2"},
+			shardsToSplit:           shardsToSplit,
+			minHealthyRdonlyTablets: "1",
+			want:                    ErrNotEnoughRdonlyTablets,
+			wantMessage:             "there are not enough rdonly tablets in source shards. You need at least 2, it got: 1",
+		},
+	}
+	for _, tc := range testCases {
+		_, err := initCheckpoint(testKeyspace, tc.vtworkers, tc.shardsToSplit, tc.minHealthyRdonlyTablets, "SplitClone", "RDONLY", "", false)
+		if !IsErrType(err, tc.want) {
+			t.Errorf("%v: wrong error type: got = %v, want code = %v", tc.name, err, tc.want)
+			continue
+		}
+		if got := err.Error(); got != tc.wantMessage {
+			t.Errorf("%v: wrong error message: got = %v, want = %v", tc.name, got, tc.wantMessage)
+		}
+	}
+}
+
+func TestInitInvalidArguments(t *testing.T) {
+	ts := setupTopology(context.Background(), t, testKeyspace)
+	m := workflow.NewManager(ts)
+	for _, args := range [][]string{
+		{"-keyspace=" + testKeyspace},
+		{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=1", "-split_type=diagonal"},
+		{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=1", "-split_type=vertical"},
+		{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=1", "-split_type=vertical", "-tables=t1", "-validate_only"},
+		{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=1", "-split_cmd=MultiSplitDiff"},
+		{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-min_healthy_rdonly_tablets=1", "-vtworker_assignment=random"},
+	} {
+		if _, err := m.Create(context.Background(), keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+}
+
+func TestKeyspaceNotSharded(t *testing.T) {
+	ctx := context.Background()
+	ts := memorytopo.NewServer("cell")
+	if err := ts.CreateKeyspace(ctx, "v2", &topodatapb.Keyspace{ShardingColumnName: "keyspace_id", ShardingColumnType: topodatapb.KeyspaceIdType_UINT64}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.CreateKeyspace(ctx, "v3", &topodatapb.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.SaveVSchema(ctx, "v3", &vschemapb.Keyspace{Sharded: true}); err != nil {
+		t.Fatalf("SaveVSchema: %v", err)
+	}
+	if err := ts.CreateKeyspace(ctx, "unsharded", &topodatapb.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.SaveVSchema(ctx, "unsharded", &vschemapb.Keyspace{Sharded: false}); err != nil {
+		t.Fatalf("SaveVSchema: %v", err)
+	}
+	if err := ts.CreateKeyspace(ctx, "no_vschema", &topodatapb.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+
+	testCases := []struct {
+		keyspace string
+		wantErr  bool
+	}{
+		{"v2", false},
+		{"v3", false},
+		{"unsharded", true},
+		{"no_vschema", true},
+	}
+	for _, tc := range testCases {
+		err := checkKeyspaceSharded(ctx, ts, tc.keyspace)
+		if !tc.wantErr {
+			if err != nil {
+				t.Errorf("checkKeyspaceSharded(%v) should have succeeded: %v", tc.keyspace, err)
+			}
+			continue
+		}
+		if !IsErrType(err, ErrKeyspaceNotSharded) {
+			t.Errorf("checkKeyspaceSharded(%v) should have failed with ErrKeyspaceNotSharded: %v", tc.keyspace, err)
+		}
+	}
+
+	// The check guards the creation of the workflow, also with -validate_only.
+	m := workflow.NewManager(ts)
+	for _, args := range [][]string{
+		{},
+		{"-validate_only"},
+	} {
+		args = append(args, "-keyspace=unsharded", "-vtworkers="+testVtworkers, "-min_healthy_rdonly_tablets=1")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrKeyspaceNotSharded) {
+			t.Errorf("Create(%v) should have failed with ErrKeyspaceNotSharded: %v", args, err)
+		}
+	}
+}
+
+func TestCheckSplitCmd(t *testing.T) {
+	for _, splitCmd := range []string{splitCmdSplitClone, splitCmdLegacySplitClone} {
+		if err := checkSplitCmd(splitCmd); err != nil {
+			t.Errorf("checkSplitCmd(%v) should have succeeded: %v", splitCmd, err)
+		}
+	}
+	if err := checkSplitCmd("SplitDiff"); !IsErrType(err, ErrInvalidArguments) {
+		t.Errorf("checkSplitCmd(SplitDiff) should have failed with ErrInvalidArguments: %v", err)
+	}
+}
+
+func TestKeyspaceNotFound(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+	for _, args := range [][]string{
+		{},
+		{"-validate_only"},
+		{"-split_type=vertical", "-tables=t1"},
+	} {
+		args = append(args, "-keyspace=typo_keyspace", "-vtworkers="+testVtworkers, "-min_healthy_rdonly_tablets=1")
+		_, err := m.Create(ctx, keyspaceReshardingFactoryName, args)
+		if !IsErrType(err, ErrKeyspaceNotFound) {
+			t.Errorf("Create(%v) should have failed with ErrKeyspaceNotFound: %v", args, err)
+			continue
+		}
+		if got, want := err.Error(), "keyspace typo_keyspace not found"; got != want {
+			t.Errorf("Create(%v): wrong error message: got = %v, want = %v", args, got, want)
+		}
+	}
+}
+
+func TestHorizontalChildWorkflowParams(t *testing.T) {
+	// Checkpoints written before vertical splits were supported have no
+	// "split_type" setting and must still create horizontal workflows.
+	hw := &reshardingWorkflowGen{
+		checkpoint: &workflowpb.WorkflowCheckpoint{},
+	}
+	if factoryName, _ := hw.childWorkflowParams(&workflowpb.Task{}); factoryName != horizontalReshardingFactoryName {
+		t.Fatalf("wrong child workflow factory: got = %v, want = %v", factoryName, horizontalReshardingFactoryName)
+	}
+}
+
+// recordingFactory is a child workflow factory which records the parameters
+// of each created workflow. Its workflows do nothing.
+type recordingFactory struct {
+	args [][]string
+}
+
+func (f *recordingFactory) Init(m *workflow.Manager, w *workflowpb.Workflow, args []string) error {
+	f.args = append(f.args, args)
+	w.Name = "recording"
+	return nil
+}
+
+func (f *recordingFactory) Instantiate(m *workflow.Manager, w *workflowpb.Workflow, rootNode *workflow.Node) (workflow.Workflow, error) {
+	return &noopWorkflow{}, nil
+}
+
+type noopWorkflow struct{}
+
+func (*noopWorkflow) Run(ctx context.Context, manager *workflow.Manager, wi *topo.WorkflowInfo) error {
+	return nil
+}
+
+func TestChildFactory(t *testing.T) {
+	const customFactoryName = "custom_resharding"
+	factory := &recordingFactory{}
+	workflow.Register(customFactoryName, factory)
+	defer workflow.Unregister(customFactoryName)
+
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers, "-split_type=vertical", "-tables=t1", "-child_factory=" + customFactoryName}); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("child_factory should have been rejected for a vertical split: %v", err)
+	}
+
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers + "," + testVtworkers, "-min_healthy_rdonly_tablets=2", "-child_factory=" + customFactoryName})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	defer m.Stop(ctx, uuid)
+
+	if len(hw.childUUIDs) != 1 || len(factory.args) != 1 {
+		t.Fatalf("one child workflow must be created with the configured factory: child UUIDs: %v, recorded args: %v", hw.childUUIDs, factory.args)
+	}
+	wi, err := ts.GetWorkflow(ctx, hw.childUUIDs[0])
+	if err != nil {
+		t.Fatalf("cannot read child workflow: %v", err)
+	}
+	if got, want := wi.FactoryName, customFactoryName; got != want {
+		t.Fatalf("wrong factory of the child workflow: got = %v, want = %v", got, want)
+	}
+	if got, want := factory.args[0][0], "-keyspace="+testKeyspace; got != want {
+		t.Fatalf("the child workflow should have received the horizontal resharding parameters: got = %v, want = %v", factory.args[0], want)
+	}
+}
+
+func TestSkipSplitDiff(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-skip_split_diff"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := checkpoint.Settings["skip_split_diff"], "true"; got != want {
+		t.Fatalf("wrong skip_split_diff setting: got = %v, want = %v", got, want)
+	}
+
+	rootNode := workflow.NewNode()
+	w, err := (&Factory{}).Instantiate(m, wi.Workflow, rootNode)
+	if err != nil {
+		t.Fatalf("cannot instantiate workflow: %v", err)
+	}
+	if !strings.Contains(rootNode.Message, skipSplitDiffWarning) {
+		t.Fatalf("root node message does not contain the warning: %v", rootNode.Message)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	_, args := hw.childWorkflowParams(checkpoint.Tasks[phaseName+"/0"])
+	if got, want := args[len(args)-1], "-skip_split_diff"; got != want {
+		t.Fatalf("-skip_split_diff was not passed to the child workflow: %v", args)
+	}
+}
+
+func TestDiffCells(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	if err := ts.CreateCellInfo(ctx, "cell2", &topodatapb.CellInfo{}); err != nil {
+		t.Fatalf("CreateCellInfo: %v", err)
+	}
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	for _, args := range [][]string{
+		{"-diff_cells=cell,cell3"},
+		{"-diff_cells=cell", "-skip_split_diff"},
+		{"-diff_cells=cell", "-split_type=vertical", "-tables=t1"},
+	} {
+		args = append(args, "-keyspace="+testKeyspace, "-vtworkers="+vtworkersParameter, "-min_healthy_rdonly_tablets=2")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-diff_cells=cell,cell2"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	_, args := hw.childWorkflowParams(hw.checkpoint.Tasks[phaseName+"/0"])
+	if got, want := args[len(args)-1], "-diff_cells=cell,cell2"; got != want {
+		t.Fatalf("-diff_cells was not passed to the child workflow: %v", args)
+	}
+}
+
+func TestDiffSamplePercent(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	for _, args := range [][]string{
+		{"-diff_sample_percent=0"},
+		{"-diff_sample_percent=101"},
+		{"-diff_sample_percent=10", "-skip_split_diff"},
+		{"-diff_sample_percent=10", "-split_type=vertical", "-tables=t1"},
+	} {
+		args = append(args, "-keyspace="+testKeyspace, "-vtworkers="+vtworkersParameter, "-min_healthy_rdonly_tablets=2")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-diff_sample_percent=10"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if got, want := hw.checkpoint.Settings["diff_sample_percent"], "10"; got != want {
+		t.Errorf("wrong diff_sample_percent setting: got = %v, want = %v", got, want)
+	}
+	if !strings.Contains(hw.rootUINode.Message, "SAMPLE of 10%") {
+		t.Errorf("the UI must warn that only a sample is verified: %v", hw.rootUINode.Message)
+	}
+	_, args := hw.childWorkflowParams(hw.checkpoint.Tasks[phaseName+"/0"])
+	if got, want := args[len(args)-1], "-diff_sample_percent=10"; got != want {
+		t.Fatalf("-diff_sample_percent was not passed to the child workflow: %v", args)
+	}
+}
+
+func TestDiscoveryCells(t *testing.T) {
+	ctx := context.Background()
+	ts := memorytopo.NewServer("cell", "cell2")
+	if err := ts.CreateKeyspace(ctx, testKeyspace, &topodatapb.Keyspace{
+		ShardingColumnName: "keyspace_id",
+		ShardingColumnType: topodatapb.KeyspaceIdType_UINT64,
+	}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	for _, shard := range []string{"-80", "80-", "-40", "40-80", "80-c0", "c0-"} {
+		if err := ts.CreateShard(ctx, testKeyspace, shard); err != nil {
+			t.Fatalf("CreateShard: %v", err)
+		}
+	}
+	// Source shard -80 is serving in "cell" and source shard 80- in "cell2".
+	for cell, shard := range map[string]string{"cell": "-80", "cell2": "80-"} {
+		var partitions []*topodatapb.SrvKeyspace_KeyspacePartition
+		for _, tabletType := range []topodatapb.TabletType{topodatapb.TabletType_MASTER, topodatapb.TabletType_REPLICA, topodatapb.TabletType_RDONLY} {
+			partitions = append(partitions, &topodatapb.SrvKeyspace_KeyspacePartition{
+				ServedType:      tabletType,
+				ShardReferences: []*topodatapb.ShardReference{{Name: shard}},
+			})
+		}
+		if err := ts.UpdateSrvKeyspace(ctx, cell, testKeyspace, &topodatapb.SrvKeyspace{Partitions: partitions}); err != nil {
+			t.Fatalf("UpdateSrvKeyspace: %v", err)
+		}
+	}
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	for _, args := range [][]string{
+		{"-discovery_cells=cell2,cell3"},
+		{"-discovery_cells=cell2", "-split_type=vertical", "-tables=t1"},
+	} {
+		args = append(args, "-keyspace="+testKeyspace, "-vtworkers="+vtworkersParameter, "-min_healthy_rdonly_tablets=2")
+		if _, err := m.Create(ctx, keyspaceReshardingFactoryName, args); !IsErrType(err, ErrInvalidArguments) {
+			t.Errorf("Create(%v) should have failed with ErrInvalidArguments: %v", args, err)
+		}
+	}
+
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-discovery_cells=cell2"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if len(hw.checkpoint.Tasks) != 1 {
+		t.Fatalf("only the source shard which is serving in cell2 must be split: got = %v", hw.checkpoint.Tasks)
+	}
+	task := hw.checkpoint.Tasks[phaseName+"/0"]
+	if got, want := task.Attributes["source_shards"], "80-"; got != want {
+		t.Errorf("wrong source shards: got = %v, want = %v", got, want)
+	}
+	if got, want := task.Attributes["destination_shards"], "80-c0,c0-"; got != want {
+		t.Errorf("wrong destination shards: got = %v, want = %v", got, want)
+	}
+}
+
+func TestValidateOnly(t *testing.T) {
+	ctx := context.Background()
+	ts := setupServingTopology(ctx, t, testKeyspace, 2 /* rdonlyTablets */)
+	setupFakeVtworkerPing(t, testVtworkers)
+	defer vtworkerclient.UnregisterFactoryForTest("fake")
+
+	m := workflow.NewManager(ts)
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-validate_only"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoint.Tasks) != 0 {
+		t.Fatalf("validate_only must not create any tasks: %v", checkpoint.Tasks)
+	}
+	if got, want := checkpoint.Settings["validation_passed"], "true"; got != want {
+		t.Fatalf("pre-flight checks should have passed: got = %v, want = %v report:\n%v", got, want, checkpoint.Settings["validation_report"])
+	}
+	for _, c := range preflightChecks {
+		if want := "PASS " + c.name + "\n"; !strings.Contains(checkpoint.Settings["validation_report"], want) {
+			t.Fatalf("report does not contain: %v report:\n%v", want, checkpoint.Settings["validation_report"])
+		}
+	}
+}
+
+func TestValidateOnlyFailures(t *testing.T) {
+	ctx := context.Background()
+	ts := setupServingTopology(ctx, t, testKeyspace, 1 /* rdonlyTablets */)
+	setupFakeVtworkerPing(t, testVtworkers)
+	defer vtworkerclient.UnregisterFactoryForTest("fake")
+
+	// Only one vtworker for two destination shards and not enough rdonly
+	// tablets in the source shard. All checks must run nonetheless.
+	results := runPreflightChecks(ctx, &preflightParams{
+		ts:                      ts,
+		discoverer:              overlappingShardsDiscoverer{},
+		keyspace:                testKeyspace,
+		vtworkers:               []string{testVtworkers},
+		minHealthyRdonlyTablets: "2",
+		splitCmd:                splitCmdSplitClone,
+		maxOverlaps:             defaultMaxOverlaps,
+	})
+	if got, want := len(results), len(preflightChecks); got != want {
+		t.Fatalf("not all checks were run: got = %v, want = %v", got, want)
+	}
+	report, passed := preflightReport(results)
+	if passed {
+		t.Fatalf("pre-flight checks should have failed. report:\n%v", report)
+	}
+	for _, want := range []string{
+		"PASS DiscoverShards\n",
+		"FAIL VtworkerCount: there are 1 vtworkers, 2 destination shards",
+		"PASS MinHealthyRdonlyTablets\n",
+		"FAIL SourceRdonlyTablets: source shard test_keyspace/0 has 1 rdonly tablets, but at least 2 are required",
+		"PASS DestinationShardsNotServing\n",
+		"PASS VtworkersReachable\n",
+		"PASS DestinationReplicaTablets\n",
+		"PASS SplitCmd\n",
+		"PASS DestinationCoverage\n",
+		"PASS CellsExist\n",
+		"PASS MaxOverlaps\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("report does not contain: %v report:\n%v", want, report)
+		}
+	}
+	if err := results[1].err; !IsErrType(err, ErrVtworkerCountMismatch) {
+		t.Fatalf("VtworkerCount should have failed with ErrVtworkerCountMismatch: %v", err)
+	}
+	if err := results[3].err; !IsErrType(err, ErrNotEnoughRdonlyTablets) {
+		t.Fatalf("SourceRdonlyTablets should have failed with ErrNotEnoughRdonlyTablets: %v", err)
+	}
+}
+
+func TestMinDestinationReplicas(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+	args := []string{"-keyspace=" + testKeyspace, "-vtworkers=" + testVtworkers + "," + testVtworkers, "-min_healthy_rdonly_tablets=2"}
+
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-min_destination_replicas=-1")); !IsErrType(err, ErrInvalidArguments) {
+		t.Fatalf("Create() with a negative -min_destination_replicas should have failed with ErrInvalidArguments: %v", err)
+	}
+
+	// Only the destination shard -80 has a replica.
+	addReplica := func(uid uint32, shard string) {
+		if err := ts.CreateTablet(ctx, &topodatapb.Tablet{
+			Alias:    &topodatapb.TabletAlias{Cell: "cell", Uid: uid},
+			Keyspace: testKeyspace,
+			Shard:    shard,
+			Type:     topodatapb.TabletType_REPLICA,
+		}); err != nil {
+			t.Fatalf("CreateTablet: %v", err)
+		}
+	}
+	addReplica(200, "-80")
+	_, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-min_destination_replicas=1"))
+	if !IsErrType(err, ErrNotEnoughReplicaTablets) {
+		t.Fatalf("Create() with an under-replicated destination shard should have failed with ErrNotEnoughReplicaTablets: %v", err)
+	}
+	if want := "destination shard test_keyspace/80- has 0 replica tablets, but at least 1 are required"; !strings.Contains(err.Error(), want) {
+		t.Fatalf("wrong error: got = %v, want substring = %v", err, want)
+	}
+
+	addReplica(201, "80-")
+	if _, err := m.Create(ctx, keyspaceReshardingFactoryName, append(args, "-min_destination_replicas=1")); err != nil {
+		t.Fatalf("Create() should succeed if all destination shards have enough replicas: %v", err)
+	}
+}
+
+func TestOwnerAndOncall(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTopology(ctx, t, testKeyspace)
+	m := workflow.NewManager(ts)
+
+	vtworkersParameter := testVtworkers + "," + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkersParameter, "-min_healthy_rdonly_tablets=2", "-owner=alice", "-oncall=db-oncall@example.com"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	wi, err := ts.GetWorkflow(ctx, uuid)
+	if err != nil {
+		t.Fatalf("cannot read workflow: %v", err)
+	}
+	checkpoint := &workflowpb.WorkflowCheckpoint{}
+	if err := proto.Unmarshal(wi.Data, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := checkpoint.Settings["owner"], "alice"; got != want {
+		t.Fatalf("wrong owner: got = %v, want = %v", got, want)
+	}
+	if got, want := checkpoint.Settings["oncall"], "db-oncall@example.com"; got != want {
+		t.Fatalf("wrong oncall: got = %v, want = %v", got, want)
+	}
+
+	rootNode := workflow.NewNode()
+	if _, err := (&Factory{}).Instantiate(m, wi.Workflow, rootNode); err != nil {
+		t.Fatalf("cannot instantiate workflow: %v", err)
+	}
+	if want := "Owner: alice, Oncall: db-oncall@example.com"; !strings.Contains(rootNode.Message, want) {
+		t.Fatalf("root node message does not contain: %v message: %v", want, rootNode.Message)
+	}
+}
+
+func TestTaskLogs(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	defer m.Stop(ctx, uuid)
+
+	// Each task node only shows the messages of its own child workflow.
+	sourceShards := []string{"-80", "80-"}
+	for i, own := range sourceShards {
+		other := sourceShards[1-i]
+		taskUINode, err := hw.rootUINode.GetChildByPath(fmt.Sprintf("%s/%v", phaseName, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "for source shards: " + own + "."; !strings.Contains(taskUINode.Log, want) {
+			t.Fatalf("task %v log does not contain its own message: %v log: %v", i, want, taskUINode.Log)
+		}
+		if notWant := "for source shards: " + other + "."; strings.Contains(taskUINode.Log, notWant) {
+			t.Fatalf("task %v log contains the message of another task: %v log: %v", i, notWant, taskUINode.Log)
+		}
+	}
+	// The root node shows the combined log.
+	for _, want := range []string{"for source shards: -80.", "for source shards: 80-.", "Keyspace resharding is finished successfully."} {
+		if !strings.Contains(hw.rootUINode.Log, want) {
+			t.Fatalf("root log does not contain: %v log: %v", want, hw.rootUINode.Log)
+		}
+	}
+}
+
+func TestChildrenParentWorkflow(t *testing.T) {
+	ctx := context.Background()
+	ts := setupTasksTopology(ctx, t, []string{"-80", "80-"}, []string{"-40", "40-80", "80-c0", "c0-"})
+	m := workflow.NewManager(ts)
+	workflow.StartManager(m)
+
+	vtworkers := strings.Repeat(testVtworkers+",", 3) + testVtworkers
+	uuid, err := m.Create(ctx, keyspaceReshardingFactoryName, []string{"-keyspace=" + testKeyspace, "-vtworkers=" + vtworkers, "-min_healthy_rdonly_tablets=2"})
+	if err != nil {
+		t.Fatalf("cannot create resharding workflow: %v", err)
+	}
+	w, err := m.WorkflowForTesting(uuid)
+	if err != nil {
+		t.Fatalf("fail to get workflow from manager: %v", err)
+	}
+	hw := w.(*reshardingWorkflowGen)
+	if err := m.Start(ctx, uuid); err != nil {
+		t.Fatalf("cannot start resharding workflow: %v", err)
+	}
+	m.Wait(ctx, uuid)
+	defer m.Stop(ctx, uuid)
+
+	if len(hw.childUUIDs) != 2 {
+		t.Fatalf("two child workflows must be created: %v", hw.childUUIDs)
+	}
+	for _, childUUID := range hw.childUUIDs {
+		wi, err := ts.GetWorkflow(ctx, childUUID)
+		if err != nil {
+			t.Fatalf("cannot read child workflow: %v", err)
+		}
+		parent, err := resharding.ParentWorkflow(wi.Workflow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent != uuid {
+			t.Fatalf("wrong parent of child workflow %v: got = %v, want = %v", childUUID, parent, uuid)
+		}
+	}
+}
+
+func TestInstantiateInvalidCheckpoint(t *testing.T) {
+	m := workflow.NewManager(memorytopo.NewServer("cell"))
+	checkpoint, err := initCheckpoint(testKeyspace, []string{"vtworker1", "vtworker2"}, [][][]string{{{"0"}, {"-80", "80-"}}}, "2", "SplitClone", "RDONLY", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		modify      func(*workflowpb.WorkflowCheckpoint)
+		wantMessage string
+	}{
+		{
+			name: "count too high",
+			modify: func(c *workflowpb.WorkflowCheckpoint) {
+				c.Settings["workflows_count"] = "2"
+			},
+			wantMessage: "checkpoint has 1 tasks in phase create_workflows, but workflows_count is 2",
+		},
+		{
+			name: "wrong task ID",
+			modify: func(c *workflowpb.WorkflowCheckpoint) {
+				task := c.Tasks[phaseName+"/0"]
+				delete(c.Tasks, phaseName+"/0")
+				c.Tasks[phaseName+"/1"] = task
+			},
+			wantMessage: "checkpoint has no task create_workflows/0 (workflows_count is 1)",
+		},
+	}
+	for _, tc := range testCases {
+		c := proto.Clone(checkpoint).(*workflowpb.WorkflowCheckpoint)
+		tc.modify(c)
+		data, err := proto.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = (&Factory{}).Instantiate(m, &workflowpb.Workflow{Data: data}, workflow.NewNode())
+		if !IsErrType(err, ErrInvalidCheckpoint) {
+			t.Errorf("%v: wrong error type: got = %v, want code = %v", tc.name, err, ErrInvalidCheckpoint)
+			continue
+		}
+		if got := err.Error(); got != tc.wantMessage {
+			t.Errorf("%v: wrong error message: got = %v, want = %v", tc.name, got, tc.wantMessage)
+		}
+	}
+}
+
+// setupServingTopology creates a keyspace where shard "0" is serving and is
+// split into the non-serving shards "-80" and "80-".
+func setupServingTopology(ctx context.Context, t *testing.T, keyspace string, rdonlyTablets int) *topo.Server {
+	ts := setupTopology(ctx, t, keyspace)
+	var partitions []*topodatapb.SrvKeyspace_KeyspacePartition
+	for _, tabletType := range []topodatapb.TabletType{topodatapb.TabletType_MASTER, topodatapb.TabletType_REPLICA, topodatapb.TabletType_RDONLY} {
+		partitions = append(partitions, &topodatapb.SrvKeyspace_KeyspacePartition{
+			ServedType:      tabletType,
+			ShardReferences: []*topodatapb.ShardReference{{Name: "0"}},
+		})
+	}
+	if err := ts.UpdateSrvKeyspace(ctx, "cell", keyspace, &topodatapb.SrvKeyspace{Partitions: partitions}); err != nil {
+		t.Fatalf("UpdateSrvKeyspace: %v", err)
+	}
+	for i := 0; i < rdonlyTablets; i++ {
+		if err := ts.CreateTablet(ctx, &topodatapb.Tablet{
+			Alias:    &topodatapb.TabletAlias{Cell: "cell", Uid: uint32(100 + i)},
+			Keyspace: keyspace,
+			Shard:    "0",
+			Type:     topodatapb.TabletType_RDONLY,
+		}); err != nil {
+			t.Fatalf("CreateTablet: %v", err)
+		}
+	}
+	return ts
+}
+
+// setupFakeVtworkerPing registers a fake vtworker which answers one "Ping"
+// command at "addr".
+func setupFakeVtworkerPing(t *testing.T, addr string) *fakevtworkerclient.FakeVtworkerClient {
+	flag.Set("vtworker_client_protocol", "fake")
+	fakeVtworkerClient := fakevtworkerclient.NewFakeVtworkerClient()
+	if err := fakeVtworkerClient.RegisterResultForAddr(addr, []string{"Ping", "keyspace resharding pre-flight check"}, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	vtworkerclient.RegisterFactory("fake", fakeVtworkerClient.FakeVtworkerClientFactory)
+	return fakeVtworkerClient
+}
+
+func setupVerticalSplitTopology(ctx context.Context, t *testing.T) *topo.Server {
+	ts := memorytopo.NewServer("cell")
+	if err := ts.CreateKeyspace(ctx, testSourceKeyspace, &topodatapb.Keyspace{}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.CreateShard(ctx, testSourceKeyspace, "0"); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+	if err := ts.CreateKeyspace(ctx, testKeyspace, &topodatapb.Keyspace{
+		ServedFroms: []*topodatapb.Keyspace_ServedFrom{
+			{TabletType: topodatapb.TabletType_MASTER, Keyspace: testSourceKeyspace},
+			{TabletType: topodatapb.TabletType_REPLICA, Keyspace: testSourceKeyspace},
+			{TabletType: topodatapb.TabletType_RDONLY, Keyspace: testSourceKeyspace},
+		},
+	}); err != nil {
+		t.Fatalf("CreateKeyspace: %v", err)
+	}
+	if err := ts.CreateShard(ctx, testKeyspace, "0"); err != nil {
+		t.Fatalf("CreateShard: %v", err)
+	}
+	return ts
+}